@@ -27,7 +27,7 @@ type Backend struct {
 
 func NewBackend(ctx *pkgservice.ServiceContext, config *Config, ptt *pkgservice.BasePtt) (*Backend, error) {
 	// init account
-	err := InitAccount(config.DataDir)
+	err := InitAccount(config.DataDir, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +48,9 @@ func NewBackend(ctx *pkgservice.ServiceContext, config *Config, ptt *pkgservice.
 	}
 	backend.BaseService = b
 
+	// oplog-audit
+	ptt.SetUserNameGetter(getUserName)
+
 	return backend, nil
 }
 
@@ -44,9 +44,9 @@ const (
 
 // db
 var (
-	dbAccount *pttdb.LDBDatabase = nil
+	dbAccount pttdb.KVDatabase = nil
 
-	dbMeta *pttdb.LDBDatabase = nil
+	dbMeta pttdb.KVDatabase = nil
 
 	DBUserNamePrefix = []byte(".urnm")
 	DBUserImgPrefix  = []byte(".urim")
@@ -55,20 +55,31 @@ var (
 	DBUserNodeIdxPrefix = []byte(".unix")
 )
 
-func InitAccount(dataDir string) error {
-	var err error
-
-	dbAccount, err = pttdb.NewLDBDatabase("account", dataDir, 0, 0)
+func InitAccount(dataDir string, migrateOpts *pttdb.MigrateOptions) error {
+	account, err := pttdb.OpenDatabase("account", dataDir)
 	if err != nil {
 		return err
 	}
 
-	dbMeta, err = pttdb.NewLDBDatabase("accountmeta", dataDir, 0, 0)
+	meta, err := pttdb.OpenDatabase("accountmeta", dataDir)
 	if err != nil {
+		account.Close()
 		return err
 	}
 
-	return nil
+	InitAccountWithDB(account, meta)
+
+	return pttdb.MigrateAll(dbMeta, migrateOpts, dbAccount)
+}
+
+/*
+InitAccountWithDB inits the account with the given dbs.
+
+It can be used with pttdb.MemLDBDatabase in tests.
+*/
+func InitAccountWithDB(account pttdb.KVDatabase, meta pttdb.KVDatabase) {
+	dbAccount = account
+	dbMeta = meta
 }
 
 func TeardownAccount() {
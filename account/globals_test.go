@@ -18,11 +18,11 @@ package account
 
 import (
 	"crypto/ecdsa"
-	"os"
 	"testing"
 
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/pttdb"
 )
 
 const ()
@@ -81,7 +81,7 @@ func setupTest(t *testing.T) {
 	tTsD = types.Timestamp{Ts: 4, NanoTs: 8}
 	tUserNameD, _ = NewUserName(tUserIDD, tTsD)
 
-	InitAccount("./test.out")
+	InitAccountWithDB(pttdb.NewMemLDBDatabase("account"), pttdb.NewMemLDBDatabase("accountmeta"))
 
 }
 
@@ -89,6 +89,4 @@ func teardownTest(t *testing.T) {
 	types.RandRead = origRandRead
 
 	TeardownAccount()
-
-	os.RemoveAll("./test.out")
 }
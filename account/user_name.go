@@ -207,6 +207,16 @@ func (u *UserName) GetList(id *types.PttID, limit int, listOrder pttdb.ListOrder
 	return userNames, nil
 }
 
+func getUserName(id *types.PttID) ([]byte, error) {
+	u := &UserName{}
+	err := u.Get(id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.Name, nil
+}
+
 func isValidName(name []byte) bool {
 	if utf8.RuneCount(name) > MaxNameLength {
 		return false
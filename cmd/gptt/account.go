@@ -0,0 +1,284 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ailabstw/go-pttai/cmd/utils"
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/me"
+	"github.com/ailabstw/go-pttai/node"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+/*
+accountImport is the account import command.
+
+The key file is either encrypted (with the key passphrase) or plain hex.
+The postfix is from <file>.postfix if exists, or derived from the key as a new id.
+*/
+func accountImport(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return ErrInvalidArgs
+	}
+
+	cfg, err := loadAndSetStoppedConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	keyfile := cfg.Me.ResolvePath(me.DataDirPrivateKey)
+	if _, err := os.Stat(keyfile); err == nil {
+		return ErrKeyExists
+	}
+
+	keyPassphrase := utils.NewKeyPassphrase(ctx)
+
+	filename := ctx.Args().First()
+	key, _, err := crypto.LoadECDSAWithPassphrase(filename, keyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	postfixBytes, err := ioutil.ReadFile(filename + ".postfix")
+	if os.IsNotExist(err) {
+		id, err := types.NewPttIDFromKey(key)
+		if err != nil {
+			return err
+		}
+		postfixBytes = id[common.AddressLength:]
+	} else if err != nil {
+		return err
+	}
+
+	id, err := types.NewPttIDFromKeyPostfix(key, postfixBytes)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := getKeyPassphrase(keyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(cfg.Me.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	err = saveEncryptedKey(keyfile, key, postfixBytes, passphrase)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ID: %v\n", pttIDString(id))
+
+	return nil
+}
+
+/*
+accountExport is the account export command.
+
+My key is exported encrypted with the key passphrase, with the postfix in <file>.postfix.
+*/
+func accountExport(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return ErrInvalidArgs
+	}
+
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	filename := ctx.Args().First()
+	if _, err := os.Stat(filename); err == nil {
+		return ErrFileExists
+	}
+
+	keyfile := cfg.Me.ResolvePath(me.DataDirPrivateKey)
+	if _, err := os.Stat(keyfile); err != nil {
+		return ErrNoKey
+	}
+
+	keyPassphrase := utils.NewKeyPassphrase(ctx)
+
+	key, _, err := crypto.LoadECDSAWithPassphrase(keyfile, keyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	postfixBytes, err := ioutil.ReadFile(keyfile + ".postfix")
+	if err != nil {
+		return err
+	}
+
+	id, err := types.NewPttIDFromKeyPostfix(key, postfixBytes)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := getKeyPassphrase(keyPassphrase)
+	if err != nil {
+		return err
+	}
+
+	err = saveEncryptedKey(filename, key, postfixBytes, passphrase)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ID: %v\n", pttIDString(id))
+
+	return nil
+}
+
+/*
+accountPasswd is the account passwd command.
+
+My key and the node key are unlocked with the current key passphrase (if encrypted),
+and encrypted again with the new passphrase.
+*/
+func accountPasswd(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return ErrInvalidArgs
+	}
+
+	cfg, err := loadAndSetStoppedConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	keyPassphrase := utils.NewKeyPassphrase(ctx)
+
+	keyfiles := []string{
+		cfg.Me.ResolvePath(me.DataDirPrivateKey),
+		cfg.Node.ResolvePath(node.DataDirPrivateKey),
+	}
+
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, keyfile := range keyfiles {
+		if _, err := os.Stat(keyfile); err != nil {
+			continue
+		}
+
+		key, _, err := crypto.LoadECDSAWithPassphrase(keyfile, keyPassphrase)
+		if err != nil {
+			return fmt.Errorf("%v: %v", keyfile, err)
+		}
+		keys[keyfile] = key
+	}
+	if len(keys) == 0 {
+		return ErrNoKey
+	}
+
+	passphrase, err := getNewKeyPassphrase(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, keyfile := range keyfiles {
+		key, ok := keys[keyfile]
+		if !ok {
+			continue
+		}
+
+		err = crypto.SaveEncryptedECDSA(keyfile, key, passphrase)
+		if err != nil {
+			return fmt.Errorf("%v: %v", keyfile, err)
+		}
+
+		fmt.Printf("Encrypted: %v\n", keyfile)
+	}
+
+	return nil
+}
+
+/*
+loadAndSetStoppedConfig loads the config and ensures that the node is not running,
+so that the key files are not replaced under the running node.
+*/
+func loadAndSetStoppedConfig(ctx *cli.Context) (*Config, error) {
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dialNode(cfg)
+	if err == nil {
+		client.Close()
+		return nil, ErrNodeRunning
+	}
+
+	return cfg, nil
+}
+
+/*
+getKeyPassphrase gets the key passphrase without prompting (from the file, the env,
+or the unlocked key), or prompts the new passphrase.
+*/
+func getKeyPassphrase(keyPassphrase crypto.KeyPassphrase) (string, error) {
+	passphrase, err := keyPassphrase(false)
+	if err == crypto.ErrNoPassphrase {
+		return utils.PromptNewPassphrase("New key passphrase: ")
+	}
+
+	return passphrase, err
+}
+
+/*
+getNewKeyPassphrase gets the new key passphrase from --newpassphrasefile, the env, or the terminal prompt.
+*/
+func getNewKeyPassphrase(ctx *cli.Context) (string, error) {
+	if filename := ctx.String(newKeyPassphraseFileFlag.Name); filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		passphrase := strings.TrimRight(string(content), "\r\n")
+		if passphrase == "" {
+			return "", crypto.ErrNoPassphrase
+		}
+		return passphrase, nil
+	}
+
+	if passphrase := os.Getenv(NewKeyPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	return utils.PromptNewPassphrase("New key passphrase: ")
+}
+
+func saveEncryptedKey(filename string, key *ecdsa.PrivateKey, postfixBytes []byte, passphrase string) error {
+	if passphrase == "" {
+		return crypto.ErrNoPassphrase
+	}
+
+	err := crypto.SaveEncryptedECDSA(filename, key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename+".postfix", postfixBytes, 0600)
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/rpc"
+	"golang.org/x/crypto/ssh/terminal"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+/*
+backup is the backup command.
+
+If the node is running, the backup is done by the ptt_backup rpc through the ipc-endpoint.
+Otherwise the dbs are opened directly.
+*/
+func backup(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return ErrInvalidArgs
+	}
+
+	filename, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := getPassphrase(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	manifest := &pttdb.BackupManifest{}
+
+	client, err := dialNode(cfg)
+	if err == nil {
+		defer client.Close()
+
+		log.Info("backup: online", "file", filename)
+		err = client.Call(manifest, "ptt_backup", filename, passphrase)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Info("backup: offline", "file", filename)
+
+		teardown, err := initDBs(cfg, nil)
+		defer teardown()
+		if err != nil {
+			return err
+		}
+
+		manifest, err = pttdb.Backup(filename, cfg.DB.RootDir, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	printBackupManifest(manifest)
+
+	return nil
+}
+
+/*
+restore is the restore command. The node must not be running.
+*/
+func restore(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return ErrInvalidArgs
+	}
+
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialNode(cfg)
+	if err == nil {
+		client.Close()
+		return ErrNodeRunning
+	}
+
+	passphrase, err := getPassphrase(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := pttdb.Restore(ctx.Args().First(), cfg.DB.RootDir, passphrase)
+	if err != nil {
+		return err
+	}
+
+	printBackupManifest(manifest)
+
+	return nil
+}
+
+/*
+dialNode dials the ipc-endpoint of the running node.
+*/
+func dialNode(cfg *Config) (*rpc.Client, error) {
+	endpoint := cfg.Node.IPCEndpoint()
+	if endpoint == "" {
+		return nil, ErrNoIPCEndpoint
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DialNodeTimeout)
+	defer cancel()
+
+	return rpc.DialIPC(ctx, endpoint)
+}
+
+/*
+getPassphrase gets the passphrase from --passphrasefile, the env, or the terminal prompt.
+*/
+func getPassphrase(ctx *cli.Context, isConfirm bool) (string, error) {
+	if filename := ctx.String(passphraseFileFlag.Name); filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := promptPassphrase("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+
+	if !isConfirm {
+		return passphrase, nil
+	}
+
+	confirm, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", ErrPassphraseMismatch
+	}
+
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", ErrNoPassphrase
+	}
+
+	fmt.Fprint(os.Stdout, prompt)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stdout)
+	if err != nil {
+		return "", err
+	}
+
+	return string(passphrase), nil
+}
+
+func printBackupManifest(manifest *pttdb.BackupManifest) {
+	fmt.Printf("Version: %v\n", manifest.Version)
+	fmt.Printf("Time: %v\n", time.Unix(int64(manifest.TS.Ts), int64(manifest.TS.NanoTs)).UTC().Format(time.RFC3339))
+	for _, item := range manifest.Items {
+		kind := "file"
+		if item.IsDB {
+			kind = "db"
+		}
+		fmt.Printf("%-4v %-40v %10v %x\n", kind, item.Path, item.N, item.Hash)
+	}
+}
@@ -28,6 +28,8 @@ import (
 	"github.com/ailabstw/go-pttai/friend"
 	"github.com/ailabstw/go-pttai/me"
 	"github.com/ailabstw/go-pttai/node"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/pttstats"
 	pkgservice "github.com/ailabstw/go-pttai/service"
 	"github.com/naoina/toml"
 	cli "gopkg.in/urfave/cli.v1"
@@ -41,6 +43,8 @@ type Config struct {
 	Friend  *friend.Config
 	Ptt     *pkgservice.Config
 	Utils   *utils.Config
+	DB      *pttdb.Config
+	Stats   *pttstats.Config
 }
 
 func NewConfig(ctx *cli.Context) (*Config, error) {
@@ -52,6 +56,8 @@ func NewConfig(ctx *cli.Context) (*Config, error) {
 		Friend:  &friend.DefaultConfig,
 		Ptt:     &pkgservice.DefaultConfig,
 		Utils:   &utils.DefaultConfig,
+		DB:      &pttdb.DefaultConfig,
+		Stats:   &pttstats.DefaultConfig,
 	}, nil
 }
 
@@ -77,6 +83,34 @@ func loadConfig(ctx *cli.Context) (*Config, error) {
 	return &cfg, err
 }
 
+// loadAndSetConfig loads the config file and sets the config with the flags.
+func loadAndSetConfig(ctx *cli.Context) (*Config, error) {
+	cfg, err := loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.SetUtilsConfig(ctx, cfg.Utils)
+
+	utils.SetNodeConfig(ctx, cfg.Node)
+
+	utils.SetMeConfig(ctx, cfg.Me, cfg.Node)
+
+	utils.SetAccountConfig(ctx, cfg.Account, cfg.Node)
+
+	utils.SetContentConfig(ctx, cfg.Content, cfg.Node)
+
+	utils.SetFriendConfig(ctx, cfg.Friend, cfg.Node)
+
+	utils.SetPttConfig(ctx, cfg.Ptt, cfg.Node, gitCommit)
+
+	utils.SetDBConfig(ctx, cfg.DB, cfg.Node)
+
+	utils.SetStatsConfig(ctx, cfg.Stats)
+
+	return cfg, nil
+}
+
 // dumpConfig is the dumpconfig command.
 func dumpConfig(ctx *cli.Context) error {
 	cfg, err := NewConfig(ctx)
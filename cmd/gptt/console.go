@@ -0,0 +1,119 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ailabstw/go-pttai/cmd/utils"
+	"github.com/ailabstw/go-pttai/console"
+	"github.com/ailabstw/go-pttai/rpc"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+/*
+remoteConsole is the attach command, with the javascript-console connected to the running node.
+
+The endpoint is the ipc-endpoint of the data-dir if not specified.
+*/
+func remoteConsole(ctx *cli.Context) error {
+	if len(ctx.Args()) > 1 {
+		return ErrInvalidArgs
+	}
+
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	endpoint := ctx.Args().First()
+	if endpoint == "" {
+		endpoint = cfg.Node.IPCEndpoint()
+	}
+	if endpoint == "" {
+		return ErrNoIPCEndpoint
+	}
+
+	client, err := dialEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	config := console.Config{
+		DataDir: cfg.Node.DataDir,
+		DocRoot: ".",
+		Client:  client,
+		Preload: makeConsolePreloads(ctx),
+	}
+
+	// exec
+	if script := ctx.String(utils.ExecFlag.Name); script != "" {
+		c, err := console.New(config)
+		if err != nil {
+			return err
+		}
+		defer c.Stop()
+
+		return c.Evaluate(script)
+	}
+
+	// interactive
+	config.Prompter = console.NewTerminalPrompter()
+	c, err := console.New(config)
+	if err != nil {
+		config.Prompter.Close()
+		return err
+	}
+	defer c.Stop()
+
+	c.Welcome()
+	c.Interactive()
+
+	return nil
+}
+
+/*
+dialEndpoint dials the ipc / http / ws endpoint.
+*/
+func dialEndpoint(endpoint string) (*rpc.Client, error) {
+	endpoint = strings.TrimPrefix(endpoint, "ipc:")
+
+	ctx, cancel := context.WithTimeout(context.Background(), DialNodeTimeout)
+	defer cancel()
+
+	return rpc.DialContext(ctx, endpoint)
+}
+
+func makeConsolePreloads(ctx *cli.Context) []string {
+	preloadStr := ctx.String(utils.PreloadJSFlag.Name)
+	if preloadStr == "" {
+		return nil
+	}
+
+	preloads := make([]string, 0)
+	for _, file := range strings.Split(preloadStr, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		preloads = append(preloads, file)
+	}
+
+	return preloads
+}
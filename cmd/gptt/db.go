@@ -0,0 +1,747 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ailabstw/go-pttai/account"
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/hexutil"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/content"
+	"github.com/ailabstw/go-pttai/friend"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/me"
+	"github.com/ailabstw/go-pttai/pttdb"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+/**********
+ * stores
+ **********/
+
+type dbStore struct {
+	Name    string
+	DataDir func(cfg *Config) string
+}
+
+func pttDataDir(cfg *Config) string      { return cfg.Ptt.DataDir }
+func accountDataDir(cfg *Config) string  { return cfg.Account.DataDir }
+func contentDataDir(cfg *Config) string  { return cfg.Content.DataDir }
+func keystoreDataDir(cfg *Config) string { return cfg.Content.KeystoreDir }
+func friendDataDir(cfg *Config) string   { return cfg.Friend.DataDir }
+func meDataDir(cfg *Config) string       { return cfg.Me.DataDir }
+
+/*
+dbStores are the dbs opened by the services in Init*.
+*/
+var dbStores = []*dbStore{
+	{"oplog", pttDataDir},
+	{"meta", pttDataDir},
+	{"account", accountDataDir},
+	{"accountmeta", accountDataDir},
+	{"board", contentDataDir},
+	{"comment", contentDataDir},
+	{"key", keystoreDataDir},
+	{"contentmeta", contentDataDir},
+	{"friend", friendDataDir},
+	{"friendmeta", friendDataDir},
+	{"friendkey", friendDataDir},
+	{"me", meDataDir},
+	{"mynodes", meDataDir},
+	{"raft", meDataDir},
+	{"memeta", meDataDir},
+	{"signkey", meDataDir},
+}
+
+type openedDBStore struct {
+	Name string
+	DB   pttdb.KVDatabase
+}
+
+/*
+openDBStores opens the dbs read-only, all the existing dbs if name is empty.
+
+The node must not be running. teardown is always returned, and closes the opened dbs.
+*/
+func openDBStores(cfg *Config, name string) ([]*openedDBStore, func(), error) {
+	opened := make([]*openedDBStore, 0, len(dbStores))
+	var store *pttdb.LDBDatabase
+	teardown := func() {
+		for _, each := range opened {
+			each.DB.Close()
+		}
+		if store != nil {
+			store.Close()
+		}
+	}
+
+	client, err := dialNode(cfg)
+	if err == nil {
+		client.Close()
+		return nil, teardown, ErrNodeRunning
+	}
+
+	store, err = pttdb.OpenReadOnlyStore(cfg.DB)
+	if err != nil {
+		return nil, teardown, err
+	}
+
+	for _, each := range dbStores {
+		if name != "" && each.Name != name {
+			continue
+		}
+
+		db, err := pttdb.OpenReadOnlyDatabase(store, each.Name, each.DataDir(cfg))
+		if err != nil && name == "" {
+			log.Warn("openDBStores: unable to open", "name", each.Name, "e", err)
+			continue
+		}
+		if err != nil {
+			return nil, teardown, err
+		}
+
+		opened = append(opened, &openedDBStore{Name: each.Name, DB: db})
+	}
+
+	if name != "" && len(opened) == 0 {
+		return nil, teardown, ErrInvalidDBStore
+	}
+
+	return opened, teardown, nil
+}
+
+/**********
+ * prefixes
+ **********/
+
+type dbRecordKind int
+
+const (
+	dbKindJSON dbRecordKind = iota
+	dbKindOplog
+	dbKindIndex
+	dbKindMerkle
+	dbKindKeyInfo
+)
+
+type dbPrefix struct {
+	Name string
+	Kind dbRecordKind
+}
+
+/*
+dbPrefixes are the known prefixes of the keys, by the first pttdb.SizeDBKeyPrefix bytes.
+*/
+var dbPrefixes = newDBPrefixes()
+
+func newDBPrefixes() map[string]*dbPrefix {
+	prefixes := make(map[string]*dbPrefix)
+	add := func(prefix []byte, name string, kind dbRecordKind) {
+		if _, ok := prefixes[string(prefix)]; ok {
+			return
+		}
+		prefixes[string(prefix)] = &dbPrefix{Name: name, Kind: kind}
+	}
+
+	addOplog := func(name string, oplogPrefix []byte, idxPrefix []byte, merklePrefix []byte) {
+		add(oplogPrefix, name+"-oplog", dbKindOplog)
+		add(oplogPrefixWithPostfix(oplogPrefix, 'i'), name+"-oplog-internal", dbKindOplog)
+		add(oplogPrefixWithPostfix(oplogPrefix, 'm'), name+"-oplog-pending", dbKindOplog)
+		add(oplogPrefixWithPostfix(oplogPrefix, 'f'), name+"-oplog-failed", dbKindOplog)
+		add(idxPrefix, name+"-oplog-idx", dbKindIndex)
+		add(merklePrefix, name+"-merkle", dbKindMerkle)
+	}
+
+	// oplogs
+	addOplog("op-key", pkgservice.DBOpKeyOplogPrefix, pkgservice.DBOpKeyIdxOplogPrefix, pkgservice.DBOpKeyMerkleOplogPrefix)
+	addOplog("master", pkgservice.DBMasterOplogPrefix, pkgservice.DBMasterIdxOplogPrefix, pkgservice.DBMasterMerkleOplogPrefix)
+	addOplog("me", pkgservice.DBMeOplogPrefix, pkgservice.DBMeIdxOplogPrefix, pkgservice.DBMeMerkleOplogPrefix)
+	addOplog("ptt", pkgservice.DBPttOplogPrefix, pkgservice.DBPttIdxOplogPrefix, pkgservice.DBPttMerkleOplogPrefix)
+	addOplog("content-node", content.DBNodeOplogPrefix, content.DBNodeIdxOplogPrefix, content.DBNodeMerkleOplogPrefix)
+	addOplog("board", content.DBBoardOplogPrefix, content.DBBoardIdxOplogPrefix, content.DBBoardMerkleOplogPrefix)
+	addOplog("comment", content.DBCommentOplogPrefix, content.DBCommentIdxOplogPrefix, content.DBCommentMerkleOplogPrefix)
+	addOplog("board-master", content.DBMasterOplogPrefix, content.DBMasterIdxOplogPrefix, content.DBMasterMerkleOplogPrefix)
+	addOplog("board-member", content.DBMemberOplogPrefix, content.DBMemberIdxOplogPrefix, content.DBMemberMerkleOplogPrefix)
+	addOplog("friend", friend.DBFriendOplogPrefix, friend.DBFriendIdxOplogPrefix, friend.DBFriendMerkleOplogPrefix)
+
+	// service
+	add(pkgservice.DBOpKeyPrefix, "op-key", dbKindKeyInfo)
+	add(pkgservice.DBOpKeyIdxPrefix, "op-key-idx", dbKindIndex)
+	add(pkgservice.DBOpKeyIdx2Prefix, "op-key-idx2", dbKindJSON)
+	add(pkgservice.DBNewestMasterLogIDPrefix, "newest-master-log-id", dbKindJSON)
+	add(pkgservice.DBApprovalPolicyPrefix, "approval-policy", dbKindJSON)
+	add(pkgservice.DBCountPttOplogPrefix, "count-ptt-oplog", dbKindJSON)
+	add(pkgservice.DBLocalePrefix, "locale", dbKindJSON)
+	add(pkgservice.DBPttLogSeenPrefix, "ptt-oplog-seen", dbKindJSON)
+	add(pkgservice.DBPrunedOplogPrefix, "pruned-oplog", dbKindJSON)
+	add(pkgservice.DBOplogSnapshotPrefix, "oplog-snapshot", dbKindJSON)
+	add(pkgservice.DBMailboxPrefix, "mailbox", dbKindJSON)
+	add(pkgservice.DBMailboxReceiptPrefix, "mailbox-receipt", dbKindJSON)
+	add(pkgservice.DBMerkleGenerateTimePrefix, "merkle-generate-time", dbKindJSON)
+	add(pkgservice.DBMerkleSyncTimePrefix, "merkle-sync-time", dbKindJSON)
+	add(pkgservice.DBMerkleFailSyncTimePrefix, "merkle-fail-sync-time", dbKindJSON)
+	add(pttdb.DBSchemaVersionPrefix, "schema-version", dbKindJSON)
+
+	// account
+	add(account.DBUserNamePrefix, "user-name", dbKindJSON)
+	add(account.DBUserImgPrefix, "user-img", dbKindJSON)
+	add(account.DBUserNodePrefix, "user-node", dbKindJSON)
+	add(account.DBUserNodeIdxPrefix, "user-node-idx", dbKindIndex)
+
+	// content
+	add(content.DBBoardPrefix, "board", dbKindJSON)
+	add(content.DBBoardIdxPrefix, "board-idx", dbKindIndex)
+	add(content.DBBoardIdx2Prefix, "board-idx2", dbKindJSON)
+	add(content.DBBoardLastSeenPrefix, "board-last-seen", dbKindJSON)
+	add(content.DBBoardArticleCreateTSPrefix, "board-article-create-ts", dbKindJSON)
+	add(content.DBBoardCommentCreateTSPrefix, "board-comment-create-ts", dbKindJSON)
+	add(content.DBArticlePrefix, "article", dbKindJSON)
+	add(content.DBArticleIdxPrefix, "article-idx", dbKindIndex)
+	add(content.DBArticleLastSeenPrefix, "article-last-seen", dbKindJSON)
+	add(content.DBArticleCommentCreateTSPrefix, "article-comment-create-ts", dbKindJSON)
+	add(content.DBPushPrefix, "push", dbKindJSON)
+	add(content.DBBooPrefix, "boo", dbKindJSON)
+	add(content.DBCommentPrefix, "comment", dbKindJSON)
+	add(content.DBCommentIdxPrefix, "comment-idx", dbKindIndex)
+	add(content.DBReplyPrefix, "reply", dbKindJSON)
+	add(content.DBReplyIdxPrefix, "reply-idx", dbKindIndex)
+	add(content.DBImagePrefix, "image", dbKindJSON)
+	add(content.DBImageIdxPrefix, "image-idx", dbKindIndex)
+	add(content.DBMediaPrefix, "media", dbKindJSON)
+	add(content.DBMediaIdxPrefix, "media-idx", dbKindIndex)
+	add(content.DBContentBlockPrefix, "content-block", dbKindJSON)
+	add(content.DBMasterPrefix, "board-master", dbKindJSON)
+	add(content.DBMasterIdxPrefix, "board-master-idx", dbKindIndex)
+	add(content.DBMemberPrefix, "board-member", dbKindJSON)
+	add(content.DBMemberIdxPrefix, "board-member-idx", dbKindIndex)
+
+	// friend
+	add(friend.DBFriendPrefix, "friend", dbKindJSON)
+	add(friend.DBFriendIdxPrefix, "friend-idx", dbKindIndex)
+	add(friend.DBFriendIdx2Prefix, "friend-idx2", dbKindJSON)
+
+	// me
+	add(me.DBMePrefix, "me", dbKindJSON)
+	add(me.DBMyNodePrefix, "my-node", dbKindJSON)
+	add(me.DBRaftPrefix, "raft", dbKindJSON)
+	add(me.DBKeyRaftHardState, "raft-hard-state", dbKindJSON)
+
+	return prefixes
+}
+
+/*
+oplogPrefixWithPostfix returns the prefix of the oplogs with the status (i: internal-pending, m: pending, f: failed).
+*/
+func oplogPrefixWithPostfix(prefix []byte, postfix byte) []byte {
+	theBytes := common.CloneBytes(prefix)
+	theBytes[pttdb.SizeDBKeyPrefix-1] = postfix
+	return theBytes
+}
+
+func getDBPrefix(key []byte) (string, *dbPrefix) {
+	if len(key) < pttdb.SizeDBKeyPrefix {
+		return "", nil
+	}
+
+	prefix := string(key[:pttdb.SizeDBKeyPrefix])
+	return prefix, dbPrefixes[prefix]
+}
+
+/**********
+ * records
+ **********/
+
+type dbRecord struct {
+	Store    string        `json:"s"`
+	Prefix   string        `json:"P"`
+	Name     string        `json:"N"`
+	PrefixID *types.PttID  `json:"pID,omitempty"`
+	Key      hexutil.Bytes `json:"K"`
+	Record   interface{}   `json:"R"`
+	Err      string        `json:"E,omitempty"`
+}
+
+type dbMerkleNode struct {
+	Level     pkgservice.MerkleTreeLevel `json:"L"`
+	TS        types.Timestamp            `json:"T"`
+	Addr      hexutil.Bytes              `json:"A"`
+	UpdateTS  types.Timestamp            `json:"UT"`
+	NChildren uint32                     `json:"N"`
+	Key       hexutil.Bytes              `json:"K,omitempty"`
+}
+
+/*
+decodeDBRecord decodes the key / value into the typed record by the prefix of the key.
+*/
+func decodeDBRecord(store string, key []byte, value []byte) *dbRecord {
+	prefix, p := getDBPrefix(key)
+	record := &dbRecord{
+		Store:  store,
+		Prefix: prefix,
+		Key:    common.CloneBytes(key),
+	}
+
+	kind := dbKindJSON
+	if p != nil {
+		record.Name = p.Name
+		kind = p.Kind
+	}
+
+	if kind != dbKindJSON && len(key) >= pttdb.SizeDBKeyPrefix+types.SizePttID {
+		prefixID := &types.PttID{}
+		copy(prefixID[:], key[pttdb.SizeDBKeyPrefix:])
+		record.PrefixID = prefixID
+	}
+
+	// the merkle-tree of the op-key oplogs shares the prefix with the oplogs.
+	if kind == dbKindOplog && !json.Valid(value) {
+		kind = dbKindMerkle
+	}
+
+	var err error
+	switch kind {
+	case dbKindOplog:
+		oplog := &pkgservice.Oplog{}
+		err = oplog.Unmarshal(value)
+		record.Record = oplog
+	case dbKindIndex:
+		idx := &pttdb.Index{}
+		err = idx.Unmarshal(value)
+		record.Record = idx
+	case dbKindMerkle:
+		record.Record, err = decodeDBMerkleNode(key, value)
+	case dbKindKeyInfo:
+		keyInfo := &pkgservice.KeyInfo{}
+		err = json.Unmarshal(value, keyInfo)
+		keyInfo.KeyBytes = nil // not to dump the private key.
+		record.Record = keyInfo
+	default:
+		if json.Valid(value) {
+			record.Record = json.RawMessage(common.CloneBytes(value))
+		} else {
+			record.Record = hexutil.Bytes(common.CloneBytes(value))
+		}
+	}
+	if err != nil {
+		record.Record = hexutil.Bytes(common.CloneBytes(value))
+		record.Err = err.Error()
+	}
+
+	return record
+}
+
+/*
+decodeDBMerkleNode decodes the merkle-node with the key: prefix:prefixID:level:ts(:oplogID:op).
+*/
+func decodeDBMerkleNode(key []byte, value []byte) (*dbMerkleNode, error) {
+	offsetTS := pttdb.SizeDBKeyPrefix + types.SizePttID + pkgservice.SizeMerkleTreeLevel
+	if len(key) < offsetTS+types.SizeTimestamp {
+		return nil, ErrInvalidDBRecord
+	}
+	if len(value) < pkgservice.MerkleTreeOffsetTS+types.SizeTimestamp+pkgservice.SizeMerkleTreeNChildren {
+		return nil, ErrInvalidDBRecord
+	}
+
+	ts, err := types.UnmarshalTimestamp(key[offsetTS:(offsetTS + types.SizeTimestamp)])
+	if err != nil {
+		return nil, err
+	}
+
+	node := &pkgservice.MerkleNode{}
+	err = node.Unmarshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dbMerkleNode{
+		Level:     node.Level,
+		TS:        ts,
+		Addr:      node.Addr,
+		UpdateTS:  node.UpdateTS,
+		NChildren: node.NChildren,
+		Key:       node.Key,
+	}, nil
+}
+
+/*
+forEachDBRecord iterates the records of the db with the prefix, all the records if prefix is empty.
+*/
+func forEachDBRecord(db pttdb.KVDatabase, prefix []byte, f func(key []byte, value []byte) error) error {
+	iter, err := db.NewIteratorWithPrefix(nil, prefix, pttdb.ListOrderNext)
+	if err != nil {
+		return err
+	}
+	defer iter.Release()
+
+	for iter.Next() {
+		err = f(iter.Key(), iter.Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
+
+/**********
+ * commands
+ **********/
+
+/*
+dbContext opens the dbs of the args (all if no args) read-only, and runs f with the opened dbs.
+*/
+func dbContext(ctx *cli.Context, maxArgs int, f func(stores []*openedDBStore) error) error {
+	if len(ctx.Args()) > maxArgs {
+		return ErrInvalidArgs
+	}
+
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	stores, teardown, err := openDBStores(cfg, ctx.Args().First())
+	defer teardown()
+	if err != nil {
+		return err
+	}
+
+	return f(stores)
+}
+
+type dbStat struct {
+	Store  string `json:"s"`
+	Prefix string `json:"P"`
+	Name   string `json:"N"`
+	Count  int    `json:"C"`
+	Bytes  int    `json:"B"`
+}
+
+/*
+dbStats is the db stats command, counting the records by the prefixes.
+*/
+func dbStats(ctx *cli.Context) error {
+	return dbContext(ctx, 1, func(stores []*openedDBStore) error {
+		stats := make([]*dbStat, 0)
+		for _, store := range stores {
+			statMap := make(map[string]*dbStat)
+			err := forEachDBRecord(store.DB, nil, func(key []byte, value []byte) error {
+				prefix, p := getDBPrefix(key)
+				stat, ok := statMap[prefix]
+				if !ok {
+					stat = &dbStat{Store: store.Name, Prefix: prefix}
+					if p != nil {
+						stat.Name = p.Name
+					}
+					statMap[prefix] = stat
+				}
+				stat.Count++
+				stat.Bytes += len(key) + len(value)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			storeStats := make([]*dbStat, 0, len(statMap))
+			for _, stat := range statMap {
+				storeStats = append(storeStats, stat)
+			}
+			sort.Slice(storeStats, func(i, j int) bool {
+				return storeStats[i].Prefix < storeStats[j].Prefix
+			})
+			stats = append(stats, storeStats...)
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, stats)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STORE\tPREFIX\tNAME\tRECORDS\tBYTES")
+		for _, stat := range stats {
+			fmt.Fprintf(tw, "%v\t%q\t%v\t%v\t%v\n", stat.Store, stat.Prefix, stat.Name, stat.Count, stat.Bytes)
+		}
+		return tw.Flush()
+	})
+}
+
+/*
+dbDump is the db dump command, decoding the records of the store with the prefix.
+*/
+func dbDump(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return ErrInvalidArgs
+	}
+
+	prefix := []byte(ctx.Args().Get(1))
+
+	return dbContext(ctx, 2, func(stores []*openedDBStore) error {
+		records := make([]*dbRecord, 0)
+		for _, store := range stores {
+			err := forEachDBRecord(store.DB, prefix, func(key []byte, value []byte) error {
+				records = append(records, decodeDBRecord(store.Name, key, value))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, records)
+		}
+
+		return printDBRecords(os.Stdout, records)
+	})
+}
+
+/*
+dbMerkle is the db merkle command, dumping the merkle-trees of the store by the levels.
+*/
+func dbMerkle(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return ErrInvalidArgs
+	}
+
+	var entityID *types.PttID
+	if len(ctx.Args()) > 1 {
+		var err error
+		entityID, err = types.UnmarshalTextPttID([]byte(ctx.Args().Get(1)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return dbContext(ctx, 2, func(stores []*openedDBStore) error {
+		records := make([]*dbRecord, 0)
+		for _, store := range stores {
+			err := forEachDBRecord(store.DB, nil, func(key []byte, value []byte) error {
+				record := decodeDBRecord(store.Name, key, value)
+				if _, ok := record.Record.(*dbMerkleNode); !ok {
+					return nil
+				}
+				if entityID != nil && !reflect.DeepEqual(record.PrefixID, entityID) {
+					return nil
+				}
+				records = append(records, record)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// by the trees, and then by the levels.
+		sort.SliceStable(records, func(i, j int) bool {
+			a, b := records[i], records[j]
+			if a.Prefix != b.Prefix {
+				return a.Prefix < b.Prefix
+			}
+			if cmp := bytes.Compare(a.PrefixID[:], b.PrefixID[:]); cmp != 0 {
+				return cmp < 0
+			}
+			return a.Record.(*dbMerkleNode).Level > b.Record.(*dbMerkleNode).Level
+		})
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, records)
+		}
+
+		return printDBMerkle(os.Stdout, records)
+	})
+}
+
+type dbVerifyResult struct {
+	NOplogs int         `json:"N"`
+	Failed  []*dbRecord `json:"F"`
+}
+
+/*
+dbVerify is the db verify command, verifying the signatures of all the oplogs.
+*/
+func dbVerify(ctx *cli.Context) error {
+	return dbContext(ctx, 1, func(stores []*openedDBStore) error {
+		result := &dbVerifyResult{Failed: make([]*dbRecord, 0)}
+		for _, store := range stores {
+			err := forEachDBRecord(store.DB, nil, func(key []byte, value []byte) error {
+				_, p := getDBPrefix(key)
+				if p == nil || p.Kind != dbKindOplog {
+					return nil
+				}
+
+				record := decodeDBRecord(store.Name, key, value)
+				switch oplog := record.Record.(type) {
+				case *dbMerkleNode:
+					return nil
+				case *pkgservice.Oplog:
+					err := oplog.Verify()
+					if err != nil {
+						record.Err = err.Error()
+					}
+				}
+
+				result.NOplogs++
+				if record.Err != "" {
+					result.Failed = append(result.Failed, record)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, result)
+		}
+
+		fmt.Fprintf(os.Stdout, "oplogs: %v failed: %v\n", result.NOplogs, len(result.Failed))
+		if len(result.Failed) == 0 {
+			return nil
+		}
+		fmt.Fprintln(os.Stdout)
+
+		return printDBRecords(os.Stdout, result.Failed)
+	})
+}
+
+type dbOrphanIndex struct {
+	Store       string          `json:"s"`
+	Name        string          `json:"N"`
+	IdxKey      hexutil.Bytes   `json:"I"`
+	MissingKeys []hexutil.Bytes `json:"M,omitempty"`
+	Err         string          `json:"E,omitempty"`
+}
+
+/*
+dbOrphans is the db orphans command, reporting the index-entries referring to the missing records.
+*/
+func dbOrphans(ctx *cli.Context) error {
+	return dbContext(ctx, 1, func(stores []*openedDBStore) error {
+		idxPrefixes := make([]string, 0)
+		for prefix, p := range dbPrefixes {
+			if p.Kind == dbKindIndex {
+				idxPrefixes = append(idxPrefixes, prefix)
+			}
+		}
+		sort.Strings(idxPrefixes)
+
+		orphans := make([]*dbOrphanIndex, 0)
+		for _, store := range stores {
+			for _, prefix := range idxPrefixes {
+				eachOrphans, err := pttdb.FindOrphanIndexes(store.DB, []byte(prefix))
+				if err != nil {
+					return err
+				}
+				for _, orphan := range eachOrphans {
+					missingKeys := make([]hexutil.Bytes, len(orphan.MissingKeys))
+					for i, key := range orphan.MissingKeys {
+						missingKeys[i] = key
+					}
+					orphans = append(orphans, &dbOrphanIndex{
+						Store:       store.Name,
+						Name:        dbPrefixes[prefix].Name,
+						IdxKey:      orphan.IdxKey,
+						MissingKeys: missingKeys,
+						Err:         orphan.Err,
+					})
+				}
+			}
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, orphans)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STORE\tNAME\tIDX-KEY\tMISSING")
+		for _, orphan := range orphans {
+			missing := orphan.Err
+			for _, key := range orphan.MissingKeys {
+				if missing != "" {
+					missing += ","
+				}
+				missing += key.String()
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", orphan.Store, orphan.Name, orphan.IdxKey, missing)
+		}
+		return tw.Flush()
+	})
+}
+
+func printDBRecords(w io.Writer, records []*dbRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tNAME\tKEY\tRECORD")
+	for _, record := range records {
+		value := record.Err
+		if value == "" {
+			marshaled, err := json.Marshal(record.Record)
+			if err != nil {
+				return err
+			}
+			value = truncateString(string(marshaled), MaxDBRecordWidth)
+		}
+
+		name := record.Name
+		if name == "" {
+			name = fmt.Sprintf("%q", record.Prefix)
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", record.Store, name, record.Key, value)
+	}
+
+	return tw.Flush()
+}
+
+func printDBMerkle(w io.Writer, records []*dbRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tNAME\tENTITY\tLEVEL\tTS\tCHILDREN\tADDR\tUPDATE")
+	for _, record := range records {
+		node := record.Record.(*dbMerkleNode)
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", record.Store, record.Name, pttIDString(record.PrefixID), merkleLevelName(node.Level), timestampString(node.TS), node.NChildren, node.Addr, timestampString(node.UpdateTS))
+	}
+
+	return tw.Flush()
+}
+
+func merkleLevelName(level pkgservice.MerkleTreeLevel) string {
+	name, ok := merkleLevelNames[level]
+	if !ok {
+		return fmt.Sprintf("%v", level)
+	}
+
+	return name
+}
+
+func truncateString(str string, width int) string {
+	if len(str) <= width {
+		return str
+	}
+
+	return str[:width] + "..."
+}
@@ -16,4 +16,17 @@
 
 package main
 
-var ()
+import "errors"
+
+var (
+	ErrInvalidArgs        = errors.New("invalid args")
+	ErrNodeRunning        = errors.New("node is running")
+	ErrNoIPCEndpoint      = errors.New("no ipc endpoint")
+	ErrNoPassphrase       = errors.New("no passphrase (not a terminal)")
+	ErrInvalidDBStore     = errors.New("invalid db store")
+	ErrInvalidDBRecord    = errors.New("invalid db record")
+	ErrPassphraseMismatch = errors.New("passphrases do not match")
+	ErrKeyExists          = errors.New("key already exists")
+	ErrNoKey              = errors.New("no key")
+	ErrFileExists         = errors.New("file already exists")
+)
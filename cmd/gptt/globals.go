@@ -19,20 +19,35 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"time"
 	"unicode"
 
 	"github.com/ailabstw/go-pttai/account"
 	"github.com/ailabstw/go-pttai/cmd/utils"
+	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/content"
 	"github.com/ailabstw/go-pttai/friend"
 	"github.com/ailabstw/go-pttai/me"
 	"github.com/ailabstw/go-pttai/node"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/pttstats"
 	pkgservice "github.com/ailabstw/go-pttai/service"
 	"github.com/naoina/toml"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
-const ()
+const (
+	// PassphraseEnv is the env of the passphrase of the backups.
+	PassphraseEnv = "PTT_PASSPHRASE"
+
+	// NewKeyPassphraseEnv is the env of the new passphrase of the keys (gptt account passwd).
+	NewKeyPassphraseEnv = "PTT_NEW_KEY_PASSPHRASE"
+
+	DialNodeTimeout = 3 * time.Second
+
+	// MaxDBRecordWidth is the max width of the records in the table of gptt db.
+	MaxDBRecordWidth = 120
+)
 
 // config
 var (
@@ -44,6 +59,44 @@ var (
 		Friend:  &friend.DefaultConfig,
 		Ptt:     &pkgservice.DefaultConfig,
 		Utils:   &utils.DefaultConfig,
+		DB:      &pttdb.DefaultConfig,
+		Stats:   &pttstats.DefaultConfig,
+	}
+)
+
+var (
+	peerTypeNames = map[pkgservice.PeerType]string{
+		pkgservice.PeerTypeErr:       "err",
+		pkgservice.PeerTypeRemoved:   "removed",
+		pkgservice.PeerTypeRandom:    "random",
+		pkgservice.PeerTypeMember:    "member",
+		pkgservice.PeerTypeImportant: "important",
+		pkgservice.PeerTypeMe:        "me",
+	}
+
+	merkleLevelNames = map[pkgservice.MerkleTreeLevel]string{
+		pkgservice.MerkleTreeLevelNow:   "now",
+		pkgservice.MerkleTreeLevelHR:    "hr",
+		pkgservice.MerkleTreeLevelDay:   "day",
+		pkgservice.MerkleTreeLevelMonth: "month",
+		pkgservice.MerkleTreeLevelYear:  "year",
+	}
+
+	statusNames = map[types.Status]string{
+		types.StatusInvalid:         "invalid",
+		types.StatusInit:            "init",
+		types.StatusInternalSync:    "internal-sync",
+		types.StatusInternalPending: "internal-pending",
+		types.StatusPending:         "pending",
+		types.StatusSync:            "sync",
+		types.StatusAlive:           "alive",
+		types.StatusFailed:          "failed",
+		types.StatusPendingTransfer: "pending-transfer",
+		types.StatusPendingMigrate:  "pending-migrate",
+		types.StatusInternalDeleted: "internal-deleted",
+		types.StatusPendingDeleted:  "pending-deleted",
+		types.StatusDeleted:         "deleted",
+		types.StatusMigrated:        "migrated",
 	}
 )
 
@@ -54,11 +107,62 @@ var (
 		Usage: "TOML configuration file",
 	}
 
+	migrateDryRunFlag = cli.BoolFlag{
+		Name:  "dryrun",
+		Usage: "Check the db migrations without writing to the db",
+	}
+
+	consoleFlags = []cli.Flag{
+		utils.ExecFlag,
+		utils.PreloadJSFlag,
+	}
+
+	jsonFlag = cli.BoolFlag{
+		Name:  "json",
+		Usage: "Output in json instead of the table",
+	}
+
+	oplogTypeFlag = cli.StringFlag{
+		Name:  "type",
+		Usage: "Type of the oplogs (master, opKey)",
+		Value: "master",
+	}
+
+	startFlag = cli.StringFlag{
+		Name:  "start",
+		Usage: "Id of the oplog to list from (included), for the next page",
+	}
+
+	limitFlag = cli.IntFlag{
+		Name:  "limit",
+		Usage: "Max number of the oplogs (0 for all)",
+	}
+
+	reverseFlag = cli.BoolFlag{
+		Name:  "reverse",
+		Usage: "List from the latest oplog",
+	}
+
+	dbFlags = append(append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag), jsonFlag)
+
+	passphraseFileFlag = cli.StringFlag{
+		Name:  "passphrasefile",
+		Usage: "File containing the passphrase (also from the env " + PassphraseEnv + ", or prompted)",
+	}
+
+	newKeyPassphraseFileFlag = cli.StringFlag{
+		Name:  "newpassphrasefile",
+		Usage: "File containing the new key passphrase (also from the env " + NewKeyPassphraseEnv + ", or prompted)",
+	}
+
+	accountFlags = append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag)
+
 	// flags that configure me
 	meFlags = []cli.Flag{
 		utils.MyDataDirFlag,
 		utils.MyKeyFileFlag,
 		utils.MyKeyHexFlag,
+		utils.KeyPassphraseFileFlag,
 		utils.ServerFlag,
 	}
 
@@ -87,6 +191,18 @@ var (
 
 		utils.CacheFlag,
 		utils.CacheDatabaseFlag,
+		utils.SingleDBFlag,
+		utils.OplogRetentionFlag,
+		utils.OplogSignRLPFlag,
+		utils.RelayBandwidthFlag,
+		utils.RelayPeerBandwidthFlag,
+		utils.MailboxFlag,
+		utils.MailboxQuotaFlag,
+		utils.MailboxUserQuotaFlag,
+		utils.MailboxSenderQuotaFlag,
+		utils.MailboxExpireFlag,
+		utils.HealthMinPeersFlag,
+		utils.HealthMaxSyncLagFlag,
 		utils.CacheGCFlag,
 
 		utils.PttStatsURLFlag,
@@ -97,6 +213,7 @@ var (
 		utils.MetricsInfluxDBUsernameFlag,
 		utils.MetricsInfluxDBPasswordFlag,
 		utils.MetricsInfluxDBHostTagFlag,
+		utils.MetricsAddrFlag,
 	}
 
 	// flags that configure p2p-network
@@ -115,6 +232,7 @@ var (
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
+		utils.TopicDiscoveryFlag,
 		utils.NetrestrictFlag,
 	}
 
@@ -126,6 +244,7 @@ var (
 		utils.RPCCORSDomainFlag,
 		utils.RPCVirtualHostsFlag,
 		utils.ExternRPCPortFlag,
+		utils.RPCNoAuthFlag,
 
 		utils.RPCApiFlag,
 
@@ -170,6 +289,243 @@ The output of this command is supposed to be machine-readable.
 		Category:    "MISCELLANEOUS COMMANDS",
 		Description: `The dumpconfig command shows configuration values.`,
 	}
+
+	migrateCommand = cli.Command{
+		Action:    utils.MigrateFlags(migrate),
+		Name:      "migrate",
+		Usage:     "Migrate the db schemas of the data-dir",
+		ArgsUsage: " ",
+		Flags:     append(append(append(nodeFlags, meFlags...), contentFlags...), migrateDryRunFlag),
+		Category:  "DATABASE COMMANDS",
+		Description: `
+The migrate command runs the pending db migrations without starting the node.
+The migrations run on every start of gptt as well.
+
+With --dryrun, the migrations are checked and logged without writing to the db.
+`,
+	}
+
+	backupCommand = cli.Command{
+		Action:    utils.MigrateFlags(backup),
+		Name:      "backup",
+		Usage:     "Backup the data-dir to an encrypted archive",
+		ArgsUsage: "<file>",
+		Flags:     append(append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag), passphraseFileFlag),
+		Category:  "DATABASE COMMANDS",
+		Description: `
+The backup command writes the snapshots of all the dbs, and the private key of me,
+to an archive encrypted with the passphrase.
+
+If the node is running, the backup is done online through the ipc-endpoint (ptt_backup).
+`,
+	}
+
+	attachCommand = cli.Command{
+		Action:    utils.MigrateFlags(remoteConsole),
+		Name:      "attach",
+		Usage:     "Start an interactive JavaScript console (connect to the node)",
+		ArgsUsage: "[endpoint]",
+		Flags:     append(append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag), consoleFlags...),
+		Category:  "CONSOLE COMMANDS",
+		Description: `
+The attach command starts the JavaScript console connected to the running node,
+through the endpoint (ipc path, http:// or ws://), or the ipc-endpoint of the data-dir.
+
+The rpc-apis are in the namespaces of the rpc-modules (ex: ptt.getVersion()),
+with the tab-completion. send(method, params...) calls any rpc-method directly.
+
+With --exec, the statement is evaluated and the console exits.
+With --preload, the comma-separated scripts are loaded before the console starts.
+`,
+	}
+
+	peersCommand = cli.Command{
+		Action:    utils.MigrateFlags(peers),
+		Name:      "peers",
+		Usage:     "List the peers of the running node",
+		ArgsUsage: " ",
+		Flags:     append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag, jsonFlag),
+		Category:  "NODE COMMANDS",
+		Description: `
+The peers command lists the numbers of the peers by the peer-types,
+and the peers of the running node (ptt_countPeers and ptt_getPeers).
+`,
+	}
+
+	entitiesCommand = cli.Command{
+		Action:    utils.MigrateFlags(entities),
+		Name:      "entities",
+		Usage:     "List the registered entities of the running node",
+		ArgsUsage: " ",
+		Flags:     append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag, jsonFlag),
+		Category:  "NODE COMMANDS",
+		Description: `
+The entities command lists the registered entities of the running node,
+with the hashes of the op-keys and the last sync-time (ptt_getEntities).
+`,
+	}
+
+	keysCommand = cli.Command{
+		Action:    utils.MigrateFlags(keys),
+		Name:      "keys",
+		Usage:     "List the op-keys and the join-keys of the running node",
+		ArgsUsage: "[entity]",
+		Flags:     append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag, jsonFlag),
+		Category:  "NODE COMMANDS",
+		Description: `
+The keys command lists the op-keys and the join-keys with the expire-time,
+of the entity or of all the registered entities (ptt_getKeys).
+`,
+	}
+
+	oplogsCommand = cli.Command{
+		Action:    utils.MigrateFlags(oplogs),
+		Name:      "oplogs",
+		Usage:     "List the oplogs of the entity of the running node",
+		ArgsUsage: "<entity>",
+		Flags:     append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag, jsonFlag, oplogTypeFlag, startFlag, limitFlag, reverseFlag),
+		Category:  "NODE COMMANDS",
+		Description: `
+The oplogs command lists the oplogs of the entity with the verification results (ptt_getOplogAudit).
+`,
+	}
+
+	dbCommand = cli.Command{
+		Name:      "db",
+		Usage:     "Inspect the dbs of the data-dir offline",
+		ArgsUsage: "",
+		Category:  "DATABASE COMMANDS",
+		Description: `
+The db commands open the dbs of the data-dir read-only without starting the node,
+and decode the records by the prefixes of the keys.
+
+The node must not be running. The stores are: oplog, meta, account, accountmeta, board, comment,
+key, contentmeta, friend, friendmeta, friendkey, me, mynodes, raft, memeta, signkey.
+Without the store, all the existing stores are inspected.
+`,
+		Subcommands: []cli.Command{
+			{
+				Action:      utils.MigrateFlags(dbStats),
+				Name:        "stats",
+				Usage:       "Count the records by the prefixes",
+				ArgsUsage:   "[store]",
+				Flags:       dbFlags,
+				Description: `The stats command counts the records and the bytes by the prefixes of the keys.`,
+			},
+			{
+				Action:    utils.MigrateFlags(dbDump),
+				Name:      "dump",
+				Usage:     "Dump the decoded records",
+				ArgsUsage: "<store> [prefix]",
+				Flags:     dbFlags,
+				Description: `
+The dump command decodes the records of the store with the prefix (ex: .ptlg) into the typed records:
+the oplogs, the indexes, the merkle-nodes and the op-keys (without the private keys).
+`,
+			},
+			{
+				Action:    utils.MigrateFlags(dbMerkle),
+				Name:      "merkle",
+				Usage:     "Dump the merkle-trees of the oplogs by the levels",
+				ArgsUsage: "<store> [entity]",
+				Flags:     dbFlags,
+				Description: `
+The merkle command dumps the merkle-trees of the oplogs of the store (of the entity),
+from the year-level to the leaves.
+`,
+			},
+			{
+				Action:    utils.MigrateFlags(dbVerify),
+				Name:      "verify",
+				Usage:     "Verify the signatures of the oplogs",
+				ArgsUsage: "[store]",
+				Flags:     dbFlags,
+				Description: `
+The verify command verifies the hashes and the signatures of all the oplogs,
+and lists the oplogs failed in the verification or unable to be decoded.
+`,
+			},
+			{
+				Action:    utils.MigrateFlags(dbOrphans),
+				Name:      "orphans",
+				Usage:     "Report the orphaned index-entries",
+				ArgsUsage: "[store]",
+				Flags:     dbFlags,
+				Description: `
+The orphans command reports the index-entries referring to the missing records,
+or unable to be decoded, left by the records written out of TryPutAll / DeleteAll.
+`,
+			},
+		},
+	}
+
+	accountCommand = cli.Command{
+		Name:      "account",
+		Usage:     "Manage my key and the node key",
+		ArgsUsage: "",
+		Category:  "ACCOUNT COMMANDS",
+		Description: `
+The keys are saved in the data-dir encrypted with the key passphrase (scrypt and AES-GCM).
+The key passphrase is from --keypassphrasefile, the env ` + utils.KeyPassphraseEnv + `, or prompted.
+
+The unencrypted keys of the old data-dirs are still loaded, and are encrypted
+when the node starts with the key passphrase set, or with gptt account passwd.
+`,
+		Subcommands: []cli.Command{
+			{
+				Action:    utils.MigrateFlags(accountImport),
+				Name:      "import",
+				Usage:     "Import my key from a key file",
+				ArgsUsage: "<keyfile>",
+				Flags:     accountFlags,
+				Description: `
+The import command imports my key from the key file (encrypted or plain hex),
+with the postfix from <keyfile>.postfix if exists.
+
+The node must not be running, and the data-dir must not have my key.
+`,
+			},
+			{
+				Action:    utils.MigrateFlags(accountExport),
+				Name:      "export",
+				Usage:     "Export my key to an encrypted key file",
+				ArgsUsage: "<keyfile>",
+				Flags:     accountFlags,
+				Description: `
+The export command writes my key encrypted with the key passphrase to the key file,
+and the postfix to <keyfile>.postfix.
+`,
+			},
+			{
+				Action:    utils.MigrateFlags(accountPasswd),
+				Name:      "passwd",
+				Usage:     "Change the passphrase of my key and the node key",
+				ArgsUsage: " ",
+				Flags:     append(accountFlags, newKeyPassphraseFileFlag),
+				Description: `
+The passwd command unlocks my key and the node key with the key passphrase,
+and encrypts them with the new passphrase (also for the unencrypted keys).
+
+The node must not be running.
+`,
+			},
+		},
+	}
+
+	restoreCommand = cli.Command{
+		Action:    utils.MigrateFlags(restore),
+		Name:      "restore",
+		Usage:     "Restore the data-dir from an encrypted archive",
+		ArgsUsage: "<file>",
+		Flags:     append(append(append(append(nodeFlags, meFlags...), contentFlags...), utils.IPCPathFlag), passphraseFileFlag),
+		Category:  "DATABASE COMMANDS",
+		Description: `
+The restore command verifies the checksums of the archive, and then replaces the dbs
+and the private key of me in the data-dir. The original ones are moved to <datadir>.old.<ts>.
+
+The node must not be running.
+`,
+	}
 )
 
 // toml-settings
@@ -31,6 +31,8 @@ import (
 	"github.com/ailabstw/go-pttai/me"
 	"github.com/ailabstw/go-pttai/node"
 	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/pttstats"
 	pkgservice "github.com/ailabstw/go-pttai/service"
 	cli "gopkg.in/urfave/cli.v1"
 )
@@ -39,28 +41,27 @@ func gptt(ctx *cli.Context) error {
 	log.Info("Ptt.ai: Hello world!")
 
 	// Load Config
-	cfg, err := loadConfig(ctx)
+	cfg, err := loadAndSetConfig(ctx)
 	if err != nil {
 		return err
 	}
 
-	utils.SetUtilsConfig(ctx, cfg.Utils)
-
-	utils.SetNodeConfig(ctx, cfg.Node)
-
-	utils.SetMeConfig(ctx, cfg.Me, cfg.Node)
-
-	utils.SetAccountConfig(ctx, cfg.Account, cfg.Node)
-
-	utils.SetContentConfig(ctx, cfg.Content, cfg.Node)
-
-	utils.SetFriendConfig(ctx, cfg.Friend, cfg.Node)
-
-	utils.SetPttConfig(ctx, cfg.Ptt, cfg.Node, gitCommit)
+	// Unlock keys
+	err = utils.SetKeys(ctx, cfg.Me, cfg.Node)
+	if err != nil {
+		return err
+	}
 
 	// Setup metrics
 	utils.SetupMetrics(ctx)
 
+	// Init store
+	err = pttdb.InitStore(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer pttdb.TeardownStore()
+
 	// new node
 	n, err := node.New(cfg.Node)
 	if err != nil {
@@ -72,11 +73,25 @@ func gptt(ctx *cli.Context) error {
 		return err
 	}
 
+	// register pttstats
+	if cfg.Stats.URL != "" {
+		if err := registerPttStats(n, cfg); err != nil {
+			return err
+		}
+	}
+
 	// node start
 	if err := n.Start(); err != nil {
 		return err
 	}
 
+	// http-server
+	httpServer := utils.NewHTTPServer(cfg.Utils, cfg.Node.HTTPVirtualHosts, n.RPCHandler, n.RPCAuthenticator)
+	if err := httpServer.Start(); err != nil {
+		return err
+	}
+	defer httpServer.Stop()
+
 	// set-signal
 	go setSignal(n)
 
@@ -96,8 +111,22 @@ func registerPtt(n *node.Node, cfg *Config) error {
 	})
 }
 
+func registerPttStats(n *node.Node, cfg *Config) error {
+	return n.Register(func(ctx *pkgservice.ServiceContext) (pkgservice.PttService, error) {
+		var ptt *pkgservice.BasePtt
+		if err := ctx.Service(&ptt); err != nil {
+			return nil, err
+		}
+
+		return pttstats.NewService(cfg.Stats, ptt)
+	})
+}
+
 func registerServices(ctx *pkgservice.ServiceContext, cfg *Config) (pkgservice.PttService, error) {
-	myNodeKey := cfg.Node.NodeKey()
+	myNodeKey, err := cfg.Node.NodeKey()
+	if err != nil {
+		return nil, err
+	}
 	myNodeID := discover.PubkeyID(&myNodeKey.PublicKey)
 
 	ptt, err := pkgservice.NewPtt(ctx, cfg.Ptt, &myNodeID)
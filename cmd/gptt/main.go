@@ -72,6 +72,16 @@ func InitApp() *cli.App {
 		versionCommand,
 		licenseCommand,
 		dumpConfigCommand,
+		migrateCommand,
+		backupCommand,
+		restoreCommand,
+		accountCommand,
+		dbCommand,
+		attachCommand,
+		peersCommand,
+		entitiesCommand,
+		keysCommand,
+		oplogsCommand,
 	}
 	sort.Sort(cli.CommandsByName(app.Commands))
 
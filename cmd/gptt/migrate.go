@@ -0,0 +1,109 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/ailabstw/go-pttai/account"
+	"github.com/ailabstw/go-pttai/content"
+	"github.com/ailabstw/go-pttai/friend"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/me"
+	"github.com/ailabstw/go-pttai/pttdb"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+/*
+migrate is the migrate command.
+
+The migrations run in Init* of each service, so migrate just inits and tears down the services without starting the node.
+With --dryrun, the migrations are checked without writing to the dbs.
+*/
+func migrate(ctx *cli.Context) error {
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrateOpts := &pttdb.MigrateOptions{
+		IsDryRun: ctx.Bool(migrateDryRunFlag.Name),
+	}
+
+	log.Info("migrate: start", "isDryRun", migrateOpts.IsDryRun)
+
+	teardown, err := initDBs(cfg, migrateOpts)
+	defer teardown()
+	if err != nil {
+		return err
+	}
+
+	log.Info("migrate: done", "isDryRun", migrateOpts.IsDryRun)
+
+	return nil
+}
+
+/*
+initDBs opens the dbs of all the services without starting the node, running the pending migrations.
+
+teardown is always returned, and closes the opened dbs.
+*/
+func initDBs(cfg *Config, migrateOpts *pttdb.MigrateOptions) (func(), error) {
+	teardowns := make([]func(), 0, 6)
+	teardown := func() {
+		for i := len(teardowns) - 1; i >= 0; i-- {
+			teardowns[i]()
+		}
+	}
+
+	err := pttdb.InitStore(cfg.DB)
+	if err != nil {
+		return teardown, err
+	}
+	teardowns = append(teardowns, pttdb.TeardownStore)
+
+	err = pkgservice.InitService(cfg.Ptt.DataDir, migrateOpts)
+	teardowns = append(teardowns, pkgservice.TeardownService)
+	if err != nil {
+		return teardown, err
+	}
+
+	err = account.InitAccount(cfg.Account.DataDir, migrateOpts)
+	teardowns = append(teardowns, account.TeardownAccount)
+	if err != nil {
+		return teardown, err
+	}
+
+	err = content.InitContent(cfg.Content.DataDir, cfg.Content.KeystoreDir, migrateOpts)
+	teardowns = append(teardowns, content.TeardownContent)
+	if err != nil {
+		return teardown, err
+	}
+
+	err = friend.InitFriend(cfg.Friend.DataDir, migrateOpts)
+	teardowns = append(teardowns, friend.TeardownFriend)
+	if err != nil {
+		return teardown, err
+	}
+
+	err = me.InitMe(cfg.Me.DataDir, migrateOpts)
+	teardowns = append(teardowns, me.TeardownMe)
+	if err != nil {
+		return teardown, err
+	}
+
+	return teardown, nil
+}
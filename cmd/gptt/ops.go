@@ -0,0 +1,284 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/rpc"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+type peersOutput struct {
+	Counts *pkgservice.BackendCountPeers `json:"C"`
+	Peers  []*pkgservice.BackendPeer     `json:"P"`
+}
+
+/*
+peers is the peers command (ptt_countPeers and ptt_getPeers).
+*/
+func peers(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return ErrInvalidArgs
+	}
+
+	return callNode(ctx, func(client *rpc.Client) error {
+		output := &peersOutput{}
+		err := client.Call(&output.Counts, "ptt_countPeers")
+		if err != nil {
+			return err
+		}
+
+		err = client.Call(&output.Peers, "ptt_getPeers")
+		if err != nil {
+			return err
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, output)
+		}
+
+		return printPeers(os.Stdout, output)
+	})
+}
+
+/*
+entities is the entities command (ptt_getEntities).
+*/
+func entities(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return ErrInvalidArgs
+	}
+
+	return callNode(ctx, func(client *rpc.Client) error {
+		var entities []*pkgservice.BackendEntity
+		err := client.Call(&entities, "ptt_getEntities")
+		if err != nil {
+			return err
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, entities)
+		}
+
+		return printEntities(os.Stdout, entities)
+	})
+}
+
+/*
+keys is the keys command (ptt_getKeys). Without the entity, the keys of all the entities are listed.
+*/
+func keys(ctx *cli.Context) error {
+	if len(ctx.Args()) > 1 {
+		return ErrInvalidArgs
+	}
+
+	return callNode(ctx, func(client *rpc.Client) error {
+		var keys []*pkgservice.BackendKey
+		err := client.Call(&keys, "ptt_getKeys", ctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, keys)
+		}
+
+		return printKeys(os.Stdout, keys)
+	})
+}
+
+/*
+oplogs is the oplogs command (ptt_getOplogAudit).
+*/
+func oplogs(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return ErrInvalidArgs
+	}
+
+	listOrder := pttdb.ListOrderNext
+	if ctx.Bool(reverseFlag.Name) {
+		listOrder = pttdb.ListOrderPrev
+	}
+
+	return callNode(ctx, func(client *rpc.Client) error {
+		var audits []*pkgservice.BackendOplogAudit
+		err := client.Call(&audits, "ptt_getOplogAudit", ctx.Args().First(), ctx.String(oplogTypeFlag.Name), nil, ctx.String(startFlag.Name), ctx.Int(limitFlag.Name), listOrder)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Bool(jsonFlag.Name) {
+			return printJSON(os.Stdout, audits)
+		}
+
+		return printOplogs(os.Stdout, audits)
+	})
+}
+
+/*
+callNode calls f with the client of the ipc-endpoint of the running node.
+*/
+func callNode(ctx *cli.Context, f func(client *rpc.Client) error) error {
+	cfg, err := loadAndSetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialNode(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return f(client)
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printPeers(w io.Writer, output *peersOutput) error {
+	counts := output.Counts
+	if counts != nil {
+		fmt.Fprintf(w, "me: %v important: %v member: %v random: %v\n\n", counts.MyPeers, counts.ImportantPeers, counts.MemberPeers, counts.RandomPeers)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tTYPE\tUSER\tADDR")
+	for _, peer := range output.Peers {
+		nodeID := ""
+		if peer.NodeID != nil {
+			nodeID = peer.NodeID.TerminalString()
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", nodeID, peerTypeName(peer.PeerType), pttIDString(peer.UserID), peer.Addr)
+	}
+
+	return tw.Flush()
+}
+
+func printEntities(w io.Writer, entities []*pkgservice.BackendEntity) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tPEERS\tOP-KEYS\tSYNC")
+	for _, entity := range entities {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", pttIDString(entity.ID), entity.Name, statusName(entity.Status), entity.NPeers, addressesString(entity.OpKeyHashes), timestampString(entity.SyncTS))
+	}
+
+	return tw.Flush()
+}
+
+func printKeys(w io.Writer, keys []*pkgservice.BackendKey) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTITY\tTYPE\tHASH\tUPDATE\tEXPIRE\tSTATUS")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", pttIDString(key.EntityID), key.Type, addressString(key.Hash), timestampString(key.UpdateTS), timestampString(key.ExpireTS), statusName(key.Status))
+	}
+
+	return tw.Flush()
+}
+
+func printOplogs(w io.Writer, audits []*pkgservice.BackendOplogAudit) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tOP\tOBJ\tDOER\tCREATE\tSTATUS\tVERIFIED")
+	for _, audit := range audits {
+		verified := "yes"
+		if !audit.IsVerified {
+			verified = "no: " + audit.VerifyErr
+		}
+
+		doer := pttIDString(audit.DoerID)
+		if len(audit.DoerName) != 0 {
+			doer += " (" + string(audit.DoerName) + ")"
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", pttIDString(audit.ID), audit.OpName, pttIDString(audit.ObjID), doer, timestampString(audit.CreateTS), statusName(audit.Status), verified)
+	}
+
+	return tw.Flush()
+}
+
+func pttIDString(id *types.PttID) string {
+	if id == nil {
+		return "-"
+	}
+
+	idBytes, err := id.MarshalText()
+	if err != nil {
+		return "-"
+	}
+
+	return string(idBytes)
+}
+
+func addressString(addr *common.Address) string {
+	if addr == nil {
+		return "-"
+	}
+
+	return addr.Hex()
+}
+
+func addressesString(addrs []*common.Address) string {
+	if len(addrs) == 0 {
+		return "-"
+	}
+
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = addressString(addr)
+	}
+
+	return strings.Join(strs, ",")
+}
+
+func timestampString(ts types.Timestamp) string {
+	if ts.Ts == 0 {
+		return "-"
+	}
+
+	return time.Unix(int64(ts.Ts), int64(ts.NanoTs)).UTC().Format(time.RFC3339)
+}
+
+func peerTypeName(peerType pkgservice.PeerType) string {
+	name, ok := peerTypeNames[peerType]
+	if !ok {
+		return fmt.Sprintf("%v", peerType)
+	}
+
+	return name
+}
+
+func statusName(status types.Status) string {
+	name, ok := statusNames[status]
+	if !ok {
+		return fmt.Sprintf("%v", status)
+	}
+
+	return name
+}
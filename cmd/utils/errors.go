@@ -20,4 +20,6 @@ import "errors"
 
 var (
 	ErrInvalidPath = errors.New("invalid path")
+
+	ErrPassphraseMismatch = errors.New("passphrases do not match")
 )
@@ -39,6 +39,7 @@ import (
 	"github.com/ailabstw/go-pttai/me"
 	"github.com/ailabstw/go-pttai/metrics"
 	"github.com/ailabstw/go-pttai/node"
+	pkgservice "github.com/ailabstw/go-pttai/service"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -89,10 +90,61 @@ var (
 		Usage: "my postfix (20 bytes)",
 	}
 
+	KeyPassphraseFileFlag = cli.StringFlag{
+		Name:  "keypassphrasefile",
+		Usage: "File containing the passphrase of my key and the node key (also from the env " + KeyPassphraseEnv + ", or prompted to unlock)",
+	}
+
 	ServerFlag = cli.BoolFlag{
 		Name:  "server",
 		Usage: "set as server mode",
 	}
+	RelayBandwidthFlag = cli.Uint64Flag{
+		Name:  "relay.bandwidth",
+		Usage: "Maximum bytes per second relayed for the NATed nodes in server mode (0 = no limit)",
+		Value: pkgservice.DefaultConfig.RelayMaxBytesPerSecond,
+	}
+	RelayPeerBandwidthFlag = cli.Uint64Flag{
+		Name:  "relay.peerbandwidth",
+		Usage: "Maximum bytes per second relayed from each peer in server mode (0 = no limit)",
+		Value: pkgservice.DefaultConfig.RelayMaxPeerBytesPerSecond,
+	}
+	MailboxFlag = cli.BoolFlag{
+		Name:  "mailbox",
+		Usage: "Store-and-forward the encrypted data for the offline users",
+	}
+	MailboxQuotaFlag = cli.Uint64Flag{
+		Name:  "mailbox.quota",
+		Usage: "Maximum bytes stored in the mailbox (0 = no limit)",
+		Value: pkgservice.DefaultConfig.MailboxQuotaBytes,
+	}
+	MailboxUserQuotaFlag = cli.Uint64Flag{
+		Name:  "mailbox.userquota",
+		Usage: "Maximum bytes stored in the mailbox for each recipient (0 = no limit)",
+		Value: pkgservice.DefaultConfig.MailboxUserQuotaBytes,
+	}
+	MailboxSenderQuotaFlag = cli.Uint64Flag{
+		Name:  "mailbox.senderquota",
+		Usage: "Maximum bytes stored in the mailbox from each sender (0 = no limit)",
+		Value: pkgservice.DefaultConfig.MailboxSenderQuotaBytes,
+	}
+	MailboxExpireFlag = cli.Uint64Flag{
+		Name:  "mailbox.expire",
+		Usage: "Maximum seconds to keep the data in the mailbox",
+		Value: pkgservice.DefaultConfig.MailboxMaxExpireSeconds,
+	}
+
+	// health
+	HealthMinPeersFlag = cli.IntFlag{
+		Name:  "health.minpeers",
+		Usage: "Minimum number of the ptt-peers to be ready in /readyz",
+		Value: pkgservice.DefaultConfig.HealthMinPeers,
+	}
+	HealthMaxSyncLagFlag = cli.Uint64Flag{
+		Name:  "health.maxsynclag",
+		Usage: "Maximum seconds since the last successful sync of the entities with peers to be healthy in /healthz (0 = no check)",
+		Value: pkgservice.DefaultConfig.HealthMaxSyncLagSeconds,
+	}
 
 	// Content settings
 	ContentDataDirFlag = DirectoryFlag{
@@ -118,6 +170,18 @@ var (
 		Usage: "Percentage of cache memory allowance to use for database io",
 		Value: 75,
 	}
+	SingleDBFlag = cli.BoolFlag{
+		Name:  "db.single",
+		Usage: "Run all the services on one LevelDB with namespaced keys, sharing the database cache (refused if the data-dir has the separated dbs)",
+	}
+	OplogRetentionFlag = cli.Uint64Flag{
+		Name:  "oplog.retention",
+		Usage: "Seconds to keep the superseded oplogs before compacting them into a snapshot (0 = no compaction, min 604800)",
+	}
+	OplogSignRLPFlag = cli.BoolFlag{
+		Name:  "oplog.signrlp",
+		Usage: "Signs the oplogs with the canonical rlp encoding (only after all the nodes are upgraded to verify it)",
+	}
 	CacheGCFlag = cli.IntFlag{
 		Name:  "cache.gc",
 		Usage: "Percentage of cache memory allowance to use for trie pruning",
@@ -166,6 +230,10 @@ var (
 		Usage: "InfluxDB `host` tag attached to all measurements",
 		Value: "localhost",
 	}
+	MetricsAddrFlag = cli.StringFlag{
+		Name:  "metrics.addr",
+		Usage: "Enable the Prometheus /metrics HTTP listener on the address, ex: 127.0.0.1:6060 (requires --metrics)",
+	}
 
 	// HTTP server
 	HTTPAddrFlag = cli.StringFlag{
@@ -210,6 +278,10 @@ var (
 		Usage: "Comma separated list of virtual hostnames from which to accept requests (server enforced). Accepts '*' wildcard.",
 		Value: strings.Join(node.DefaultConfig.HTTPVirtualHosts, ","),
 	}
+	RPCNoAuthFlag = cli.BoolFlag{
+		Name:  "rpcnoauth",
+		Usage: "Disable the bearer-token authentication of the HTTP-RPC and WS-RPC servers (tokens in <datadir>/gptt/rpc-tokens.json)",
+	}
 	RPCApiFlag = cli.StringFlag{
 		Name:  "rpcapi",
 		Usage: "API's offered over the HTTP-RPC interface",
@@ -308,6 +380,10 @@ var (
 		Name:  "v5disc",
 		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
 	}
+	TopicDiscoveryFlag = cli.BoolFlag{
+		Name:  "v5disc.topics",
+		Usage: "Registers and searches the topics of the public entities (ex: the public boards) on V5 discovery (requires --v5disc)",
+	}
 	NetrestrictFlag = cli.StringFlag{
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
@@ -16,7 +16,11 @@
 
 package utils
 
-import cli "gopkg.in/urfave/cli.v1"
+import (
+	"time"
+
+	cli "gopkg.in/urfave/cli.v1"
+)
 
 // default config
 var (
@@ -59,3 +63,54 @@ GLOBAL OPTIONS:
 const (
 	MaxUploadSize = 10000000 // 10MB
 )
+
+// http-server
+const (
+	HTTPAPIPath    = "/api"
+	HTTPConfigPath = "/config.json"
+	HTTPIndexFile  = "index.html"
+
+	HTTPFrontendTokenName   = "frontend"
+	HTTPFrontendTokenCookie = "gptt_token"
+	HTTPAdminTokenQuery     = "token" // the same as the token query of the rpc-auth
+
+	HTTPHealthzPath = "/healthz" // liveness
+	HTTPReadyzPath  = "/readyz"  // readiness
+
+	HTTPStaticMaxAge = "3600"
+
+	HTTPReadTimeout  = 5 * time.Second
+	HTTPWriteTimeout = 10 * time.Second
+	HTTPIdleTimeout  = 120 * time.Second
+
+	HTTPHealthTimeout = 5 * time.Second
+)
+
+// HTTPFrontendScopes are the scopes of the frontend-token, the methods called by the frontend.
+//
+// The admin / debug, the keys / join-materials, the oplog-maintenance
+// and the node-control (shutdown / restart / backup / relay / topic) are not included.
+var HTTPFrontendScopes = []string{
+	"me_*",
+	"friend_*",
+	"content_*",
+	"account_getUserName*",
+	"account_getUserImg*",
+	"ptt_getVersion",
+	"ptt_getGitCommit",
+	"ptt_getPeers",
+	"ptt_countPeers",
+	"ptt_getEntities",
+	"ptt_getMailboxStats",
+	"ptt_health",
+}
+
+// metrics
+const (
+	MetricsHTTPPath = "/metrics"
+)
+
+// key passphrase
+const (
+	KeyPassphraseEnv = "PTT_KEY_PASSPHRASE"
+)
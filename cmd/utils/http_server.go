@@ -0,0 +1,407 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/rpc"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+)
+
+/*
+HTTPConfig is the config for the frontend, served in HTTPConfigPath.
+
+The frontend-token for RPCURL is not in the config, but in the cookie HTTPFrontendTokenCookie (see setFrontendCookie).
+*/
+type HTTPConfig struct {
+	ExternHTTPAddr string `json:"ExternHTTPAddr"`
+	RPCURL         string `json:"RPCURL"`
+}
+
+/*
+HTTPServer serves the single-page-app from HTTPDir on HTTPAddr,
+with the json-rpc of the node proxied in HTTPAPIPath,
+and the liveness / readiness of ptt in HTTPHealthzPath / HTTPReadyzPath.
+*/
+type HTTPServer struct {
+	cfg *Config
+
+	getRPCHandler func() (*rpc.Server, error)
+	getRPCAuth    func() *rpc.Authenticator
+
+	frontendToken *rpc.AuthToken
+
+	vhosts rpc.VirtualHosts
+
+	listener net.Listener
+	server   *http.Server
+}
+
+/*
+NewHTTPServer creates the http-server.
+The rpc-handler is retrieved for each request because the node may restart with the new handler.
+HTTPAPIPath requires the same auth tokens as the HTTP-RPC (no auth if getRPCAuth returns nil),
+or the cookie of the frontend-token generated on Start.
+HTTPAPIPath and HTTPConfigPath require the same virtual-hosts as the HTTP-RPC (vhosts), and the host of ExternHTTPAddr.
+*/
+func NewHTTPServer(cfg *Config, vhosts []string, getRPCHandler func() (*rpc.Server, error), getRPCAuth func() *rpc.Authenticator) *HTTPServer {
+	if host := externHost(cfg.ExternHTTPAddr); host != "" {
+		vhosts = append([]string{host}, vhosts...)
+	}
+
+	return &HTTPServer{
+		cfg:           cfg,
+		getRPCHandler: getRPCHandler,
+		getRPCAuth:    getRPCAuth,
+		vhosts:        rpc.NewVirtualHosts(vhosts),
+	}
+}
+
+/*
+externHost returns the host (without the scheme and the port) of ExternHTTPAddr.
+*/
+func externHost(externHTTPAddr string) string {
+	if i := strings.Index(externHTTPAddr, "://"); i >= 0 {
+		externHTTPAddr = externHTTPAddr[i+3:]
+	}
+	externHTTPAddr = strings.SplitN(externHTTPAddr, "/", 2)[0]
+
+	host, _, err := net.SplitHostPort(externHTTPAddr)
+	if err != nil {
+		return externHTTPAddr
+	}
+
+	return host
+}
+
+func (s *HTTPServer) Start() error {
+	if s.cfg.HTTPAddr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.cfg.HTTPAddr)
+	if err != nil {
+		return err
+	}
+
+	err = s.setFrontendToken()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:      s,
+		ReadTimeout:  HTTPReadTimeout,
+		WriteTimeout: HTTPWriteTimeout,
+		IdleTimeout:  HTTPIdleTimeout,
+	}
+
+	go s.server.Serve(listener)
+
+	log.Info("HTTP server opened", "url", "http://"+s.cfg.HTTPAddr, "dir", s.cfg.HTTPDir)
+
+	return nil
+}
+
+func (s *HTTPServer) Stop() error {
+	if s.server == nil {
+		return nil
+	}
+
+	err := s.server.Close()
+	s.server = nil
+	s.listener = nil
+
+	log.Info("HTTP server closed", "url", "http://"+s.cfg.HTTPAddr)
+
+	return err
+}
+
+/*
+setFrontendToken generates the frontend-token with HTTPFrontendScopes.
+The token is not persisted and changes whenever the http-server restarts.
+*/
+func (s *HTTPServer) setFrontendToken() error {
+	token, err := rpc.NewAuthToken(HTTPFrontendTokenName, HTTPFrontendScopes)
+	if err != nil {
+		return err
+	}
+
+	s.frontendToken = token
+
+	return nil
+}
+
+/*
+getFrontendCookieToken returns the frontend-token if the request carries the cookie of the frontend-token.
+*/
+func (s *HTTPServer) getFrontendCookieToken(r *http.Request) (*rpc.AuthToken, bool) {
+	if s.frontendToken == nil {
+		return nil, false
+	}
+
+	cookie, err := r.Cookie(HTTPFrontendTokenCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(s.frontendToken.Token)) != 1 {
+		return nil, false
+	}
+
+	return s.frontendToken, true
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+
+	switch {
+	case urlPath == HTTPAPIPath || strings.HasPrefix(urlPath, HTTPAPIPath+"/"):
+		s.serveAPI(w, r)
+	case urlPath == HTTPConfigPath:
+		s.serveConfig(w, r)
+	case urlPath == HTTPHealthzPath:
+		s.serveHealth(w, r, false)
+	case urlPath == HTTPReadyzPath:
+		s.serveHealth(w, r, true)
+	default:
+		s.serveStatic(w, r, urlPath)
+	}
+}
+
+/*
+checkVHost rejects the requests with the invalid Host-header (DNS rebinding) as the HTTP-RPC.
+*/
+func (s *HTTPServer) checkVHost(w http.ResponseWriter, r *http.Request) bool {
+	if s.vhosts.IsValid(r) {
+		return true
+	}
+
+	http.Error(w, "invalid host specified", http.StatusForbidden)
+	return false
+}
+
+func (s *HTTPServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.checkVHost(w, r) {
+		return
+	}
+
+	rpcHandler, err := s.getRPCHandler()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	auth := s.getRPCAuth()
+	if token, ok := s.getFrontendCookieToken(r); auth != nil && ok {
+		rpcHandler.ServeHTTP(w, r.WithContext(rpc.ContextWithAuthToken(r.Context(), token)))
+		return
+	}
+
+	rpc.NewAuthHandler(auth, rpcHandler).ServeHTTP(w, r)
+}
+
+/*
+serveConfig serves the config for the frontend.
+*/
+func (s *HTTPServer) serveConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.checkVHost(w, r) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	rpcURL := s.cfg.ExternHTTPAddr
+	if !strings.Contains(rpcURL, "://") {
+		rpcURL = "http://" + rpcURL
+	}
+	rpcURL = strings.TrimSuffix(rpcURL, "/") + HTTPAPIPath
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&HTTPConfig{
+		ExternHTTPAddr: s.cfg.ExternHTTPAddr,
+		RPCURL:         rpcURL,
+	})
+}
+
+/*
+serveHealth serves the ptt-health without the auth for the probes of the container-orchestration,
+with 200 if ptt is healthy (or ready if isReady), and 503 otherwise.
+
+Only the checks are served. The entities (ids and names) are available only in the authenticated ptt_health.
+*/
+func (s *HTTPServer) serveHealth(w http.ResponseWriter, r *http.Request, isReady bool) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	rpcHandler, err := s.getRPCHandler()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	client := rpc.DialInProc(rpcHandler)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), HTTPHealthTimeout)
+	defer cancel()
+
+	health := &pkgservice.BackendHealth{}
+	err = client.CallContext(ctx, health, "ptt_health")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	isOK := health.IsHealthy
+	if isReady {
+		isOK = health.IsReady
+	}
+
+	unauthHealth := &pkgservice.BackendHealth{
+		IsHealthy: health.IsHealthy,
+		IsReady:   health.IsReady,
+		TS:        health.TS,
+		Checks:    health.Checks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !isOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(unauthHealth)
+}
+
+/*
+serveStatic serves the files in HTTPDir.
+
+The paths without the corresponding files fall back to index.html for the routing in the single-page-app,
+except the paths with the extensions (missing assets).
+*/
+func (s *HTTPServer) serveStatic(w http.ResponseWriter, r *http.Request, urlPath string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := filepath.Join(s.cfg.HTTPDir, filepath.FromSlash(urlPath))
+	info, err := os.Stat(filename)
+	if err == nil && info.IsDir() {
+		filename = filepath.Join(filename, HTTPIndexFile)
+		info, err = os.Stat(filename)
+	}
+
+	if err != nil {
+		if path.Ext(urlPath) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		filename = filepath.Join(s.cfg.HTTPDir, HTTPIndexFile)
+	}
+
+	if filepath.Base(filename) == HTTPIndexFile && s.setFrontendCookie(w, r) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheControl(filename))
+	http.ServeFile(w, r, filename)
+}
+
+/*
+setFrontendCookie sets the frontend-token in the cookie of the index.html requested with the admin-token
+(?token=<admin-token>, the first visit of the frontend), and redirects to the url without the admin-token.
+Returns true if the request is handled.
+
+The cookie is HttpOnly, SameSite=Strict, and only sent to HTTPAPIPath.
+The frontend-token is never served without the admin-token, and the hosts not in the virtual-hosts (DNS rebinding)
+are rejected, so that neither the other hosts in the network nor the pages of the other sites get the token.
+*/
+func (s *HTTPServer) setFrontendCookie(w http.ResponseWriter, r *http.Request) bool {
+	auth := s.getRPCAuth()
+	if auth == nil || s.frontendToken == nil {
+		return false
+	}
+
+	if r.URL.Query().Get(HTTPAdminTokenQuery) == "" && r.Header.Get("Authorization") == "" {
+		return false
+	}
+
+	if !s.checkVHost(w, r) {
+		return true
+	}
+
+	token, ok := auth.Authenticate(r)
+	if !ok || !token.IsAdmin() {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gptt"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return true
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     HTTPFrontendTokenCookie,
+		Value:    s.frontendToken.Token,
+		Path:     HTTPAPIPath,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	query := r.URL.Query()
+	query.Del(HTTPAdminTokenQuery)
+	redirectURL := *r.URL
+	redirectURL.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirectURL.RequestURI(), http.StatusFound)
+
+	return true
+}
+
+var hashedFileRegexp = regexp.MustCompile(`[.-][0-9a-f]{8,}\.`)
+
+/*
+cacheControl returns the cache-control of the file.
+
+    1. index.html: always revalidate to get the new version of the app.
+    2. the file with the content-hash in the filename: immutable.
+    3. others: cached for a while.
+*/
+func cacheControl(filename string) string {
+	base := filepath.Base(filename)
+
+	switch {
+	case base == HTTPIndexFile:
+		return "no-cache"
+	case hashedFileRegexp.MatchString(base):
+		return "public, max-age=31536000, immutable"
+	default:
+		return "public, max-age=" + HTTPStaticMaxAge
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/rpc"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+)
+
+type HTTPTestAPI struct{}
+
+func (api *HTTPTestAPI) Echo(str string) string {
+	return str
+}
+
+type HTTPTestPttAPI struct{}
+
+func (api *HTTPTestPttAPI) Health() (*pkgservice.BackendHealth, error) {
+	id, _ := types.NewPttID()
+
+	return &pkgservice.BackendHealth{
+		IsHealthy: true,
+		IsReady:   false,
+		Checks:    []*pkgservice.BackendHealthCheck{{Name: pkgservice.HealthCheckPeers, IsReadiness: true}},
+		Entities:  []*pkgservice.BackendEntityHealth{{ID: id, Name: "board"}},
+	}, nil
+}
+
+func newTestHTTPServer(t *testing.T, auth *rpc.Authenticator) (*HTTPServer, func()) {
+	dir, err := ioutil.TempDir("", "gptt-http")
+	if err != nil {
+		t.Fatalf("unable to create dir: e: %v", err)
+	}
+
+	os.MkdirAll(filepath.Join(dir, "js"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, HTTPIndexFile), []byte("index"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "js", "app.0123abcd.js"), []byte("app"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "favicon.ico"), []byte("icon"), 0644)
+
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("test", &HTTPTestAPI{})
+	rpcServer.RegisterName("ptt", &HTTPTestPttAPI{})
+
+	cfg := &Config{
+		HTTPDir:        dir,
+		HTTPAddr:       "localhost:9774",
+		ExternHTTPAddr: "ptt.example.com:9774",
+	}
+	s := NewHTTPServer(cfg, []string{"localhost"}, func() (*rpc.Server, error) { return rpcServer, nil }, func() *rpc.Authenticator { return auth })
+
+	return s, func() {
+		rpcServer.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHTTPServer_ServeHTTP(t *testing.T) {
+	s, teardown := newTestHTTPServer(t, nil)
+	defer teardown()
+
+	tests := []struct {
+		path         string
+		code         int
+		body         string
+		cacheControl string
+	}{
+		{"/", http.StatusOK, "index", "no-cache"},
+		{"/board/abc", http.StatusOK, "index", "no-cache"},
+		{"/js/app.0123abcd.js", http.StatusOK, "app", "public, max-age=31536000, immutable"},
+		{"/favicon.ico", http.StatusOK, "icon", "public, max-age=" + HTTPStaticMaxAge},
+		{"/js/missing.js", http.StatusNotFound, "", ""},
+		{"/../../etc/passwd", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if w.Code != tt.code {
+			t.Errorf("ServeHTTP: %v: code: %v expected: %v", tt.path, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("ServeHTTP: %v: body: %v expected: %v", tt.path, w.Body.String(), tt.body)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != tt.cacheControl {
+			t.Errorf("ServeHTTP: %v: cache-control: %v expected: %v", tt.path, cc, tt.cacheControl)
+		}
+	}
+
+	// config
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost:9774"+HTTPConfigPath, nil))
+	httpConfig := &HTTPConfig{}
+	err := json.Unmarshal(w.Body.Bytes(), httpConfig)
+	if err != nil || httpConfig.RPCURL != "http://ptt.example.com:9774/api" {
+		t.Errorf("ServeHTTP: config: %v e: %v", httpConfig, err)
+	}
+
+	// api
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://localhost:9774"+HTTPAPIPath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["ptt"]}`))
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"result":"ptt"`) {
+		t.Errorf("ServeHTTP: api: code: %v body: %v", w.Code, w.Body.String())
+	}
+}
+
+func TestHTTPServer_VirtualHosts(t *testing.T) {
+	s, teardown := newTestHTTPServer(t, nil)
+	defer teardown()
+
+	tests := []struct {
+		host string
+		code int
+	}{
+		{"localhost:9774", http.StatusOK},
+		{"ptt.example.com:9774", http.StatusOK},
+		{"127.0.0.1:9774", http.StatusOK},
+		{"evil.example.com:9774", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		for _, urlPath := range []string{HTTPConfigPath, HTTPAPIPath} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://"+tt.host+urlPath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["ptt"]}`))
+			r.Header.Set("Content-Type", "application/json")
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("ServeHTTP: %v%v: code: %v expected: %v", tt.host, urlPath, w.Code, tt.code)
+			}
+		}
+	}
+}
+
+func TestHTTPServer_Auth(t *testing.T) {
+	adminToken, _ := rpc.NewAuthToken("admin", []string{rpc.AuthScopeAll})
+	readToken, _ := rpc.NewAuthToken("read", rpc.DefaultReadScopes)
+	auth, _ := rpc.NewAuthenticator([]*rpc.AuthToken{adminToken, readToken})
+
+	s, teardown := newTestHTTPServer(t, auth)
+	defer teardown()
+
+	err := s.setFrontendToken()
+	if err != nil {
+		t.Fatalf("setFrontendToken: e: %v", err)
+	}
+
+	getURL := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	callAPI := func(method string, token string, cookie *http.Cookie) (int, string) {
+		params := `[]`
+		if method == "test_echo" {
+			params = `["ptt"]`
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://localhost:9774"+HTTPAPIPath, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":`+params+`}`))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		s.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	// config: no token.
+	w := getURL("http://localhost:9774" + HTTPConfigPath)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), s.frontendToken.Token) {
+		t.Errorf("ServeHTTP: config: code: %v body: %v", w.Code, w.Body.String())
+	}
+
+	// index: the cookie only with the admin-token.
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"http://localhost:9774/", http.StatusOK},
+		{"http://localhost:9774/?token=" + readToken.Token, http.StatusUnauthorized},
+		{"http://localhost:9774/?token=invalid", http.StatusUnauthorized},
+		{"http://evil.example.com:9774/?token=" + adminToken.Token, http.StatusForbidden},
+		{"http://localhost:9774/board/abc?token=" + adminToken.Token + "&x=1", http.StatusFound},
+	}
+	var cookie *http.Cookie
+	for _, tt := range tests {
+		w := getURL(tt.target)
+		if w.Code != tt.code {
+			t.Errorf("ServeHTTP: index: %v: code: %v expected: %v", tt.target, w.Code, tt.code)
+			continue
+		}
+
+		cookies := w.Result().Cookies()
+		if tt.code != http.StatusFound {
+			if len(cookies) != 0 {
+				t.Errorf("ServeHTTP: index: %v: cookies: %v", tt.target, cookies)
+			}
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != "/board/abc?x=1" {
+			t.Errorf("ServeHTTP: index: %v: location: %v", tt.target, location)
+		}
+		if len(cookies) != 1 || cookies[0].Value != s.frontendToken.Token || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode || cookies[0].Path != HTTPAPIPath {
+			t.Errorf("ServeHTTP: index: %v: cookies: %v", tt.target, cookies)
+			continue
+		}
+		cookie = cookies[0]
+	}
+	if cookie == nil {
+		t.Fatalf("ServeHTTP: index: no cookie")
+	}
+
+	// api
+	if code, _ := callAPI("test_echo", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("ServeHTTP: api: no token: code: %v", code)
+	}
+	if code, body := callAPI("test_echo", adminToken.Token, nil); code != http.StatusOK || strings.Contains(body, "error") {
+		t.Errorf("ServeHTTP: api: admin token: code: %v body: %v", code, body)
+	}
+	if code, _ := callAPI("test_echo", s.frontendToken.Token, nil); code != http.StatusUnauthorized {
+		t.Errorf("ServeHTTP: api: frontend token in the header: code: %v", code)
+	}
+	if code, _ := callAPI("test_echo", "", &http.Cookie{Name: HTTPFrontendTokenCookie, Value: adminToken.Token}); code != http.StatusUnauthorized {
+		t.Errorf("ServeHTTP: api: admin token in the cookie: code: %v", code)
+	}
+	if code, body := callAPI("ptt_health", "", cookie); code != http.StatusOK || strings.Contains(body, "error") {
+		t.Errorf("ServeHTTP: api: frontend cookie: code: %v body: %v", code, body)
+	}
+	if code, body := callAPI("test_echo", "", cookie); code != http.StatusOK || !strings.Contains(body, "not allowed") {
+		t.Errorf("ServeHTTP: api: frontend cookie: out of the scopes: code: %v body: %v", code, body)
+	}
+}
+
+func TestHTTPServer_Health(t *testing.T) {
+	s, teardown := newTestHTTPServer(t, nil)
+	defer teardown()
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{HTTPHealthzPath, http.StatusOK},
+		{HTTPReadyzPath, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if w.Code != tt.code {
+			t.Errorf("ServeHTTP: %v: code: %v expected: %v", tt.path, w.Code, tt.code)
+		}
+
+		health := &pkgservice.BackendHealth{}
+		err := json.Unmarshal(w.Body.Bytes(), health)
+		if err != nil || len(health.Checks) != 1 || health.Entities != nil {
+			t.Errorf("ServeHTTP: %v: health: %v e: %v", tt.path, health, err)
+		}
+		if strings.Contains(w.Body.String(), "board") {
+			t.Errorf("ServeHTTP: %v: entities served: %v", tt.path, w.Body.String())
+		}
+	}
+}
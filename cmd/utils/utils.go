@@ -19,8 +19,12 @@ package utils
 import (
 	"crypto/ecdsa"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ailabstw/go-pttai/account"
@@ -32,13 +36,17 @@ import (
 	"github.com/ailabstw/go-pttai/me"
 	"github.com/ailabstw/go-pttai/metrics"
 	"github.com/ailabstw/go-pttai/metrics/influxdb"
+	"github.com/ailabstw/go-pttai/metrics/prometheus"
 	"github.com/ailabstw/go-pttai/node"
 	"github.com/ailabstw/go-pttai/p2p"
 	"github.com/ailabstw/go-pttai/p2p/discover"
 	"github.com/ailabstw/go-pttai/p2p/nat"
 	"github.com/ailabstw/go-pttai/p2p/netutil"
 	"github.com/ailabstw/go-pttai/params"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/pttstats"
 	pkgservice "github.com/ailabstw/go-pttai/service"
+	"golang.org/x/crypto/ssh/terminal"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
@@ -101,9 +109,20 @@ func SetMeConfig(ctx *cli.Context, cfg *me.Config, cfgNode *node.Config) {
 	default:
 		cfg.NodeType = pkgservice.NodeTypeDesktop
 	}
+}
+
+// SetKeys sets the key passphrase of me and the node, and loads the node key and my key/id/postfix.
+// The keys are not loaded in SetNodeConfig / SetMeConfig, so that the commands without the keys
+// do not prompt for the passphrase.
+func SetKeys(ctx *cli.Context, cfg *me.Config, cfgNode *node.Config) error {
+	keyPassphrase := NewKeyPassphrase(ctx)
+
+	cfg.KeyPassphrase = keyPassphrase
+	cfgNode.KeyPassphrase = keyPassphrase
 
-	// key/id/postfix
-	setMyKey(ctx, cfg)
+	setNodeKey(ctx, &cfgNode.P2P, keyPassphrase)
+
+	return setMyKey(ctx, cfg)
 }
 
 // SetMyKey creates a node key from set command line flags, either loading it
@@ -124,6 +143,84 @@ func setMyKey(ctx *cli.Context, cfg *me.Config) error {
 	return nil
 }
 
+// NewKeyPassphrase returns the key passphrase from --keypassphrasefile, the env,
+// or the terminal prompt (only to unlock the encrypted keys).
+// The passphrase is asked only once, and is shared by my key and the node key.
+func NewKeyPassphrase(ctx *cli.Context) crypto.KeyPassphrase {
+	var (
+		lock       sync.Mutex
+		passphrase string
+	)
+
+	return func(isPrompt bool) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if passphrase != "" {
+			return passphrase, nil
+		}
+
+		var err error
+		switch filename := ctx.GlobalString(KeyPassphraseFileFlag.Name); {
+		case filename != "":
+			var content []byte
+			content, err = ioutil.ReadFile(filename)
+			passphrase = strings.TrimRight(string(content), "\r\n")
+		case os.Getenv(KeyPassphraseEnv) != "":
+			passphrase = os.Getenv(KeyPassphraseEnv)
+		case isPrompt:
+			passphrase, err = PromptPassphrase("Key passphrase: ")
+		}
+		if err != nil {
+			passphrase = ""
+			return "", err
+		}
+		if passphrase == "" {
+			return "", crypto.ErrNoPassphrase
+		}
+
+		return passphrase, nil
+	}
+}
+
+// PromptPassphrase prompts the passphrase in the terminal without echo.
+func PromptPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", crypto.ErrNoPassphrase
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	return string(passphrase), nil
+}
+
+// PromptNewPassphrase prompts the new passphrase twice in the terminal.
+func PromptNewPassphrase(prompt string) (string, error) {
+	passphrase, err := PromptPassphrase(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	confirm, err := PromptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", ErrPassphraseMismatch
+	}
+	if passphrase == "" {
+		return "", crypto.ErrNoPassphrase
+	}
+
+	return passphrase, nil
+}
+
 // SetContentConfig applies node-related command line flags to the config.
 func SetAccountConfig(ctx *cli.Context, cfg *account.Config, cfgNode *node.Config) {
 	cfg.DataDir = filepath.Join(cfgNode.DataDir, "account")
@@ -153,12 +250,88 @@ func SetFriendConfig(ctx *cli.Context, cfg *friend.Config, cfgNode *node.Config)
 	cfg.DataDir = filepath.Join(cfgNode.DataDir, "friend")
 }
 
+// SetDBConfig applies db-related command line flags to the config.
+func SetDBConfig(ctx *cli.Context, cfg *pttdb.Config, cfgNode *node.Config) {
+	cfg.RootDir = cfgNode.DataDir
+	cfg.DataDir = filepath.Join(cfgNode.DataDir, "db")
+	cfg.IgnoredDirs = []string{cfgNode.NodeDB()}
+
+	if ctx.GlobalIsSet(SingleDBFlag.Name) {
+		cfg.IsSingle = ctx.GlobalBool(SingleDBFlag.Name)
+	}
+
+	cfg.Cache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
+	cfg.Handles = makeDatabaseHandles()
+}
+
+// SetStatsConfig applies pttstats-related command line flags to the config.
+func SetStatsConfig(ctx *cli.Context, cfg *pttstats.Config) {
+	if ctx.GlobalIsSet(PttStatsURLFlag.Name) {
+		cfg.URL = ctx.GlobalString(PttStatsURLFlag.Name)
+	}
+}
+
 // SetPttConfig applies ptt-related command line flags to the config.
 func SetPttConfig(ctx *cli.Context, cfg *pkgservice.Config, cfgNode *node.Config, gitCommit string) {
 	log.Debug("SetPttConfig: start", "cfg", cfg, "cfgNode", cfgNode)
 	cfg.DataDir = filepath.Join(cfgNode.DataDir, "ptt")
 	cfg.Version = params.Version
 	cfg.GitCommit = gitCommit
+
+	if ctx.GlobalIsSet(OplogRetentionFlag.Name) {
+		cfg.OplogRetentionSeconds = ctx.GlobalUint64(OplogRetentionFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(OplogSignRLPFlag.Name) {
+		cfg.OplogSignRLP = ctx.GlobalBool(OplogSignRLPFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(TopicDiscoveryFlag.Name) {
+		cfg.TopicDiscovery = ctx.GlobalBool(TopicDiscoveryFlag.Name)
+	}
+
+	// node-record
+	switch {
+	case ctx.GlobalBool(ServerFlag.Name):
+		cfg.NodeType = pkgservice.NodeTypeServer
+		cfg.IsRelay = true
+		cfg.IsHostBoards = true
+	default:
+		cfg.NodeType = pkgservice.NodeTypeDesktop
+	}
+
+	// relay
+	if ctx.GlobalIsSet(RelayBandwidthFlag.Name) {
+		cfg.RelayMaxBytesPerSecond = ctx.GlobalUint64(RelayBandwidthFlag.Name)
+	}
+	if ctx.GlobalIsSet(RelayPeerBandwidthFlag.Name) {
+		cfg.RelayMaxPeerBytesPerSecond = ctx.GlobalUint64(RelayPeerBandwidthFlag.Name)
+	}
+
+	// mailbox
+	if ctx.GlobalIsSet(MailboxFlag.Name) {
+		cfg.IsMailbox = ctx.GlobalBool(MailboxFlag.Name)
+	}
+	if ctx.GlobalIsSet(MailboxQuotaFlag.Name) {
+		cfg.MailboxQuotaBytes = ctx.GlobalUint64(MailboxQuotaFlag.Name)
+	}
+	if ctx.GlobalIsSet(MailboxUserQuotaFlag.Name) {
+		cfg.MailboxUserQuotaBytes = ctx.GlobalUint64(MailboxUserQuotaFlag.Name)
+	}
+	if ctx.GlobalIsSet(MailboxSenderQuotaFlag.Name) {
+		cfg.MailboxSenderQuotaBytes = ctx.GlobalUint64(MailboxSenderQuotaFlag.Name)
+	}
+	if ctx.GlobalIsSet(MailboxExpireFlag.Name) {
+		cfg.MailboxMaxExpireSeconds = ctx.GlobalUint64(MailboxExpireFlag.Name)
+	}
+
+	// health
+	if ctx.GlobalIsSet(HealthMinPeersFlag.Name) {
+		cfg.HealthMinPeers = ctx.GlobalInt(HealthMinPeersFlag.Name)
+	}
+	if ctx.GlobalIsSet(HealthMaxSyncLagFlag.Name) {
+		cfg.HealthMaxSyncLagSeconds = ctx.GlobalUint64(HealthMaxSyncLagFlag.Name)
+	}
 }
 
 // MakeDataDir retrieves the currently requested data directory, terminating
@@ -178,7 +351,8 @@ func MakeDataDir(ctx *cli.Context) string {
 // setNodeKey creates a node key from set command line flags, either loading it
 // from a file or as a specified hex value. If neither flags were provided, this
 // method returns nil and an emphemeral key is to be generated.
-func setNodeKey(ctx *cli.Context, cfg *p2p.Config) {
+// The key file is unlocked with the key passphrase if it is encrypted.
+func setNodeKey(ctx *cli.Context, cfg *p2p.Config, keyPassphrase crypto.KeyPassphrase) {
 	var (
 		hex  = ctx.GlobalString(NodeKeyHexFlag.Name)
 		file = ctx.GlobalString(NodeKeyFileFlag.Name)
@@ -189,7 +363,7 @@ func setNodeKey(ctx *cli.Context, cfg *p2p.Config) {
 	case file != "" && hex != "":
 		Fatalf("Options %q and %q are mutually exclusive", NodeKeyFileFlag.Name, NodeKeyHexFlag.Name)
 	case file != "":
-		if key, err = crypto.LoadECDSA(file); err != nil {
+		if key, _, err = crypto.LoadECDSAWithPassphrase(file, keyPassphrase); err != nil {
 			Fatalf("Option %q: %v", NodeKeyFileFlag.Name, err)
 		}
 		cfg.PrivateKey = key
@@ -282,6 +456,9 @@ func setHTTP(ctx *cli.Context, cfg *node.Config) {
 	if ctx.GlobalIsSet(RPCVirtualHostsFlag.Name) {
 		cfg.HTTPVirtualHosts = splitAndTrim(ctx.GlobalString(RPCVirtualHostsFlag.Name))
 	}
+	if ctx.GlobalIsSet(RPCNoAuthFlag.Name) {
+		cfg.RPCNoAuth = ctx.GlobalBool(RPCNoAuthFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(ExternRPCPortFlag.Name) {
 		cfg.ExternHTTPPort = ctx.GlobalInt(ExternRPCPortFlag.Name)
@@ -342,7 +519,6 @@ func makeDatabaseHandles() int {
 }
 
 func setP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
-	setNodeKey(ctx, cfg)
 	setNAT(ctx, cfg)
 	setListenAddress(ctx, cfg)
 	setBootstrapNodes(ctx, cfg)
@@ -447,6 +623,11 @@ func MigrateFlags(action func(ctx *cli.Context) error) func(*cli.Context) error
 }
 
 func SetupMetrics(ctx *cli.Context) {
+	metricsAddr := ctx.GlobalString(MetricsAddrFlag.Name)
+	if metricsAddr != "" && !metrics.Enabled {
+		log.Warn("Prometheus metrics listener requires --metrics", "addr", metricsAddr)
+	}
+
 	if metrics.Enabled {
 		log.Info("Enabling metrics collection")
 		var (
@@ -464,5 +645,20 @@ func SetupMetrics(ctx *cli.Context) {
 				"host": hosttag,
 			})
 		}
+
+		if metricsAddr != "" {
+			log.Info("Enabling metrics export to Prometheus", "url", "http://"+metricsAddr+MetricsHTTPPath)
+			go startMetricsServer(metricsAddr)
+		}
+	}
+}
+
+// startMetricsServer serves the metrics in the Prometheus text exposition format.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsHTTPPath, prometheus.Handler(metrics.DefaultRegistry))
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Error("Failed to start the Prometheus metrics listener", "addr", addr, "e", err)
 	}
 }
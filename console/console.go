@@ -0,0 +1,433 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ailabstw/go-pttai/rpc"
+	"github.com/peterh/liner"
+	"github.com/robertkrimen/otto"
+)
+
+var (
+	identifierRegexp = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*$`)
+
+	builtins = []string{"send", "loadScript", "exit"}
+)
+
+type Config struct {
+	DataDir  string       // the dir for the history, no history if empty.
+	DocRoot  string       // the dir for the relative paths of the scripts.
+	Client   *rpc.Client  // the rpc-client connected to the node.
+	Prompt   string       // the prompt, DefaultPrompt if empty.
+	Prompter UserPrompter // the input of the interactive console, nil if not interactive.
+	Printer  io.Writer    // the output, os.Stdout if nil.
+	Preload  []string     // the scripts preloaded.
+}
+
+/*
+Console is the javascript-console with the rpc-apis of the node.
+
+The apis are in the namespaces as the rpc-modules (ex: ptt.getVersion()),
+and send(method, params...) calls any rpc-method directly.
+*/
+type Console struct {
+	client   *rpc.Client
+	vm       *otto.Otto
+	prompt   string
+	prompter UserPrompter
+	printer  io.Writer
+	docRoot  string
+
+	histPath string
+	history  []string
+
+	methods map[string][]string
+}
+
+func New(config Config) (*Console, error) {
+	if config.Prompt == "" {
+		config.Prompt = DefaultPrompt
+	}
+	if config.Printer == nil {
+		config.Printer = os.Stdout
+	}
+
+	c := &Console{
+		client:   config.Client,
+		vm:       otto.New(),
+		prompt:   config.Prompt,
+		prompter: config.Prompter,
+		printer:  config.Printer,
+		docRoot:  config.DocRoot,
+	}
+	if config.DataDir != "" {
+		c.histPath = filepath.Join(config.DataDir, HistoryFile)
+	}
+
+	err := c.init(config.Preload)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Console) init(preload []string) error {
+	// methods
+	err := c.client.Call(&c.methods, "rpc_methods")
+	if err != nil {
+		// the node without rpc_methods, with the namespaces only.
+		modules := make(map[string]string)
+		err = c.client.Call(&modules, "rpc_modules")
+		if err != nil {
+			return err
+		}
+
+		c.methods = make(map[string][]string)
+		for module := range modules {
+			c.methods[module] = nil
+		}
+	}
+
+	// bridge
+	c.vm.Set("send", c.send)
+	c.vm.Set("loadScript", c.loadScript)
+
+	_, err = c.vm.Run(c.namespacesJS())
+	if err != nil {
+		return err
+	}
+
+	// preload
+	for _, path := range preload {
+		err = c.Execute(path)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+	}
+
+	// history
+	if c.histPath != "" {
+		content, err := ioutil.ReadFile(c.histPath)
+		if err == nil {
+			c.history = strings.Split(strings.TrimSpace(string(content)), "\n")
+		}
+	}
+
+	if c.prompter != nil {
+		c.prompter.SetHistory(c.history)
+		c.prompter.SetWordCompleter(c.AutoCompleteInput)
+	}
+
+	return nil
+}
+
+/*
+namespacesJS returns the js to set up the namespaces, with the functions calling the rpc-methods.
+*/
+func (c *Console) namespacesJS() string {
+	js := &bytes.Buffer{}
+	for _, namespace := range c.namespaces() {
+		fmt.Fprintf(js, "var %v = {};\n", namespace)
+		for _, method := range c.methods[namespace] {
+			if !identifierRegexp.MatchString(method) {
+				continue
+			}
+			fmt.Fprintf(js, "%v.%v = function() { return send.apply(null, [%q].concat(Array.prototype.slice.call(arguments))); };\n", namespace, method, namespace+"_"+method)
+		}
+	}
+
+	return js.String()
+}
+
+func (c *Console) namespaces() []string {
+	namespaces := make([]string, 0, len(c.methods))
+	for namespace := range c.methods {
+		if !identifierRegexp.MatchString(namespace) {
+			continue
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+/*
+send calls the rpc-method with the params, and returns the parsed result. (send(method, params...))
+*/
+func (c *Console) send(call otto.FunctionCall) otto.Value {
+	method, err := call.Argument(0).ToString()
+	if err != nil {
+		panic(c.vm.MakeCustomError("Error", err.Error()))
+	}
+
+	params := make([]interface{}, 0, len(call.ArgumentList))
+	for _, arg := range call.ArgumentList[1:] {
+		param, err := arg.Export()
+		if err != nil {
+			panic(c.vm.MakeCustomError("Error", err.Error()))
+		}
+		params = append(params, param)
+	}
+
+	var result json.RawMessage
+	err = c.client.Call(&result, method, params...)
+	if err != nil {
+		panic(c.vm.MakeCustomError("RPCError", err.Error()))
+	}
+	if len(result) == 0 {
+		return otto.NullValue()
+	}
+
+	value, err := c.vm.Call("JSON.parse", nil, string(result))
+	if err != nil {
+		panic(c.vm.MakeCustomError("Error", err.Error()))
+	}
+
+	return value
+}
+
+/*
+loadScript executes the script in the console. (loadScript(path))
+*/
+func (c *Console) loadScript(call otto.FunctionCall) otto.Value {
+	path, err := call.Argument(0).ToString()
+	if err != nil {
+		panic(c.vm.MakeCustomError("Error", err.Error()))
+	}
+
+	err = c.Execute(path)
+	if err != nil {
+		panic(c.vm.MakeCustomError("Error", err.Error()))
+	}
+
+	return otto.TrueValue()
+}
+
+/*
+Execute executes the script in the path (relative to the doc-root).
+*/
+func (c *Console) Execute(path string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.docRoot, path)
+	}
+
+	script, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.vm.Run(string(script))
+	return err
+}
+
+/*
+Evaluate evaluates the statement and prints the result.
+*/
+func (c *Console) Evaluate(statement string) error {
+	value, err := c.vm.Run(statement)
+	if err != nil {
+		fmt.Fprintln(c.printer, err)
+		return err
+	}
+
+	c.printValue(value)
+
+	return nil
+}
+
+func (c *Console) printValue(value otto.Value) {
+	switch {
+	case value.IsUndefined():
+		return
+	case value.IsObject():
+		str, err := c.vm.Call("JSON.stringify", nil, value, nil, "  ")
+		if err == nil {
+			fmt.Fprintln(c.printer, str.String())
+			return
+		}
+	}
+
+	fmt.Fprintln(c.printer, value.String())
+}
+
+/*
+Welcome prints the welcome-message with the namespaces.
+*/
+func (c *Console) Welcome() {
+	fmt.Fprintf(c.printer, "Welcome to the gptt console!\n\n")
+	fmt.Fprintf(c.printer, " modules: %v\n\n", strings.Join(c.namespaces(), " "))
+	fmt.Fprintf(c.printer, "To exit, press ctrl-d or type exit\n")
+}
+
+/*
+Interactive starts the interactive console until exit or EOF.
+*/
+func (c *Console) Interactive() {
+	if c.prompter == nil {
+		return
+	}
+
+	prompt := c.prompt
+	input := ""
+	for {
+		line, err := c.prompter.PromptInput(prompt)
+		if err == liner.ErrPromptAborted {
+			// ctrl-c: discard the current input.
+			prompt, input = c.prompt, ""
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		if input == "" {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if trimmed == "exit" || trimmed == "quit" {
+				return
+			}
+		}
+
+		input += line + "\n"
+		if countIndents(input) > 0 {
+			prompt = strings.Repeat(".", len(c.prompt)-1) + " "
+			continue
+		}
+
+		command := strings.TrimSpace(input)
+		if len(c.history) == 0 || command != c.history[len(c.history)-1] {
+			c.history = append(c.history, command)
+			c.prompter.AppendHistory(command)
+		}
+
+		c.Evaluate(command)
+		prompt, input = c.prompt, ""
+	}
+}
+
+/*
+AutoCompleteInput completes the namespaces and the methods of the word at pos.
+*/
+func (c *Console) AutoCompleteInput(line string, pos int) (string, []string, string) {
+	if pos <= 0 || pos > len(line) {
+		return "", nil, ""
+	}
+
+	start := pos
+	for start > 0 && isCompleteChar(line[start-1]) {
+		start--
+	}
+	if start == pos {
+		return "", nil, ""
+	}
+
+	return line[:start], c.complete(line[start:pos]), line[pos:]
+}
+
+func (c *Console) complete(word string) []string {
+	completions := make([]string, 0)
+
+	idx := strings.Index(word, ".")
+	if idx < 0 {
+		for _, name := range append(c.namespaces(), builtins...) {
+			if strings.HasPrefix(name, word) {
+				completions = append(completions, name)
+			}
+		}
+	} else {
+		namespace, prefix := word[:idx], word[idx+1:]
+		for _, method := range c.methods[namespace] {
+			if strings.HasPrefix(method, prefix) {
+				completions = append(completions, namespace+"."+method)
+			}
+		}
+	}
+	sort.Strings(completions)
+
+	return completions
+}
+
+func isCompleteChar(ch byte) bool {
+	return ch == '.' || ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+/*
+countIndents returns the number of the unclosed brackets in the input, skipping the strings.
+*/
+func countIndents(input string) int {
+	indents := 0
+	var quote rune
+	isEscaped := false
+
+	for _, ch := range input {
+		switch {
+		case isEscaped:
+			isEscaped = false
+		case ch == '\\':
+			isEscaped = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '{' || ch == '(' || ch == '[':
+			indents++
+		case ch == '}' || ch == ')' || ch == ']':
+			indents--
+		}
+	}
+
+	return indents
+}
+
+/*
+Stop stops the console, and saves the history.
+*/
+func (c *Console) Stop() error {
+	if c.histPath != "" && len(c.history) != 0 {
+		history := c.history
+		if len(history) > MaxHistory {
+			history = history[len(history)-MaxHistory:]
+		}
+		err := ioutil.WriteFile(c.histPath, []byte(strings.Join(history, "\n")+"\n"), 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.prompter != nil {
+		return c.prompter.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/rpc"
+)
+
+type TestAPI struct{}
+
+func (api *TestAPI) Echo(str string) string {
+	return str
+}
+
+func (api *TestAPI) Add(a, b int) int {
+	return a + b
+}
+
+type testPrompter struct {
+	inputs  []string
+	history []string
+}
+
+func (p *testPrompter) PromptInput(prompt string) (string, error) {
+	if len(p.inputs) == 0 {
+		return "", io.EOF
+	}
+	input := p.inputs[0]
+	p.inputs = p.inputs[1:]
+	return input, nil
+}
+
+func (p *testPrompter) AppendHistory(command string) {
+	p.history = append(p.history, command)
+}
+
+func (p *testPrompter) SetHistory(history []string)              {}
+func (p *testPrompter) SetWordCompleter(completer WordCompleter) {}
+func (p *testPrompter) Close() error                             { return nil }
+
+func newTestConsole(t *testing.T, prompter UserPrompter) (*Console, *bytes.Buffer, string, func()) {
+	dir, err := ioutil.TempDir("", "gptt-console")
+	if err != nil {
+		t.Fatalf("unable to create dir: e: %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "preload.js"), []byte(`function double(x) { return test.add(x, x); }`), 0644)
+
+	server := rpc.NewServer()
+	server.RegisterName("test", &TestAPI{})
+	client := rpc.DialInProc(server)
+
+	printer := &bytes.Buffer{}
+	c, err := New(Config{
+		DataDir:  dir,
+		DocRoot:  dir,
+		Client:   client,
+		Prompter: prompter,
+		Printer:  printer,
+		Preload:  []string{"preload.js"},
+	})
+	if err != nil {
+		t.Fatalf("New: e: %v", err)
+	}
+
+	return c, printer, dir, func() {
+		client.Close()
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConsole_Evaluate(t *testing.T) {
+	c, printer, _, teardown := newTestConsole(t, nil)
+	defer teardown()
+
+	tests := []struct {
+		statement string
+		expected  string
+	}{
+		{`test.echo("ptt")`, "ptt\n"},
+		{`double(21)`, "42\n"},
+		{`send("test_add", 1, 2)`, "3\n"},
+		{`rpc.modules().test`, "1.0\n"},
+		{`var a = 1`, ""},
+	}
+
+	for _, tt := range tests {
+		printer.Reset()
+		err := c.Evaluate(tt.statement)
+		if err != nil || printer.String() != tt.expected {
+			t.Errorf("Evaluate: %v: %q expected: %q e: %v", tt.statement, printer.String(), tt.expected, err)
+		}
+	}
+
+	// rpc-error
+	printer.Reset()
+	if err := c.Evaluate(`test.echo()`); err == nil {
+		t.Errorf("Evaluate: expected rpc-error")
+	}
+}
+
+func TestConsole_AutoCompleteInput(t *testing.T) {
+	c, _, _, teardown := newTestConsole(t, nil)
+	defer teardown()
+
+	tests := []struct {
+		line        string
+		head        string
+		completions []string
+	}{
+		{"te", "", []string{"test"}},
+		{"x = test.e", "x = ", []string{"test.echo"}},
+		{"test.", "", []string{"test.add", "test.echo"}},
+		{"rpc.m", "", []string{"rpc.methods", "rpc.modules"}},
+		{"lo", "", []string{"loadScript"}},
+	}
+
+	for _, tt := range tests {
+		head, completions, tail := c.AutoCompleteInput(tt.line, len(tt.line))
+		if head != tt.head || tail != "" || !reflect.DeepEqual(completions, tt.completions) {
+			t.Errorf("AutoCompleteInput: %v: head: %q completions: %v", tt.line, head, completions)
+		}
+	}
+}
+
+func TestConsole_Interactive(t *testing.T) {
+	prompter := &testPrompter{inputs: []string{
+		`function triple(x) {`,
+		`  return x * 3;`,
+		`}`,
+		`triple(2)`,
+		`exit`,
+		`test.echo("not evaluated")`,
+	}}
+	c, printer, dir, teardown := newTestConsole(t, prompter)
+	defer teardown()
+
+	c.Interactive()
+	c.Stop()
+
+	if printer.String() != "6\n" {
+		t.Errorf("Interactive: output: %q", printer.String())
+	}
+	if len(prompter.history) != 2 || prompter.history[1] != "triple(2)" {
+		t.Errorf("Interactive: history: %v", prompter.history)
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, HistoryFile))
+	if !strings.HasSuffix(string(content), "triple(2)\n") {
+		t.Errorf("Stop: history-file: %q", content)
+	}
+}
+
+func TestCountIndents(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{`test.echo("a")`, 0},
+		{`function a() {`, 1},
+		{`var a = "{[("`, 0},
+		{`var a = '\'{'; [`, 1},
+	}
+
+	for _, tt := range tests {
+		if indents := countIndents(tt.input); indents != tt.expected {
+			t.Errorf("countIndents: %v: %v expected: %v", tt.input, indents, tt.expected)
+		}
+	}
+}
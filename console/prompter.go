@@ -0,0 +1,77 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package console
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+/*
+UserPrompter is the interface of the interactive input of the console.
+*/
+type UserPrompter interface {
+	PromptInput(prompt string) (string, error)
+
+	AppendHistory(command string)
+	SetHistory(history []string)
+
+	SetWordCompleter(completer WordCompleter)
+
+	Close() error
+}
+
+/*
+WordCompleter returns the completions of the word in the line at pos, with the head and the tail of the line.
+*/
+type WordCompleter func(line string, pos int) (string, []string, string)
+
+/*
+terminalPrompter is the UserPrompter with the terminal (liner), with the history and the tab-completion.
+*/
+type terminalPrompter struct {
+	*liner.State
+}
+
+func NewTerminalPrompter() UserPrompter {
+	state := liner.NewLiner()
+	state.SetCtrlCAborts(true)
+	state.SetTabCompletionStyle(liner.TabPrints)
+
+	return &terminalPrompter{State: state}
+}
+
+func (p *terminalPrompter) PromptInput(prompt string) (string, error) {
+	return p.State.Prompt(prompt)
+}
+
+func (p *terminalPrompter) SetHistory(history []string) {
+	p.State.ReadHistory(strings.NewReader(strings.Join(history, "\n")))
+}
+
+func (p *terminalPrompter) SetWordCompleter(completer WordCompleter) {
+	p.State.SetWordCompleter(liner.WordCompleter(completer))
+}
+
+func (p *terminalPrompter) Close() error {
+	err := p.State.Close()
+	fmt.Println()
+
+	return err
+}
@@ -29,7 +29,7 @@ type Backend struct {
 
 func NewBackend(ctx *pkgservice.ServiceContext, cfg *Config, id *types.PttID, ptt *pkgservice.BasePtt, accountBackend *account.Backend) (*Backend, error) {
 	// init content
-	err := InitContent(cfg.DataDir, cfg.KeystoreDir)
+	err := InitContent(cfg.DataDir, cfg.KeystoreDir, nil)
 	if err != nil {
 		return nil, err
 	}
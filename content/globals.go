@@ -41,15 +41,15 @@ var (
 
 // db
 var (
-	dbKey *pttdb.LDBDatabase = nil
+	dbKey pttdb.KVDatabase = nil
 
-	dbBoardCore *pttdb.LDBDatabase = nil
-	dbBoard     *pttdb.LDBBatch    = nil
+	dbBoardCore pttdb.KVDatabase = nil
+	dbBoard     *pttdb.LDBBatch  = nil
 
-	dbCommentCore *pttdb.LDBDatabase = nil
-	dbComment     *pttdb.LDBBatch    = nil
+	dbCommentCore pttdb.KVDatabase = nil
+	dbComment     *pttdb.LDBBatch  = nil
 
-	dbMeta *pttdb.LDBDatabase = nil
+	dbMeta pttdb.KVDatabase = nil
 
 	DBNodeIdxOplogPrefix    = []byte(".ndig")
 	DBNodeOplogPrefix       = []byte(".ndlg")
@@ -100,11 +100,11 @@ var (
 	DBMemberMerkleOplogPrefix = []byte(".mbmk")
 )
 
-func InitContent(dataDir string, keystoreDir string) error {
+func InitContent(dataDir string, keystoreDir string, migrateOpts *pttdb.MigrateOptions) error {
 	var err error
 
 	// db
-	dbBoardCore, err = pttdb.NewLDBDatabase("board", dataDir, 0, 0)
+	dbBoardCore, err = pttdb.OpenDatabase("board", dataDir)
 	if err != nil {
 		return err
 	}
@@ -114,7 +114,7 @@ func InitContent(dataDir string, keystoreDir string) error {
 		return err
 	}
 
-	dbCommentCore, err = pttdb.NewLDBDatabase("comment", dataDir, 0, 0)
+	dbCommentCore, err = pttdb.OpenDatabase("comment", dataDir)
 	if err != nil {
 		return err
 	}
@@ -124,17 +124,17 @@ func InitContent(dataDir string, keystoreDir string) error {
 		return err
 	}
 
-	dbKey, err = pttdb.NewLDBDatabase("key", keystoreDir, 0, 0)
+	dbKey, err = pttdb.OpenDatabase("key", keystoreDir)
 	if err != nil {
 		return err
 	}
 
-	dbMeta, err = pttdb.NewLDBDatabase("contentmeta", dataDir, 0, 0)
+	dbMeta, err = pttdb.OpenDatabase("contentmeta", dataDir)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return pttdb.MigrateAll(dbMeta, migrateOpts, dbBoardCore, dbCommentCore, dbKey)
 }
 
 func initMyInfo(id *types.PttID, nodeID *discover.NodeID) error {
@@ -0,0 +1,359 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+The encrypted key file is the json of KeyStore:
+
+	{"version":1,"cipher":"aes-256-gcm","ciphertext":...,"nonce":...,"kdf":"scrypt","kdfparams":{"n":..,"r":..,"p":..,"dklen":32,"salt":...}}
+
+The AES-256-GCM key is derived from the passphrase with scrypt,
+and the address of the key is the additional data of GCM.
+The files with the plain hex key (SaveECDSA) are still loaded by LoadECDSAWithPassphrase.
+*/
+
+const (
+	KeyStoreVersion = 1
+
+	keyStoreCipher = "aes-256-gcm"
+	keyStoreKDF    = "scrypt"
+
+	keyStoreSaltLength = 32
+	keyStoreKeyLength  = 32
+
+	// bounds of the scrypt parameters in the keystore,
+	// so that a crafted keystore does not exhaust the memory / cpu in DecryptKey.
+	maxKeyStoreScryptN  = 1 << 20
+	maxKeyStoreScryptRP = 1 << 30
+)
+
+var (
+	// scrypt parameters of the key passphrase, the same as the standard keystore.
+	StandardScryptN = 1 << 18
+	StandardScryptP = 1
+
+	// LightScryptN / LightScryptP are used in tests.
+	LightScryptN = 1 << 12
+	LightScryptP = 6
+
+	keyStoreScryptR = 8
+)
+
+var (
+	ErrNoPassphrase       = errors.New("no passphrase for the encrypted key")
+	ErrDecryptKey         = errors.New("could not decrypt key with given passphrase")
+	ErrInvalidKeyStore    = errors.New("invalid keystore")
+	ErrKeyStoreVersion    = errors.New("unsupported keystore version")
+	ErrKeyStoreCipher     = errors.New("unsupported keystore cipher")
+	ErrKeyStoreKDF        = errors.New("unsupported keystore kdf")
+	ErrKeyStoreKDFParams  = errors.New("invalid keystore kdf params")
+	ErrInvalidKeyStoreKey = errors.New("invalid keystore key")
+)
+
+type KeyStore struct {
+	Version    int               `json:"version"`
+	Address    string            `json:"address"`
+	Cipher     string            `json:"cipher"`
+	CipherText string            `json:"ciphertext"`
+	Nonce      string            `json:"nonce"`
+	KDF        string            `json:"kdf"`
+	KDFParams  *KeyStoreKDFParam `json:"kdfparams"`
+}
+
+type KeyStoreKDFParam struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+/*
+KeyPassphrase returns the passphrase of the encrypted keys, ErrNoPassphrase if not available.
+The passphrase is prompted only if isPrompt (to unlock the encrypted keys).
+*/
+type KeyPassphrase func(isPrompt bool) (string, error)
+
+/*
+EncryptKey encrypts the key with the passphrase to the json of KeyStore.
+*/
+func EncryptKey(key *ecdsa.PrivateKey, passphrase string, scryptN int, scryptP int) ([]byte, error) {
+	salt := make([]byte, keyStoreSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, keyStoreScryptR, scryptP, keyStoreKeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newKeyStoreGCM(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	keyBytes := FromECDSA(key)
+	defer zeroBytes(keyBytes)
+
+	addr := PubkeyToAddress(key.PublicKey)
+	cipherText := gcm.Seal(nil, nonce, keyBytes, addr[:])
+
+	keyStore := &KeyStore{
+		Version:    KeyStoreVersion,
+		Address:    hex.EncodeToString(addr[:]),
+		Cipher:     keyStoreCipher,
+		CipherText: hex.EncodeToString(cipherText),
+		Nonce:      hex.EncodeToString(nonce),
+		KDF:        keyStoreKDF,
+		KDFParams: &KeyStoreKDFParam{
+			N:     scryptN,
+			R:     keyStoreScryptR,
+			P:     scryptP,
+			DKLen: keyStoreKeyLength,
+			Salt:  hex.EncodeToString(salt),
+		},
+	}
+
+	return json.Marshal(keyStore)
+}
+
+/*
+isValidScryptParams checks the scrypt parameters before deriving the key:
+N is a power of 2 in (1, maxKeyStoreScryptN], r and p are positive, and r * p < maxKeyStoreScryptRP.
+*/
+func isValidScryptParams(params *KeyStoreKDFParam) bool {
+	n, r, p := params.N, params.R, params.P
+
+	switch {
+	case n <= 1 || n > maxKeyStoreScryptN || n&(n-1) != 0:
+		return false
+	case r <= 0 || p <= 0:
+		return false
+	case uint64(r)*uint64(p) >= maxKeyStoreScryptRP:
+		return false
+	}
+
+	return true
+}
+
+/*
+DecryptKey decrypts the json of KeyStore with the passphrase.
+*/
+func DecryptKey(keyJSON []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	keyStore := &KeyStore{}
+	err := json.Unmarshal(keyJSON, keyStore)
+	if err != nil {
+		return nil, ErrInvalidKeyStore
+	}
+
+	switch {
+	case keyStore.Version != KeyStoreVersion:
+		return nil, ErrKeyStoreVersion
+	case keyStore.Cipher != keyStoreCipher:
+		return nil, ErrKeyStoreCipher
+	case keyStore.KDF != keyStoreKDF:
+		return nil, ErrKeyStoreKDF
+	case keyStore.KDFParams == nil || keyStore.KDFParams.DKLen != keyStoreKeyLength:
+		return nil, ErrInvalidKeyStore
+	case !isValidScryptParams(keyStore.KDFParams):
+		return nil, ErrKeyStoreKDFParams
+	}
+
+	salt, err := hex.DecodeString(keyStore.KDFParams.Salt)
+	if err != nil {
+		return nil, ErrInvalidKeyStore
+	}
+	nonce, err := hex.DecodeString(keyStore.Nonce)
+	if err != nil {
+		return nil, ErrInvalidKeyStore
+	}
+	cipherText, err := hex.DecodeString(keyStore.CipherText)
+	if err != nil {
+		return nil, ErrInvalidKeyStore
+	}
+	addr, err := hex.DecodeString(keyStore.Address)
+	if err != nil {
+		return nil, ErrInvalidKeyStore
+	}
+
+	params := keyStore.KDFParams
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newKeyStoreGCM(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrInvalidKeyStore
+	}
+
+	keyBytes, err := gcm.Open(nil, nonce, cipherText, addr)
+	if err != nil {
+		return nil, ErrDecryptKey
+	}
+	defer zeroBytes(keyBytes)
+
+	key, err := ToECDSA(keyBytes)
+	if err != nil {
+		return nil, ErrInvalidKeyStoreKey
+	}
+
+	keyAddr := PubkeyToAddress(key.PublicKey)
+	if !bytes.Equal(keyAddr[:], addr) {
+		return nil, ErrInvalidKeyStoreKey
+	}
+
+	return key, nil
+}
+
+func newKeyStoreGCM(derivedKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+/*
+IsEncryptedKey checks whether the content of the key file is the json of KeyStore.
+*/
+func IsEncryptedKey(content []byte) bool {
+	content = bytes.TrimSpace(content)
+	return len(content) != 0 && content[0] == '{'
+}
+
+/*
+IsEncryptedKeyFile checks whether the key file is encrypted.
+*/
+func IsEncryptedKeyFile(file string) (bool, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+
+	return IsEncryptedKey(content), nil
+}
+
+/*
+SaveEncryptedECDSA saves the key encrypted with the passphrase to the file.
+The file is replaced atomically, so that the original key is not lost if failed in the middle.
+*/
+func SaveEncryptedECDSA(file string, key *ecdsa.PrivateKey, passphrase string) error {
+	keyJSON, err := EncryptKey(key, passphrase, StandardScryptN, StandardScryptP)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpFile := f.Name()
+
+	_, err = f.Write(keyJSON)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile, 0600)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return os.Rename(tmpFile, file)
+}
+
+/*
+LoadECDSAWithPassphrase loads the key from the file,
+which is either encrypted (SaveEncryptedECDSA) or plain hex (SaveECDSA).
+
+The passphrase is retrieved only if the file is encrypted.
+*/
+func LoadECDSAWithPassphrase(file string, getPassphrase KeyPassphrase) (*ecdsa.PrivateKey, bool, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !IsEncryptedKey(content) {
+		key, err := LoadECDSA(file)
+		return key, false, err
+	}
+
+	if getPassphrase == nil {
+		return nil, true, ErrNoPassphrase
+	}
+
+	passphrase, err := getPassphrase(true)
+	if err != nil {
+		return nil, true, err
+	}
+
+	key, err := DecryptKey(content, passphrase)
+	return key, true, err
+}
+
+/*
+SaveECDSAWithPassphrase saves the key encrypted if the passphrase is set (without prompting),
+or as plain hex if not (ErrNoPassphrase).
+*/
+func SaveECDSAWithPassphrase(file string, key *ecdsa.PrivateKey, getPassphrase KeyPassphrase) (bool, error) {
+	if getPassphrase == nil {
+		return false, SaveECDSA(file, key)
+	}
+
+	passphrase, err := getPassphrase(false)
+	if err == ErrNoPassphrase {
+		return false, SaveECDSA(file, key)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, SaveEncryptedECDSA(file, key, passphrase)
+}
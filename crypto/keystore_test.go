@@ -0,0 +1,180 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common"
+)
+
+func TestEncryptDecryptKey(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+
+	keyJSON, err := EncryptKey(key, "foo", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("EncryptKey: e: %v", err)
+	}
+	if bytes.Contains(keyJSON, []byte(testPrivHex)) {
+		t.Errorf("EncryptKey: plain key in the keystore")
+	}
+	if !IsEncryptedKey(keyJSON) {
+		t.Errorf("IsEncryptedKey: false")
+	}
+
+	key2, err := DecryptKey(keyJSON, "foo")
+	if err != nil {
+		t.Fatalf("DecryptKey: e: %v", err)
+	}
+	if !bytes.Equal(FromECDSA(key2), FromECDSA(key)) {
+		t.Errorf("DecryptKey: key mismatch")
+	}
+
+	_, err = DecryptKey(keyJSON, "bar")
+	if err != ErrDecryptKey {
+		t.Errorf("DecryptKey: wrong passphrase: e: %v", err)
+	}
+
+	// tampered address
+	keyStore := &KeyStore{}
+	json.Unmarshal(keyJSON, keyStore)
+	keyStore.Address = common.Bytes2Hex(make([]byte, common.AddressLength))
+	tampered, _ := json.Marshal(keyStore)
+	_, err = DecryptKey(tampered, "foo")
+	if err != ErrDecryptKey {
+		t.Errorf("DecryptKey: tampered address: e: %v", err)
+	}
+
+	keyStore.Version = KeyStoreVersion + 1
+	tampered, _ = json.Marshal(keyStore)
+	_, err = DecryptKey(tampered, "foo")
+	if err != ErrKeyStoreVersion {
+		t.Errorf("DecryptKey: version: e: %v", err)
+	}
+}
+
+func TestDecryptKey_KDFParams(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+
+	keyJSON, err := EncryptKey(key, "foo", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("EncryptKey: e: %v", err)
+	}
+
+	tests := []struct {
+		n, r, p int
+	}{
+		{0, 8, 1},
+		{1, 8, 1},
+		{-(1 << 12), 8, 1},
+		{1<<12 + 1, 8, 1},
+		{1 << 21, 8, 1},
+		{1 << 12, 0, 1},
+		{1 << 12, -8, 1},
+		{1 << 12, 8, 0},
+		{1 << 12, 8, -1},
+		{1 << 12, 1 << 15, 1 << 15},
+	}
+	for _, tt := range tests {
+		keyStore := &KeyStore{}
+		json.Unmarshal(keyJSON, keyStore)
+		keyStore.KDFParams.N, keyStore.KDFParams.R, keyStore.KDFParams.P = tt.n, tt.r, tt.p
+		tampered, _ := json.Marshal(keyStore)
+
+		_, err = DecryptKey(tampered, "foo")
+		if err != ErrKeyStoreKDFParams {
+			t.Errorf("DecryptKey: n: %v r: %v p: %v e: %v", tt.n, tt.r, tt.p, err)
+		}
+	}
+}
+
+func TestLoadECDSAWithPassphrase(t *testing.T) {
+	origScryptN, origScryptP := StandardScryptN, StandardScryptP
+	StandardScryptN, StandardScryptP = LightScryptN, LightScryptP
+	defer func() {
+		StandardScryptN, StandardScryptP = origScryptN, origScryptP
+	}()
+
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := HexToECDSA(testPrivHex)
+	nPrompt := 0
+	getPassphrase := func(isPrompt bool) (string, error) {
+		if !isPrompt {
+			return "", ErrNoPassphrase
+		}
+		nPrompt++
+		return "foo", nil
+	}
+
+	// plain: no passphrase.
+	plainFile := filepath.Join(dir, "plain")
+	isEncrypted, err := SaveECDSAWithPassphrase(plainFile, key, getPassphrase)
+	if err != nil || isEncrypted {
+		t.Fatalf("SaveECDSAWithPassphrase: plain: %v e: %v", isEncrypted, err)
+	}
+	key2, isEncrypted, err := LoadECDSAWithPassphrase(plainFile, getPassphrase)
+	if err != nil || isEncrypted || !bytes.Equal(FromECDSA(key2), FromECDSA(key)) {
+		t.Errorf("LoadECDSAWithPassphrase: plain: %v e: %v", isEncrypted, err)
+	}
+	if nPrompt != 0 {
+		t.Errorf("LoadECDSAWithPassphrase: prompted for the plain key")
+	}
+
+	// encrypted: prompted to unlock.
+	encryptedFile := filepath.Join(dir, "encrypted")
+	err = SaveEncryptedECDSA(encryptedFile, key, "foo")
+	if err != nil {
+		t.Fatalf("SaveEncryptedECDSA: e: %v", err)
+	}
+	info, _ := os.Stat(encryptedFile)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("SaveEncryptedECDSA: mode: %v", info.Mode())
+	}
+	key2, isEncrypted, err = LoadECDSAWithPassphrase(encryptedFile, getPassphrase)
+	if err != nil || !isEncrypted || !bytes.Equal(FromECDSA(key2), FromECDSA(key)) {
+		t.Errorf("LoadECDSAWithPassphrase: encrypted: %v e: %v", isEncrypted, err)
+	}
+	if nPrompt != 1 {
+		t.Errorf("LoadECDSAWithPassphrase: nPrompt: %v", nPrompt)
+	}
+
+	_, isEncrypted, err = LoadECDSAWithPassphrase(encryptedFile, nil)
+	if err != ErrNoPassphrase || !isEncrypted {
+		t.Errorf("LoadECDSAWithPassphrase: no passphrase: %v e: %v", isEncrypted, err)
+	}
+
+	// set passphrase: saved encrypted.
+	setFile := filepath.Join(dir, "set")
+	isEncrypted, err = SaveECDSAWithPassphrase(setFile, key, func(isPrompt bool) (string, error) { return "bar", nil })
+	if err != nil || !isEncrypted {
+		t.Fatalf("SaveECDSAWithPassphrase: set: %v e: %v", isEncrypted, err)
+	}
+	content, _ := ioutil.ReadFile(setFile)
+	if _, err := DecryptKey(content, "bar"); err != nil {
+		t.Errorf("SaveECDSAWithPassphrase: set: e: %v", err)
+	}
+}
@@ -25,15 +25,21 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/node"
+	"github.com/ailabstw/go-pttai/rpc"
 	baloo "gopkg.in/h2non/baloo.v3"
 )
 
-const ()
+const (
+	// the admin-token of the nodes, pre-generated in the rpc-tokens of the datadir.
+	tRPCToken = "6b0cd3e1a9f04c1d8e2f7a5b3c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f70812"
+)
 
 var (
 	ctx       context.Context    = nil
@@ -119,12 +125,17 @@ func setupTest(t *testing.T) {
 		rpcport := fmt.Sprintf("%d", 9450+i)
 		port := fmt.Sprintf("%d", 9500+i)
 
+		err := writeRPCTokens(dir)
+		if err != nil {
+			t.Errorf("unable to write rpc-tokens: i: %v e: %v", i, err)
+		}
+
 		ctxs[i], cancels[i] = context.WithTimeout(context.Background(), TimeoutSeconds)
 		nodes[i] = exec.CommandContext(ctxs[i], "../build/bin/gptt", "--verbosity", "4", "--datadir", dir, "--rpcaddr", "127.0.0.1", "--rpcport", rpcport, "--port", port, "--bootnodes", "pnode://847e1b261cd827f83a62c6fa6d335179054cecb5651d47b4b152cef67e4b45d7f872e07a2e222771124e0354e58b6b3b1fc8908bb63ec30744abd9784ced31e8@127.0.0.1:9489", "--ipcdisable")
 		filename := fmt.Sprintf("./test.out/log.err.%d.txt", i)
 		stderrs[i], _ = os.Create(filename)
 		nodes[i].Stderr = stderrs[i]
-		err := nodes[i].Start()
+		err = nodes[i].Start()
 		if err != nil {
 			t.Errorf("unable to start node: i: %v e: %v", i, err)
 		}
@@ -134,6 +145,28 @@ func setupTest(t *testing.T) {
 	time.Sleep(12 * time.Second)
 }
 
+/*
+writeRPCTokens writes the admin-token to the rpc-tokens of the datadir,
+so that the node loads the token instead of generating a random one.
+*/
+func writeRPCTokens(dir string) error {
+	instanceDir := filepath.Join(dir, "gptt")
+	err := os.MkdirAll(instanceDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tokens := []*rpc.AuthToken{
+		{Name: node.RPCAuthTokenAdmin, Token: tRPCToken, Scopes: []string{rpc.AuthScopeAll}},
+	}
+	marshaled, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(instanceDir, node.DataDirRPCTokens), marshaled, 0600)
+}
+
 func teardownTest(t *testing.T) {
 	log.Root().SetHandler(origHandler)
 
@@ -153,6 +186,7 @@ func testListCore(c *baloo.Client, bodyString string, data interface{}, t *testi
 	c.Post("/").
 		BodyString(bodyString).
 		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", "Bearer "+tRPCToken).
 		Expect(t).
 		AssertFunc(GetResponseBody(rbody)).
 		Done()
@@ -172,6 +206,7 @@ func testCore(c *baloo.Client, bodyString string, data interface{}, t *testing.T
 	c.Post("/").
 		BodyString(bodyString).
 		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", "Bearer "+tRPCToken).
 		Expect(t).
 		AssertFunc(GetResponseBody(rbody)).
 		Done()
@@ -204,6 +239,7 @@ func testStringCore(c *baloo.Client, bodyString string, t *testing.T, isDebug bo
 	c.Post("/").
 		BodyString(bodyString).
 		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", "Bearer "+tRPCToken).
 		Expect(t).
 		AssertFunc(GetResponseBody(rbody)).
 		Done()
@@ -226,6 +262,7 @@ func testIntCore(c *baloo.Client, bodyString string, t *testing.T, isDebug bool)
 	c.Post("/").
 		BodyString(bodyString).
 		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", "Bearer "+tRPCToken).
 		Expect(t).
 		AssertFunc(GetResponseBody(rbody)).
 		Done()
@@ -245,6 +282,7 @@ func testBodyEqualCore(c *baloo.Client, bodyString string, resultString string,
 	c.Post("/").
 		BodyString(bodyString).
 		SetHeader("Content-Type", "application/json").
+		SetHeader("Authorization", "Bearer "+tRPCToken).
 		Expect(t).
 		AssertFunc(GetResponseBody(rbody)).
 		BodyEquals(resultString).
@@ -17,27 +17,91 @@
 package e2e
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
+	pkgservice "github.com/ailabstw/go-pttai/service"
+	"github.com/stretchr/testify/assert"
 	baloo "gopkg.in/h2non/baloo.v3"
 )
 
 func TestPttRestart(t *testing.T) {
 	var bodyString string
 
-	//assert := assert.New(t)
+	assert := assert.New(t)
 
 	setupTest(t)
 	defer teardownTest(t)
 
 	t0 := baloo.New("http://127.0.0.1:9450")
 
-	// 2. ptt_countEntities
+	// 1. ptt_getKeys / ptt_getConfirmJoins before the restart
+	bodyString = `{"id": "testID", "method": "ptt_getKeys", "params": [""]}`
+
+	dataGetKeys0_1 := &struct {
+		Result []*pkgservice.BackendKey `json:"result"`
+	}{}
+	testListCore(t0, bodyString, dataGetKeys0_1, t, true)
+
+	bodyString = `{"id": "testID", "method": "ptt_getConfirmJoins", "params": []}`
+
+	dataGetConfirmJoins0_1 := &struct {
+		Result []*pkgservice.BackendConfirmJoin `json:"result"`
+	}{}
+	testListCore(t0, bodyString, dataGetConfirmJoins0_1, t, true)
+
+	// 2. ptt_restart
 	bodyString = `{"id": "testID", "method": "ptt_restart", "params": []}`
 
 	resultString := `{"jsonrpc":"2.0","id":"testID","result":true}`
 	testBodyEqualCore(t0, bodyString, resultString, t)
 
 	time.Sleep(10 * time.Second)
+
+	// 3. the join-keys are still valid after the restart.
+	bodyString = `{"id": "testID", "method": "ptt_getKeys", "params": [""]}`
+
+	dataGetKeys0_3 := &struct {
+		Result []*pkgservice.BackendKey `json:"result"`
+	}{}
+	testListCore(t0, bodyString, dataGetKeys0_3, t, true)
+
+	joinKeys0_3 := make(map[string]bool)
+	for _, key := range dataGetKeys0_3.Result {
+		if key.Type == pkgservice.BackendKeyTypeJoin {
+			joinKeys0_3[key.Hash.Hex()] = true
+		}
+	}
+	for _, key := range dataGetKeys0_1.Result {
+		if key.Type != pkgservice.BackendKeyTypeJoin {
+			continue
+		}
+		assert.True(joinKeys0_3[key.Hash.Hex()], "join-key lost after restart: %v", key.Hash.Hex())
+	}
+
+	// 4. the pending joins are kept after the restart.
+	bodyString = `{"id": "testID", "method": "ptt_getConfirmJoins", "params": []}`
+
+	dataGetConfirmJoins0_4 := &struct {
+		Result []*pkgservice.BackendConfirmJoin `json:"result"`
+	}{}
+	testListCore(t0, bodyString, dataGetConfirmJoins0_4, t, true)
+	assert.Equal(dataGetConfirmJoins0_1.Result, dataGetConfirmJoins0_4.Result)
+
+	// 5. the pending joins complete after the restart.
+	for _, confirmJoin := range dataGetConfirmJoins0_4.Result {
+		marshaled, _ := json.Marshal(confirmJoin.ConfirmKey)
+		bodyString = fmt.Sprintf(`{"id": "testID", "method": "ptt_approveJoin", "params": [%s]}`, marshaled)
+		testBodyEqualCore(t0, bodyString, resultString, t)
+	}
+
+	bodyString = `{"id": "testID", "method": "ptt_getConfirmJoins", "params": []}`
+
+	dataGetConfirmJoins0_5 := &struct {
+		Result []*pkgservice.BackendConfirmJoin `json:"result"`
+	}{}
+	testListCore(t0, bodyString, dataGetConfirmJoins0_5, t, true)
+	assert.Equal(0, len(dataGetConfirmJoins0_5.Result))
 }
@@ -32,7 +32,7 @@ type Backend struct {
 
 func NewBackend(ctx *pkgservice.ServiceContext, cfg *Config, id *types.PttID, ptt *pkgservice.BasePtt, accountBackend *account.Backend, contentBackend *content.Backend) (*Backend, error) {
 	// init friend
-	err := InitFriend(cfg.DataDir)
+	err := InitFriend(cfg.DataDir, nil)
 	if err != nil {
 		return nil, err
 	}
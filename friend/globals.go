@@ -40,11 +40,11 @@ var (
 
 // db
 var (
-	dbFriendCore *pttdb.LDBDatabase = nil
-	dbFriend     *pttdb.LDBBatch    = nil
-	dbKey        *pttdb.LDBDatabase = nil
+	dbFriendCore pttdb.KVDatabase = nil
+	dbFriend     *pttdb.LDBBatch  = nil
+	dbKey        pttdb.KVDatabase = nil
 
-	dbMeta *pttdb.LDBDatabase = nil
+	dbMeta pttdb.KVDatabase = nil
 
 	DBFriendIdxPrefix         = []byte(".frix")
 	DBFriendIdx2Prefix        = []byte(".fri2")
@@ -54,10 +54,10 @@ var (
 	DBFriendMerkleOplogPrefix = []byte(".frmk")
 )
 
-func InitFriend(dataDir string) error {
+func InitFriend(dataDir string, migrateOpts *pttdb.MigrateOptions) error {
 	var err error
 
-	dbFriendCore, err = pttdb.NewLDBDatabase("friend", dataDir, 0, 0)
+	dbFriendCore, err = pttdb.OpenDatabase("friend", dataDir)
 	if err != nil {
 		return err
 	}
@@ -66,17 +66,17 @@ func InitFriend(dataDir string) error {
 		return err
 	}
 
-	dbMeta, err = pttdb.NewLDBDatabase("friendmeta", dataDir, 0, 0)
+	dbMeta, err = pttdb.OpenDatabase("friendmeta", dataDir)
 	if err != nil {
 		return err
 	}
 
-	dbKey, err = pttdb.NewLDBDatabase("friendkey", dataDir, 0, 0)
+	dbKey, err = pttdb.OpenDatabase("friendkey", dataDir)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return pttdb.MigrateAll(dbMeta, migrateOpts, dbFriendCore, dbKey)
 }
 
 func initMyInfo(id *types.PttID, nodeID *discover.NodeID) error {
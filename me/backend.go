@@ -35,7 +35,7 @@ type Backend struct {
 }
 
 func NewBackend(ctx *pkgservice.ServiceContext, cfg *Config, ptt *pkgservice.BasePtt, accountBackend *account.Backend, contentBackend *content.Backend, friendBacked *friend.Backend) (*Backend, error) {
-	err := InitMe(cfg.DataDir)
+	err := InitMe(cfg.DataDir, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,6 +38,10 @@ type Config struct {
 	PrivateKey *ecdsa.PrivateKey `toml:"-"`
 	ID         *types.PttID      `toml:"-"` // we also need ID because other services need to know ID, but cannot directly acccess private-key and postfix.
 	Postfix    string
+
+	// KeyPassphrase returns the passphrase of the encrypted private-key. If it is nil or
+	// no passphrase is set, the private-key is saved unencrypted.
+	KeyPassphrase crypto.KeyPassphrase `toml:"-"`
 }
 
 func (c *Config) SetMyKey(hex string, file string, postfix string, isSave bool) error {
@@ -51,7 +55,7 @@ func (c *Config) SetMyKey(hex string, file string, postfix string, isSave bool)
 	case file != "" && hex != "":
 		return ErrInvalidPrivateKeyFileHex
 	case file != "":
-		if key, err = crypto.LoadECDSA(file); err != nil {
+		if key, _, err = crypto.LoadECDSAWithPassphrase(file, c.KeyPassphrase); err != nil {
 			return ErrInvalidPrivateKeyFile
 		}
 		c.PrivateKey = key
@@ -126,7 +130,7 @@ func (c *Config) myKey() (*ecdsa.PrivateKey, string, *types.PttID, error) {
 
 	// retrieve key / id from file
 	keyfile := c.ResolvePath(DataDirPrivateKey)
-	key, err := crypto.LoadECDSA(keyfile)
+	key, isEncrypted, err := crypto.LoadECDSAWithPassphrase(keyfile, c.KeyPassphrase)
 	postfixBytes, err2 := ioutil.ReadFile(keyfile + ".postfix")
 	if err == nil && err2 == nil {
 		id, err := types.NewPttIDFromKeyPostfix(key, postfixBytes)
@@ -134,9 +138,22 @@ func (c *Config) myKey() (*ecdsa.PrivateKey, string, *types.PttID, error) {
 			return nil, "", nil, ErrInvalidMe
 		}
 
+		if !isEncrypted {
+			c.encryptKey(keyfile, key)
+		}
+
 		return key, string(postfixBytes), id, nil
 	}
 
+	// do not replace the encrypted key with a new one.
+	if isEncrypted {
+		if err == nil {
+			err = err2
+		}
+		log.Error(fmt.Sprintf("Failed to unlock key: %v", err))
+		return nil, "", nil, err
+	}
+
 	log.Warn(fmt.Sprintf("Failed to load key: %v. create a new one.", err))
 	// No persistent key found, generate and store a new one.
 	key, err = crypto.GenerateKey()
@@ -176,11 +193,17 @@ func (c *Config) ResolvePath(path string) string {
 	return filepath.Join(c.DataDir, path)
 }
 
+/*
+SaveKey saves the private-key (encrypted if the passphrase is set) and the postfix.
+*/
 func (c *Config) SaveKey(filename string, key *ecdsa.PrivateKey, postfix string) error {
-	err := crypto.SaveECDSA(filename, key)
+	isEncrypted, err := crypto.SaveECDSAWithPassphrase(filename, key, c.KeyPassphrase)
 	if err != nil {
 		return err
 	}
+	if !isEncrypted {
+		log.Warn("My key is saved unencrypted. Set the key passphrase to encrypt it.", "keyfile", filename)
+	}
 
 	postfixFilename := filename + ".postfix"
 	err = ioutil.WriteFile(postfixFilename, []byte(postfix), 0600)
@@ -192,7 +215,7 @@ func (c *Config) SaveKey(filename string, key *ecdsa.PrivateKey, postfix string)
 }
 
 func (c *Config) LoadKey(filename string) (*ecdsa.PrivateKey, *types.PttID, error) {
-	key, err := crypto.LoadECDSA(filename)
+	key, _, err := crypto.LoadECDSAWithPassphrase(filename, c.KeyPassphrase)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -205,6 +228,31 @@ func (c *Config) LoadKey(filename string) (*ecdsa.PrivateKey, *types.PttID, erro
 	return key, id, nil
 }
 
+/*
+encryptKey replaces the unencrypted private-key with the encrypted one if the passphrase is set.
+*/
+func (c *Config) encryptKey(filename string, key *ecdsa.PrivateKey) {
+	if c.KeyPassphrase == nil {
+		log.Warn("My key is unencrypted", "keyfile", filename)
+		return
+	}
+
+	passphrase, err := c.KeyPassphrase(false)
+	if err == crypto.ErrNoPassphrase {
+		log.Warn("My key is unencrypted", "keyfile", filename)
+		return
+	}
+	if err == nil {
+		err = crypto.SaveEncryptedECDSA(filename, key, passphrase)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to encrypt key: %v", err))
+		return
+	}
+
+	log.Info("My key is encrypted", "keyfile", filename)
+}
+
 func (c *Config) RevokeKey() error {
 	keyfile := c.ResolvePath(DataDirPrivateKey)
 
@@ -54,23 +54,28 @@ var (
 	DefaultTitle = []byte("")
 )
 
+// backup
+var (
+	myKeyFile = ""
+)
+
 // db
 var (
-	DBMePrefix                    = []byte(".medb")
-	dbMe       *pttdb.LDBDatabase = nil
-	dbMeBatch  *pttdb.LDBBatch    = nil
+	DBMePrefix                  = []byte(".medb")
+	dbMe       pttdb.KVDatabase = nil
+	dbMeBatch  *pttdb.LDBBatch  = nil
 
 	DBMyNodePrefix = []byte(".mndb")
 
-	DBRaftPrefix                    = []byte(".rfdb")
-	dbRaft       *pttdb.LDBDatabase = nil
+	DBRaftPrefix                  = []byte(".rfdb")
+	dbRaft       pttdb.KVDatabase = nil
 
-	dbMyNodes *pttdb.LDBDatabase = nil
+	dbMyNodes pttdb.KVDatabase = nil
 
-	dbMeta *pttdb.LDBDatabase = nil
+	dbMeta pttdb.KVDatabase = nil
 
-	dbKeyCore *pttdb.LDBDatabase = nil
-	dbKey     *pttdb.LDBBatch    = nil
+	dbKeyCore pttdb.KVDatabase = nil
+	dbKey     *pttdb.LDBBatch  = nil
 
 	DBKeyRaftHardState = []byte(".rfhs")
 	DBKeyRaftSnapshot  = []byte(".rfsn")
@@ -93,11 +98,16 @@ const (
 	RaftMaxInflightMsgs = 16
 )
 
-func InitMe(dataDir string) error {
+func InitMe(dataDir string, migrateOpts *pttdb.MigrateOptions) error {
 	var err error
 
+	// backup
+	myKeyFile = filepath.Join(dataDir, DataDirPrivateKey)
+	pttdb.RegisterBackupFile(myKeyFile)
+	pttdb.RegisterBackupFile(myKeyFile + ".postfix")
+
 	// db
-	dbMe, err = pttdb.NewLDBDatabase("me", dataDir, 0, 0)
+	dbMe, err = pttdb.OpenDatabase("me", dataDir)
 	if err != nil {
 		return err
 	}
@@ -107,22 +117,22 @@ func InitMe(dataDir string) error {
 		return err
 	}
 
-	dbMyNodes, err = pttdb.NewLDBDatabase("mynodes", dataDir, 0, 0)
+	dbMyNodes, err = pttdb.OpenDatabase("mynodes", dataDir)
 	if err != nil {
 		return err
 	}
 
-	dbRaft, err = pttdb.NewLDBDatabase("raft", dataDir, 0, 0)
+	dbRaft, err = pttdb.OpenDatabase("raft", dataDir)
 	if err != nil {
 		return err
 	}
 
-	dbMeta, err = pttdb.NewLDBDatabase("memeta", dataDir, 0, 0)
+	dbMeta, err = pttdb.OpenDatabase("memeta", dataDir)
 	if err != nil {
 		return err
 	}
 
-	dbKeyCore, err = pttdb.NewLDBDatabase("signkey", dataDir, 0, 0)
+	dbKeyCore, err = pttdb.OpenDatabase("signkey", dataDir)
 	if err != nil {
 		return err
 	}
@@ -132,7 +142,7 @@ func InitMe(dataDir string) error {
 		return err
 	}
 
-	return nil
+	return pttdb.MigrateAll(dbMeta, migrateOpts, dbMe, dbMyNodes, dbRaft, dbKeyCore)
 }
 
 func initMyInfo(id *types.PttID, nodeID *discover.NodeID, key *ecdsa.PrivateKey, nodeType pkgservice.NodeType) error {
@@ -160,6 +170,12 @@ func initMyInfo(id *types.PttID, nodeID *discover.NodeID, key *ecdsa.PrivateKey,
 }
 
 func TeardownMe() {
+	if myKeyFile != "" {
+		pttdb.UnregisterBackupFile(myKeyFile)
+		pttdb.UnregisterBackupFile(myKeyFile + ".postfix")
+		myKeyFile = ""
+	}
+
 	if dbMe != nil {
 		dbMe.Close()
 		dbMe = nil
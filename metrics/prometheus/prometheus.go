@@ -0,0 +1,258 @@
+// Package prometheus exposes the metrics registry in the Prometheus text exposition format.
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ailabstw/go-pttai/metrics"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// Namespace is prepended to all the metric names.
+	Namespace = "gptt"
+
+	// LabeledPrefixes maps the prefixes of the registered names to the labels.
+	// The path segment following the prefix is taken as the label value:
+	// "pttdb/me.memeta/compact/time" is exposed as gptt_pttdb_compact_time_total{db="me.memeta"}.
+	LabeledPrefixes = map[string]string{
+		"pttdb/":     "db",
+		"ptt/peers/": "type",
+	}
+
+	// Quantiles of the timers and the histograms.
+	Quantiles = []float64{0.5, 0.75, 0.95, 0.99}
+)
+
+// Handler returns the handler serving the metrics of the registry.
+func Handler(r metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Write(w, r)
+	})
+}
+
+// Write writes the metrics of the registry in the text exposition format.
+func Write(w io.Writer, r metrics.Registry) error {
+	c := newCollector()
+	r.Each(c.add)
+
+	_, err := w.Write(c.bytes())
+	return err
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	suffix string
+	labels []label
+	value  float64
+}
+
+// key returns the labels of the sample except the quantile.
+func (s *sample) key() string {
+	key := ""
+	for _, l := range s.labels {
+		if l.name == "quantile" {
+			continue
+		}
+		key += l.name + "=" + l.value + ","
+	}
+	return key
+}
+
+type family struct {
+	name    string
+	typ     string
+	samples []*sample
+}
+
+type collector struct {
+	families map[string]*family
+}
+
+func newCollector() *collector {
+	return &collector{families: make(map[string]*family)}
+}
+
+func (c *collector) add(name string, i interface{}) {
+	name, labels := parseName(name)
+
+	switch m := i.(type) {
+	case metrics.Counter:
+		c.addSample(name+"_total", "counter", "", labels, float64(m.Count()))
+	case metrics.Gauge:
+		c.addSample(name, "gauge", "", labels, float64(m.Value()))
+	case metrics.GaugeFloat64:
+		c.addSample(name, "gauge", "", labels, m.Value())
+	case metrics.Meter:
+		c.addSample(name+"_total", "counter", "", labels, float64(m.Snapshot().Count()))
+	case metrics.Timer:
+		t := m.Snapshot()
+		c.addSummary(name+"_seconds", labels, t.Percentiles(Quantiles), float64(t.Sum())/1e9, t.Count(), 1e9)
+	case metrics.ResettingTimer:
+		t := m.Snapshot()
+		values := t.Values()
+		if len(values) == 0 {
+			return
+		}
+
+		percents := make([]float64, len(Quantiles))
+		for i, q := range Quantiles {
+			percents[i] = q * 100
+		}
+		ps := t.Percentiles(percents)
+		quantiles := make([]float64, len(ps))
+		for i, p := range ps {
+			quantiles[i] = float64(p)
+		}
+
+		sum := int64(0)
+		for _, v := range values {
+			sum += v
+		}
+		c.addSummary(name+"_seconds", labels, quantiles, float64(sum)/1e9, int64(len(values)), 1e9)
+	case metrics.Histogram:
+		h := m.Snapshot()
+		c.addSummary(name, labels, h.Percentiles(Quantiles), float64(h.Sum()), h.Count(), 1)
+	}
+}
+
+// addSummary adds the quantiles (divided by scale), the sum and the count of the summary.
+func (c *collector) addSummary(name string, labels []label, quantiles []float64, sum float64, count int64, scale float64) {
+	for i, q := range Quantiles {
+		qLabels := append(append([]label{}, labels...), label{"quantile", strconv.FormatFloat(q, 'g', -1, 64)})
+		c.addSample(name, "summary", "", qLabels, quantiles[i]/scale)
+	}
+	c.addSample(name, "summary", "_sum", labels, sum)
+	c.addSample(name, "summary", "_count", labels, float64(count))
+}
+
+func (c *collector) addSample(name string, typ string, suffix string, labels []label, value float64) {
+	f, ok := c.families[name]
+	if !ok {
+		f = &family{name: name, typ: typ}
+		c.families[name] = f
+	}
+
+	f.samples = append(f.samples, &sample{suffix: suffix, labels: labels, value: value})
+}
+
+func (c *collector) bytes() []byte {
+	names := make([]string, 0, len(c.families))
+	for name := range c.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		f := c.families[name]
+
+		// sorted by the labels, the quantiles / sum / count of a summary are kept in order.
+		sort.SliceStable(f.samples, func(i, j int) bool {
+			return f.samples[i].key() < f.samples[j].key()
+		})
+
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.samples {
+			buf.WriteString(f.name + s.suffix)
+			writeLabels(buf, s.labels)
+			buf.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func writeLabels(buf *bytes.Buffer, labels []label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	buf.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(l.name + `="` + escapeLabelValue(l.value) + `"`)
+	}
+	buf.WriteByte('}')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// parseName converts the registered name to the metric name and the labels.
+func parseName(name string) (string, []label) {
+	var labels []label
+	for prefix, labelName := range LabeledPrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		rest := name[len(prefix):]
+		value := rest
+		idx := strings.Index(rest, "/")
+		if idx >= 0 {
+			value = rest[:idx]
+		}
+		labels = append(labels, label{labelName, value})
+		name = strings.TrimSuffix(prefix, "/") + rest[len(value):]
+		break
+	}
+
+	return metricName(name), labels
+}
+
+// metricName converts the registered name to [a-z0-9_]+ with Namespace,
+// the camel-cases are converted to the snake-cases ("p2p/InboundTraffic": gptt_p2p_inbound_traffic).
+func metricName(name string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(Namespace + "_")
+
+	isPrevLower := false
+	for _, r := range name {
+		switch {
+		case unicode.IsUpper(r):
+			if isPrevLower {
+				buf.WriteByte('_')
+			}
+			buf.WriteRune(unicode.ToLower(r))
+			isPrevLower = false
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			buf.WriteRune(r)
+			isPrevLower = true
+		default:
+			buf.WriteByte('_')
+			isPrevLower = false
+		}
+	}
+
+	// collapse the consecutive underscores
+	result := buf.String()
+	for strings.Contains(result, "__") {
+		result = strings.Replace(result, "__", "_", -1)
+	}
+
+	return strings.TrimSuffix(result, "_")
+}
@@ -0,0 +1,98 @@
+package prometheus
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ailabstw/go-pttai/metrics"
+)
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		labels []label
+	}{
+		{"p2p/InboundTraffic", "gptt_p2p_inbound_traffic", nil},
+		{"peer.send_t", "gptt_peer_send_t", nil},
+		{"system/memory/allocs", "gptt_system_memory_allocs", nil},
+		{"pttdb/me.memeta/compact/time", "gptt_pttdb_compact_time", []label{{"db", "me.memeta"}}},
+		{"ptt/peers/important", "gptt_ptt_peers", []label{{"type", "important"}}},
+	}
+
+	for _, tt := range tests {
+		name, labels := parseName(tt.name)
+		if name != tt.want || len(labels) != len(tt.labels) || (len(labels) == 1 && labels[0] != tt.labels[0]) {
+			t.Errorf("parseName(%v) = (%v, %v), want (%v, %v)", tt.name, name, labels, tt.want, tt.labels)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	if !metrics.Enabled {
+		metrics.Enabled = true
+		defer func() { metrics.Enabled = false }()
+	}
+
+	r := metrics.NewRegistry()
+
+	metrics.NewRegisteredCounter("ptt/msg/errors", r).Inc(3)
+	metrics.NewRegisteredGauge("ptt/peers/me", r).Update(2)
+	metrics.NewRegisteredGauge("ptt/peers/random", r).Update(5)
+	meter := metrics.NewRegisteredMeter("pttdb/me.memeta/disk/read", r)
+	meter.Mark(1024)
+	defer meter.Stop()
+	meter2 := metrics.NewRegisteredMeter("pttdb/content.board/disk/read", r)
+	meter2.Mark(10)
+	defer meter2.Stop()
+	timer := metrics.NewRegisteredTimer("ptt/sync", r)
+	timer.Update(2 * time.Second)
+	defer timer.Stop()
+	metrics.NewRegisteredGaugeFloat64("ptt/label\"value", r).Update(1.5)
+
+	hs := httptest.NewServer(Handler(r))
+	defer hs.Close()
+
+	resp, err := http.Get(hs.URL)
+	if err != nil {
+		t.Fatalf("unable to get: e: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != ContentType {
+		t.Errorf("Content-Type: %v", resp.Header.Get("Content-Type"))
+	}
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(resp.Body)
+	body := buf.String()
+
+	expected := []string{
+		"# TYPE gptt_ptt_msg_errors_total counter\ngptt_ptt_msg_errors_total 3\n",
+		"# TYPE gptt_ptt_peers gauge\n",
+		`gptt_ptt_peers{type="me"} 2`,
+		`gptt_ptt_peers{type="random"} 5`,
+		"# TYPE gptt_pttdb_disk_read_total counter\n",
+		`gptt_pttdb_disk_read_total{db="me.memeta"} 1024`,
+		`gptt_pttdb_disk_read_total{db="content.board"} 10`,
+		"# TYPE gptt_ptt_sync_seconds summary\n",
+		`gptt_ptt_sync_seconds{quantile="0.5"} 2`,
+		"gptt_ptt_sync_seconds_sum 2\n",
+		"gptt_ptt_sync_seconds_count 1\n",
+		"gptt_ptt_label_value 1.5\n",
+	}
+	for _, each := range expected {
+		if !strings.Contains(body, each) {
+			t.Errorf("expected %q in:\n%v", each, body)
+		}
+	}
+
+	// one TYPE line per family
+	if strings.Count(body, "# TYPE gptt_ptt_peers ") != 1 {
+		t.Errorf("duplicated family:\n%v", body)
+	}
+}
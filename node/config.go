@@ -34,7 +34,9 @@ package node
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -47,6 +49,7 @@ import (
 	"github.com/ailabstw/go-pttai/log"
 	"github.com/ailabstw/go-pttai/p2p"
 	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/rpc"
 )
 
 type Config struct {
@@ -76,6 +79,10 @@ type Config struct {
 	// is created by New and destroyed when the node is stopped.
 	KeyStoreDir string `toml:",omitempty"`
 
+	// KeyPassphrase returns the passphrase of the encrypted node key. If it is nil or
+	// no passphrase is set, the node key is saved unencrypted.
+	KeyPassphrase crypto.KeyPassphrase `toml:"-"`
+
 	// IPCPath is the requested location to place the IPC endpoint. If the path is
 	// a simple file name, it is placed inside the data directory (or on the root
 	// pipe path on Windows), whereas if it's a resolvable path name (absolute or
@@ -138,6 +145,13 @@ type Config struct {
 	// private APIs to untrusted users is a major security risk.
 	WSExposeAll bool `toml:",omitempty"`
 
+	// RPCNoAuth disables the bearer-token authentication of the HTTP and websocket
+	// RPC servers. The tokens are generated in the instance directory (DataDirRPCTokens).
+	// The IPC and the in-process endpoints are always trusted.
+	//
+	// *WARNING* Only set this if the node is running in a trusted network.
+	RPCNoAuth bool `toml:",omitempty"`
+
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 
@@ -206,54 +220,74 @@ func (c *Config) instanceDir() string {
 // NodeKey retrieves the currently configured private key of the node, checking
 // first any manually set key, falling back to the one found in the configured
 // data folder. If no key can be found, a new one is generated.
-func (c *Config) NodeKey() *ecdsa.PrivateKey {
+// The encrypted key failing to unlock is returned as the error instead of being replaced.
+func (c *Config) NodeKey() (*ecdsa.PrivateKey, error) {
 	// Use any specifically configured key.
 	if c.P2P.PrivateKey != nil {
-		return c.P2P.PrivateKey
+		return c.P2P.PrivateKey, nil
 	}
 	// Generate ephemeral key if no datadir is being used.
 	if c.DataDir == "" {
 		key, err := discover.GenerateNodeKey()
 		if err != nil {
-			log.Crit(fmt.Sprintf("Failed to generate ephemeral node key: %v", err))
+			log.Error(fmt.Sprintf("Failed to generate ephemeral node key: %v", err))
+			return nil, err
 		}
 
-		return key
+		return key, nil
 	}
 
 	// retrieve key / postfix from file
 	keyfile := c.ResolvePath(DataDirPrivateKey)
-	key, err := discover.LoadECDSA(keyfile)
+	key, isEncrypted, err := crypto.LoadECDSAWithPassphrase(keyfile, c.KeyPassphrase)
 	if err == nil {
-		return key
+		if !isEncrypted {
+			c.encryptKey(keyfile, key)
+		}
+		return key, nil
+	}
+	// do not replace the encrypted key with a new one.
+	if isEncrypted {
+		log.Error(fmt.Sprintf("Failed to unlock node key: %v", err))
+		return nil, err
 	}
 
 	log.Warn(fmt.Sprintf("Failed to load key: %v. create a new one.", err))
 	// No persistent key found, generate and store a new one.
 	key, err = discover.GenerateNodeKey()
 	if err != nil {
-		log.Crit(fmt.Sprintf("Failed to generate node key: %v", err))
+		log.Error(fmt.Sprintf("Failed to generate node key: %v", err))
+		return nil, err
 	}
 
 	instanceDir := filepath.Join(c.DataDir, c.name())
 	if err := os.MkdirAll(instanceDir, 0700); err != nil {
 		log.Error(fmt.Sprintf("Failed to persist node key: %v", err))
-		return key
+		return key, nil
 	}
 
 	keyfile = filepath.Join(instanceDir, DataDirPrivateKey)
 	if err := c.SaveKey(keyfile, key); err != nil {
 		log.Error(fmt.Sprintf("Failed to persist node key: %v", err))
 	}
-	return key
+	return key, nil
 }
 
+// SaveKey saves the node key, encrypted if the passphrase is set.
 func (c *Config) SaveKey(filename string, key *ecdsa.PrivateKey) error {
-	return crypto.SaveECDSA(filename, key)
+	isEncrypted, err := crypto.SaveECDSAWithPassphrase(filename, key, c.KeyPassphrase)
+	if err != nil {
+		return err
+	}
+	if !isEncrypted {
+		log.Warn("Node key is saved unencrypted. Set the key passphrase to encrypt it.", "keyfile", filename)
+	}
+
+	return nil
 }
 
 func (c *Config) LoadKey(filename string) (*ecdsa.PrivateKey, error) {
-	key, err := crypto.LoadECDSA(filename)
+	key, _, err := crypto.LoadECDSAWithPassphrase(filename, c.KeyPassphrase)
 	if err != nil {
 		return nil, err
 	}
@@ -261,6 +295,29 @@ func (c *Config) LoadKey(filename string) (*ecdsa.PrivateKey, error) {
 	return key, nil
 }
 
+// encryptKey replaces the unencrypted node key with the encrypted one if the passphrase is set.
+func (c *Config) encryptKey(filename string, key *ecdsa.PrivateKey) {
+	if c.KeyPassphrase == nil {
+		log.Warn("Node key is unencrypted", "keyfile", filename)
+		return
+	}
+
+	passphrase, err := c.KeyPassphrase(false)
+	if err == crypto.ErrNoPassphrase {
+		log.Warn("Node key is unencrypted", "keyfile", filename)
+		return
+	}
+	if err == nil {
+		err = crypto.SaveEncryptedECDSA(filename, key, passphrase)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to encrypt node key: %v", err))
+		return
+	}
+
+	log.Info("Node key is encrypted", "keyfile", filename)
+}
+
 func (c *Config) RevokeKeyPath() error {
 	instanceDir := filepath.Join(c.DataDir, c.name())
 	keyfile := filepath.Join(instanceDir, DataDirPrivateKey)
@@ -320,6 +377,59 @@ func (c *Config) parsePersistentNodes(path string) []*discover.Node {
 	return nodes
 }
 
+// RPCAuthTokens loads the tokens of the HTTP and websocket RPC servers,
+// generating the admin-token and the read-only token if the token file does not exist.
+func (c *Config) RPCAuthTokens() ([]*rpc.AuthToken, error) {
+	// Generate ephemeral tokens if no datadir is being used.
+	if c.DataDir == "" {
+		return newRPCAuthTokens()
+	}
+
+	filename := c.ResolvePath(DataDirRPCTokens)
+	if _, err := os.Stat(filename); err == nil {
+		var tokens []*rpc.AuthToken
+		if err := common.LoadJSON(filename, &tokens); err != nil {
+			return nil, err
+		}
+		return tokens, nil
+	}
+
+	tokens, err := newRPCAuthTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(c.instanceDir(), 0700); err != nil {
+		return nil, err
+	}
+
+	marshaled, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filename, marshaled, 0600); err != nil {
+		return nil, err
+	}
+
+	log.Info("RPCAuthTokens: generated", "file", filename)
+
+	return tokens, nil
+}
+
+func newRPCAuthTokens() ([]*rpc.AuthToken, error) {
+	adminToken, err := rpc.NewAuthToken(RPCAuthTokenAdmin, []string{rpc.AuthScopeAll})
+	if err != nil {
+		return nil, err
+	}
+
+	readToken, err := rpc.NewAuthToken(RPCAuthTokenRead, rpc.DefaultReadScopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*rpc.AuthToken{adminToken, readToken}, nil
+}
+
 // IPCEndpoint resolves an IPC endpoint based on a configured value, taking into
 // account the set data folders as well as the designated platform we're currently
 // running on.
@@ -39,6 +39,7 @@ const (
 	DataDirStaticNodes     = "static-nodes.json"  // Path within the datadir to the static node list
 	DataDirTrustedNodes    = "trusted-nodes.json" // Path within the datadir to the trusted node list
 	DataDirNodeDatabase    = "nodes"              // Path within the datadir to store the node infos
+	DataDirRPCTokens       = "rpc-tokens.json"    // Path within the datadir to the auth tokens of the HTTP/WS RPC
 
 	DefaultHTTPHost = ""    // Default host interface for the HTTP RPC server
 	DefaultHTTPPort = 14779 // Default TCP port for the HTTP RPC server
@@ -46,6 +47,9 @@ const (
 	DefaultWSPort   = 15779 // Default TCP port for the websocket RPC server
 
 	DefaultNetworkID = Devnet
+
+	RPCAuthTokenAdmin = "admin"
+	RPCAuthTokenRead  = "read"
 )
 
 var (
@@ -82,6 +82,8 @@ type Node struct {
 	wsListener net.Listener // Websocket RPC listener socket to server API requests
 	wsHandler  *rpc.Server  // Websocket RPC request handler to process the API requests
 
+	rpcAuth *rpc.Authenticator // Bearer-token authenticator of the HTTP and websocket RPC (nil = no auth)
+
 	lock     sync.RWMutex
 	StopChan chan error
 
@@ -160,8 +162,13 @@ func (n *Node) Start() error {
 
 	// Initialize the p2p server. This creates the node key and
 	// discovery databases.
+	nodeKey, err := n.Config.NodeKey()
+	if err != nil {
+		return err
+	}
+
 	n.serverConfig = n.Config.P2P
-	n.serverConfig.PrivateKey = n.Config.NodeKey()
+	n.serverConfig.PrivateKey = nodeKey
 	n.serverConfig.Name = n.Config.NodeName()
 	n.serverConfig.Logger = n.log
 	if n.serverConfig.StaticNodes == nil {
@@ -332,6 +339,14 @@ func (n *Node) Services() map[reflect.Type]pkgservice.PttService {
 
 }
 
+// RPCAuthenticator returns the authenticator of the HTTP and websocket RPC (nil if no auth).
+func (n *Node) RPCAuthenticator() *rpc.Authenticator {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	return n.rpcAuth
+}
+
 // RPCHandler returns the in-process RPC request handler.
 func (n *Node) RPCHandler() (*rpc.Server, error) {
 	n.lock.RLock()
@@ -392,6 +407,10 @@ func (n *Node) startRPC(services map[reflect.Type]pkgservice.PttService) error {
 		apis = append(apis, service.APIs()...)
 	}
 
+	if err := n.setRPCAuth(); err != nil {
+		return err
+	}
+
 	// Start the various API endpoints, terminating all in case of errors
 	log.Debug("startRPC: to startInProc")
 	if err := n.startInProc(apis); err != nil {
@@ -422,6 +441,30 @@ func (n *Node) startRPC(services map[reflect.Type]pkgservice.PttService) error {
 	return nil
 }
 
+// setRPCAuth loads the auth tokens of the HTTP and websocket RPC endpoints.
+func (n *Node) setRPCAuth() error {
+	if n.Config.RPCNoAuth {
+		n.log.Warn("RPC authentication disabled")
+		n.rpcAuth = nil
+		return nil
+	}
+
+	tokens, err := n.Config.RPCAuthTokens()
+	if err != nil {
+		return err
+	}
+
+	auth, err := rpc.NewAuthenticator(tokens)
+	if err != nil {
+		return err
+	}
+
+	n.log.Info("RPC authentication enabled", "tokens", n.Config.ResolvePath(DataDirRPCTokens))
+	n.rpcAuth = auth
+
+	return nil
+}
+
 // startInProc initializes an in-process RPC endpoint.
 func (n *Node) startInProc(apis []rpc.API) error {
 	// Register all the APIs exposed by the services
@@ -483,7 +526,7 @@ func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors
 	if endpoint == "" {
 		return nil
 	}
-	listener, handler, httpServer, err := rpc.StartHTTPEndpoint(endpoint, apis, modules, cors, vhosts)
+	listener, handler, httpServer, err := rpc.StartHTTPEndpoint(endpoint, apis, modules, cors, vhosts, n.rpcAuth)
 	if err != nil {
 		return err
 	}
@@ -522,7 +565,7 @@ func (n *Node) startWS(endpoint string, apis []rpc.API, modules []string, wsOrig
 	if endpoint == "" {
 		return nil
 	}
-	listener, handler, err := rpc.StartWSEndpoint(endpoint, apis, modules, wsOrigins, exposeAll)
+	listener, handler, err := rpc.StartWSEndpoint(endpoint, apis, modules, wsOrigins, exposeAll, n.rpcAuth)
 	if err != nil {
 		return err
 	}
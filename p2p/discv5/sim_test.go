@@ -140,6 +140,68 @@ func TestSimTopics(t *testing.T) {
 	return res
 }*/
 
+// In this test, the members of an entity register the entity-topic,
+// and a joiner without direct peers finds the members by searching the topic.
+func TestSimEntityTopicSearch(t *testing.T) {
+	if runWithPlaygroundTime(t) {
+		return
+	}
+	sim := newSimulation()
+	bootnode := sim.launchNode(false)
+
+	nets := make([]*Network, 64)
+	for i := range nets {
+		net := sim.launchNode(false)
+		nets[i] = net
+		if err := net.SetFallbackNodes([]*Node{bootnode.Self()}); err != nil {
+			panic(err)
+		}
+		time.Sleep(time.Second * 5)
+	}
+
+	// members register the topic of the entity.
+	entityID := common.Hex2Bytes("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	topic := EntityTopic("ptt1", entityID)
+	members := make(map[NodeID]bool)
+	stop := make(chan struct{})
+	for _, net := range nets[:8] {
+		members[net.Self().ID] = true
+		go net.RegisterTopic(topic, stop)
+	}
+	time.Sleep(time.Minute * 10)
+
+	// joiner searches the topic.
+	joiner := nets[len(nets)-1]
+	setPeriod := make(chan time.Duration, 1)
+	found := make(chan *Node, 10)
+	setPeriod <- time.Second
+	go joiner.SearchTopic(topic, setPeriod, found, nil)
+
+	foundMembers := make(map[NodeID]bool)
+	timeout := time.After(time.Hour)
+loop:
+	for len(foundMembers) < len(members) {
+		select {
+		case n := <-found:
+			if !members[n.ID] {
+				t.Errorf("found non-member: %x", n.ID[:8])
+				continue
+			}
+			foundMembers[n.ID] = true
+		case <-timeout:
+			break loop
+		}
+	}
+
+	close(setPeriod)
+	close(stop)
+	sim.shutdown()
+
+	if len(foundMembers) == 0 {
+		t.Errorf("no member found")
+	}
+}
+
 func testHierarchicalTopics(i int) []Topic {
 	digits := strconv.FormatInt(int64(128+i/8), 2)
 	res := make([]Topic, 8)
@@ -18,12 +18,14 @@ package discv5
 
 import (
 	"container/heap"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"math/rand"
 	"time"
 
 	"github.com/ailabstw/go-pttai/common/mclock"
+	"github.com/ailabstw/go-pttai/crypto"
 	"github.com/ailabstw/go-pttai/log"
 )
 
@@ -32,10 +34,18 @@ const (
 	maxEntriesPerTopic = 50
 
 	fallbackRegistrationExpiry = 1 * time.Hour
+
+	SizeEntityTopicHash = 16
 )
 
 type Topic string
 
+// EntityTopic returns the topic of the entity of the protocol, as <protocol>/<hex of keccak256(id)[:16]>.
+func EntityTopic(protocolName string, id []byte) Topic {
+	hash := crypto.Keccak256(id)
+	return Topic(protocolName + "/" + hex.EncodeToString(hash[:SizeEntityTopicHash]))
+}
+
 type topicEntry struct {
 	topic   Topic
 	fifoIdx uint64
@@ -272,6 +272,13 @@ func (srv *Server) Peers() []*Peer {
 	return ps
 }
 
+// IsRunning returns whether the server is running.
+func (srv *Server) IsRunning() bool {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.running
+}
+
 // PeerCount returns the number of connected peers.
 func (srv *Server) PeerCount() int {
 	var count int
@@ -0,0 +1,480 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/rlp"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+The backup archive is:
+
+	magic | version (uint16) | scrypt N, r, p (uint32) | salt | iv | ciphertext | hmac
+
+The ciphertext is the AES-256-CTR encryption of a stream of rlp-encoded backupRecord,
+and the hmac (sha256) covers everything before it.
+The keys of AES and hmac are derived from the passphrase with scrypt.
+
+The stream is a backupRecordDB followed by the backupRecordKV of the db, for each db,
+the backupRecordFile of the files, and ends with a backupRecordManifest.
+*/
+type backupRecordType uint8
+
+const (
+	backupRecordDB backupRecordType = iota
+	backupRecordKV
+	backupRecordFile
+	backupRecordManifest
+)
+
+type backupRecord struct {
+	Type  backupRecordType
+	Path  string
+	Key   []byte
+	Value []byte
+}
+
+/*
+BackupItem is the checksum of a db or a file in the backup.
+
+N is the number of the key-values of a db, and the size of a file.
+*/
+type BackupItem struct {
+	Path string `json:"P"`
+	IsDB bool   `json:"D"`
+	N    int64  `json:"N"`
+	Hash []byte `json:"H"`
+}
+
+type BackupManifest struct {
+	Version uint16          `json:"V"`
+	TS      types.Timestamp `json:"T"`
+	Items   []*BackupItem   `json:"I"`
+}
+
+type backupHeader struct {
+	Version uint16
+	ScryptN uint32
+	ScryptR uint32
+	ScryptP uint32
+	Salt    [backupSaltLength]byte
+	IV      [aes.BlockSize]byte
+}
+
+var (
+	backupDBs   = make(map[string]*LDBDatabase)
+	backupFiles = make(map[string]bool)
+	backupLock  sync.Mutex
+)
+
+func registerBackupDB(db *LDBDatabase) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	backupDBs[db.Path()] = db
+}
+
+func unregisterBackupDB(db *LDBDatabase) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	if backupDBs[db.Path()] == db {
+		delete(backupDBs, db.Path())
+	}
+}
+
+/*
+RegisterBackupFile registers the file (ex: the private key of me) to be included in the backups.
+*/
+func RegisterBackupFile(filename string) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	backupFiles[filename] = true
+}
+
+func UnregisterBackupFile(filename string) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	delete(backupFiles, filename)
+}
+
+/*
+Backup writes the encrypted archive of all the opened LevelDBs and the registered files to filename.
+
+Each LevelDB is read from a snapshot, so Backup can run while the node is running.
+The paths in the archive are relative to rootDir.
+Backup fails with ErrBackupOutsideDataDir if any of the dbs or the files is outside rootDir
+(ex: the content keystore-dir with the op-keys), because the archive would not be a complete backup.
+*/
+func Backup(filename string, rootDir string, passphrase string) (*BackupManifest, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyBackupPassphrase
+	}
+
+	// registered dbs / files, copied without holding backupLock while writing the archive.
+	dbs, filePaths := getBackupItems()
+
+	for _, path := range filePaths {
+		err := checkBackupInDataDir(path, rootDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// snapshots
+	dbPaths := make([]string, 0, len(dbs))
+	snapshots := make([]*leveldb.Snapshot, 0, len(dbs))
+	defer func() {
+		for _, snapshot := range snapshots {
+			snapshot.Release()
+		}
+	}()
+	for _, db := range dbs {
+		path := db.Path()
+		err := checkBackupInDataDir(path, rootDir)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot, err := db.db.GetSnapshot()
+		if err == leveldb.ErrClosed {
+			log.Warn("Backup: db closed", "db", path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dbPaths = append(dbPaths, path)
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// archive
+	header := &backupHeader{
+		Version: BackupVersion,
+		ScryptN: uint32(BackupScryptN),
+		ScryptR: uint32(BackupScryptR),
+		ScryptP: uint32(BackupScryptP),
+	}
+	_, err := types.RandRead(header.Salt[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = types.RandRead(header.IV[:])
+	if err != nil {
+		return nil, err
+	}
+
+	stream, mac, err := header.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpFilename := filename + ".tmp"
+	f, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFilename)
+	defer f.Close()
+
+	bufW := bufio.NewWriter(f)
+	w := io.MultiWriter(bufW, mac)
+
+	err = header.write(w)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &cipher.StreamWriter{S: stream, W: w}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{
+		Version: BackupVersion,
+		TS:      ts,
+	}
+
+	var item *BackupItem
+	for i, path := range dbPaths {
+		item, err = backupDB(sw, snapshots[i], path, rootDir)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Items = append(manifest.Items, item)
+	}
+
+	for _, path := range filePaths {
+		item, err = backupFile(sw, path, rootDir)
+		if os.IsNotExist(err) {
+			log.Warn("Backup: file not exists", "file", path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Items = append(manifest.Items, item)
+	}
+
+	marshaled, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	err = rlp.Encode(sw, &backupRecord{Type: backupRecordManifest, Value: marshaled})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = bufW.Write(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	err = bufW.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Backup: done", "file", filename, "items", len(manifest.Items))
+
+	return manifest, nil
+}
+
+/*
+getBackupItems returns the registered dbs and files, sorted by the paths.
+*/
+func getBackupItems() ([]*LDBDatabase, []string) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	dbs := make([]*LDBDatabase, 0, len(backupDBs))
+	for _, db := range backupDBs {
+		dbs = append(dbs, db)
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Path() < dbs[j].Path() })
+
+	filePaths := make([]string, 0, len(backupFiles))
+	for path := range backupFiles {
+		filePaths = append(filePaths, path)
+	}
+	sort.Strings(filePaths)
+
+	return dbs, filePaths
+}
+
+func checkBackupInDataDir(path string, rootDir string) error {
+	_, err := backupRelPath(path, rootDir)
+	if err == ErrBackupOutsideDataDir {
+		log.Error("Backup: the path is outside the data-dir. move it into the data-dir to backup", "path", path, "rootDir", rootDir)
+	}
+
+	return err
+}
+
+func backupDB(w io.Writer, snapshot *leveldb.Snapshot, path string, rootDir string) (*BackupItem, error) {
+	relPath, err := backupRelPath(path, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rlp.Encode(w, &backupRecord{Type: backupRecordDB, Path: relPath})
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	n := int64(0)
+
+	iter := snapshot.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key, val := iter.Key(), iter.Value()
+		err = rlp.Encode(w, &backupRecord{Type: backupRecordKV, Key: key, Value: val})
+		if err != nil {
+			return nil, err
+		}
+		hashBackupKV(h, key, val)
+		n++
+	}
+	err = iter.Error()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupItem{Path: relPath, IsDB: true, N: n, Hash: h.Sum(nil)}, nil
+}
+
+func backupFile(w io.Writer, path string, rootDir string) (*BackupItem, error) {
+	relPath, err := backupRelPath(path, rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rlp.Encode(w, &backupRecord{Type: backupRecordFile, Path: relPath, Value: content})
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(content)
+
+	return &BackupItem{Path: relPath, N: int64(len(content)), Hash: h[:]}, nil
+}
+
+func backupRelPath(path string, rootDir string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	absRootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	relPath, err := filepath.Rel(absRootDir, absPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateBackupPath(relPath)
+	if err != nil {
+		return "", ErrBackupOutsideDataDir
+	}
+
+	return filepath.ToSlash(relPath), nil
+}
+
+func validateBackupPath(path string) error {
+	if path == "" || filepath.IsAbs(path) {
+		return ErrInvalidBackupPath
+	}
+
+	path = filepath.Clean(filepath.FromSlash(path))
+	if path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return ErrInvalidBackupPath
+	}
+
+	return nil
+}
+
+func hashBackupKV(h hash.Hash, key []byte, val []byte) {
+	lenBytes := make([]byte, 4)
+
+	binary.BigEndian.PutUint32(lenBytes, uint32(len(key)))
+	h.Write(lenBytes)
+	h.Write(key)
+
+	binary.BigEndian.PutUint32(lenBytes, uint32(len(val)))
+	h.Write(lenBytes)
+	h.Write(val)
+}
+
+/**********
+ * header
+ **********/
+
+func (h *backupHeader) write(w io.Writer) error {
+	_, err := w.Write(BackupMagic)
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, h)
+}
+
+func readBackupHeader(r io.Reader) (*backupHeader, error) {
+	magic := make([]byte, len(BackupMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil {
+		return nil, ErrInvalidBackup
+	}
+	if string(magic) != string(BackupMagic) {
+		return nil, ErrInvalidBackup
+	}
+
+	h := &backupHeader{}
+	err = binary.Read(r, binary.BigEndian, h)
+	if err != nil {
+		return nil, ErrInvalidBackup
+	}
+
+	if h.Version != BackupVersion {
+		return nil, ErrBackupVersion
+	}
+
+	return h, nil
+}
+
+func backupHeaderLength() int64 {
+	return int64(len(BackupMagic) + binary.Size(&backupHeader{}))
+}
+
+/*
+cipher derives the AES-CTR stream and the hmac from the passphrase.
+*/
+func (h *backupHeader) cipher(passphrase string) (cipher.Stream, hash.Hash, error) {
+	key, err := scrypt.Key([]byte(passphrase), h.Salt[:], int(h.ScryptN), int(h.ScryptR), int(h.ScryptP), backupKeyLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cipher.NewCTR(block, h.IV[:]), hmac.New(sha256.New, key[32:]), nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBackup_Restore(t *testing.T) {
+	// setup test
+	origScryptN := BackupScryptN
+	BackupScryptN = 1 << 4
+	defer func() {
+		BackupScryptN = origScryptN
+	}()
+
+	rootDir, err := ioutil.TempDir("", "pttdb-backup")
+	if err != nil {
+		t.Fatalf("TempDir: e: %v", err)
+	}
+	defer func() {
+		os.RemoveAll(rootDir)
+		oldDirs, _ := filepath.Glob(rootDir + ".old.*")
+		for _, oldDir := range oldDirs {
+			os.RemoveAll(oldDir)
+		}
+	}()
+
+	dbA, _ := OpenDatabase("a", filepath.Join(rootDir, "svc"))
+	dbB, _ := OpenDatabase("b", filepath.Join(rootDir, "svc2"))
+	dbA.Put([]byte("key-a"), []byte("value-a"))
+	dbB.Put([]byte("key-b"), []byte("value-b"))
+
+	// the keystore-dir outside the data-dir fails the backup.
+	keystoreDir, err := ioutil.TempDir("", "pttdb-backup-keystore")
+	if err != nil {
+		t.Fatalf("TempDir: e: %v", err)
+	}
+	defer os.RemoveAll(keystoreDir)
+	dbKey, _ := OpenDatabase("key", keystoreDir)
+	dbKey.Put([]byte("key-k"), []byte("value-k"))
+
+	keyFile := filepath.Join(rootDir, "me", "mykey")
+	os.MkdirAll(filepath.Dir(keyFile), 0700)
+	ioutil.WriteFile(keyFile, []byte("mykey"), 0600)
+	RegisterBackupFile(keyFile)
+	defer UnregisterBackupFile(keyFile)
+
+	// backup
+	filename := filepath.Join(rootDir, "backup.ptt")
+	_, err = Backup(filename, rootDir, "passphrase")
+	if err != ErrBackupOutsideDataDir {
+		t.Errorf("Backup: outside keystore-dir: e = %v, want %v", err, ErrBackupOutsideDataDir)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Backup: outside keystore-dir: the archive is written")
+	}
+	dbKey.(*LDBDatabase).Close()
+
+	manifest, err := Backup(filename, rootDir, "passphrase")
+	if err != nil {
+		t.Fatalf("Backup: e: %v", err)
+	}
+	if len(manifest.Items) != 3 {
+		t.Errorf("Backup: items = %v, want 3", len(manifest.Items))
+	}
+
+	// modify after backup
+	dbA.Put([]byte("key-a"), []byte("value-a2"))
+	dbA.Put([]byte("key-a3"), []byte("value-a3"))
+	dbA.Close()
+	dbB.Close()
+	ioutil.WriteFile(keyFile, []byte("mykey2"), 0600)
+
+	// wrong passphrase
+	_, err = Restore(filename, rootDir, "wrong")
+	if err != ErrInvalidBackup {
+		t.Errorf("Restore: wrong passphrase: e = %v, want %v", err, ErrInvalidBackup)
+	}
+
+	// corrupted
+	content, _ := ioutil.ReadFile(filename)
+	content[len(content)/2] ^= 0xff
+	corrupted := filepath.Join(rootDir, "corrupted.ptt")
+	ioutil.WriteFile(corrupted, content, 0600)
+	_, err = Restore(corrupted, rootDir, "passphrase")
+	if err != ErrInvalidBackup {
+		t.Errorf("Restore: corrupted: e = %v, want %v", err, ErrInvalidBackup)
+	}
+
+	// restore
+	_, err = Restore(filename, rootDir, "passphrase")
+	if err != nil {
+		t.Fatalf("Restore: e: %v", err)
+	}
+
+	dbA, _ = OpenDatabase("a", filepath.Join(rootDir, "svc"))
+	defer dbA.Close()
+
+	got, _ := dbA.Get([]byte("key-a"))
+	if !reflect.DeepEqual(got, []byte("value-a")) {
+		t.Errorf("Restore: key-a = %s, want value-a", got)
+	}
+	isHas, _ := dbA.Has([]byte("key-a3"))
+	if isHas {
+		t.Errorf("Restore: key-a3 exists after restore")
+	}
+
+	got, _ = ioutil.ReadFile(keyFile)
+	if !reflect.DeepEqual(got, []byte("mykey")) {
+		t.Errorf("Restore: keyFile = %s, want mykey", got)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+type Config struct {
+	// RootDir is the data-dir of the node, the paths in the backup archives are relative to RootDir.
+	RootDir string
+
+	// IsSingle runs all the services on one LevelDB in DataDir, with namespaced keys.
+	IsSingle bool
+	DataDir  string
+
+	// IgnoredDirs are the LevelDBs in RootDir not opened with OpenDatabase (ex: the node-db of p2p),
+	// which are not taken as the existing separated dbs of the services when IsSingle.
+	IgnoredDirs []string
+
+	// Cache is the cache (MB) of the single LevelDB, shared by all the services.
+	Cache   int
+	Handles int
+}
+
+func NewConfig() (*Config, error) {
+	return &Config{}, nil
+}
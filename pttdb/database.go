@@ -16,6 +16,15 @@
 
 package pttdb
 
+import (
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
 // Putter wraps the database write operation supported by both batches and regular databases.
 type Putter interface {
 	Put(key []byte, value []byte) error
@@ -51,3 +60,31 @@ type Batch interface {
 	// Reset resets the batch for reuse
 	Reset()
 }
+
+// KVDatabase wraps the operations of LDBDatabase used by the services,
+// including the try-put, the key-based lock-map and the ordered iterators.
+//
+// LDBDatabase is backed by LevelDB, and MemLDBDatabase is backed by memory for tests.
+type KVDatabase interface {
+	Database
+
+	Name() string
+	Path() string
+
+	TryPut(key []byte, value []byte, updateTS types.Timestamp) ([]byte, error)
+	Pop(key []byte) ([]byte, error)
+
+	TryLockMap(key []byte) error
+	UnlockMap(key []byte) error
+	TryRLockMap(key []byte) error
+	RUnlockMap(key []byte) error
+
+	NewIterator(listOrder ListOrder) iterator.Iterator
+	NewIteratorWithRange(r *util.Range, listOrder ListOrder) iterator.Iterator
+	NewIteratorWithPrefix(start []byte, prefix []byte, listOrder ListOrder) (iterator.Iterator, error)
+
+	NewTx() *LDBTx
+
+	writeBatch(b *leveldb.Batch) error
+	txLock() *sync.Mutex
+}
@@ -56,6 +56,16 @@ func TestMemoryDB_PutGet(t *testing.T) {
 	testPutGet(pttdb.NewMemDatabase(), t)
 }
 
+func TestMemLDB_PutGet(t *testing.T) {
+	testPutGet(pttdb.NewMemLDBDatabase("test"), t)
+}
+
+func TestNamespaceDB_PutGet(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testPutGet(pttdb.NewNamespaceDatabase(db, "test"), t)
+}
+
 func testPutGet(db pttdb.Database, t *testing.T) {
 	t.Parallel()
 
@@ -133,6 +143,10 @@ func TestMemoryDB_ParallelPutGet(t *testing.T) {
 	testParallelPutGet(pttdb.NewMemDatabase(), t)
 }
 
+func TestMemLDB_ParallelPutGet(t *testing.T) {
+	testParallelPutGet(pttdb.NewMemLDBDatabase("test"), t)
+}
+
 func testParallelPutGet(db pttdb.Database, t *testing.T) {
 	const n = 8
 	var pending sync.WaitGroup
@@ -188,3 +202,69 @@ func testParallelPutGet(db pttdb.Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+func TestLDB_IteratorWithPrefix(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIteratorWithPrefix(db, t)
+}
+
+func TestMemLDB_IteratorWithPrefix(t *testing.T) {
+	testIteratorWithPrefix(pttdb.NewMemLDBDatabase("test"), t)
+}
+
+func TestNamespaceDB_IteratorWithPrefix(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+
+	// the keys in the other namespaces are not visible.
+	for _, name := range []string{"tes", "test2", "tesu"} {
+		other := pttdb.NewNamespaceDatabase(db, name)
+		other.Put([]byte("b0"), []byte("b0"))
+		other.Put([]byte("z0"), []byte("z0"))
+	}
+
+	testIteratorWithPrefix(pttdb.NewNamespaceDatabase(db, "test"), t)
+}
+
+func testIteratorWithPrefix(db pttdb.KVDatabase, t *testing.T) {
+	t.Parallel()
+
+	for _, k := range []string{"a0", "b1", "b2", "b3", "c0"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	tests := []struct {
+		start     string
+		prefix    string
+		listOrder pttdb.ListOrder
+		want      []string
+	}{
+		{"", "b", pttdb.ListOrderNext, []string{"b1", "b2", "b3"}},
+		{"", "b", pttdb.ListOrderPrev, []string{"b3", "b2", "b1"}},
+		{"b2", "b", pttdb.ListOrderNext, []string{"b2", "b3"}},
+		{"b2", "b", pttdb.ListOrderPrev, []string{"b2", "b1"}},
+		{"b2", "", pttdb.ListOrderNext, []string{"b2", "b3", "c0"}},
+		{"b2", "", pttdb.ListOrderPrev, []string{"b2", "b1", "a0"}},
+		{"", "", pttdb.ListOrderNext, []string{"a0", "b1", "b2", "b3", "c0"}},
+		{"", "", pttdb.ListOrderPrev, []string{"c0", "b3", "b2", "b1", "a0"}},
+	}
+
+	for _, tt := range tests {
+		iter, err := db.NewIteratorWithPrefix([]byte(tt.start), []byte(tt.prefix), tt.listOrder)
+		if err != nil {
+			t.Fatalf("NewIteratorWithPrefix(%q, %q) failed: %v", tt.start, tt.prefix, err)
+		}
+
+		f := pttdb.GetFuncIter(iter, tt.listOrder)
+		got := []string{}
+		for f() {
+			got = append(got, string(iter.Key()))
+		}
+		iter.Release()
+
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("NewIteratorWithPrefix(%q, %q, %v) = %v, want %v", tt.start, tt.prefix, tt.listOrder, got, tt.want)
+		}
+	}
+}
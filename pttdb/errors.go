@@ -25,4 +25,16 @@ var (
 	ErrInvalidLock     = errors.New("invalid db lock")
 	ErrBusy            = errors.New("db busy")
 	ErrInvalidKeys     = errors.New("invalid db keys")
+	ErrInvalidIndex    = errors.New("invalid db index")
+	ErrTxConflict      = errors.New("tx conflict")
+	ErrTxDone          = errors.New("tx already committed or rolled back")
+	ErrSchemaTooNew    = errors.New("db schema is newer than the code")
+	ErrSeparatedDBs    = errors.New("the data-dir has the separated dbs of the services, unable to run on the single db")
+
+	ErrInvalidBackup         = errors.New("invalid backup (wrong passphrase or corrupted)")
+	ErrBackupVersion         = errors.New("unsupported backup version")
+	ErrBackupChecksum        = errors.New("backup checksum mismatch")
+	ErrBackupOutsideDataDir  = errors.New("path not in the data-dir")
+	ErrInvalidBackupPath     = errors.New("invalid backup path")
+	ErrEmptyBackupPassphrase = errors.New("empty backup passphrase")
 )
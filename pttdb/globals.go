@@ -40,6 +40,45 @@ var (
 	dbLastKey     = []byte{255}
 )
 
+// store
+const (
+	StoreName = "pttdb"
+
+	NamespaceSeparator = byte('/')
+
+	MetricsPrefix = "pttdb/"
+)
+
+// default config
+var (
+	DefaultConfig = Config{}
+)
+
+// migration
+var (
+	DBSchemaVersionPrefix = []byte(".scvr")
+
+	MigrationCheckpointInterval = 1000
+)
+
+// backup
+const (
+	BackupVersion uint16 = 1
+
+	backupSaltLength = 32
+	backupKeyLength  = 64
+	backupMACLength  = 32
+)
+
+var (
+	BackupMagic = []byte("PTTBAK")
+
+	// scrypt parameters of the backup passphrase, the same as the standard keystore.
+	BackupScryptN = 1 << 18
+	BackupScryptR = 8
+	BackupScryptP = 1
+)
+
 const (
 	minCache   = 16
 	minHandles = 16
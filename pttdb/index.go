@@ -19,6 +19,7 @@ package pttdb
 import (
 	"encoding/json"
 
+	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
 )
 
@@ -61,3 +62,56 @@ func (i *Index) Marshal() ([]byte, error) {
 func (i *Index) Unmarshal(data []byte) error {
 	return json.Unmarshal(data, i)
 }
+
+/*
+OrphanIndex is the index-entry referring to the missing keys.
+
+It is left when the records are written or deleted without the index,
+out of TryPutAll / DeleteAll (ex: the records are deleted directly by the keys).
+*/
+type OrphanIndex struct {
+	IdxKey      []byte   `json:"I"`
+	MissingKeys [][]byte `json:"M,omitempty"`
+	Err         string   `json:"E,omitempty"`
+}
+
+/*
+FindOrphanIndexes iterates the index-entries with idxPrefix,
+and returns the ones with missing keys or unable to be unmarshaled.
+*/
+func FindOrphanIndexes(db KVDatabase, idxPrefix []byte) ([]*OrphanIndex, error) {
+	iter, err := db.NewIteratorWithPrefix(nil, idxPrefix, ListOrderNext)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Release()
+
+	orphans := make([]*OrphanIndex, 0)
+	for iter.Next() {
+		idxKey := common.CloneBytes(iter.Key())
+
+		idx := &Index{}
+		err = idx.Unmarshal(iter.Value())
+		if err != nil || len(idx.Keys) == 0 {
+			orphans = append(orphans, &OrphanIndex{IdxKey: idxKey, Err: ErrInvalidIndex.Error()})
+			continue
+		}
+
+		var missingKeys [][]byte
+		for _, key := range idx.Keys {
+			isHas, err := db.Has(key)
+			if err != nil {
+				return nil, err
+			}
+			if !isHas {
+				missingKeys = append(missingKeys, key)
+			}
+		}
+
+		if len(missingKeys) != 0 {
+			orphans = append(orphans, &OrphanIndex{IdxKey: idxKey, MissingKeys: missingKeys})
+		}
+	}
+
+	return orphans, iter.Error()
+}
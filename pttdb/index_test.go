@@ -0,0 +1,67 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+)
+
+func TestFindOrphanIndexes(t *testing.T) {
+	// setup test
+	db := NewMemLDBDatabase("test")
+	batch, _ := NewLDBBatch(db)
+
+	putAll := func(idxKey []byte, keys ...[]byte) {
+		kvs := make([]*KeyVal, len(keys))
+		for i, key := range keys {
+			kvs[i] = &KeyVal{K: key, V: []byte("value")}
+		}
+		idx := &Index{Keys: keys, UpdateTS: types.Timestamp{Ts: 1234567890}}
+		err := batch.PutAllWithKeyIndex(idxKey, idx, kvs)
+		if err != nil {
+			t.Fatalf("PutAllWithKeyIndex: e: %v", err)
+		}
+	}
+
+	putAll([]byte(".tsix1"), []byte(".tsdb1"), []byte(".tsi21"))
+	putAll([]byte(".tsix2"), []byte(".tsdb2"), []byte(".tsi22"))
+	putAll([]byte(".tsix3"), []byte(".tsdb3"))
+	db.Delete([]byte(".tsdb2"))
+	db.Put([]byte(".tsix4"), []byte("invalid"))
+
+	err := batch.DeleteAll([]byte(".tsix3"))
+	if err != nil {
+		t.Errorf("DeleteAll: e: %v", err)
+	}
+
+	// test
+	orphans, err := FindOrphanIndexes(db, []byte(".tsix"))
+	if err != nil {
+		t.Errorf("FindOrphanIndexes: e: %v", err)
+	}
+
+	expected := []*OrphanIndex{
+		{IdxKey: []byte(".tsix2"), MissingKeys: [][]byte{[]byte(".tsdb2")}},
+		{IdxKey: []byte(".tsix4"), Err: ErrInvalidIndex.Error()},
+	}
+	if !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("FindOrphanIndexes: %v expected: %v", orphans, expected)
+	}
+}
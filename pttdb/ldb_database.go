@@ -17,10 +17,8 @@
 package pttdb
 
 import (
-	"encoding/json"
 	"fmt"
 	"path/filepath"
-	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -55,12 +53,27 @@ type LDBDatabase struct {
 
 	log log.Logger // Contextual logger tracking the database path
 
-	lockLockMap sync.Mutex
-	lockMap     map[string]int
+	*dbLockMap
+
+	lockTx sync.Mutex // Mutex serializing the commits of LDBTx
 }
 
 // NewLDBDatabase returns a LevelDB wrapped object.
 func NewLDBDatabase(file string, dataDir string, cache int, handles int) (*LDBDatabase, error) {
+	return newLDBDatabase(file, dataDir, cache, handles, false)
+}
+
+/*
+NewReadOnlyLDBDatabase opens the existing LevelDB read-only, for the offline inspection.
+
+The LevelDB is not recovered if corrupted, and the writes fail with leveldb.ErrReadOnly.
+It fails if the LevelDB is opened by a running node.
+*/
+func NewReadOnlyLDBDatabase(file string, dataDir string) (*LDBDatabase, error) {
+	return newLDBDatabase(file, dataDir, 0, 0, true)
+}
+
+func newLDBDatabase(file string, dataDir string, cache int, handles int, isReadOnly bool) (*LDBDatabase, error) {
 	fullFilename := filepath.Join(dataDir, file)
 
 	logger := log.New("database", fullFilename)
@@ -81,8 +94,10 @@ func NewLDBDatabase(file string, dataDir string, cache int, handles int) (*LDBDa
 		BlockCacheCapacity:     cache / 2 * opt.MiB,
 		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
 		Filter:                 filter.NewBloomFilter(10),
+		ReadOnly:               isReadOnly,
+		ErrorIfMissing:         isReadOnly,
 	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+	if _, corrupted := err.(*errors.ErrCorrupted); corrupted && !isReadOnly {
 		db, err = leveldb.RecoverFile(fullFilename, nil)
 	}
 	// (Re)check for errors and abort if opening of the db failed
@@ -90,11 +105,11 @@ func NewLDBDatabase(file string, dataDir string, cache int, handles int) (*LDBDa
 		return nil, err
 	}
 	return &LDBDatabase{
-		name:    file,
-		fn:      fullFilename,
-		db:      db,
-		log:     logger,
-		lockMap: make(map[string]int),
+		name:      file,
+		fn:        fullFilename,
+		db:        db,
+		log:       logger,
+		dbLockMap: newDBLockMap(),
 	}, nil
 }
 
@@ -113,126 +128,7 @@ TryPut tries to put the key/val based on the updateTS of val.
 Value is the jsonified bytes. the obj of the bytes needs to include UpdateTS.
 */
 func (db *LDBDatabase) TryPut(key []byte, value []byte, updateTS types.Timestamp) ([]byte, error) {
-	//log.Debug("TryPut: start", "key", key)
-
-	err := db.TryLockMap(key)
-	if err != nil {
-		return nil, err
-	}
-	defer db.UnlockMap(key)
-
-	isHasKey, err := db.Has(key)
-	if err != nil {
-		return nil, err
-	}
-
-	if !isHasKey { // new-one
-		err := db.Put(key, value)
-		return nil, err
-	}
-
-	v, err := db.Get(key)
-	if err != nil {
-		return nil, err
-	}
-
-	d := &DBable{}
-
-	err = json.Unmarshal(v, d)
-	if err != nil {
-		return nil, ErrInvalidDBable
-	}
-
-	if updateTS.IsLess(d.UpdateTS) {
-		log.Warn("updateTS < d.UpdateTS", "updateTS", updateTS, "d.UpdateTS", d.UpdateTS)
-		return v, ErrInvalidUpdateTS
-	}
-
-	// put to db
-
-	err = db.Put(key, value)
-	if err != nil {
-		return nil, err
-	}
-
-	return v, nil
-}
-
-func (db *LDBDatabase) TryLockMap(key []byte) error {
-	mapKey := string(key)
-
-	db.lockLockMap.Lock()
-	defer db.lockLockMap.Unlock()
-
-	//log.Debug("to TryLockMap", "key", key)
-
-	// try to get lock
-	val, ok := db.lockMap[mapKey]
-	if ok { // someone-else is using the map-key
-		log.Error("TryLockMap: busy", "mapKey", mapKey, "val", val)
-		return ErrBusy
-	}
-	db.lockMap[mapKey] = -1
-
-	return nil
-}
-
-func (db *LDBDatabase) UnlockMap(key []byte) error {
-	mapKey := string(key)
-
-	db.lockLockMap.Lock()
-	defer db.lockLockMap.Unlock()
-
-	_, ok := db.lockMap[mapKey]
-	if !ok { // should not happen
-		return ErrInvalidLock
-	}
-
-	delete(db.lockMap, mapKey)
-
-	return nil
-}
-
-func (db *LDBDatabase) TryRLockMap(key []byte) error {
-	mapKey := string(key)
-
-	db.lockLockMap.Lock()
-	defer db.lockLockMap.Unlock()
-
-	// try to get lock
-	i, ok := db.lockMap[mapKey]
-	if ok && i < 0 { // write-lock
-		log.Error("TryRLockMap: busy", "i", i, "ok", ok)
-		return ErrBusy
-	}
-
-	log.Debug("after TryRLockMap", "i", i, "ok", ok)
-
-	if !ok {
-		db.lockMap[mapKey] = 0
-	}
-	db.lockMap[mapKey]++
-
-	return nil
-}
-
-func (db *LDBDatabase) RUnlockMap(key []byte) error {
-	mapKey := string(key)
-
-	db.lockLockMap.Lock()
-	defer db.lockLockMap.Unlock()
-
-	i, ok := db.lockMap[mapKey]
-	if !ok || i == 0 { // should not happen
-		panic("db invalid unlock")
-	}
-	db.lockMap[mapKey]--
-
-	if i == 1 {
-		delete(db.lockMap, mapKey)
-	}
-
-	return nil
+	return tryPut(db, key, value, updateTS)
 }
 
 // Put puts the given key / value to the queue
@@ -265,24 +161,7 @@ func (db *LDBDatabase) Delete(key []byte) error {
 
 // Delete With Get
 func (db *LDBDatabase) Pop(key []byte) ([]byte, error) {
-	err := db.TryLockMap(key)
-	if err != nil {
-		return nil, err
-	}
-	defer db.UnlockMap(key)
-
-	val, err := db.Get(key)
-	if err != nil {
-		// Unable to get key. possibly no key in the db. no need to do delete
-		return nil, err
-	}
-
-	err = db.Delete(key)
-	if err != nil {
-		return nil, err
-	}
-
-	return val, nil
+	return pop(db, key)
 }
 
 func (db *LDBDatabase) NewIterator(listOrder ListOrder) iterator.Iterator {
@@ -305,50 +184,12 @@ func (db *LDBDatabase) NewIteratorWithRange(r *util.Range, listOrder ListOrder)
 
 // NewIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
 func (db *LDBDatabase) NewIteratorWithPrefix(start []byte, prefix []byte, listOrder ListOrder) (iterator.Iterator, error) {
-	// both as nil
-	if len(start) == 0 && len(prefix) == 0 {
-		return db.NewIterator(listOrder), nil
-	}
-
-	// start as nil
-	if len(start) == 0 {
-		r := util.BytesPrefix(prefix)
-		return db.NewIteratorWithRange(r, listOrder), nil
-	}
-
-	// prefix as nil
-	if len(prefix) == 0 {
-		startRange := util.BytesPrefix(start)
-		var r *util.Range
-		switch listOrder {
-		case ListOrderPrev:
-			r = &util.Range{Limit: startRange.Limit}
-		case ListOrderNext:
-			r = &util.Range{Start: startRange.Start}
-		}
-
-		return db.NewIteratorWithRange(r, listOrder), nil
-	}
-
-	// both non-nil
-	if !strings.HasPrefix(string(start), string(prefix)) {
-		return nil, ErrInvalidPrefix
-	}
-
-	startRange := util.BytesPrefix(start)
-	prefixRange := util.BytesPrefix(prefix)
-	var r *util.Range
-	switch listOrder {
-	case ListOrderPrev:
-		r = &util.Range{Start: prefixRange.Start, Limit: startRange.Limit}
-	case ListOrderNext:
-		r = &util.Range{Start: startRange.Start, Limit: prefixRange.Limit}
-	}
-
-	return db.NewIteratorWithRange(r, listOrder), nil
+	return newIteratorWithPrefix(db, start, prefix, listOrder)
 }
 
 func (db *LDBDatabase) Close() {
+	unregisterBackupDB(db)
+
 	// Stop the metrics collection to avoid internal database races
 	db.quitLock.Lock()
 	defer db.quitLock.Unlock()
@@ -373,19 +214,28 @@ func (db *LDBDatabase) LDB() *leveldb.DB {
 	return db.db
 }
 
+func (db *LDBDatabase) writeBatch(b *leveldb.Batch) error {
+	return db.db.Write(b, nil)
+}
+
+func (db *LDBDatabase) txLock() *sync.Mutex {
+	return &db.lockTx
+}
+
 // Meter configures the database metrics collectors and
+// starts the periodic collection. The meters are reused if the db is reopened (node restart).
 func (db *LDBDatabase) Meter(prefix string) {
 	if metrics.Enabled {
 		// Initialize all the metrics collector at the requested prefix
-		db.compTimeMeter = metrics.NewRegisteredMeter(prefix+"compact/time", nil)
-		db.compReadMeter = metrics.NewRegisteredMeter(prefix+"compact/input", nil)
-		db.compWriteMeter = metrics.NewRegisteredMeter(prefix+"compact/output", nil)
-		db.diskReadMeter = metrics.NewRegisteredMeter(prefix+"disk/read", nil)
-		db.diskWriteMeter = metrics.NewRegisteredMeter(prefix+"disk/write", nil)
+		db.compTimeMeter = metrics.GetOrRegisterMeter(prefix+"compact/time", nil)
+		db.compReadMeter = metrics.GetOrRegisterMeter(prefix+"compact/input", nil)
+		db.compWriteMeter = metrics.GetOrRegisterMeter(prefix+"compact/output", nil)
+		db.diskReadMeter = metrics.GetOrRegisterMeter(prefix+"disk/read", nil)
+		db.diskWriteMeter = metrics.GetOrRegisterMeter(prefix+"disk/write", nil)
 	}
 	// Initialize write delay metrics no matter we are in metric mode or not.
-	db.writeDelayMeter = metrics.NewRegisteredMeter(prefix+"compact/writedelay/duration", nil)
-	db.writeDelayNMeter = metrics.NewRegisteredMeter(prefix+"compact/writedelay/counter", nil)
+	db.writeDelayMeter = metrics.GetOrRegisterMeter(prefix+"compact/writedelay/duration", nil)
+	db.writeDelayNMeter = metrics.GetOrRegisterMeter(prefix+"compact/writedelay/counter", nil)
 
 	// Create a quit channel for the periodic collector and run it
 	db.quitLock.Lock()
@@ -595,7 +445,7 @@ func (db *LDBDatabase) NewBatch() Batch {
 }
 
 type ldbBatch struct {
-	db   *LDBDatabase
+	db   KVDatabase
 	b    *leveldb.Batch
 	size int
 }
@@ -621,8 +471,7 @@ func (b *ldbBatch) Delete(key []byte) error {
 }
 
 func (b *ldbBatch) Write() error {
-	db := b.db.LDB()
-	return db.Write(b.b, nil)
+	return b.db.writeBatch(b.b)
 }
 
 func (b *ldbBatch) ValueSize() int {
@@ -634,7 +483,7 @@ func (b *ldbBatch) Reset() {
 	b.size = 0
 }
 
-func (b *ldbBatch) DB() *LDBDatabase {
+func (b *ldbBatch) DB() KVDatabase {
 	return b.db
 }
 
@@ -642,7 +491,7 @@ type LDBBatch struct {
 	*ldbBatch
 }
 
-func NewLDBBatch(db *LDBDatabase) (*LDBBatch, error) {
+func NewLDBBatch(db KVDatabase) (*LDBBatch, error) {
 	batch := &ldbBatch{
 		db: db,
 		b:  new(leveldb.Batch),
@@ -653,39 +502,34 @@ func NewLDBBatch(db *LDBDatabase) (*LDBBatch, error) {
 	}, nil
 }
 
-func (b *LDBBatch) PutAllWithKeyIndex(key []byte, idx *Index, kvs []*KeyVal) error {
-	b.Reset()
-	marshaledIdx, err := idx.Marshal()
-	if err != nil {
-		return err
-	}
+/*
+PutAllWithKeyIndex puts the index and all the key-vals atomically.
 
-	err = b.Put(key, marshaledIdx)
-	if err != nil {
-		return err
-	}
+The writes of PutAllWithKeyIndex / PutAll / TryPutAll / TryPutAllSameUT / ForcePutAll / DeleteAll
+go through their own tx instead of the shared batch, so concurrent callers do not interleave,
+and the commit is checked against the other writers under the tx-lock of the db.
+*/
+func (b *LDBBatch) PutAllWithKeyIndex(key []byte, idx *Index, kvs []*KeyVal) error {
+	tx := b.NewTx()
+	err := tx.PutAllWithKeyIndex(key, idx, kvs)
 
-	return b.PutAll(kvs, false)
+	return commitTx(tx, err)
 }
 
+/*
+PutAll puts all the key-vals atomically.
+
+isInit resets the shared batch, which is not used by PutAll any more.
+*/
 func (b *LDBBatch) PutAll(kvs []*KeyVal, isInit bool) error {
 	if isInit {
 		b.Reset()
 	}
-	for _, kv := range kvs {
-		err := b.Put(kv.K, kv.V)
-		if err != nil {
-			return err
-		}
-	}
 
-	err := b.Write()
-	if err != nil {
-		return err
-	}
+	tx := b.NewTx()
+	err := tx.PutAll(kvs)
 
-	b.Reset()
-	return nil
+	return commitTx(tx, err)
 }
 
 /*
@@ -710,65 +554,16 @@ func (b *LDBBatch) TryPutAll(idxKey []byte, idx *Index, kvs []*KeyVal, isDeleteO
 	}
 	defer db.UnlockMap(idxKey)
 
-	isHasKey, err := db.Has(idxKey)
-	if err != nil {
-		log.Error("TryPutAll: unable to has", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	if !isHasKey { // new-one
-		err := b.PutAllWithKeyIndex(idxKey, idx, kvs)
-		log.Debug("TryPutAll: after PutAllWithKeyIndex (new-one)", "idxKey", idxKey, "e", err)
-		return nil, err
+	tx := b.NewTx()
+	origKVs, err := tx.TryPutAll(idxKey, idx, kvs, isDeleteOrig, isGetOrig)
+	if err == ErrInvalidUpdateTS {
+		tx.Rollback()
+		return origKVs, err
 	}
 
-	v, err := db.Get(idxKey)
+	err = commitTx(tx, err)
 	if err != nil {
-		log.Error("TryPutAll: unable to Get", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	d := &Index{}
-	err = d.Unmarshal(v)
-	if err != nil { // unable to get original data.
-		log.Error("TryPutAll: unable to unmarshal index", "idxKey", idxKey, "v", v, "e", err)
-		return nil, ErrInvalidDBable
-	}
-	var origKVs []*KeyVal
-	i := 0
-	var key []byte
-	if isGetOrig {
-		origKVs = make([]*KeyVal, len(d.Keys))
-		for i, key = range d.Keys {
-			v, err = db.Get(key)
-			if err != nil {
-				log.Error("TryPutAll: (GetOrig) unable to get key", "idxKey", idxKey, "k", key, "e", err)
-				return nil, err
-			}
-			origKVs[i] = &KeyVal{
-				K: key,
-				V: v,
-			}
-		}
-	}
-
-	if idx.UpdateTS.IsLess(d.UpdateTS) {
-		log.Warn("updateTS < d.UpdateTS", "idxKey", idxKey, "updateTS", idx.UpdateTS, "d.UpdateTS", d.UpdateTS, "d.Keys", d.Keys)
-		return origKVs, ErrInvalidUpdateTS
-	}
-
-	// delete original data
-	if isDeleteOrig {
-		for _, eachKey := range d.Keys {
-			db.Delete(eachKey)
-		}
-	}
-
-	// put to db
-
-	err = b.PutAllWithKeyIndex(idxKey, idx, kvs)
-	if err != nil {
-		log.Error("TryPutAll: unable to PutAllWithKeyIndex", "idxKey", idxKey, "e", err)
+		log.Error("TryPutAll: unable to put", "idxKey", idxKey, "e", err)
 		return nil, err
 	}
 
@@ -776,14 +571,7 @@ func (b *LDBBatch) TryPutAll(idxKey []byte, idx *Index, kvs []*KeyVal, isDeleteO
 }
 
 /*
-TryPutAll tries to put all the key-vals with comparing the updateTS of the 1st item.
-
-This is used when the real content is stored in ts-based key, but we want to refer the content directly from id-key.
-
-This assumes 1-record per key. The old data will be deleted if key conflict.
-
-For oplog: the id of each oplog is unique.
-For content:
+TryPutAllSameUT is the same as TryPutAll, but rejects the idx with the same updateTS and different content.
 */
 func (b *LDBBatch) TryPutAllSameUT(idxKey []byte, idx *Index, kvs []*KeyVal, isDeleteOrig bool) ([][]byte, error) {
 	log.Debug("TryPutAllSameUT: start", "idxKey", idxKey)
@@ -797,67 +585,26 @@ func (b *LDBBatch) TryPutAllSameUT(idxKey []byte, idx *Index, kvs []*KeyVal, isD
 	}
 	defer db.UnlockMap(idxKey)
 
-	isHasKey, err := db.Has(idxKey)
-	if err != nil {
-		log.Error("TryPutAllSameUT: unable to has", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	if !isHasKey { // new-one
-		err := b.PutAllWithKeyIndex(idxKey, idx, kvs)
-		log.Debug("TryPutAllSameUT: after PutAllWithKeyIndex (new-one)", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	v, err := db.Get(idxKey)
-	if err != nil {
-		log.Error("TryPutAllSameUT: unable to Get", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	d := &Index{}
-	err = d.Unmarshal(v)
-	if err != nil { // unable to get original data.
-		return nil, ErrInvalidDBable
-	}
-
-	if idx.UpdateTS.IsLess(d.UpdateTS) {
-		log.Warn("TryPutAllSameUT: updateTS < d.UpdateTS", "idxKey", idxKey, "updateTS", idx.UpdateTS, "d.UpdateTS", d.UpdateTS)
-		return d.Keys, ErrInvalidUpdateTS
-	}
-
-	if idx.UpdateTS == d.UpdateTS && !reflect.DeepEqual(d, idx) {
-		log.Warn("updateTS == d.UpdateTS but idx diff", "idxKey", idxKey, "updateTS", idx.UpdateTS, "d.UpdateTS", d.UpdateTS, "idx", idx, "d", d)
-		return d.Keys, ErrInvalidUpdateTS
-	}
-
-	// delete original data
-	if isDeleteOrig {
-		for _, eachKey := range d.Keys {
-			db.Delete(eachKey)
-		}
+	tx := b.NewTx()
+	origKeys, err := tx.TryPutAllSameUT(idxKey, idx, kvs, isDeleteOrig)
+	if err == ErrInvalidUpdateTS {
+		tx.Rollback()
+		return origKeys, err
 	}
 
-	// put to db
-
-	err = b.PutAllWithKeyIndex(idxKey, idx, kvs)
+	err = commitTx(tx, err)
 	if err != nil {
-		log.Error("TryPutAllSameUT: unable to PutAllWithKeyIndex", "idxKey", idxKey, "e", err)
+		log.Error("TryPutAllSameUT: unable to put", "idxKey", idxKey, "e", err)
 		return nil, err
 	}
 
-	return d.Keys, nil
+	return origKeys, nil
 }
 
 /*
-ForcePutAll tries to put all the key-vals with comparing the updateTS of the 1st item.
+ForcePutAll puts all the key-vals without comparing the updateTS, and deletes the original keys.
 
 This is used when the real content is stored in ts-based key, but we want to refer the content directly from id-key.
-
-This assumes 1-record per key. The old data will be deleted if key conflict.
-
-For oplog: the id of each oplog is unique.
-For content:
 */
 func (b *LDBBatch) ForcePutAll(idxKey []byte, idx *Index, kvs []*KeyVal) ([][]byte, error) {
 	log.Debug("ForcePutAll: start", "idxKey", idxKey)
@@ -871,44 +618,16 @@ func (b *LDBBatch) ForcePutAll(idxKey []byte, idx *Index, kvs []*KeyVal) ([][]by
 	}
 	defer db.UnlockMap(idxKey)
 
-	isHasKey, err := db.Has(idxKey)
-	if err != nil {
-		log.Error("ForcePutAll: unable to has", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	if !isHasKey { // new-one
-		err := b.PutAllWithKeyIndex(idxKey, idx, kvs)
-		log.Debug("ForcePutAll: after PutAllWithKeyIndex (new-one)", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
+	tx := b.NewTx()
+	origKeys, err := tx.ForcePutAll(idxKey, idx, kvs)
 
-	v, err := db.Get(idxKey)
+	err = commitTx(tx, err)
 	if err != nil {
-		log.Error("ForcePutAll: unable to Get", "idxKey", idxKey, "e", err)
+		log.Error("ForcePutAll: unable to put", "idxKey", idxKey, "e", err)
 		return nil, err
 	}
 
-	d := &Index{}
-	err = d.Unmarshal(v)
-	if err != nil { // unable to get original data.
-		return nil, ErrInvalidDBable
-	}
-
-	// delete original data
-	for _, eachKey := range d.Keys {
-		db.Delete(eachKey)
-	}
-
-	// put to db
-
-	err = b.PutAllWithKeyIndex(idxKey, idx, kvs)
-	if err != nil {
-		log.Error("TryPutAll: unable to PutAllWithKeyIndex", "idxKey", idxKey, "e", err)
-		return nil, err
-	}
-
-	return d.Keys, nil
+	return origKeys, nil
 }
 
 func (b *LDBBatch) DeleteAllKeys(keys [][]byte) error {
@@ -933,26 +652,22 @@ func (b *LDBBatch) DeleteAll(idxKey []byte) error {
 	}
 	defer db.UnlockMap(idxKey)
 
-	v, err := db.Get(idxKey)
-	if err == leveldb.ErrNotFound {
-		return nil
-	}
+	tx := b.NewTx()
+	err = tx.DeleteAll(idxKey)
+
+	return commitTx(tx, err)
+}
 
+/*
+commitTx commits the tx if err is nil, and rollbacks the tx otherwise.
+*/
+func commitTx(tx *LDBTx, err error) error {
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	d := &Index{}
-	err = d.Unmarshal(v)
-	if err != nil { // unable to get original data.
-		return ErrInvalidDBable
-	}
-
-	for _, eachKey := range d.Keys {
-		db.Delete(eachKey)
-	}
-
-	return db.Delete(idxKey)
+	return tx.Commit()
 }
 
 func (b *LDBBatch) GetByIdxKey(idxKey []byte, idx int) ([]byte, error) {
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"sync"
 	"testing"
@@ -1384,3 +1386,44 @@ func TestLDBBatch_TryPutAll(t *testing.T) {
 
 	// teardown test
 }
+
+func TestNewReadOnlyLDBDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pttdb-readonly")
+	if err != nil {
+		t.Fatalf("TempDir: e: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = NewReadOnlyLDBDatabase("test", dir)
+	if err == nil {
+		t.Errorf("NewReadOnlyLDBDatabase: opened the missing db")
+	}
+
+	db, err := NewLDBDatabase("test", dir, 0, 0)
+	if err != nil {
+		t.Fatalf("NewLDBDatabase: e: %v", err)
+	}
+	db.Put([]byte("key"), []byte("value"))
+
+	_, err = NewReadOnlyLDBDatabase("test", dir)
+	if err == nil {
+		t.Errorf("NewReadOnlyLDBDatabase: opened the db in use")
+	}
+	db.Close()
+
+	db, err = NewReadOnlyLDBDatabase("test", dir)
+	if err != nil {
+		t.Fatalf("NewReadOnlyLDBDatabase: e: %v", err)
+	}
+	defer db.Close()
+
+	got, _ := db.Get([]byte("key"))
+	if !reflect.DeepEqual(got, []byte("value")) {
+		t.Errorf("NewReadOnlyLDBDatabase: Get = %s, want value", got)
+	}
+
+	err = db.Put([]byte("key2"), []byte("value2"))
+	if err != leveldb.ErrReadOnly {
+		t.Errorf("NewReadOnlyLDBDatabase: Put e: %v expected: %v", err, leveldb.ErrReadOnly)
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+LDBTx is a unit-of-work on KVDatabase.
+
+Each tx owns its own leveldb batch, so concurrent callers do not interleave
+their writes. Reads through the tx see the writes of the tx (read-your-writes).
+
+The UpdateTS of the records read by TryPut / TryPutAll / ForcePutAll / DeleteAll
+are remembered, and are checked again in Commit (optimistic lock).
+Commit returns ErrTxConflict if any of the records is changed by others,
+and nothing is written.
+*/
+type LDBTx struct {
+	db KVDatabase
+	b  *leveldb.Batch
+
+	writes map[string]*txWrite
+	checks map[string]*txCheck
+
+	isDone bool
+}
+
+type txWrite struct {
+	v         []byte
+	isDeleted bool
+}
+
+type txCheck struct {
+	isExists bool
+	updateTS types.Timestamp
+}
+
+func NewLDBTx(db KVDatabase) *LDBTx {
+	return &LDBTx{
+		db:     db,
+		b:      new(leveldb.Batch),
+		writes: make(map[string]*txWrite),
+		checks: make(map[string]*txCheck),
+	}
+}
+
+func (db *LDBDatabase) NewTx() *LDBTx {
+	return NewLDBTx(db)
+}
+
+func (b *LDBBatch) NewTx() *LDBTx {
+	return NewLDBTx(b.ldbBatch.DB())
+}
+
+func (tx *LDBTx) DB() KVDatabase {
+	return tx.db
+}
+
+/**********
+ * read
+ **********/
+
+func (tx *LDBTx) Get(key []byte) ([]byte, error) {
+	if tx.isDone {
+		return nil, ErrTxDone
+	}
+
+	w, ok := tx.writes[string(key)]
+	if ok {
+		if w.isDeleted {
+			return nil, leveldb.ErrNotFound
+		}
+		return common.CloneBytes(w.v), nil
+	}
+
+	return tx.db.Get(key)
+}
+
+func (tx *LDBTx) Has(key []byte) (bool, error) {
+	if tx.isDone {
+		return false, ErrTxDone
+	}
+
+	w, ok := tx.writes[string(key)]
+	if ok {
+		return !w.isDeleted, nil
+	}
+
+	return tx.db.Has(key)
+}
+
+func (tx *LDBTx) GetKeyByIdxKey(idxKey []byte, idx int) ([]byte, error) {
+	v, err := tx.Get(idxKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Index{}
+	err = d.Unmarshal(v)
+	if err != nil {
+		return nil, ErrInvalidDBable
+	}
+
+	if d.Keys == nil || len(d.Keys) <= idx || d.Keys[idx] == nil {
+		return nil, ErrInvalidKeys
+	}
+
+	return d.Keys[idx], nil
+}
+
+func (tx *LDBTx) GetByIdxKey(idxKey []byte, idx int) ([]byte, error) {
+	key, err := tx.GetKeyByIdxKey(idxKey, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.Get(key)
+}
+
+/**********
+ * write
+ **********/
+
+func (tx *LDBTx) Put(key []byte, value []byte) error {
+	if tx.isDone {
+		return ErrTxDone
+	}
+
+	tx.b.Put(key, value)
+	tx.writes[string(key)] = &txWrite{v: common.CloneBytes(value)}
+
+	return nil
+}
+
+func (tx *LDBTx) Delete(key []byte) error {
+	if tx.isDone {
+		return ErrTxDone
+	}
+
+	tx.b.Delete(key)
+	tx.writes[string(key)] = &txWrite{isDeleted: true}
+
+	return nil
+}
+
+func (tx *LDBTx) PutAll(kvs []*KeyVal) error {
+	for _, kv := range kvs {
+		err := tx.Put(kv.K, kv.V)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *LDBTx) PutAllWithKeyIndex(key []byte, idx *Index, kvs []*KeyVal) error {
+	marshaledIdx, err := idx.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Put(key, marshaledIdx)
+	if err != nil {
+		return err
+	}
+
+	return tx.PutAll(kvs)
+}
+
+/*
+TryPut tries to put the key/val based on the updateTS of val.
+
+The same as LDBDatabase.TryPut, but the UpdateTS is re-checked in Commit instead of locking the key.
+*/
+func (tx *LDBTx) TryPut(key []byte, value []byte, updateTS types.Timestamp) ([]byte, error) {
+	v, err := tx.getWithCheck(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil { // new-one
+		return nil, tx.Put(key, value)
+	}
+
+	d := &DBable{}
+	err = json.Unmarshal(v, d)
+	if err != nil {
+		return nil, ErrInvalidDBable
+	}
+
+	if updateTS.IsLess(d.UpdateTS) {
+		log.Warn("LDBTx.TryPut: updateTS < d.UpdateTS", "updateTS", updateTS, "d.UpdateTS", d.UpdateTS)
+		return v, ErrInvalidUpdateTS
+	}
+
+	err = tx.Put(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+/*
+TryPutAll tries to put all the key-vals with comparing the updateTS of the idx.
+
+The same as LDBBatch.TryPutAll, but all the changes are in the tx.
+*/
+func (tx *LDBTx) TryPutAll(idxKey []byte, idx *Index, kvs []*KeyVal, isDeleteOrig bool, isGetOrig bool) ([]*KeyVal, error) {
+	d, err := tx.getIndexWithCheck(idxKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil { // new-one
+		return nil, tx.PutAllWithKeyIndex(idxKey, idx, kvs)
+	}
+
+	var origKVs []*KeyVal
+	if isGetOrig {
+		origKVs = make([]*KeyVal, len(d.Keys))
+		for i, key := range d.Keys {
+			v, err := tx.Get(key)
+			if err != nil {
+				log.Error("LDBTx.TryPutAll: (GetOrig) unable to get key", "idxKey", idxKey, "k", key, "e", err)
+				return nil, err
+			}
+			origKVs[i] = &KeyVal{K: key, V: v}
+		}
+	}
+
+	if idx.UpdateTS.IsLess(d.UpdateTS) {
+		log.Warn("LDBTx.TryPutAll: updateTS < d.UpdateTS", "idxKey", idxKey, "updateTS", idx.UpdateTS, "d.UpdateTS", d.UpdateTS)
+		return origKVs, ErrInvalidUpdateTS
+	}
+
+	if isDeleteOrig {
+		for _, eachKey := range d.Keys {
+			tx.Delete(eachKey)
+		}
+	}
+
+	err = tx.PutAllWithKeyIndex(idxKey, idx, kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return origKVs, nil
+}
+
+/*
+TryPutAllSameUT is the same as LDBBatch.TryPutAllSameUT, but all the changes are in the tx.
+*/
+func (tx *LDBTx) TryPutAllSameUT(idxKey []byte, idx *Index, kvs []*KeyVal, isDeleteOrig bool) ([][]byte, error) {
+	d, err := tx.getIndexWithCheck(idxKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil { // new-one
+		return nil, tx.PutAllWithKeyIndex(idxKey, idx, kvs)
+	}
+
+	if idx.UpdateTS.IsLess(d.UpdateTS) {
+		log.Warn("LDBTx.TryPutAllSameUT: updateTS < d.UpdateTS", "idxKey", idxKey, "updateTS", idx.UpdateTS, "d.UpdateTS", d.UpdateTS)
+		return d.Keys, ErrInvalidUpdateTS
+	}
+
+	if idx.UpdateTS == d.UpdateTS && !reflect.DeepEqual(d, idx) {
+		log.Warn("LDBTx.TryPutAllSameUT: updateTS == d.UpdateTS but idx diff", "idxKey", idxKey, "updateTS", idx.UpdateTS)
+		return d.Keys, ErrInvalidUpdateTS
+	}
+
+	if isDeleteOrig {
+		for _, eachKey := range d.Keys {
+			tx.Delete(eachKey)
+		}
+	}
+
+	err = tx.PutAllWithKeyIndex(idxKey, idx, kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Keys, nil
+}
+
+/*
+ForcePutAll puts all the key-vals without comparing the updateTS, and deletes the original keys.
+*/
+func (tx *LDBTx) ForcePutAll(idxKey []byte, idx *Index, kvs []*KeyVal) ([][]byte, error) {
+	d, err := tx.getIndexWithCheck(idxKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil { // new-one
+		return nil, tx.PutAllWithKeyIndex(idxKey, idx, kvs)
+	}
+
+	for _, eachKey := range d.Keys {
+		tx.Delete(eachKey)
+	}
+
+	err = tx.PutAllWithKeyIndex(idxKey, idx, kvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Keys, nil
+}
+
+func (tx *LDBTx) DeleteAll(idxKey []byte) error {
+	d, err := tx.getIndexWithCheck(idxKey)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return nil
+	}
+
+	for _, eachKey := range d.Keys {
+		tx.Delete(eachKey)
+	}
+
+	return tx.Delete(idxKey)
+}
+
+/**********
+ * commit / rollback
+ **********/
+
+/*
+Commit writes all the changes of the tx atomically.
+
+The records read by TryPut / TryPutAll / ForcePutAll / DeleteAll are checked with their UpdateTS,
+and ErrTxConflict is returned (without writing anything) if any of them is changed after being read.
+*/
+func (tx *LDBTx) Commit() error {
+	if tx.isDone {
+		return ErrTxDone
+	}
+	tx.isDone = true
+
+	db := tx.db
+	lockTx := db.txLock()
+	lockTx.Lock()
+	defer lockTx.Unlock()
+
+	for key, check := range tx.checks {
+		current, err := getTxCheck(db, []byte(key))
+		if err != nil {
+			return err
+		}
+		if current.isExists != check.isExists || current.updateTS != check.updateTS {
+			log.Warn("LDBTx.Commit: conflict", "key", []byte(key), "isExists", check.isExists, "current.isExists", current.isExists, "updateTS", check.updateTS, "current.updateTS", current.updateTS)
+			return ErrTxConflict
+		}
+	}
+
+	if tx.b.Len() == 0 {
+		return nil
+	}
+
+	return db.writeBatch(tx.b)
+}
+
+/*
+Rollback discards all the changes of the tx. It is safe to call Rollback after Commit.
+*/
+func (tx *LDBTx) Rollback() {
+	if tx.isDone {
+		return
+	}
+	tx.isDone = true
+
+	tx.b.Reset()
+	tx.writes = nil
+	tx.checks = nil
+}
+
+/**********
+ * utils
+ **********/
+
+func (tx *LDBTx) getWithCheck(key []byte) ([]byte, error) {
+	if tx.isDone {
+		return nil, ErrTxDone
+	}
+
+	// already written in the tx, no need to check
+	if w, ok := tx.writes[string(key)]; ok {
+		if w.isDeleted {
+			return nil, nil
+		}
+		return common.CloneBytes(w.v), nil
+	}
+
+	v, err := tx.db.Get(key)
+	if err == leveldb.ErrNotFound {
+		tx.setCheck(key, &txCheck{})
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DBable{}
+	err = json.Unmarshal(v, d)
+	if err != nil {
+		return nil, ErrInvalidDBable
+	}
+	tx.setCheck(key, &txCheck{isExists: true, updateTS: d.UpdateTS})
+
+	return v, nil
+}
+
+func (tx *LDBTx) getIndexWithCheck(idxKey []byte) (*Index, error) {
+	v, err := tx.getWithCheck(idxKey)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	d := &Index{}
+	err = d.Unmarshal(v)
+	if err != nil {
+		return nil, ErrInvalidDBable
+	}
+
+	return d, nil
+}
+
+/*
+setCheck remembers only the 1st read of the key, which is the state before the tx.
+*/
+func (tx *LDBTx) setCheck(key []byte, check *txCheck) {
+	mapKey := string(key)
+	if _, ok := tx.checks[mapKey]; ok {
+		return
+	}
+	tx.checks[mapKey] = check
+}
+
+func getTxCheck(db KVDatabase, key []byte) (*txCheck, error) {
+	v, err := db.Get(key)
+	if err == leveldb.ErrNotFound {
+		return &txCheck{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DBable{}
+	err = json.Unmarshal(v, d)
+	if err != nil {
+		return nil, ErrInvalidDBable
+	}
+
+	return &txCheck{isExists: true, updateTS: d.UpdateTS}, nil
+}
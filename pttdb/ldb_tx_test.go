@@ -0,0 +1,237 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestLDBTx_ReadYourWrites(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	tDefaultDB.Put([]byte("test-key-1"), []byte("test-value-1"))
+
+	tx := tDefaultDB.NewTx()
+	defer tx.Rollback()
+
+	tx.Put([]byte("test-key-2"), []byte("test-value-2"))
+	tx.Delete([]byte("test-key-1"))
+
+	// run test
+	got, err := tx.Get([]byte("test-key-2"))
+	if err != nil || !reflect.DeepEqual(got, []byte("test-value-2")) {
+		t.Errorf("LDBTx.Get() = %v, err: %v, want: test-value-2", got, err)
+	}
+
+	_, err = tx.Get([]byte("test-key-1"))
+	if err != leveldb.ErrNotFound {
+		t.Errorf("LDBTx.Get() (deleted) err: %v, want: ErrNotFound", err)
+	}
+
+	// not written to db before commit
+	isHas, _ := tDefaultDB.Has([]byte("test-key-2"))
+	if isHas {
+		t.Errorf("LDBTx: test-key-2 is in db before commit")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Errorf("LDBTx.Commit() err: %v", err)
+	}
+
+	isHas, _ = tDefaultDB.Has([]byte("test-key-1"))
+	if isHas {
+		t.Errorf("LDBTx: test-key-1 is in db after commit")
+	}
+	got, _ = tDefaultDB.Get([]byte("test-key-2"))
+	if !reflect.DeepEqual(got, []byte("test-value-2")) {
+		t.Errorf("LDBTx: db.Get() = %v, want: test-value-2", got)
+	}
+
+	// teardown test
+}
+
+func TestLDBTx_Rollback(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	updateTS, _ := types.GetTimestamp()
+
+	tx := tDefaultDB.NewTx()
+	_, err := tx.TryPutAll(
+		[]byte("test-idx-key"),
+		&Index{Keys: [][]byte{[]byte("test-key-1")}, UpdateTS: updateTS},
+		[]*KeyVal{&KeyVal{K: []byte("test-key-1"), V: []byte("test-value-1")}},
+		true,
+		false,
+	)
+	if err != nil {
+		t.Errorf("LDBTx.TryPutAll() err: %v", err)
+	}
+
+	// run test
+	tx.Rollback()
+
+	isHas, _ := tDefaultDB.Has([]byte("test-idx-key"))
+	if isHas {
+		t.Errorf("LDBTx: test-idx-key is in db after rollback")
+	}
+
+	err = tx.Commit()
+	if err != ErrTxDone {
+		t.Errorf("LDBTx.Commit() after rollback err: %v, want: ErrTxDone", err)
+	}
+
+	// teardown test
+}
+
+func TestLDBTx_CommitConflict(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	updateTS1, _ := types.GetTimestamp()
+	updateTS2 := updateTS1
+	updateTS2.Ts++
+	updateTS3 := updateTS2
+	updateTS3.Ts++
+
+	idxKey := []byte("test-idx-key")
+	dbBatch, _ := NewLDBBatch(tDefaultDB)
+	dbBatch.TryPutAll(
+		idxKey,
+		&Index{Keys: [][]byte{[]byte("test-key-1")}, UpdateTS: updateTS1},
+		[]*KeyVal{&KeyVal{K: []byte("test-key-1"), V: []byte("test-value-1")}},
+		true,
+		false,
+	)
+
+	tx1 := tDefaultDB.NewTx()
+	defer tx1.Rollback()
+	tx2 := tDefaultDB.NewTx()
+	defer tx2.Rollback()
+
+	_, err := tx1.TryPutAll(
+		idxKey,
+		&Index{Keys: [][]byte{[]byte("test-key-2")}, UpdateTS: updateTS2},
+		[]*KeyVal{&KeyVal{K: []byte("test-key-2"), V: []byte("test-value-2")}},
+		true,
+		false,
+	)
+	if err != nil {
+		t.Errorf("LDBTx.TryPutAll() (tx1) err: %v", err)
+	}
+
+	_, err = tx2.TryPutAll(
+		idxKey,
+		&Index{Keys: [][]byte{[]byte("test-key-3")}, UpdateTS: updateTS3},
+		[]*KeyVal{&KeyVal{K: []byte("test-key-3"), V: []byte("test-value-3")}},
+		true,
+		false,
+	)
+	if err != nil {
+		t.Errorf("LDBTx.TryPutAll() (tx2) err: %v", err)
+	}
+
+	// run test
+	err = tx2.Commit()
+	if err != nil {
+		t.Errorf("LDBTx.Commit() (tx2) err: %v", err)
+	}
+
+	err = tx1.Commit()
+	if err != ErrTxConflict {
+		t.Errorf("LDBTx.Commit() (tx1) err: %v, want: ErrTxConflict", err)
+	}
+
+	got, _ := dbBatch.GetByIdxKey(idxKey, 0)
+	if !reflect.DeepEqual(got, []byte("test-value-3")) {
+		t.Errorf("LDBTx: GetByIdxKey() = %v, want: test-value-3", got)
+	}
+	isHas, _ := tDefaultDB.Has([]byte("test-key-1"))
+	if isHas {
+		t.Errorf("LDBTx: test-key-1 is in db after commit")
+	}
+	isHas, _ = tDefaultDB.Has([]byte("test-key-2"))
+	if isHas {
+		t.Errorf("LDBTx: test-key-2 (conflict) is in db")
+	}
+
+	// teardown test
+}
+
+func TestLDBBatch_ConflictWithTx(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	updateTS1, _ := types.GetTimestamp()
+	updateTS2 := updateTS1
+	updateTS2.Ts++
+
+	idxKey := []byte("test-idx-key")
+	dbBatch, _ := NewLDBBatch(tDefaultDB)
+
+	tx := tDefaultDB.NewTx()
+	defer tx.Rollback()
+
+	_, err := tx.TryPutAll(
+		idxKey,
+		&Index{Keys: [][]byte{[]byte("test-key-1")}, UpdateTS: updateTS1},
+		[]*KeyVal{&KeyVal{K: []byte("test-key-1"), V: []byte("test-value-1")}},
+		true,
+		false,
+	)
+	if err != nil {
+		t.Errorf("LDBTx.TryPutAll() err: %v", err)
+	}
+
+	// run test
+	_, err = dbBatch.ForcePutAll(
+		idxKey,
+		&Index{Keys: [][]byte{[]byte("test-key-2")}, UpdateTS: updateTS2},
+		[]*KeyVal{&KeyVal{K: []byte("test-key-2"), V: []byte("test-value-2")}},
+	)
+	if err != nil {
+		t.Errorf("LDBBatch.ForcePutAll() err: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != ErrTxConflict {
+		t.Errorf("LDBTx.Commit() err: %v, want: ErrTxConflict", err)
+	}
+
+	err = dbBatch.DeleteAll(idxKey)
+	if err != nil {
+		t.Errorf("LDBBatch.DeleteAll() err: %v", err)
+	}
+	for _, key := range [][]byte{idxKey, []byte("test-key-1"), []byte("test-key-2")} {
+		isHas, _ := tDefaultDB.Has(key)
+		if isHas {
+			t.Errorf("LDBBatch: %s is in db after DeleteAll", key)
+		}
+	}
+
+	// teardown test
+}
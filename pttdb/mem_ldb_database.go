@@ -0,0 +1,170 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+MemLDBDatabase implements KVDatabase in memory. It does not get persisted, for tests only.
+
+The keys are kept in order with the same comparer as LevelDB,
+and the iterators follow the ListOrder semantics of LDBDatabase.
+Unlike LDBDatabase, the iterators are not snapshots.
+*/
+type MemLDBDatabase struct {
+	name string
+	db   *memdb.DB
+
+	lock sync.RWMutex // make writeBatch atomic to the readers
+
+	*dbLockMap
+
+	lockTx sync.Mutex
+}
+
+func NewMemLDBDatabase(name string) *MemLDBDatabase {
+	return &MemLDBDatabase{
+		name:      name,
+		db:        memdb.New(comparer.DefaultComparer, 0),
+		dbLockMap: newDBLockMap(),
+	}
+}
+
+func (db *MemLDBDatabase) Name() string {
+	return db.name
+}
+
+func (db *MemLDBDatabase) Path() string {
+	return ""
+}
+
+func (db *MemLDBDatabase) Put(key []byte, value []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.db.Put(key, value)
+}
+
+func (db *MemLDBDatabase) Has(key []byte) (bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.db.Contains(key), nil
+}
+
+// Get returns leveldb.ErrNotFound if the key is not present, the same as LDBDatabase.
+func (db *MemLDBDatabase) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	dat, err := db.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return common.CloneBytes(dat), nil
+}
+
+func (db *MemLDBDatabase) Delete(key []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	err := db.db.Delete(key)
+	if err == memdb.ErrNotFound {
+		err = nil
+	}
+
+	return err
+}
+
+func (db *MemLDBDatabase) TryPut(key []byte, value []byte, updateTS types.Timestamp) ([]byte, error) {
+	return tryPut(db, key, value, updateTS)
+}
+
+func (db *MemLDBDatabase) Pop(key []byte) ([]byte, error) {
+	return pop(db, key)
+}
+
+func (db *MemLDBDatabase) NewIterator(listOrder ListOrder) iterator.Iterator {
+	iter := db.db.NewIterator(nil)
+	if listOrder == ListOrderPrev {
+		iter.Seek(dbLastKey)
+	}
+
+	return iter
+}
+
+func (db *MemLDBDatabase) NewIteratorWithRange(r *util.Range, listOrder ListOrder) iterator.Iterator {
+	iter := db.db.NewIterator(r)
+	if listOrder == ListOrderPrev {
+		iter.Seek(r.Limit)
+	}
+
+	return iter
+}
+
+func (db *MemLDBDatabase) NewIteratorWithPrefix(start []byte, prefix []byte, listOrder ListOrder) (iterator.Iterator, error) {
+	return newIteratorWithPrefix(db, start, prefix, listOrder)
+}
+
+func (db *MemLDBDatabase) NewTx() *LDBTx {
+	return NewLDBTx(db)
+}
+
+func (db *MemLDBDatabase) NewBatch() Batch {
+	return &ldbBatch{db: db, b: new(leveldb.Batch)}
+}
+
+func (db *MemLDBDatabase) Close() {}
+
+func (db *MemLDBDatabase) Len() int {
+	return db.db.Len()
+}
+
+func (db *MemLDBDatabase) writeBatch(b *leveldb.Batch) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return b.Replay(&memBatchReplay{db: db.db})
+}
+
+func (db *MemLDBDatabase) txLock() *sync.Mutex {
+	return &db.lockTx
+}
+
+// memBatchReplay replays leveldb.Batch to memdb.
+type memBatchReplay struct {
+	db *memdb.DB
+}
+
+func (r *memBatchReplay) Put(key, value []byte) {
+	r.db.Put(key, value)
+}
+
+func (r *memBatchReplay) Delete(key []byte) {
+	r.db.Delete(key)
+}
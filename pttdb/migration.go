@@ -0,0 +1,365 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+SchemaVersion is the schema version of a db, stored in the meta db of the service.
+
+Pending and Checkpoint are set while a migration step is running,
+so that the step can be resumed after a crash.
+*/
+type SchemaVersion struct {
+	V          types.Version
+	Version    uint32          `json:"v"`
+	Pending    uint32          `json:"p,omitempty"`
+	Checkpoint []byte          `json:"c,omitempty"`
+	UpdateTS   types.Timestamp `json:"UT"`
+}
+
+/*
+Migration is a step to migrate the db from Version - 1 to Version.
+
+Run may be interrupted and resumed from the checkpoint of the MigrationContext,
+so Run needs to be idempotent.
+*/
+type Migration struct {
+	Version uint32
+	Name    string
+	Run     func(mc *MigrationContext) error
+}
+
+/*
+MigrateOptions is the options of Migrate / MigrateAll. nil is the same as the zero value.
+
+With IsDryRun, the steps run without writing anything, and the number of the changes is logged
+("gptt migrate --dryrun").
+*/
+type MigrateOptions struct {
+	IsDryRun bool
+}
+
+func (opts *MigrateOptions) isDryRun() bool {
+	return opts != nil && opts.IsDryRun
+}
+
+var (
+	migrations     = make(map[string][]*Migration)
+	lockMigrations sync.RWMutex
+)
+
+/*
+RegisterMigration registers the migration step of the db.
+
+It panics if the version is not positive or is already registered, which is a programming error.
+*/
+func RegisterMigration(dbName string, m *Migration) {
+	lockMigrations.Lock()
+	defer lockMigrations.Unlock()
+
+	if m.Version == 0 {
+		panic("pttdb: migration version must be positive: " + dbName + " " + m.Name)
+	}
+
+	steps := migrations[dbName]
+	for _, each := range steps {
+		if each.Version == m.Version {
+			panic("pttdb: duplicated migration version: " + dbName + " " + m.Name)
+		}
+	}
+
+	steps = append(steps, m)
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].Version < steps[j].Version })
+	migrations[dbName] = steps
+}
+
+func getMigrations(dbName string) []*Migration {
+	lockMigrations.RLock()
+	defer lockMigrations.RUnlock()
+
+	return migrations[dbName]
+}
+
+// LatestSchemaVersion returns the version of the last registered migration of the db.
+func LatestSchemaVersion(dbName string) uint32 {
+	steps := getMigrations(dbName)
+	if len(steps) == 0 {
+		return 0
+	}
+
+	return steps[len(steps)-1].Version
+}
+
+func schemaVersionKey(dbName string) []byte {
+	return append(common.CloneBytes(DBSchemaVersionPrefix), []byte(dbName)...)
+}
+
+func GetSchemaVersion(dbName string, meta KVDatabase) (*SchemaVersion, error) {
+	val, err := meta.Get(schemaVersionKey(dbName))
+	if err != nil {
+		return nil, err
+	}
+
+	sv := &SchemaVersion{}
+	err = json.Unmarshal(val, sv)
+	if err != nil {
+		return nil, err
+	}
+
+	return sv, nil
+}
+
+func saveSchemaVersion(dbName string, meta KVDatabase, sv *SchemaVersion) error {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+	sv.UpdateTS = ts
+
+	marshaled, err := json.Marshal(sv)
+	if err != nil {
+		return err
+	}
+
+	return meta.Put(schemaVersionKey(dbName), marshaled)
+}
+
+/*
+Migrate runs the registered migration steps of the db in order, from the schema version stored in meta.
+
+A db without schema version is considered as the latest version if it is empty (new data-dir),
+and as version 0 otherwise (data-dir created before the migrations).
+*/
+func Migrate(dbName string, db KVDatabase, meta KVDatabase, opts *MigrateOptions) error {
+	isDryRun := opts.isDryRun()
+
+	steps := getMigrations(dbName)
+	latest := LatestSchemaVersion(dbName)
+
+	sv, err := GetSchemaVersion(dbName, meta)
+	if err == leveldb.ErrNotFound {
+		sv = &SchemaVersion{V: types.CurrentVersion}
+		if isEmptyDB(db) {
+			sv.Version = latest
+		}
+		if !isDryRun {
+			err = saveSchemaVersion(dbName, meta, sv)
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if sv.Version > latest {
+		log.Error("Migrate: schema is newer than the code", "db", dbName, "version", sv.Version, "latest", latest)
+		return ErrSchemaTooNew
+	}
+
+	if sv.Version == latest {
+		return nil
+	}
+
+	log.Info("Migrate: start", "db", dbName, "version", sv.Version, "latest", latest, "isDryRun", isDryRun)
+
+	for _, step := range steps {
+		if step.Version <= sv.Version {
+			continue
+		}
+
+		if sv.Pending == step.Version {
+			log.Info("Migrate: resume", "db", dbName, "version", step.Version, "name", step.Name, "checkpoint", sv.Checkpoint)
+		} else {
+			sv.Pending = step.Version
+			sv.Checkpoint = nil
+			if !isDryRun {
+				err = saveSchemaVersion(dbName, meta, sv)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		mc := &MigrationContext{
+			DBName:   dbName,
+			DB:       db,
+			IsDryRun: isDryRun,
+
+			meta: meta,
+			sv:   sv,
+		}
+
+		err = step.Run(mc)
+		if err != nil {
+			log.Error("Migrate: unable to run", "db", dbName, "version", step.Version, "name", step.Name, "e", err)
+			return err
+		}
+
+		log.Info("Migrate: done", "db", dbName, "version", step.Version, "name", step.Name, "nChanged", mc.NChanged, "isDryRun", isDryRun)
+
+		sv.Version = step.Version
+		sv.Pending = 0
+		sv.Checkpoint = nil
+		if isDryRun {
+			continue
+		}
+
+		err = saveSchemaVersion(dbName, meta, sv)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+MigrateAll migrates the meta db and then all the dbs of a service, with the schema versions stored in meta.
+
+The dbs are registered and referred by their names.
+*/
+func MigrateAll(meta KVDatabase, opts *MigrateOptions, dbs ...KVDatabase) error {
+	err := Migrate(meta.Name(), meta, meta, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, db := range dbs {
+		err = Migrate(db.Name(), db, meta, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isEmptyDB(db KVDatabase) bool {
+	iter := db.NewIterator(ListOrderNext)
+	defer iter.Release()
+
+	return !iter.Next()
+}
+
+/*
+MigrationContext is passed to Migration.Run.
+
+The writes through MigrationContext are skipped (but counted) in dry-run.
+*/
+type MigrationContext struct {
+	DBName   string
+	DB       KVDatabase
+	IsDryRun bool
+
+	NChanged int
+
+	meta KVDatabase
+	sv   *SchemaVersion
+}
+
+func (mc *MigrationContext) Put(key []byte, value []byte) error {
+	mc.NChanged++
+	if mc.IsDryRun {
+		return nil
+	}
+
+	return mc.DB.Put(key, value)
+}
+
+func (mc *MigrationContext) Delete(key []byte) error {
+	mc.NChanged++
+	if mc.IsDryRun {
+		return nil
+	}
+
+	return mc.DB.Delete(key)
+}
+
+// Checkpoint returns the checkpoint saved by the interrupted run of the step, nil if starting from the beginning.
+func (mc *MigrationContext) Checkpoint() []byte {
+	return mc.sv.Checkpoint
+}
+
+func (mc *MigrationContext) SetCheckpoint(key []byte) error {
+	mc.sv.Checkpoint = common.CloneBytes(key)
+	if mc.IsDryRun {
+		return nil
+	}
+
+	return saveSchemaVersion(mc.DBName, mc.meta, mc.sv)
+}
+
+/*
+ForEachWithPrefix iterates the records with prefix in ListOrderNext,
+starting after the checkpoint if the step is resumed.
+
+The checkpoint is saved every MigrationCheckpointInterval records.
+The checkpoint not under prefix (saved by another ForEachWithPrefix of the step) is clamped:
+the scan starts from the beginning if the checkpoint is before prefix,
+and is already done if after.
+*/
+func (mc *MigrationContext) ForEachWithPrefix(prefix []byte, f func(key []byte, val []byte) error) error {
+	checkpoint := mc.Checkpoint()
+	if checkpoint != nil && !bytes.HasPrefix(checkpoint, prefix) {
+		if bytes.Compare(checkpoint, prefix) > 0 {
+			return nil
+		}
+		checkpoint = nil
+	}
+
+	iter, err := mc.DB.NewIteratorWithPrefix(checkpoint, prefix, ListOrderNext)
+	if err != nil {
+		return err
+	}
+	defer iter.Release()
+
+	var key []byte
+	i := 0
+	for iter.Next() {
+		key = common.CloneBytes(iter.Key())
+		if checkpoint != nil && bytes.Equal(key, checkpoint) {
+			continue
+		}
+
+		err = f(key, common.CloneBytes(iter.Value()))
+		if err != nil {
+			return err
+		}
+
+		i++
+		if i%MigrationCheckpointInterval == 0 {
+			err = mc.SetCheckpoint(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return iter.Error()
+}
@@ -0,0 +1,237 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	// setup test
+	db := NewMemLDBDatabase("test-migrate")
+	meta := NewMemLDBDatabase("test-migrate-meta")
+
+	db.Put([]byte("test-a1"), []byte("v1"))
+	db.Put([]byte("test-a2"), []byte("v1"))
+	db.Put([]byte("test-b1"), []byte("v1"))
+
+	// rewrite test-a* with v2
+	RegisterMigration(db.Name(), &Migration{
+		Version: 1,
+		Name:    "rewrite-a",
+		Run: func(mc *MigrationContext) error {
+			return mc.ForEachWithPrefix([]byte("test-a"), func(key []byte, val []byte) error {
+				return mc.Put(key, []byte("v2"))
+			})
+		},
+	})
+
+	// reindex test-b* to test-c*
+	RegisterMigration(db.Name(), &Migration{
+		Version: 2,
+		Name:    "reindex-b",
+		Run: func(mc *MigrationContext) error {
+			return mc.ForEachWithPrefix([]byte("test-b"), func(key []byte, val []byte) error {
+				newKey := append([]byte("test-c"), key[len("test-b"):]...)
+				err := mc.Put(newKey, val)
+				if err != nil {
+					return err
+				}
+				return mc.Delete(key)
+			})
+		},
+	})
+
+	// dry-run
+	err := Migrate(db.Name(), db, meta, &MigrateOptions{IsDryRun: true})
+	if err != nil {
+		t.Errorf("Migrate (dry-run): e: %v", err)
+	}
+	val, _ := db.Get([]byte("test-a1"))
+	if !bytes.Equal(val, []byte("v1")) {
+		t.Errorf("Migrate (dry-run): test-a1 = %s, want v1", val)
+	}
+	_, err = GetSchemaVersion(db.Name(), meta)
+	if err == nil {
+		t.Errorf("Migrate (dry-run): schema version is saved")
+	}
+
+	// migrate
+	err = Migrate(db.Name(), db, meta, nil)
+	if err != nil {
+		t.Errorf("Migrate: e: %v", err)
+	}
+
+	val, _ = db.Get([]byte("test-a2"))
+	if !bytes.Equal(val, []byte("v2")) {
+		t.Errorf("Migrate: test-a2 = %s, want v2", val)
+	}
+	isHas, _ := db.Has([]byte("test-b1"))
+	if isHas {
+		t.Errorf("Migrate: test-b1 is not reindexed")
+	}
+	val, _ = db.Get([]byte("test-c1"))
+	if !bytes.Equal(val, []byte("v1")) {
+		t.Errorf("Migrate: test-c1 = %s, want v1", val)
+	}
+
+	sv, err := GetSchemaVersion(db.Name(), meta)
+	if err != nil || sv.Version != 2 || sv.Pending != 0 {
+		t.Errorf("Migrate: schema version: %v e: %v, want 2", sv, err)
+	}
+
+	// newer schema than the code
+	sv.Version = 3
+	saveSchemaVersion(db.Name(), meta, sv)
+	err = Migrate(db.Name(), db, meta, nil)
+	if err != ErrSchemaTooNew {
+		t.Errorf("Migrate: e: %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestMigrate_Empty(t *testing.T) {
+	// setup test
+	db := NewMemLDBDatabase("test-migrate-empty")
+	meta := NewMemLDBDatabase("test-migrate-empty-meta")
+
+	RegisterMigration(db.Name(), &Migration{
+		Version: 1,
+		Name:    "fail",
+		Run: func(mc *MigrationContext) error {
+			return errors.New("should not run")
+		},
+	})
+
+	// run test
+	err := MigrateAll(meta, nil, db)
+	if err != nil {
+		t.Errorf("MigrateAll: e: %v", err)
+	}
+
+	sv, err := GetSchemaVersion(db.Name(), meta)
+	if err != nil || sv.Version != 1 {
+		t.Errorf("MigrateAll: schema version: %v e: %v, want 1", sv, err)
+	}
+}
+
+func TestMigrate_Resume(t *testing.T) {
+	// setup test
+	origInterval := MigrationCheckpointInterval
+	MigrationCheckpointInterval = 1
+	defer func() {
+		MigrationCheckpointInterval = origInterval
+	}()
+
+	db := NewMemLDBDatabase("test-migrate-resume")
+	meta := NewMemLDBDatabase("test-migrate-resume-meta")
+
+	db.Put([]byte("test-a1"), []byte("v1"))
+	db.Put([]byte("test-a2"), []byte("v1"))
+	db.Put([]byte("test-a3"), []byte("v1"))
+
+	errCrash := errors.New("crash")
+	isCrash := true
+	visited := [][]byte{}
+
+	RegisterMigration(db.Name(), &Migration{
+		Version: 1,
+		Name:    "rewrite-a",
+		Run: func(mc *MigrationContext) error {
+			return mc.ForEachWithPrefix([]byte("test-a"), func(key []byte, val []byte) error {
+				if isCrash && bytes.Equal(key, []byte("test-a2")) {
+					return errCrash
+				}
+				visited = append(visited, key)
+				return mc.Put(key, []byte("v2"))
+			})
+		},
+	})
+
+	// run test
+	err := Migrate(db.Name(), db, meta, nil)
+	if err != errCrash {
+		t.Errorf("Migrate: e: %v, want errCrash", err)
+	}
+
+	sv, _ := GetSchemaVersion(db.Name(), meta)
+	if sv.Version != 0 || sv.Pending != 1 || !bytes.Equal(sv.Checkpoint, []byte("test-a1")) {
+		t.Errorf("Migrate: schema version: %v, want pending 1 with checkpoint test-a1", sv)
+	}
+
+	isCrash = false
+	err = Migrate(db.Name(), db, meta, nil)
+	if err != nil {
+		t.Errorf("Migrate (resume): e: %v", err)
+	}
+
+	if len(visited) != 3 || !bytes.Equal(visited[1], []byte("test-a2")) {
+		t.Errorf("Migrate (resume): visited: %s, want test-a1, test-a2, test-a3", visited)
+	}
+
+	sv, _ = GetSchemaVersion(db.Name(), meta)
+	if sv.Version != 1 || sv.Pending != 0 || sv.Checkpoint != nil {
+		t.Errorf("Migrate (resume): schema version: %v, want 1", sv)
+	}
+}
+
+func TestMigrationContext_ForEachWithPrefix(t *testing.T) {
+	// setup test
+	db := NewMemLDBDatabase("test-migrate-foreach")
+
+	db.Put([]byte("test-a1"), []byte("v1"))
+	db.Put([]byte("test-b1"), []byte("v1"))
+	db.Put([]byte("test-b2"), []byte("v1"))
+
+	tests := []struct {
+		name       string
+		checkpoint []byte
+		want       []string
+	}{
+		{"no checkpoint", nil, []string{"test-b1", "test-b2"}},
+		{"in prefix", []byte("test-b1"), []string{"test-b2"}},
+		{"before prefix", []byte("test-a1"), []string{"test-b1", "test-b2"}},
+		{"after prefix", []byte("test-c1"), []string{}},
+	}
+
+	// run test
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := &MigrationContext{
+				DBName:   db.Name(),
+				DB:       db,
+				IsDryRun: true,
+
+				sv: &SchemaVersion{Checkpoint: tt.checkpoint},
+			}
+
+			got := []string{}
+			err := mc.ForEachWithPrefix([]byte("test-b"), func(key []byte, val []byte) error {
+				got = append(got, string(key))
+				return nil
+			})
+			if err != nil {
+				t.Errorf("ForEachWithPrefix: e: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ForEachWithPrefix: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
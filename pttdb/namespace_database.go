@@ -0,0 +1,176 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+NamespaceDatabase implements KVDatabase as a namespace of a shared db.
+
+All the keys are prefixed with the name of the namespace, and the iterators return the keys without the prefix.
+The tx of the namespaces share the tx-lock of the shared db,
+so a tx on the shared db can write to several namespaces atomically with Key.
+*/
+type NamespaceDatabase struct {
+	name   string
+	prefix []byte
+	db     KVDatabase
+
+	*dbLockMap
+}
+
+func NewNamespaceDatabase(db KVDatabase, name string) *NamespaceDatabase {
+	return &NamespaceDatabase{
+		name:      name,
+		prefix:    append([]byte(name), NamespaceSeparator),
+		db:        db,
+		dbLockMap: newDBLockMap(),
+	}
+}
+
+func (ns *NamespaceDatabase) Name() string {
+	return ns.name
+}
+
+func (ns *NamespaceDatabase) Path() string {
+	return ns.db.Path()
+}
+
+// Root returns the shared db.
+func (ns *NamespaceDatabase) Root() KVDatabase {
+	return ns.db
+}
+
+// Key returns the key in the shared db.
+func (ns *NamespaceDatabase) Key(key []byte) []byte {
+	nsKey := make([]byte, len(ns.prefix)+len(key))
+	copy(nsKey, ns.prefix)
+	copy(nsKey[len(ns.prefix):], key)
+	return nsKey
+}
+
+func (ns *NamespaceDatabase) Put(key []byte, value []byte) error {
+	return ns.db.Put(ns.Key(key), value)
+}
+
+func (ns *NamespaceDatabase) Has(key []byte) (bool, error) {
+	return ns.db.Has(ns.Key(key))
+}
+
+func (ns *NamespaceDatabase) Get(key []byte) ([]byte, error) {
+	return ns.db.Get(ns.Key(key))
+}
+
+func (ns *NamespaceDatabase) Delete(key []byte) error {
+	return ns.db.Delete(ns.Key(key))
+}
+
+func (ns *NamespaceDatabase) TryPut(key []byte, value []byte, updateTS types.Timestamp) ([]byte, error) {
+	return tryPut(ns, key, value, updateTS)
+}
+
+func (ns *NamespaceDatabase) Pop(key []byte) ([]byte, error) {
+	return pop(ns, key)
+}
+
+func (ns *NamespaceDatabase) NewIterator(listOrder ListOrder) iterator.Iterator {
+	return ns.NewIteratorWithRange(&util.Range{}, listOrder)
+}
+
+func (ns *NamespaceDatabase) NewIteratorWithRange(r *util.Range, listOrder ListOrder) iterator.Iterator {
+	nsRange := util.BytesPrefix(ns.prefix)
+	if r != nil && r.Start != nil {
+		nsRange.Start = ns.Key(r.Start)
+	}
+	if r != nil && r.Limit != nil {
+		nsRange.Limit = ns.Key(r.Limit)
+	}
+
+	return &namespaceIterator{
+		Iterator: ns.db.NewIteratorWithRange(nsRange, listOrder),
+		ns:       ns,
+	}
+}
+
+func (ns *NamespaceDatabase) NewIteratorWithPrefix(start []byte, prefix []byte, listOrder ListOrder) (iterator.Iterator, error) {
+	return newIteratorWithPrefix(ns, start, prefix, listOrder)
+}
+
+func (ns *NamespaceDatabase) NewTx() *LDBTx {
+	return NewLDBTx(ns)
+}
+
+func (ns *NamespaceDatabase) NewBatch() Batch {
+	return &ldbBatch{db: ns, b: new(leveldb.Batch)}
+}
+
+// Close does nothing. The shared db is closed by its owner.
+func (ns *NamespaceDatabase) Close() {}
+
+func (ns *NamespaceDatabase) writeBatch(b *leveldb.Batch) error {
+	nsBatch := new(leveldb.Batch)
+	err := b.Replay(&namespaceBatchReplay{ns: ns, b: nsBatch})
+	if err != nil {
+		return err
+	}
+
+	return ns.db.writeBatch(nsBatch)
+}
+
+func (ns *NamespaceDatabase) txLock() *sync.Mutex {
+	return ns.db.txLock()
+}
+
+// namespaceIterator strips the prefix of the namespace from the keys.
+type namespaceIterator struct {
+	iterator.Iterator
+	ns *NamespaceDatabase
+}
+
+func (it *namespaceIterator) Key() []byte {
+	key := it.Iterator.Key()
+	if key == nil {
+		return nil
+	}
+
+	return key[len(it.ns.prefix):]
+}
+
+func (it *namespaceIterator) Seek(key []byte) bool {
+	return it.Iterator.Seek(it.ns.Key(key))
+}
+
+// namespaceBatchReplay replays leveldb.Batch with the prefix of the namespace.
+type namespaceBatchReplay struct {
+	ns *NamespaceDatabase
+	b  *leveldb.Batch
+}
+
+func (r *namespaceBatchReplay) Put(key, value []byte) {
+	r.b.Put(r.ns.Key(key), value)
+}
+
+func (r *namespaceBatchReplay) Delete(key []byte) {
+	r.b.Delete(r.ns.Key(key))
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceDatabase_Tx(t *testing.T) {
+	// setup test
+	root := NewMemLDBDatabase("test")
+	nsA := NewNamespaceDatabase(root, "a")
+	nsB := NewNamespaceDatabase(root, "b")
+
+	// tx on namespace
+	tx := nsA.NewTx()
+	tx.Put([]byte("key"), []byte("value-a"))
+	err := tx.Commit()
+	if err != nil {
+		t.Errorf("NamespaceDatabase.NewTx: Commit e: %v", err)
+	}
+
+	got, _ := root.Get([]byte("a/key"))
+	if !reflect.DeepEqual(got, []byte("value-a")) {
+		t.Errorf("NamespaceDatabase.NewTx: root.Get = %s, want value-a", got)
+	}
+
+	// tx across namespaces on root
+	tx = root.NewTx()
+	tx.Put(nsA.Key([]byte("key2")), []byte("value-a2"))
+	tx.Put(nsB.Key([]byte("key2")), []byte("value-b2"))
+	err = tx.Commit()
+	if err != nil {
+		t.Errorf("root.NewTx: Commit e: %v", err)
+	}
+
+	got, _ = nsA.Get([]byte("key2"))
+	if !reflect.DeepEqual(got, []byte("value-a2")) {
+		t.Errorf("root.NewTx: nsA.Get = %s, want value-a2", got)
+	}
+	got, _ = nsB.Get([]byte("key2"))
+	if !reflect.DeepEqual(got, []byte("value-b2")) {
+		t.Errorf("root.NewTx: nsB.Get = %s, want value-b2", got)
+	}
+
+	isHas, _ := nsB.Has([]byte("key"))
+	if isHas {
+		t.Errorf("NamespaceDatabase: nsB has the key of nsA")
+	}
+}
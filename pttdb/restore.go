@@ -0,0 +1,328 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/rlp"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+Restore restores the backup archive to rootDir. The node must not be running.
+
+The whole archive is authenticated with the hmac first,
+and then restored to a staging dir next to rootDir, with the checksums verified against the manifest.
+Only after all the checksums match, the dbs and the files are moved into rootDir,
+and the original ones are moved to rootDir.old.<ts>.
+*/
+func Restore(filename string, rootDir string, passphrase string) (*BackupManifest, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyBackupPassphrase
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header, stream, err := verifyBackup(f, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	tsStr := time.Now().UTC().Format("2006-01-02_15-04-05.000")
+	stageDir := rootDir + ".restore." + tsStr
+
+	manifest, err := restoreToStage(f, header, stream, stageDir)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return nil, err
+	}
+
+	oldDir := rootDir + ".old." + tsStr
+	err = replaceBackupItems(manifest, stageDir, rootDir, oldDir)
+	if err != nil {
+		log.Error("Restore: unable to replace", "stageDir", stageDir, "oldDir", oldDir, "e", err)
+		return nil, err
+	}
+
+	os.RemoveAll(stageDir)
+
+	log.Info("Restore: done", "file", filename, "rootDir", rootDir, "oldDir", oldDir, "items", len(manifest.Items))
+
+	return manifest, nil
+}
+
+/*
+verifyBackup checks the hmac of the archive, and returns the header and the stream to decrypt the records.
+*/
+func verifyBackup(f *os.File, passphrase string) (*backupHeader, cipher.Stream, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headerLength := backupHeaderLength()
+	size := info.Size()
+	if size < headerLength+backupMACLength {
+		return nil, nil, ErrInvalidBackup
+	}
+
+	header, err := readBackupHeader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream, mac, err := header.cipher(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = io.Copy(mac, io.LimitReader(f, size-backupMACLength))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expected := make([]byte, backupMACLength)
+	_, err = io.ReadFull(f, expected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return nil, nil, ErrInvalidBackup
+	}
+
+	_, err = f.Seek(headerLength, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, stream, nil
+}
+
+type restoreItem struct {
+	item *BackupItem
+	h    hash.Hash
+}
+
+func restoreToStage(f *os.File, header *backupHeader, stream cipher.Stream, stageDir string) (*BackupManifest, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	length := info.Size() - backupHeaderLength() - backupMACLength
+
+	r := &cipher.StreamReader{S: stream, R: bufio.NewReader(io.LimitReader(f, length))}
+	s := rlp.NewStream(r, uint64(length))
+
+	var (
+		db      *leveldb.DB
+		batch   *leveldb.Batch
+		current *restoreItem
+		items   = make(map[string]*restoreItem)
+	)
+
+	closeDB := func() error {
+		if db == nil {
+			return nil
+		}
+		defer func() {
+			db.Close()
+			db = nil
+		}()
+
+		return db.Write(batch, nil)
+	}
+	defer closeDB()
+
+	var manifest *BackupManifest
+	for manifest == nil {
+		record := &backupRecord{}
+		err = s.Decode(record)
+		if err != nil {
+			return nil, ErrInvalidBackup
+		}
+
+		switch record.Type {
+		case backupRecordDB:
+			err = closeDB()
+			if err != nil {
+				return nil, err
+			}
+
+			current, err = newRestoreItem(items, record.Path, true)
+			if err != nil {
+				return nil, err
+			}
+
+			db, err = leveldb.OpenFile(filepath.Join(stageDir, filepath.FromSlash(record.Path)), nil)
+			if err != nil {
+				return nil, err
+			}
+			batch = new(leveldb.Batch)
+		case backupRecordKV:
+			if db == nil {
+				return nil, ErrInvalidBackup
+			}
+
+			batch.Put(record.Key, record.Value)
+			hashBackupKV(current.h, record.Key, record.Value)
+			current.item.N++
+
+			if len(batch.Dump()) < IdealBatchSize {
+				continue
+			}
+			err = db.Write(batch, nil)
+			if err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		case backupRecordFile:
+			err = closeDB()
+			if err != nil {
+				return nil, err
+			}
+
+			current, err = newRestoreItem(items, record.Path, false)
+			if err != nil {
+				return nil, err
+			}
+
+			err = restoreFile(filepath.Join(stageDir, filepath.FromSlash(record.Path)), record.Value)
+			if err != nil {
+				return nil, err
+			}
+			current.h.Write(record.Value)
+			current.item.N = int64(len(record.Value))
+		case backupRecordManifest:
+			manifest = &BackupManifest{}
+			err = json.Unmarshal(record.Value, manifest)
+			if err != nil {
+				return nil, ErrInvalidBackup
+			}
+		default:
+			return nil, ErrInvalidBackup
+		}
+	}
+
+	err = closeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.Version != header.Version || len(manifest.Items) != len(items) {
+		return nil, ErrBackupChecksum
+	}
+
+	for _, item := range manifest.Items {
+		restored, ok := items[item.Path]
+		if !ok || restored.item.IsDB != item.IsDB || restored.item.N != item.N || !bytes.Equal(restored.h.Sum(nil), item.Hash) {
+			log.Error("restoreToStage: checksum mismatch", "path", item.Path)
+			return nil, ErrBackupChecksum
+		}
+	}
+
+	return manifest, nil
+}
+
+func newRestoreItem(items map[string]*restoreItem, path string, isDB bool) (*restoreItem, error) {
+	err := validateBackupPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := items[path]; ok {
+		return nil, ErrInvalidBackup
+	}
+
+	item := &restoreItem{
+		item: &BackupItem{Path: path, IsDB: isDB},
+		h:    sha256.New(),
+	}
+	items[path] = item
+
+	return item, nil
+}
+
+func restoreFile(filename string, content []byte) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, content, 0600)
+}
+
+/*
+replaceBackupItems moves the original dbs and files in rootDir to oldDir,
+and moves the restored ones from stageDir to rootDir.
+*/
+func replaceBackupItems(manifest *BackupManifest, stageDir string, rootDir string, oldDir string) error {
+	for _, item := range manifest.Items {
+		path := filepath.FromSlash(item.Path)
+		dst := filepath.Join(rootDir, path)
+
+		_, err := os.Lstat(dst)
+		switch {
+		case err == nil:
+			old := filepath.Join(oldDir, path)
+			err = os.MkdirAll(filepath.Dir(old), 0700)
+			if err != nil {
+				return err
+			}
+			err = os.Rename(dst, old)
+			if err != nil {
+				return err
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(dst), 0700)
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(filepath.Join(stageDir, path), dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
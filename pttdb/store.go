@@ -0,0 +1,258 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/metrics"
+)
+
+var (
+	storeDB      *LDBDatabase
+	storeRootDir string
+)
+
+/*
+InitStore opens the single LevelDB shared by all the services if cfg.IsSingle.
+
+Otherwise each db of the services is opened as a separated LevelDB in OpenDatabase.
+
+The single db is not created if the data-dir already has the separated dbs of the services (ErrSeparatedDBs),
+because the services would start from the empty single db and the separated dbs would be ignored.
+*/
+func InitStore(cfg *Config) error {
+	storeRootDir = cfg.RootDir
+
+	if !cfg.IsSingle {
+		return nil
+	}
+
+	storePath := filepath.Join(cfg.DataDir, StoreName)
+	if !isLevelDBDir(storePath) {
+		separatedDBs, err := findLevelDBDirs(cfg.RootDir, append([]string{storePath}, cfg.IgnoredDirs...))
+		if err != nil {
+			return err
+		}
+		if len(separatedDBs) != 0 {
+			log.Error("InitStore: the data-dir has the separated dbs. run without --db.single", "dbs", separatedDBs)
+			return ErrSeparatedDBs
+		}
+	}
+
+	db, err := NewLDBDatabase(StoreName, cfg.DataDir, cfg.Cache, cfg.Handles)
+	if err != nil {
+		return err
+	}
+
+	log.Info("InitStore: single db", "path", db.Path(), "cache", cfg.Cache, "handles", cfg.Handles)
+
+	storeDB = db
+	registerBackupDB(db)
+	meterDB(db)
+
+	return nil
+}
+
+func TeardownStore() {
+	if storeDB != nil {
+		storeDB.Close()
+		storeDB = nil
+	}
+
+	storeRootDir = ""
+}
+
+/*
+StoreDB returns the single LevelDB shared by all the services, nil if the services run on separated LevelDBs.
+
+A tx on StoreDB can write to the namespaces of several services atomically, with NamespaceDatabase.Key.
+*/
+func StoreDB() *LDBDatabase {
+	return storeDB
+}
+
+/*
+OpenDatabase opens the db of the services.
+
+It returns the namespace of the single LevelDB if InitStore is with IsSingle,
+and a separated LevelDB in dataDir otherwise.
+*/
+func OpenDatabase(file string, dataDir string) (KVDatabase, error) {
+	if storeDB != nil {
+		return NewNamespaceDatabase(storeDB, file), nil
+	}
+
+	db, err := NewLDBDatabase(file, dataDir, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	registerBackupDB(db)
+	meterDB(db)
+
+	return db, nil
+}
+
+/*
+OpenReadOnlyStore opens the single LevelDB read-only if cfg.IsSingle, nil otherwise.
+
+The store is not registered as StoreDB, and is passed to OpenReadOnlyDatabase.
+*/
+func OpenReadOnlyStore(cfg *Config) (*LDBDatabase, error) {
+	if !cfg.IsSingle {
+		return nil, nil
+	}
+
+	return NewReadOnlyLDBDatabase(StoreName, cfg.DataDir)
+}
+
+/*
+OpenReadOnlyDatabase opens the db of the services read-only, for the offline inspection.
+
+It returns the namespace of store if store is not nil, and the separated LevelDB in dataDir otherwise.
+*/
+func OpenReadOnlyDatabase(store *LDBDatabase, file string, dataDir string) (KVDatabase, error) {
+	if store != nil {
+		return NewNamespaceDatabase(store, file), nil
+	}
+
+	return NewReadOnlyLDBDatabase(file, dataDir)
+}
+
+/*
+RootDir returns the data-dir of the node, where the backup archives are rooted.
+*/
+func RootDir() string {
+	return storeRootDir
+}
+
+/*
+meterDB meters the LevelDB in MetricsPrefix/<the path relative to the data-dir with "/" as ".">/
+if the metrics are enabled.
+*/
+func meterDB(db *LDBDatabase) {
+	if !metrics.Enabled {
+		return
+	}
+
+	name, err := backupRelPath(db.Path(), storeRootDir)
+	if err != nil {
+		name = filepath.Base(db.Path())
+	}
+	name = strings.Replace(name, "/", ".", -1)
+
+	db.Meter(MetricsPrefix + name + "/")
+}
+
+/*
+DBSizes returns the on-disk sizes of the opened LevelDBs,
+keyed by the paths relative to the data-dir.
+*/
+func DBSizes() map[string]int64 {
+	backupLock.Lock()
+	paths := make([]string, 0, len(backupDBs))
+	for path := range backupDBs {
+		paths = append(paths, path)
+	}
+	backupLock.Unlock()
+
+	sizes := make(map[string]int64)
+	for _, path := range paths {
+		name, err := backupRelPath(path, storeRootDir)
+		if err != nil {
+			name = filepath.Base(path)
+		}
+
+		sizes[name] = dirSize(path)
+	}
+
+	return sizes
+}
+
+/*
+findLevelDBDirs returns the LevelDB dirs in rootDir, except the dirs in ignoredDirs.
+*/
+func findLevelDBDirs(rootDir string, ignoredDirs []string) ([]string, error) {
+	if rootDir == "" {
+		return nil, nil
+	}
+
+	ignored := make(map[string]bool)
+	for _, dir := range ignoredDirs {
+		if dir == "" {
+			continue
+		}
+		ignored[filepath.Clean(dir)] = true
+	}
+
+	var dirs []string
+	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if ignored[filepath.Clean(path)] {
+			return filepath.SkipDir
+		}
+		if isLevelDBDir(path) {
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dirs, nil
+}
+
+/*
+isLevelDBDir checks whether dir is a LevelDB (with the CURRENT file).
+*/
+func isLevelDBDir(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "CURRENT"))
+	return err == nil && info.Mode().IsRegular()
+}
+
+/*
+dirSize returns the total size of the files in the LevelDB dir (no sub-dirs in LevelDB).
+*/
+func dirSize(dir string) int64 {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	size := int64(0)
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitStore_SeparatedDBs(t *testing.T) {
+	// setup test
+	rootDir, err := ioutil.TempDir("", "pttdb-store")
+	if err != nil {
+		t.Fatalf("TempDir: e: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	nodeDB, err := NewLDBDatabase("nodes", filepath.Join(rootDir, "gptt"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewLDBDatabase: e: %v", err)
+	}
+	nodeDB.Close()
+
+	cfg := &Config{
+		RootDir:     rootDir,
+		IsSingle:    true,
+		DataDir:     filepath.Join(rootDir, "db"),
+		IgnoredDirs: []string{filepath.Join(rootDir, "gptt", "nodes")},
+	}
+
+	// the ignored node-db only
+	err = InitStore(cfg)
+	if err != nil {
+		t.Errorf("InitStore: e: %v", err)
+	}
+	TeardownStore()
+
+	// the separated db is created after the single db: the single db is used.
+	db, err := NewLDBDatabase("oplog", filepath.Join(rootDir, "ptt"), 0, 0)
+	if err != nil {
+		t.Fatalf("NewLDBDatabase: e: %v", err)
+	}
+	db.Close()
+
+	err = InitStore(cfg)
+	if err != nil {
+		t.Errorf("InitStore: existing single db: e: %v", err)
+	}
+	TeardownStore()
+
+	// the separated db without the single db.
+	os.RemoveAll(cfg.DataDir)
+
+	err = InitStore(cfg)
+	if err != ErrSeparatedDBs {
+		t.Errorf("InitStore: e: %v, want: %v", err, ErrSeparatedDBs)
+	}
+	if StoreDB() != nil {
+		t.Errorf("InitStore: StoreDB is opened with the separated dbs")
+	}
+	TeardownStore()
+}
@@ -17,12 +17,17 @@
 package pttdb
 
 import (
+	"encoding/json"
+	"strings"
+
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
-func GetIterByID(db *LDBDatabase, prefix []byte, idxPrefix []byte, startID *types.PttID, listOrder ListOrder) (iterator.Iterator, error) {
+func GetIterByID(db KVDatabase, prefix []byte, idxPrefix []byte, startID *types.PttID, listOrder ListOrder) (iterator.Iterator, error) {
 	if startID == nil {
 		return db.NewIteratorWithPrefix(nil, prefix, listOrder)
 	}
@@ -50,3 +55,127 @@ func GetFuncIter(iter iterator.Iterator, listOrder ListOrder) func() bool {
 
 	return nil
 }
+
+/*
+tryPut tries to put the key/val based on the updateTS of val.
+
+Shared by LDBDatabase and MemLDBDatabase.
+*/
+func tryPut(db KVDatabase, key []byte, value []byte, updateTS types.Timestamp) ([]byte, error) {
+	err := db.TryLockMap(key)
+	if err != nil {
+		return nil, err
+	}
+	defer db.UnlockMap(key)
+
+	isHasKey, err := db.Has(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isHasKey { // new-one
+		err := db.Put(key, value)
+		return nil, err
+	}
+
+	v, err := db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DBable{}
+
+	err = json.Unmarshal(v, d)
+	if err != nil {
+		return nil, ErrInvalidDBable
+	}
+
+	if updateTS.IsLess(d.UpdateTS) {
+		log.Warn("updateTS < d.UpdateTS", "updateTS", updateTS, "d.UpdateTS", d.UpdateTS)
+		return v, ErrInvalidUpdateTS
+	}
+
+	// put to db
+
+	err = db.Put(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+/*
+pop deletes the key with getting the value.
+
+Shared by LDBDatabase and MemLDBDatabase.
+*/
+func pop(db KVDatabase, key []byte) ([]byte, error) {
+	err := db.TryLockMap(key)
+	if err != nil {
+		return nil, err
+	}
+	defer db.UnlockMap(key)
+
+	val, err := db.Get(key)
+	if err != nil {
+		// Unable to get key. possibly no key in the db. no need to do delete
+		return nil, err
+	}
+
+	err = db.Delete(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+/*
+newIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
+
+Shared by LDBDatabase and MemLDBDatabase.
+*/
+func newIteratorWithPrefix(db KVDatabase, start []byte, prefix []byte, listOrder ListOrder) (iterator.Iterator, error) {
+	// both as nil
+	if len(start) == 0 && len(prefix) == 0 {
+		return db.NewIterator(listOrder), nil
+	}
+
+	// start as nil
+	if len(start) == 0 {
+		r := util.BytesPrefix(prefix)
+		return db.NewIteratorWithRange(r, listOrder), nil
+	}
+
+	// prefix as nil
+	if len(prefix) == 0 {
+		startRange := util.BytesPrefix(start)
+		var r *util.Range
+		switch listOrder {
+		case ListOrderPrev:
+			r = &util.Range{Limit: startRange.Limit}
+		case ListOrderNext:
+			r = &util.Range{Start: startRange.Start}
+		}
+
+		return db.NewIteratorWithRange(r, listOrder), nil
+	}
+
+	// both non-nil
+	if !strings.HasPrefix(string(start), string(prefix)) {
+		return nil, ErrInvalidPrefix
+	}
+
+	startRange := util.BytesPrefix(start)
+	prefixRange := util.BytesPrefix(prefix)
+	var r *util.Range
+	switch listOrder {
+	case ListOrderPrev:
+		r = &util.Range{Start: prefixRange.Start, Limit: startRange.Limit}
+	case ListOrderNext:
+		r = &util.Range{Start: startRange.Start, Limit: prefixRange.Limit}
+	}
+
+	return db.NewIteratorWithRange(r, listOrder), nil
+}
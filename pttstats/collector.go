@@ -0,0 +1,149 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttstats
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+)
+
+/*
+Collector is a minimal collector receiving and validating the reports in ReportPath,
+for the tests and the local monitoring.
+
+The reports are validated with the secrets of the node-names,
+and the replayed or the out-dated reports (MaxClockSkewSeconds) are rejected.
+*/
+type Collector struct {
+	secrets map[string]string
+
+	lock    sync.RWMutex
+	reports map[string]*Report
+
+	reportChan chan *Report
+}
+
+func NewCollector(secrets map[string]string) *Collector {
+	return &Collector{
+		secrets: secrets,
+		reports: make(map[string]*Report),
+
+		reportChan: make(chan *Report, 10),
+	}
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != ReportPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	marshaled, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxReportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := c.validate(marshaled, r.Header.Get(SignatureHeader))
+	switch err {
+	case nil:
+	case ErrUnknownNode, ErrInvalidSignature:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Debug("Collector: received", "name", report.Name, "nodeID", report.NodeID)
+
+	select {
+	case c.reportChan <- report:
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Collector) validate(marshaled []byte, sig string) (*Report, error) {
+	report := &Report{}
+	err := json.Unmarshal(marshaled, report)
+	if err != nil || report.NodeID == nil {
+		return nil, ErrInvalidReport
+	}
+
+	secret, ok := c.secrets[report.Name]
+	if !ok {
+		return nil, ErrUnknownNode
+	}
+
+	err = VerifyReport(marshaled, secret, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	if report.TS.Ts+MaxClockSkewSeconds < now.Ts || now.Ts+MaxClockSkewSeconds < report.TS.Ts {
+		return nil, ErrInvalidTS
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// replayed
+	if last, ok := c.reports[report.Name]; ok && !last.TS.IsLess(report.TS) {
+		return nil, ErrInvalidTS
+	}
+	c.reports[report.Name] = report
+
+	return report, nil
+}
+
+/*
+Reports returns the latest reports of the nodes.
+*/
+func (c *Collector) Reports() map[string]*Report {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	reports := make(map[string]*Report)
+	for name, report := range c.reports {
+		reports[name] = report
+	}
+
+	return reports
+}
+
+/*
+ReportChan returns the chan of the validated reports (dropped if not received in time).
+*/
+func (c *Collector) ReportChan() <-chan *Report {
+	return c.reportChan
+}
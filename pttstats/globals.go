@@ -0,0 +1,45 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttstats
+
+import "time"
+
+const (
+	ReportPath = "/api/report"
+
+	SignatureHeader = "X-Pttstats-Signature"
+
+	MaxReportSize = 1024 * 1024
+)
+
+var (
+	DefaultConfig = Config{}
+
+	InitReportDelay = 3 * time.Second
+	ReportInterval  = 30 * time.Second
+	MinBackoff      = 1 * time.Second
+	MaxBackoff      = 5 * time.Minute
+
+	ReportTimeout = 10 * time.Second
+
+	// collector
+	MaxClockSkewSeconds uint64 = 300
+)
+
+var (
+	processStartTime = time.Now()
+)
@@ -0,0 +1,71 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttstats
+
+import (
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+)
+
+var (
+	tDefaultNodeID = discover.MustHexID("0x3b4e8e2e0b1f1b35c9fd4a5b2f3b6f2b1b1f7f2c6e3a7b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8")
+
+	tDefaultSyncTS = types.Timestamp{Ts: 1234567890}
+)
+
+type testBackend struct{}
+
+func (b *testBackend) MyNodeID() *discover.NodeID {
+	return &tDefaultNodeID
+}
+
+func (b *testBackend) GetVersion() (string, error) {
+	return "0.1.0-test", nil
+}
+
+func (b *testBackend) GetGitCommit() (string, error) {
+	return "abcdef", nil
+}
+
+func (b *testBackend) CountPeers() (*pkgservice.BackendCountPeers, error) {
+	return &pkgservice.BackendCountPeers{MyPeers: 1, ImportantPeers: 2, MemberPeers: 3, RandomPeers: 4}, nil
+}
+
+func (b *testBackend) GetEntities() ([]*pkgservice.BackendEntity, error) {
+	return []*pkgservice.BackendEntity{
+		{Status: types.StatusAlive, NPeers: 2, SyncTS: tDefaultSyncTS},
+		{Status: types.StatusAlive, NPeers: 1},
+		{Status: types.StatusFailed},
+	}, nil
+}
+
+func setupTest() {
+	InitReportDelay = 10 * time.Millisecond
+	ReportInterval = 50 * time.Millisecond
+	MinBackoff = 10 * time.Millisecond
+	MaxBackoff = 40 * time.Millisecond
+}
+
+func teardownTest() {
+	InitReportDelay = 3 * time.Second
+	ReportInterval = 30 * time.Second
+	MinBackoff = 1 * time.Second
+	MaxBackoff = 5 * time.Minute
+}
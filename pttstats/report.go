@@ -0,0 +1,89 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttstats
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+)
+
+/*
+Report is the periodic statistics of the node, posted to the collector in ReportPath.
+*/
+type Report struct {
+	Name      string           `json:"name"`
+	NodeID    *discover.NodeID `json:"nodeID"`
+	TS        types.Timestamp  `json:"ts"`
+	Version   string           `json:"version"`
+	GitCommit string           `json:"gitCommit"`
+
+	UptimeSeconds uint64 `json:"uptime"`
+
+	Peers *pkgservice.BackendCountPeers `json:"peers"`
+
+	Entities    map[types.Status]int `json:"entities"` // entity counts by status
+	EntityStats []*EntityStats       `json:"entityStats"`
+
+	DBSizes map[string]int64 `json:"dbSizes"`
+}
+
+/*
+EntityStats is the sync-status of the entity.
+
+LagSeconds is the seconds since the last successful sync, -1 if never synced.
+*/
+type EntityStats struct {
+	ID         *types.PttID    `json:"ID"`
+	Status     types.Status    `json:"S"`
+	NPeers     int             `json:"NP"`
+	SyncTS     types.Timestamp `json:"ST"`
+	LagSeconds int64           `json:"L"`
+}
+
+/*
+SignReport returns the hex-encoded HMAC-SHA256 of the marshaled report with the secret.
+*/
+func SignReport(marshaled []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(marshaled)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+VerifyReport verifies the signature of the marshaled report in constant-time.
+*/
+func VerifyReport(marshaled []byte, secret string, sig string) error {
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(marshaled)
+
+	if !hmac.Equal(mac.Sum(nil), sigBytes) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
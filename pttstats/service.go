@@ -0,0 +1,284 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/rpc"
+	pkgservice "github.com/ailabstw/go-pttai/service"
+)
+
+/*
+Backend is the node-statistics required by the reporter, implemented by BasePtt.
+*/
+type Backend interface {
+	MyNodeID() *discover.NodeID
+	GetVersion() (string, error)
+	GetGitCommit() (string, error)
+	CountPeers() (*pkgservice.BackendCountPeers, error)
+	GetEntities() ([]*pkgservice.BackendEntity, error)
+}
+
+/*
+Service is the opt-in stats reporter (like ethstats in go-ethereum),
+periodically posting the signed reports to the collector in Config.URL.
+
+The failed reports are retried with the exponential backoff from MinBackoff to MaxBackoff.
+*/
+type Service struct {
+	backend Backend
+
+	name     string
+	secret   string
+	endpoint string
+
+	client *http.Client
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+var urlRegexp = regexp.MustCompile(`^([^:@]+):([^@]+)@(.+)$`)
+
+func NewService(cfg *Config, backend Backend) (*Service, error) {
+	name, secret, endpoint, err := parseURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		backend: backend,
+
+		name:     name,
+		secret:   secret,
+		endpoint: endpoint,
+
+		client: &http.Client{Timeout: ReportTimeout},
+
+		quit: make(chan struct{}),
+	}, nil
+}
+
+/*
+parseURL parses nodename:secret@host:port to the name, the secret and the endpoint of the collector.
+host can be prefixed with the scheme (http by default).
+*/
+func parseURL(url string) (string, string, string, error) {
+	parts := urlRegexp.FindStringSubmatch(url)
+	if len(parts) != 4 {
+		return "", "", "", ErrInvalidURL
+	}
+
+	endpoint := parts[3]
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/") + ReportPath
+
+	return parts[1], parts[2], endpoint, nil
+}
+
+/*
+String hides the secret from the logs.
+*/
+func (s *Service) String() string {
+	return fmt.Sprintf("pttstats(%v@%v)", s.name, s.endpoint)
+}
+
+func (s *Service) Protocols() []p2p.Protocol {
+	return nil
+}
+
+func (s *Service) APIs() []rpc.API {
+	return nil
+}
+
+func (s *Service) Start(server *p2p.Server) error {
+	log.Info("pttstats: start", "name", s.name, "endpoint", s.endpoint)
+
+	s.wg.Add(1)
+	go s.loop()
+
+	return nil
+}
+
+func (s *Service) Stop() error {
+	close(s.quit)
+	s.wg.Wait()
+
+	log.Info("pttstats: stopped")
+
+	return nil
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(InitReportDelay)
+	defer timer.Stop()
+
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-s.quit:
+			log.Debug("pttstats.loop: quit")
+			return
+		}
+
+		err := s.report()
+		if err == nil {
+			backoff = 0
+			timer.Reset(ReportInterval)
+			continue
+		}
+
+		backoff = nextBackoff(backoff)
+		log.Warn("pttstats: unable to report", "endpoint", s.endpoint, "retry", backoff, "e", err)
+		timer.Reset(backoff)
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff < MinBackoff {
+		return MinBackoff
+	}
+
+	backoff *= 2
+	if backoff > MaxBackoff {
+		return MaxBackoff
+	}
+
+	return backoff
+}
+
+/*
+report posts the signed report to the collector.
+*/
+func (s *Service) report() error {
+	report, err := s.assembleReport()
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(marshaled))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, SignReport(marshaled, s.secret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pttstats: %v", resp.Status)
+	}
+
+	return nil
+}
+
+func (s *Service) assembleReport() (*Report, error) {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := s.backend.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	gitCommit, err := s.backend.GetGitCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := s.backend.CountPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := s.backend.GetEntities()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[types.Status]int)
+	entityStats := make([]*EntityStats, len(entities))
+	for i, entity := range entities {
+		counts[entity.Status]++
+		entityStats[i] = &EntityStats{
+			ID:         entity.ID,
+			Status:     entity.Status,
+			NPeers:     entity.NPeers,
+			SyncTS:     entity.SyncTS,
+			LagSeconds: syncLagSeconds(entity.SyncTS, ts),
+		}
+	}
+
+	return &Report{
+		Name:      s.name,
+		NodeID:    s.backend.MyNodeID(),
+		TS:        ts,
+		Version:   version,
+		GitCommit: gitCommit,
+
+		UptimeSeconds: uint64(time.Since(processStartTime).Seconds()),
+
+		Peers: peers,
+
+		Entities:    counts,
+		EntityStats: entityStats,
+
+		DBSizes: pttdb.DBSizes(),
+	}, nil
+}
+
+func syncLagSeconds(syncTS types.Timestamp, ts types.Timestamp) int64 {
+	if syncTS.Ts == 0 {
+		return -1
+	}
+	if ts.Ts < syncTS.Ts {
+		return 0
+	}
+	return int64(ts.Ts - syncTS.Ts)
+}
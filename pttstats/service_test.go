@@ -0,0 +1,164 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package pttstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		name     string
+		secret   string
+		endpoint string
+		err      error
+	}{
+		{"node1:secret@localhost:3000", "node1", "secret", "http://localhost:3000" + ReportPath, nil},
+		{"node1:secret@https://stats.ptt.ai/", "node1", "secret", "https://stats.ptt.ai" + ReportPath, nil},
+		{"node1@localhost:3000", "", "", "", ErrInvalidURL},
+		{"localhost:3000", "", "", "", ErrInvalidURL},
+	}
+
+	for _, tt := range tests {
+		name, secret, endpoint, err := parseURL(tt.url)
+		if err != tt.err || name != tt.name || secret != tt.secret || endpoint != tt.endpoint {
+			t.Errorf("parseURL(%v) = (%v, %v, %v, %v), want (%v, %v, %v, %v)", tt.url, name, secret, endpoint, err, tt.name, tt.secret, tt.endpoint, tt.err)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	setupTest()
+	defer teardownTest()
+
+	backoffs := []time.Duration{10, 20, 40, 40}
+	backoff := time.Duration(0)
+	for _, want := range backoffs {
+		backoff = nextBackoff(backoff)
+		if backoff != want*time.Millisecond {
+			t.Errorf("nextBackoff = %v, want %v", backoff, want*time.Millisecond)
+		}
+	}
+}
+
+func TestService_Report(t *testing.T) {
+	setupTest()
+	defer teardownTest()
+
+	collector := NewCollector(map[string]string{"node1": "secret"})
+
+	// the collector is unavailable for the first 2 reports.
+	nFailed := int32(0)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&nFailed, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		collector.ServeHTTP(w, r)
+	}))
+	defer hs.Close()
+
+	s, err := NewService(&Config{URL: "node1:secret@" + hs.URL}, &testBackend{})
+	if err != nil {
+		t.Fatalf("unable to new service: e: %v", err)
+	}
+	s.Start(nil)
+	defer s.Stop()
+
+	var reports []*Report
+	for len(reports) < 2 {
+		select {
+		case report := <-collector.ReportChan():
+			reports = append(reports, report)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no report received")
+		}
+	}
+
+	report := reports[0]
+	if report.Name != "node1" || *report.NodeID != tDefaultNodeID || report.Version != "0.1.0-test" || report.GitCommit != "abcdef" {
+		t.Errorf("report: name: %v nodeID: %v version: %v gitCommit: %v", report.Name, report.NodeID, report.Version, report.GitCommit)
+	}
+	if report.Peers.ImportantPeers != 2 || report.Peers.RandomPeers != 4 {
+		t.Errorf("report: peers: %v", report.Peers)
+	}
+	if report.Entities[types.StatusAlive] != 2 || report.Entities[types.StatusFailed] != 1 {
+		t.Errorf("report: entities: %v", report.Entities)
+	}
+	if len(report.EntityStats) != 3 || report.EntityStats[0].LagSeconds <= 0 || report.EntityStats[1].LagSeconds != -1 {
+		t.Errorf("report: entityStats: %v", report.EntityStats)
+	}
+	if !reports[0].TS.IsLess(reports[1].TS) {
+		t.Errorf("report: ts not increasing: %v %v", reports[0].TS, reports[1].TS)
+	}
+}
+
+func TestCollector_ServeHTTP(t *testing.T) {
+	collector := NewCollector(map[string]string{"node1": "secret"})
+
+	s, _ := NewService(&Config{URL: "node1:secret@localhost"}, &testBackend{})
+	report, _ := s.assembleReport()
+	marshaled, _ := json.Marshal(report)
+
+	post := func(body []byte, sig string) int {
+		r := httptest.NewRequest(http.MethodPost, ReportPath, strings.NewReader(string(body)))
+		r.Header.Set(SignatureHeader, sig)
+		w := httptest.NewRecorder()
+		collector.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := post(marshaled, SignReport(marshaled, "wrong")); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: code: %v", code)
+	}
+	if code := post(marshaled, "zz"); code != http.StatusUnauthorized {
+		t.Errorf("invalid sig: code: %v", code)
+	}
+	if code := post(marshaled, SignReport(marshaled, "secret")); code != http.StatusNoContent {
+		t.Errorf("valid: code: %v", code)
+	}
+	if code := post(marshaled, SignReport(marshaled, "secret")); code != http.StatusBadRequest {
+		t.Errorf("replay: code: %v", code)
+	}
+
+	// out-dated
+	report.TS.Ts -= MaxClockSkewSeconds + 10
+	outdated, _ := json.Marshal(report)
+	if code := post(outdated, SignReport(outdated, "secret")); code != http.StatusBadRequest {
+		t.Errorf("out-dated: code: %v", code)
+	}
+
+	// unknown node
+	report.Name = "node2"
+	unknown, _ := json.Marshal(report)
+	if code := post(unknown, SignReport(unknown, "secret")); code != http.StatusUnauthorized {
+		t.Errorf("unknown: code: %v", code)
+	}
+
+	if reports := collector.Reports(); len(reports) != 1 || reports["node1"] == nil {
+		t.Errorf("Reports: %v", reports)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	authTokenLength = 32
+
+	authHeaderPrefix = "Bearer "
+	authQueryKey     = "token"
+
+	// AuthScopeAll is the scope of the admin-token.
+	AuthScopeAll = "*"
+)
+
+var (
+	ErrInvalidAuthToken = errors.New("invalid auth token")
+	ErrInvalidAuthScope = errors.New("invalid auth scope")
+)
+
+// DefaultReadScopes are the scopes of the read-only token generated along with the admin-token.
+//
+// The getters are listed explicitly because some of them return the keys
+// or the join-materials (ptt_getKeys, ptt_getConfirmJoins, account_getRaw*),
+// and the searches dial the peers.
+var DefaultReadScopes = []string{
+	"ptt_getVersion",
+	"ptt_getGitCommit",
+	"ptt_getPeers",
+	"ptt_getEntities",
+	"ptt_getOplogAudit",
+	"ptt_getFailedOplogs",
+	"ptt_getOplogSnapshot",
+	"ptt_getMailboxStats",
+	"account_getUserName*",
+	"account_getUserImg*",
+	"*_count*",
+	"*_health",
+	"net_*",
+	"rpc_modules",
+}
+
+type authTokenKey struct{}
+
+// AuthToken is a bearer-token accepted by the HTTP and WS endpoints.
+//
+// Scopes are glob patterns (path.Match) against the full method name,
+// for example "ptt_*" or "*_get*". Subscriptions are checked as "<namespace>_subscribe".
+type AuthToken struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
+// NewAuthToken generates a random token with the given scopes.
+func NewAuthToken(name string, scopes []string) (*AuthToken, error) {
+	b := make([]byte, authTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &AuthToken{
+		Name:   name,
+		Token:  hex.EncodeToString(b),
+		Scopes: scopes,
+	}, nil
+}
+
+// Allow returns true if the method is covered by one of the scopes of the token.
+func (t *AuthToken) Allow(method string) bool {
+	for _, scope := range t.Scopes {
+		if ok, _ := path.Match(scope, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin returns true if the token has the scope of the admin-token.
+func (t *AuthToken) IsAdmin() bool {
+	for _, scope := range t.Scopes {
+		if scope == AuthScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator validates the bearer-tokens of the incoming http requests.
+type Authenticator struct {
+	tokens []*AuthToken
+}
+
+// NewAuthenticator creates an authenticator accepting the given tokens.
+func NewAuthenticator(tokens []*AuthToken) (*Authenticator, error) {
+	for _, token := range tokens {
+		if len(token.Token) == 0 {
+			return nil, ErrInvalidAuthToken
+		}
+		for _, scope := range token.Scopes {
+			if _, err := path.Match(scope, ""); err != nil {
+				return nil, ErrInvalidAuthScope
+			}
+		}
+	}
+
+	return &Authenticator{tokens: tokens}, nil
+}
+
+// Authenticate returns the token of the request, from the Authorization header,
+// or from the token query for the browsers and the websocket clients.
+func (a *Authenticator) Authenticate(r *http.Request) (*AuthToken, bool) {
+	given := r.URL.Query().Get(authQueryKey)
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, authHeaderPrefix) {
+		given = strings.TrimSpace(header[len(authHeaderPrefix):])
+	}
+	if given == "" {
+		return nil, false
+	}
+
+	for _, token := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token.Token)) == 1 {
+			return token, true
+		}
+	}
+	return nil, false
+}
+
+// authHandler rejects the requests without a valid token,
+// and passes the token to the rpc-server through the request context.
+type authHandler struct {
+	auth *Authenticator
+	next http.Handler
+}
+
+// NewAuthHandler wraps next with the token authentication. No authentication if auth is nil.
+func NewAuthHandler(auth *Authenticator, next http.Handler) http.Handler {
+	if auth == nil {
+		return next
+	}
+	return &authHandler{auth: auth, next: next}
+}
+
+// ServeHTTP serves the requests with valid tokens, implements http.Handler
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// cors preflight does not carry the credentials.
+	if r.Method == http.MethodOptions {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	token, ok := h.auth.Authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gptt"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.next.ServeHTTP(w, r.WithContext(ContextWithAuthToken(r.Context(), token)))
+}
+
+// ContextWithAuthToken returns the context carrying the authenticated token.
+func ContextWithAuthToken(ctx context.Context, token *AuthToken) context.Context {
+	return context.WithValue(ctx, authTokenKey{}, token)
+}
+
+// AuthTokenFromContext returns the authenticated token of the request.
+// The requests from the trusted transports (IPC, in-proc) do not carry tokens.
+func AuthTokenFromContext(ctx context.Context) (*AuthToken, bool) {
+	token, ok := ctx.Value(authTokenKey{}).(*AuthToken)
+	return token, ok
+}
+
+// isAuthorized checks whether the method is allowed by the token of the request.
+func isAuthorized(ctx context.Context, method string) bool {
+	token, ok := AuthTokenFromContext(ctx)
+	if !ok {
+		return true
+	}
+	return token.Allow(method)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, *AuthToken, *AuthToken) {
+	adminToken, err := NewAuthToken("admin", []string{AuthScopeAll})
+	if err != nil {
+		t.Fatalf("unable to new token: e: %v", err)
+	}
+	readToken, err := NewAuthToken("read", []string{"test_echo*"})
+	if err != nil {
+		t.Fatalf("unable to new token: e: %v", err)
+	}
+
+	auth, err := NewAuthenticator([]*AuthToken{adminToken, readToken})
+	if err != nil {
+		t.Fatalf("unable to new authenticator: e: %v", err)
+	}
+
+	return auth, adminToken, readToken
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	if _, err := NewAuthenticator([]*AuthToken{{Name: "empty"}}); err != ErrInvalidAuthToken {
+		t.Errorf("NewAuthenticator: empty token: e: %v", err)
+	}
+	if _, err := NewAuthenticator([]*AuthToken{{Name: "bad", Token: "abc", Scopes: []string{"ptt_["}}}); err != ErrInvalidAuthScope {
+		t.Errorf("NewAuthenticator: bad scope: e: %v", err)
+	}
+}
+
+func TestAuthToken_Allow(t *testing.T) {
+	token := &AuthToken{Scopes: DefaultReadScopes}
+
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{"ptt_getPeers", true},
+		{"me_countMyNodes", true},
+		{"net_version", true},
+		{"rpc_modules", true},
+		{"ptt_health", true},
+		{"account_getUserNameByIDs", true},
+		{"ptt_getKeys", false},
+		{"ptt_getConfirmJoins", false},
+		{"account_getRawUserName", false},
+		{"me_getMyKey", false},
+		{"ptt_searchTopicPeers", false},
+		{"ptt_shutdown", false},
+		{"admin_addPeer", false},
+		{"debug_setHead", false},
+	}
+	for _, tt := range tests {
+		if got := token.Allow(tt.method); got != tt.want {
+			t.Errorf("Allow(%v) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestAuthToken_IsAdmin(t *testing.T) {
+	_, adminToken, readToken := newTestAuthenticator(t)
+
+	if !adminToken.IsAdmin() {
+		t.Errorf("IsAdmin: admin token: %v", adminToken.Scopes)
+	}
+	if readToken.IsAdmin() {
+		t.Errorf("IsAdmin: read token: %v", readToken.Scopes)
+	}
+}
+
+func TestAuthHandler_HTTP(t *testing.T) {
+	auth, adminToken, readToken := newTestAuthenticator(t)
+
+	server := newTestServer("test", new(Service))
+	defer server.Stop()
+
+	hs := httptest.NewServer(NewAuthHandler(auth, server))
+	defer hs.Close()
+
+	var result Result
+
+	// no token
+	client, _ := DialHTTP(hs.URL)
+	err := client.Call(&result, "test_echo", "hello", 10, &Args{"world"})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("no token: expected 401: e: %v", err)
+	}
+
+	// invalid token
+	client, _ = DialHTTP(hs.URL + "/?token=invalid")
+	err = client.Call(&result, "test_echo", "hello", 10, &Args{"world"})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("invalid token: expected 401: e: %v", err)
+	}
+
+	// read token
+	client, _ = DialHTTP(hs.URL + "/?token=" + readToken.Token)
+	if err := client.Call(&result, "test_echo", "hello", 10, &Args{"world"}); err != nil {
+		t.Errorf("read token: unable to echo: e: %v", err)
+	}
+	err = client.Call(nil, "test_noArgsRets")
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("read token: expected forbidden: e: %v", err)
+	}
+
+	// admin token
+	client, _ = DialHTTP(hs.URL + "/?token=" + adminToken.Token)
+	if err := client.Call(nil, "test_noArgsRets"); err != nil {
+		t.Errorf("admin token: unable to call: e: %v", err)
+	}
+}
+
+func TestAuthHandler_WS(t *testing.T) {
+	auth, _, readToken := newTestAuthenticator(t)
+
+	server := newTestServer("test", new(Service))
+	defer server.Stop()
+
+	hs := httptest.NewServer(NewAuthHandler(auth, server.WebsocketHandler([]string{"*"})))
+	defer hs.Close()
+
+	wsURL := "ws:" + strings.TrimPrefix(hs.URL, "http:")
+
+	if _, err := DialWebsocket(context.Background(), wsURL, ""); err == nil {
+		t.Errorf("no token: expected handshake error")
+	}
+
+	client, err := DialWebsocket(context.Background(), wsURL+"/?token="+readToken.Token, "")
+	if err != nil {
+		t.Fatalf("unable to dial: e: %v", err)
+	}
+	defer client.Close()
+
+	var result Result
+	if err := client.Call(&result, "test_echo", "hello", 10, &Args{"world"}); err != nil {
+		t.Errorf("read token: unable to echo: e: %v", err)
+	}
+	err = client.Call(nil, "test_noArgsRets")
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("read token: expected forbidden: e: %v", err)
+	}
+}
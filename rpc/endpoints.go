@@ -23,8 +23,8 @@ import (
 	"github.com/ailabstw/go-pttai/log"
 )
 
-// StartHTTPEndpoint starts the HTTP RPC endpoint, configured with cors/vhosts/modules
-func StartHTTPEndpoint(endpoint string, apis []API, modules []string, cors []string, vhosts []string) (net.Listener, *Server, *http.Server, error) {
+// StartHTTPEndpoint starts the HTTP RPC endpoint, configured with cors/vhosts/modules/auth (nil: no auth)
+func StartHTTPEndpoint(endpoint string, apis []API, modules []string, cors []string, vhosts []string, auth *Authenticator) (net.Listener, *Server, *http.Server, error) {
 	// Generate the whitelist based on the allowed modules
 	whitelist := make(map[string]bool)
 	for _, module := range modules {
@@ -49,13 +49,13 @@ func StartHTTPEndpoint(endpoint string, apis []API, modules []string, cors []str
 		return nil, nil, nil, err
 	}
 	log.Debug("StartHTTPEndpoint: to NewHTTPServer", "endpoint", endpoint, "handler.run", handler.run, "handler", handler)
-	httpServer := NewHTTPServer(cors, vhosts, handler)
+	httpServer := newHTTPServer(cors, vhosts, NewAuthHandler(auth, handler))
 	go httpServer.Serve(listener)
 	return listener, handler, httpServer, err
 }
 
-// StartWSEndpoint starts a websocket endpoint
-func StartWSEndpoint(endpoint string, apis []API, modules []string, wsOrigins []string, exposeAll bool) (net.Listener, *Server, error) {
+// StartWSEndpoint starts a websocket endpoint, auth is checked in the handshake (nil: no auth)
+func StartWSEndpoint(endpoint string, apis []API, modules []string, wsOrigins []string, exposeAll bool, auth *Authenticator) (net.Listener, *Server, error) {
 
 	// Generate the whitelist based on the allowed modules
 	whitelist := make(map[string]bool)
@@ -80,7 +80,8 @@ func StartWSEndpoint(endpoint string, apis []API, modules []string, wsOrigins []
 	if listener, err = net.Listen("tcp", endpoint); err != nil {
 		return nil, nil, err
 	}
-	go NewWSServer(wsOrigins, handler).Serve(listener)
+	wsServer := &http.Server{Handler: NewAuthHandler(auth, handler.WebsocketHandler(wsOrigins))}
+	go wsServer.Serve(listener)
 	return listener, handler, err
 
 }
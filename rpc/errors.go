@@ -64,3 +64,12 @@ type shutdownError struct{}
 func (e *shutdownError) ErrorCode() int { return -32000 }
 
 func (e *shutdownError) Error() string { return "server is shutting down" }
+
+// method is not allowed by the auth token of the request
+type forbiddenError struct{ method string }
+
+func (e *forbiddenError) ErrorCode() int { return -32003 }
+
+func (e *forbiddenError) Error() string {
+	return fmt.Sprintf("The method %s is not allowed by the auth token", e.method)
+}
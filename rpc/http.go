@@ -162,6 +162,11 @@ func (t *httpReadWriteNopCloser) Close() error {
 //
 // Deprecated: Server implements http.Handler
 func NewHTTPServer(cors []string, vhosts []string, srv *Server) *http.Server {
+	return newHTTPServer(cors, vhosts, srv)
+}
+
+// newHTTPServer creates a new HTTP RPC server around the handler (ex: the auth-handler of the server).
+func newHTTPServer(cors []string, vhosts []string, srv http.Handler) *http.Server {
 	// Wrap the CORS-handler within a host-handler
 	//log.Debug("NewHTTPServer", "cors", cors)
 	handler := newCorsHandler(srv, cors)
@@ -221,7 +226,7 @@ func validateRequest(r *http.Request) (int, error) {
 	return 0, nil
 }
 
-func newCorsHandler(srv *Server, allowedOrigins []string) http.Handler {
+func newCorsHandler(srv http.Handler, allowedOrigins []string) http.Handler {
 	// disable CORS support if user has not specified a custom CORS configuration
 	if len(allowedOrigins) == 0 {
 		return srv
@@ -240,7 +245,7 @@ func newCorsHandler(srv *Server, allowedOrigins []string) http.Handler {
 // since they do in-domain requests against the RPC api. Instead, we can see on the Host-header
 // which domain was used, and validate that against a whitelist.
 type virtualHostHandler struct {
-	vhosts map[string]struct{}
+	vhosts VirtualHosts
 	next   http.Handler
 }
 
@@ -253,12 +258,6 @@ func (h *virtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.next.ServeHTTP(w, r)
 		return
 	}
-	host, _, err := net.SplitHostPort(r.Host)
-	if err != nil {
-		// Either invalid (too many colons) or no port specified
-		host = r.Host
-	}
-	//log.Debug("virtualHostHandler.ServerHTTP", "host", host)
 
 	// XXX hack for with-credentials
 	//csrftoken, _ := uuid.NewUUID().MarshalText()
@@ -269,20 +268,9 @@ func (h *virtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Access-Control-Allow-Method", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "X-CSRFToken, Content-Type")
-
-	if ipAddr := net.ParseIP(host); ipAddr != nil {
-		// It's an IP address, we can serve that
-		h.next.ServeHTTP(w, r)
-		return
+	w.Header().Set("Access-Control-Allow-Headers", "X-CSRFToken, Content-Type, Authorization")
 
-	}
-	// Not an ip address, but a hostname. Need to validate
-	if _, exist := h.vhosts["*"]; exist {
-		h.next.ServeHTTP(w, r)
-		return
-	}
-	if _, exist := h.vhosts[host]; exist {
+	if h.vhosts.IsValid(r) {
 		//log.Debug("virtualHostHandler.ServerHTTP: to ServeHTTP", "next", h.next)
 		h.next.ServeHTTP(w, r)
 		return
@@ -291,9 +279,42 @@ func (h *virtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func newVHostHandler(vhosts []string, next http.Handler) http.Handler {
-	vhostMap := make(map[string]struct{})
+	return &virtualHostHandler{NewVirtualHosts(vhosts), next}
+}
+
+// VirtualHosts is the whitelist of the Host-header, shared by the HTTP-RPC and the http-servers proxying the rpc.
+type VirtualHosts map[string]struct{}
+
+func NewVirtualHosts(vhosts []string) VirtualHosts {
+	vhostMap := make(VirtualHosts)
 	for _, allowedHost := range vhosts {
 		vhostMap[strings.ToLower(allowedHost)] = struct{}{}
 	}
-	return &virtualHostHandler{vhostMap, next}
+	return vhostMap
+}
+
+// IsValid checks the Host-header of the request.
+// The empty Host and the ip addresses are always valid, the hostnames need to be in the whitelist (or "*").
+func (v VirtualHosts) IsValid(r *http.Request) bool {
+	// if r.Host is not set, we can continue serving since a browser would set the Host header
+	if r.Host == "" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// Either invalid (too many colons) or no port specified
+		host = r.Host
+	}
+	//log.Debug("VirtualHosts.IsValid", "host", host)
+
+	if ipAddr := net.ParseIP(host); ipAddr != nil {
+		// It's an IP address, we can serve that
+		return true
+	}
+	// Not an ip address, but a hostname. Need to validate
+	if _, exist := v["*"]; exist {
+		return true
+	}
+	_, exist := v[strings.ToLower(host)]
+	return exist
 }
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"net"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 )
@@ -101,6 +102,19 @@ func TestServerRegisterName(t *testing.T) {
 	}
 }
 
+func TestRPCServiceMethods(t *testing.T) {
+	server := NewServer()
+	server.RegisterName("calc", new(Service))
+
+	methods := (&RPCService{server}).Methods()
+	if len(methods["calc"]) != 5 || !sort.StringsAreSorted(methods["calc"]) {
+		t.Errorf("Expected 5 sorted methods for service 'calc', got %v", methods["calc"])
+	}
+	if len(methods[MetadataApi]) != 2 {
+		t.Errorf("Expected modules and methods for service 'rpc', got %v", methods[MetadataApi])
+	}
+}
+
 func testServerMethodExecution(t *testing.T, method string) {
 	server := NewServer()
 	service := new(Service)
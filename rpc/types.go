@@ -57,6 +57,7 @@ type service struct {
 type serverRequest struct {
 	id            interface{}
 	svcname       string
+	method        string // full method name for the auth scopes
 	callb         *callback
 	args          []reflect.Value
 	isUnsubscribe bool
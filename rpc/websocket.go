@@ -68,7 +68,14 @@ func (srv *Server) WebsocketHandler(allowedOrigins []string) http.Handler {
 			decoder := func(v interface{}) error {
 				return websocketJSONCodec.Receive(conn, v)
 			}
-			srv.ServeCodec(NewCodec(conn, encoder, decoder), OptionMethodInvocation|OptionSubscriptions)
+
+			// the request context ends with the handshake, keeps only the auth token.
+			ctx := context.Background()
+			if token, ok := AuthTokenFromContext(conn.Request().Context()); ok {
+				ctx = ContextWithAuthToken(ctx, token)
+			}
+
+			srv.serveCodec(ctx, NewCodec(conn, encoder, decoder), OptionMethodInvocation|OptionSubscriptions)
 		},
 	}
 }
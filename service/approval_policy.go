@@ -0,0 +1,193 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+
+	"github.com/ailabstw/go-pttai/common/types"
+)
+
+/*
+ApprovalPolicyType is the rule of the master-signs required for an oplog to be valid.
+*/
+type ApprovalPolicyType uint8
+
+const (
+	_ ApprovalPolicyType = iota
+
+	// any one master.
+	ApprovalPolicyAnyMaster
+
+	// N of the masters.
+	ApprovalPolicyNOfMasters
+
+	// the doer (the author) plus N masters other than the doer.
+	ApprovalPolicyDoerAndMasters
+)
+
+/*
+ApprovalPolicy is the declarative rule of the master-signs of the oplogs of the entity.
+
+Ops are the op-types of the oplogs of the entity that the policy applies to.
+The policy with empty Ops is the default policy of the entity.
+*/
+type ApprovalPolicy struct {
+	Ops  []OpType           `json:"O,omitempty"`
+	Type ApprovalPolicyType `json:"T"`
+	N    uint32             `json:"N,omitempty"`
+}
+
+func (p *ApprovalPolicy) Validate() error {
+	switch p.Type {
+	case ApprovalPolicyAnyMaster:
+		if p.N != 0 {
+			return ErrInvalidApprovalPolicy
+		}
+	case ApprovalPolicyNOfMasters, ApprovalPolicyDoerAndMasters:
+		if p.N == 0 {
+			return ErrInvalidApprovalPolicy
+		}
+	default:
+		return ErrInvalidApprovalPolicy
+	}
+
+	return nil
+}
+
+func (p *ApprovalPolicy) isForOp(op OpType) bool {
+	for _, eachOp := range p.Ops {
+		if eachOp == op {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+IsSatisfied evaluates the master-signs of the oplog with the policy.
+Each distinct master counts as weight 1, no matter the order of the master-signs
+(the sign order of the json-signed oplogs is not checked in Verify).
+
+Return: weight, is-satisfied
+*/
+func (p *ApprovalPolicy) IsSatisfied(log *Oplog, isMaster func(id *types.PttID) bool) (uint32, bool) {
+	weight := uint32(0)
+	signedIDs := make(map[types.PttID]bool)
+	for _, sign := range log.MasterSigns {
+		if sign.ID == nil || signedIDs[*sign.ID] {
+			continue
+		}
+		signedIDs[*sign.ID] = true
+
+		if p.Type == ApprovalPolicyDoerAndMasters && log.DoerID != nil && *sign.ID == *log.DoerID {
+			continue
+		}
+
+		if !isMaster(sign.ID) {
+			continue
+		}
+		weight++
+	}
+
+	switch p.Type {
+	case ApprovalPolicyAnyMaster:
+		return weight, weight >= 1
+	case ApprovalPolicyNOfMasters, ApprovalPolicyDoerAndMasters:
+		return weight, weight >= p.N
+	}
+
+	return weight, false
+}
+
+/*
+ApprovalPolicies are the approval policies of the entity, set by the valid master-oplog of LogID.
+*/
+type ApprovalPolicies struct {
+	Policies []*ApprovalPolicy `json:"P"`
+
+	LogID    *types.PttID    `json:"l"`
+	UpdateTS types.Timestamp `json:"UT"`
+}
+
+func (ps *ApprovalPolicies) Validate() error {
+	isDefault := false
+	ops := make(map[OpType]bool)
+	for _, p := range ps.Policies {
+		err := p.Validate()
+		if err != nil {
+			return err
+		}
+
+		if len(p.Ops) == 0 {
+			if isDefault {
+				return ErrInvalidApprovalPolicy
+			}
+			isDefault = true
+		}
+
+		for _, op := range p.Ops {
+			if ops[op] {
+				return ErrInvalidApprovalPolicy
+			}
+			ops[op] = true
+		}
+	}
+
+	return nil
+}
+
+/*
+Policy returns the policy of op, the default policy if no policy is for op,
+and nil if the entity does not have any policy.
+*/
+func (ps *ApprovalPolicies) Policy(op OpType) *ApprovalPolicy {
+	if ps == nil {
+		return nil
+	}
+
+	var defaultPolicy *ApprovalPolicy
+	for _, p := range ps.Policies {
+		if len(p.Ops) == 0 {
+			defaultPolicy = p
+			continue
+		}
+		if p.isForOp(op) {
+			return p
+		}
+	}
+
+	return defaultPolicy
+}
+
+/*
+IsNewer checks whether the master-oplog of (ts, logID) is newer than the oplog setting ps.
+*/
+func (ps *ApprovalPolicies) IsNewer(ts types.Timestamp, logID *types.PttID) bool {
+	if ps == nil || ps.LogID == nil {
+		return true
+	}
+
+	if ps.UpdateTS.IsLess(ts) {
+		return true
+	}
+	if ts.IsLess(ps.UpdateTS) {
+		return false
+	}
+
+	return logID != nil && bytes.Compare(ps.LogID[:], logID[:]) < 0
+}
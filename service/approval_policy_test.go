@@ -0,0 +1,163 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+)
+
+func TestApprovalPolicy_IsSatisfied(t *testing.T) {
+	// setup test
+	ids := make([]*types.PttID, 4)
+	for i := range ids {
+		ids[i] = &types.PttID{}
+		ids[i][0] = byte(i + 1)
+	}
+	doerID, masterA, masterB, nonMaster := ids[0], ids[1], ids[2], ids[3]
+
+	isMaster := func(id *types.PttID) bool {
+		return *id != *nonMaster
+	}
+
+	newLog := func(signIDs ...*types.PttID) *Oplog {
+		o := &Oplog{DoerID: doerID}
+		for _, id := range signIDs {
+			o.MasterSigns = append(o.MasterSigns, &SignInfo{ID: id})
+		}
+		return o
+	}
+
+	// define test-structure
+	tests := []struct {
+		name       string
+		policy     *ApprovalPolicy
+		log        *Oplog
+		wantWeight uint32
+		want       bool
+	}{
+		{
+			name:   "any-master: none",
+			policy: &ApprovalPolicy{Type: ApprovalPolicyAnyMaster},
+			log:    newLog(nonMaster),
+			want:   false,
+		},
+		{
+			name:       "any-master: one",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyAnyMaster},
+			log:        newLog(masterA),
+			wantWeight: 1,
+			want:       true,
+		},
+		{
+			name:       "2-of-n: one",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyNOfMasters, N: 2},
+			log:        newLog(masterA, nonMaster),
+			wantWeight: 1,
+			want:       false,
+		},
+		{
+			name:       "2-of-n: duplicated",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyNOfMasters, N: 2},
+			log:        newLog(masterA, masterA),
+			wantWeight: 1,
+			want:       false,
+		},
+		{
+			name:       "2-of-n: non-adjacent duplicated",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyNOfMasters, N: 2},
+			log:        newLog(masterA, nonMaster, masterA),
+			wantWeight: 1,
+			want:       false,
+		},
+		{
+			name:       "2-of-n: two",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyNOfMasters, N: 2},
+			log:        newLog(masterA, masterB),
+			wantWeight: 2,
+			want:       true,
+		},
+		{
+			name:       "doer-and-master: doer only",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyDoerAndMasters, N: 1},
+			log:        newLog(doerID),
+			wantWeight: 0,
+			want:       false,
+		},
+		{
+			name:       "doer-and-master: duplicated around the doer",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyDoerAndMasters, N: 2},
+			log:        newLog(masterA, doerID, masterA),
+			wantWeight: 1,
+			want:       false,
+		},
+		{
+			name:       "doer-and-master: doer and master",
+			policy:     &ApprovalPolicy{Type: ApprovalPolicyDoerAndMasters, N: 1},
+			log:        newLog(doerID, masterB),
+			wantWeight: 1,
+			want:       true,
+		},
+	}
+
+	// run test
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotWeight, got := tt.policy.IsSatisfied(tt.log, isMaster)
+			if gotWeight != tt.wantWeight || got != tt.want {
+				t.Errorf("ApprovalPolicy.IsSatisfied() = (%v, %v), want (%v, %v)", gotWeight, got, tt.wantWeight, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalPolicies_Policy(t *testing.T) {
+	// setup test
+	defaultPolicy := &ApprovalPolicy{Type: ApprovalPolicyAnyMaster}
+	deletePolicy := &ApprovalPolicy{Ops: []OpType{3, 4}, Type: ApprovalPolicyDoerAndMasters, N: 1}
+
+	ps := &ApprovalPolicies{Policies: []*ApprovalPolicy{defaultPolicy, deletePolicy}}
+	err := ps.Validate()
+	if err != nil {
+		t.Errorf("ApprovalPolicies.Validate: e: %v", err)
+	}
+
+	if got := ps.Policy(4); got != deletePolicy {
+		t.Errorf("ApprovalPolicies.Policy(4) = %v, want %v", got, deletePolicy)
+	}
+	if got := ps.Policy(1); got != defaultPolicy {
+		t.Errorf("ApprovalPolicies.Policy(1) = %v, want %v", got, defaultPolicy)
+	}
+
+	var nilPolicies *ApprovalPolicies
+	if got := nilPolicies.Policy(1); got != nil {
+		t.Errorf("nil ApprovalPolicies.Policy(1) = %v, want nil", got)
+	}
+
+	// invalid
+	invalids := []*ApprovalPolicies{
+		{Policies: []*ApprovalPolicy{{Type: ApprovalPolicyNOfMasters}}},
+		{Policies: []*ApprovalPolicy{defaultPolicy, {Type: ApprovalPolicyAnyMaster}}},
+		{Policies: []*ApprovalPolicy{deletePolicy, {Ops: []OpType{4}, Type: ApprovalPolicyAnyMaster}}},
+	}
+	for i, each := range invalids {
+		if err := each.Validate(); err != ErrInvalidApprovalPolicy {
+			t.Errorf("ApprovalPolicies.Validate (%v): e = %v, want %v", i, err, ErrInvalidApprovalPolicy)
+		}
+	}
+}
@@ -17,8 +17,11 @@
 package service
 
 import (
+	"bytes"
 	"net"
+	"sort"
 
+	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/p2p/discover"
 )
@@ -45,3 +48,122 @@ func PeerToBackendPeer(peer *PttPeer) *BackendPeer {
 		Addr:     peer.RemoteAddr(),
 	}
 }
+
+type BackendOplogSnapshot struct {
+	PrefixID  *types.PttID    `json:"pID"`
+	CreateTS  types.Timestamp `json:"CT"`
+	CutoffTS  types.Timestamp `json:"cT"`
+	NOplogs   int             `json:"N"`
+	NPruned   uint64          `json:"n"`
+	AuditHash []byte          `json:"A"`
+	SignerID  *types.PttID    `json:"SID"`
+}
+
+func OplogSnapshotToBackendOplogSnapshot(s *OplogSnapshot) *BackendOplogSnapshot {
+	var signerID *types.PttID
+	if s.Sign != nil {
+		signerID = s.Sign.ID
+	}
+
+	return &BackendOplogSnapshot{
+		PrefixID:  s.PrefixID,
+		CreateTS:  s.CreateTS,
+		CutoffTS:  s.CutoffTS,
+		NOplogs:   len(s.Oplogs),
+		NPruned:   s.NPruned,
+		AuditHash: s.AuditHash,
+		SignerID:  signerID,
+	}
+}
+
+type BackendEntity struct {
+	ID          *types.PttID      `json:"ID"`
+	Name        string            `json:"N"`
+	Status      types.Status      `json:"S"`
+	CreateTS    types.Timestamp   `json:"CT"`
+	OwnerID     *types.PttID      `json:"O"`
+	NPeers      int               `json:"NP"`
+	OpKeyHashes []*common.Address `json:"OK"`
+	SyncTS      types.Timestamp   `json:"ST"`
+}
+
+func EntityToBackendEntity(entity Entity) *BackendEntity {
+	pm := entity.PM()
+
+	opKeyInfos := pm.OpKeyInfos()
+	opKeyHashes := make([]*common.Address, 0, len(opKeyInfos))
+	for _, keyInfo := range opKeyInfos {
+		opKeyHashes = append(opKeyHashes, keyInfo.Hash)
+	}
+	sort.Slice(opKeyHashes, func(i, j int) bool {
+		return bytes.Compare(opKeyHashes[i][:], opKeyHashes[j][:]) < 0
+	})
+
+	return &BackendEntity{
+		ID:          entity.GetID(),
+		Name:        entity.Name(),
+		Status:      entity.GetStatus(),
+		CreateTS:    entity.GetCreateTS(),
+		OwnerID:     entity.GetOwnerID(),
+		NPeers:      pm.Peers().Len(false),
+		OpKeyHashes: opKeyHashes,
+		SyncTS:      pm.SyncTS(),
+	}
+}
+
+type BackendKeyType string
+
+const (
+	BackendKeyTypeOp   BackendKeyType = "op"
+	BackendKeyTypeJoin BackendKeyType = "join"
+)
+
+type BackendKey struct {
+	EntityID *types.PttID    `json:"EID"`
+	Type     BackendKeyType  `json:"T"`
+	Hash     *common.Address `json:"H"`
+	UpdateTS types.Timestamp `json:"UT"`
+	ExpireTS types.Timestamp `json:"ET"`
+	Status   types.Status    `json:"S"`
+}
+
+func KeyInfoToBackendKey(entityID *types.PttID, keyType BackendKeyType, keyInfo *KeyInfo, expireSeconds uint64) *BackendKey {
+	expireTS := keyInfo.UpdateTS
+	expireTS.Ts += expireSeconds
+
+	return &BackendKey{
+		EntityID: entityID,
+		Type:     keyType,
+		Hash:     keyInfo.Hash,
+		UpdateTS: keyInfo.UpdateTS,
+		ExpireTS: expireTS,
+		Status:   keyInfo.Status,
+	}
+}
+
+type BackendConfirmJoin struct {
+	ConfirmKey []byte           `json:"CK"`
+	EntityID   *types.PttID     `json:"EID"`
+	ID         *types.PttID     `json:"ID"`
+	Name       []byte           `json:"N"`
+	NodeID     *discover.NodeID `json:"NID"`
+	JoinType   JoinType         `json:"T"`
+	UpdateTS   types.Timestamp  `json:"UT"`
+}
+
+func ConfirmJoinToBackendConfirmJoin(confirmKey []byte, confirmJoin *ConfirmJoin) *BackendConfirmJoin {
+	backendConfirmJoin := &BackendConfirmJoin{
+		ConfirmKey: confirmKey,
+		EntityID:   confirmJoin.EntityID,
+		NodeID:     confirmJoin.NodeID,
+		JoinType:   confirmJoin.JoinType,
+		UpdateTS:   confirmJoin.UpdateTS,
+	}
+
+	if confirmJoin.JoinEntity != nil {
+		backendConfirmJoin.ID = confirmJoin.JoinEntity.ID
+		backendConfirmJoin.Name = confirmJoin.JoinEntity.Name
+	}
+
+	return backendConfirmJoin
+}
@@ -24,4 +24,30 @@ type Config struct {
 	DataDir           string
 	Version           string
 	GitCommit         string
+
+	OplogRetentionSeconds uint64 // 0: no oplog-compaction
+
+	OplogSignRLP bool // sign the oplogs with OplogSignVersionRLP (not verifiable by the legacy nodes)
+
+	TopicDiscovery bool // register / search the topics of the public entities on discv5
+
+	// node-record
+	NodeType     NodeType
+	IsRelay      bool // willing to relay for the NATed nodes
+	IsHostBoards bool // willing to host the public boards
+
+	// relay, 0: no limit
+	RelayMaxBytesPerSecond     uint64
+	RelayMaxPeerBytesPerSecond uint64
+
+	// mailbox, 0: no limit
+	IsMailbox               bool // willing to store-and-forward for the offline users
+	MailboxQuotaBytes       uint64
+	MailboxUserQuotaBytes   uint64 // for each recipient
+	MailboxSenderQuotaBytes uint64 // for each sender
+	MailboxMaxExpireSeconds uint64
+
+	// health
+	HealthMinPeers          int    // not ready with fewer ptt-peers
+	HealthMaxSyncLagSeconds uint64 // not healthy if an entity is not synced for longer, 0: no check
 }
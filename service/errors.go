@@ -39,6 +39,8 @@ var (
 
 	ErrServiceUnknown = errors.New("service unknown")
 
+	ErrIncompatibleVersion = errors.New("incompatible version")
+
 	ErrInvalidMsgCode = errors.New("invalid msg code")
 
 	ErrNotSent = errors.New("not sent")
@@ -51,6 +53,17 @@ var (
 
 	ErrInvalidOp          = errors.New("invalid op")
 	ErrInvalidOplog       = errors.New("invalid oplog")
+	ErrInvalidSignVersion = errors.New("invalid sign version")
+	ErrInvalidSignOrder   = errors.New("invalid sign order")
+
+	ErrInvalidApprovalPolicy = errors.New("invalid approval policy")
+
+	ErrInvalidOplogAuditType = errors.New("invalid oplog audit type")
+
+	ErrOplogPruned           = errors.New("oplog is pruned")
+	ErrInvalidOplogSnapshot  = errors.New("invalid oplog snapshot")
+	ErrInvalidOplogRetention = errors.New("invalid oplog retention")
+
 	ErrOplogAlreadyExists = errors.New("oplog already exists")
 	ErrSkipOplog          = errors.New("skip oplog")
 	ErrNewerOplog         = errors.New("newer oplog")
@@ -68,6 +81,20 @@ var (
 	ErrPeerRecentAdded = errors.New("peer recent added")
 
 	ErrAlreadyMyNode = errors.New("already my node")
+
+	ErrNoTopicDiscovery = errors.New("topic discovery not enabled")
+
+	ErrInvalidNodeRecord = errors.New("invalid node record")
+
+	ErrNotRelay     = errors.New("not relay")
+	ErrNoRelay      = errors.New("no relay")
+	ErrInvalidRelay = errors.New("invalid relay")
+	ErrRelayClosed  = errors.New("relay closed")
+
+	ErrNotMailbox         = errors.New("not mailbox")
+	ErrNoMailbox          = errors.New("no mailbox")
+	ErrMailboxQuota       = errors.New("exceeding mailbox quota")
+	ErrInvalidMailboxItem = errors.New("invalid mailbox item")
 )
 
 func ErrResp(code error, format string, v ...interface{}) error {
@@ -21,6 +21,7 @@ import (
 
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/p2p/discv5"
 	"github.com/ailabstw/go-pttai/pttdb"
 )
 
@@ -31,6 +32,17 @@ var (
 		MaxImportantPeers: 100,
 		MaxMemberPeers:    200,
 		MaxRandomPeers:    50,
+
+		RelayMaxBytesPerSecond:     1024 * 1024,
+		RelayMaxPeerBytesPerSecond: 128 * 1024,
+
+		MailboxQuotaBytes:       256 * 1024 * 1024,
+		MailboxUserQuotaBytes:   16 * 1024 * 1024,
+		MailboxSenderQuotaBytes: 16 * 1024 * 1024,
+		MailboxMaxExpireSeconds: 7 * 86400,
+
+		HealthMinPeers:          1,
+		HealthMaxSyncLagSeconds: 3600,
 	}
 )
 
@@ -38,12 +50,26 @@ var (
 const (
 	_ uint = iota
 	Ptt1
+	Ptt2 // with the capability-negotiation in the status
 )
 
 var (
-	ProtocolVersions = [1]uint{Ptt1}
+	ProtocolVersions = [2]uint{Ptt2, Ptt1}
 	ProtocolName     = "ptt1"
-	ProtocolLengths  = [1]uint64{uint64(NCodeType)}
+	ProtocolLengths  = [2]uint64{uint64(NCodeType), uint64(NCodeType)}
+)
+
+// protocol-features
+const (
+	PttFeatureRelay uint64 = 1 << iota
+	PttFeatureMailbox
+)
+
+const (
+	PttFeatures = PttFeatureRelay | PttFeatureMailbox
+
+	// ptt1 does not negotiate the features, and the legacy ptt1-peers know neither the relay nor the mailbox.
+	Ptt1Features uint64 = 0
 )
 
 // ptt-layer
@@ -63,6 +89,16 @@ const (
 const (
 	IntRenewJoinKeySeconds = 86400 // 1 day for now
 	RenewJoinKeySeconds    = time.Duration(IntRenewJoinKeySeconds) * time.Second
+
+	NJoinKeys = 3 // the join-key is valid for NJoinKeys renewals.
+
+	// the pending confirm-join is kept across restarts as long as the join-key is valid.
+	ExpireConfirmJoinSeconds = NJoinKeys * IntRenewJoinKeySeconds
+)
+
+var (
+	DBJoinKeyPrefix     = []byte(".jkdb")
+	DBConfirmJoinPrefix = []byte(".cjdb")
 )
 
 // op
@@ -102,6 +138,16 @@ const (
 
 	BoardLastSeenMsg
 	ArticleLastSeenMsg
+
+	// master
+	AddMasterOplogMsg
+	AddMasterOplogsMsg
+
+	AddPendingMasterOplogMsg
+	AddPendingMasterOplogsMsg
+
+	// oplog-snapshot
+	OplogSnapshotMsg
 	NMsg
 )
 
@@ -137,11 +183,12 @@ const (
 
 var (
 	dbOplog     *pttdb.LDBBatch
-	dbOplogCore *pttdb.LDBDatabase
+	dbOplogCore pttdb.KVDatabase
 
-	dbMeta *pttdb.LDBDatabase
+	dbMeta pttdb.KVDatabase
 
 	DBNewestMasterLogIDPrefix = []byte(".nmld")
+	DBApprovalPolicyPrefix    = []byte(".appl")
 
 	DBMasterOplogPrefix       = []byte(".malg")
 	DBMasterIdxOplogPrefix    = []byte(".maig")
@@ -170,6 +217,117 @@ const (
 	OffsetMasterOplogRaftIdx = 12
 )
 
+// pending-oplog
+const (
+	RetryPendingOplogTickSeconds = 5 * time.Second
+
+	RetryPendingOplogSeconds    = 10
+	MaxRetryPendingOplogSeconds = 120
+)
+
+// oplog-compaction
+const (
+	MinOplogRetentionSeconds = 604800 // 7 days, older than the hr-level merkle-tree for sync.
+
+	CompactOplogsSeconds = 24 * time.Hour
+)
+
+var (
+	DBPrunedOplogPrefix   = []byte(".prlg")
+	DBOplogSnapshotPrefix = []byte(".olsn")
+)
+
+// node-record
+const (
+	NodeRecordFlagRelay uint = 1 << iota
+	NodeRecordFlagHostBoards
+	NodeRecordFlagMailbox
+)
+
+const (
+	MaxNodeTypes = 1000
+)
+
+// relay
+const (
+	RelaySessionQueueSize = 100
+
+	ExpireRelayFailSeconds = 300 * time.Second
+
+	RelayPeerName = "relay"
+
+	// the relay-paths of the 2 ends may be with different versions,
+	// and the node-records are only in the status since Ptt2.
+	RelayPeerVersion = Ptt2
+)
+
+var (
+	RelayTopic = discv5.Topic(ProtocolName + "/relay")
+)
+
+// mailbox
+const (
+	MailboxDeliverBatchSize = 50
+
+	ExpireMailboxLoopSeconds = 1 * time.Hour
+)
+
+var (
+	DBMailboxPrefix        = []byte(".mbox")
+	DBMailboxReceiptPrefix = []byte(".mbrc")
+)
+
+// topic-discovery
+const (
+	SizeEntityTopicHash = discv5.SizeEntityTopicHash
+
+	SearchEntityTopicPeriod  = 1 * time.Second
+	SearchEntityPeersSeconds = 30 * time.Second
+	MaxSearchEntityPeers     = 16
+)
+
+// restart
+const (
+	DrainHandlersTimeout = 10 * time.Second
+)
+
+var (
+	DBSyncTSPrefix = []byte(".stdb")
+)
+
+// health
+const (
+	HealthCheckP2P           = "p2p"
+	HealthCheckPeers         = "peers"
+	HealthCheckServicePrefix = "service/"
+	HealthCheckDB            = "db"
+	HealthCheckSync          = "sync"
+	HealthCheckSyncLag       = "sync-lag"
+)
+
+var (
+	DBHealthPrefix = []byte(".hlth")
+)
+
+// oplog-sign
+const (
+	// the json of the oplog, the legacy encoding before the canonical encoding.
+	OplogSignVersionJSON uint8 = 0
+	// the rlp of the signed part of the oplog, with the canonical json of Data.
+	OplogSignVersionRLP uint8 = 1
+)
+
+/*
+CurrentOplogSignVersion is the sign-version of the oplogs signed by this node.
+
+The nodes before OplogSignVersionRLP drop the unknown SignV and verify the oplogs as OplogSignVersionJSON,
+so OplogSignVersionRLP is used only with Config.OplogSignRLP, after all the nodes are upgraded.
+The oplogs are verified with their own SignV regardless of CurrentOplogSignVersion.
+*/
+var (
+	CurrentOplogSignVersion = OplogSignVersionJSON
+)
+
 // oplog-merkle-tree
 var (
 	SizeMerkleTreeLevel     = 1 // uint8
@@ -193,18 +351,38 @@ var (
 	DialHistoryLoopInterval  = 30 * time.Second
 )
 
-func InitService(dataDir string) error {
-	dbOplogCore, err := pttdb.NewLDBDatabase("oplog", dataDir, 0, 0)
+func InitService(dataDir string, migrateOpts *pttdb.MigrateOptions) error {
+	oplogCore, err := pttdb.OpenDatabase("oplog", dataDir)
+	if err != nil {
+		return err
+	}
+
+	meta, err := pttdb.OpenDatabase("meta", dataDir)
 	if err != nil {
+		oplogCore.Close()
 		return err
 	}
 
+	return InitServiceWithDB(oplogCore, meta, migrateOpts)
+}
+
+/*
+InitServiceWithDB inits the service with the given dbs.
+
+It can be used with pttdb.MemLDBDatabase in tests.
+*/
+func InitServiceWithDB(oplogCore pttdb.KVDatabase, meta pttdb.KVDatabase, migrateOpts *pttdb.MigrateOptions) error {
+	var err error
+
+	dbOplogCore = oplogCore
 	dbOplog, err = pttdb.NewLDBBatch(dbOplogCore)
 	if err != nil {
 		return err
 	}
 
-	dbMeta, err = pttdb.NewLDBDatabase("meta", dataDir, 0, 0)
+	dbMeta = meta
+
+	err = pttdb.MigrateAll(dbMeta, migrateOpts, dbOplogCore)
 	if err != nil {
 		return err
 	}
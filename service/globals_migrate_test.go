@@ -0,0 +1,108 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+func TestInitServiceWithDB_Migrate(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	origInterval := pttdb.MigrationCheckpointInterval
+	pttdb.MigrationCheckpointInterval = 1
+	defer func() {
+		pttdb.MigrationCheckpointInterval = origInterval
+	}()
+
+	oplogCore := pttdb.NewMemLDBDatabase("test-init-service-oplog")
+	meta := pttdb.NewMemLDBDatabase("test-init-service-meta")
+	defer TeardownService()
+
+	prefix := []byte(".tslg")
+	keys := [][]byte{[]byte(".tslg1"), []byte(".tslg2"), []byte(".tslg3")}
+	for _, key := range keys {
+		oplogCore.Put(key, []byte("v1"))
+	}
+
+	errCrash := errors.New("crash")
+	isCrash := true
+	visited := 0
+
+	// v1: rewrite the oplogs with v2, crashed at the 2nd oplog.
+	pttdb.RegisterMigration(oplogCore.Name(), &pttdb.Migration{
+		Version: 1,
+		Name:    "rewrite-oplog",
+		Run: func(mc *pttdb.MigrationContext) error {
+			return mc.ForEachWithPrefix(prefix, func(key []byte, val []byte) error {
+				if isCrash && bytes.Equal(key, keys[1]) {
+					return errCrash
+				}
+				visited++
+				return mc.Put(key, []byte("v2"))
+			})
+		},
+	})
+
+	// dry-run
+	err := InitServiceWithDB(oplogCore, meta, &pttdb.MigrateOptions{IsDryRun: true})
+	if err != errCrash {
+		t.Errorf("InitServiceWithDB (dry-run): e: %v, want errCrash", err)
+	}
+	_, err = pttdb.GetSchemaVersion(oplogCore.Name(), meta)
+	if err == nil {
+		t.Errorf("InitServiceWithDB (dry-run): schema version is saved")
+	}
+
+	// crash
+	visited = 0
+	err = InitServiceWithDB(oplogCore, meta, nil)
+	if err != errCrash {
+		t.Errorf("InitServiceWithDB (crash): e: %v, want errCrash", err)
+	}
+	sv, _ := pttdb.GetSchemaVersion(oplogCore.Name(), meta)
+	if sv == nil || sv.Pending != 1 || !bytes.Equal(sv.Checkpoint, keys[0]) {
+		t.Errorf("InitServiceWithDB (crash): schema version: %v, want pending 1 with checkpoint %s", sv, keys[0])
+	}
+
+	// resume
+	isCrash = false
+	err = InitServiceWithDB(oplogCore, meta, nil)
+	if err != nil {
+		t.Errorf("InitServiceWithDB (resume): e: %v", err)
+	}
+	if visited != len(keys) {
+		t.Errorf("InitServiceWithDB (resume): visited: %v, want %v", visited, len(keys))
+	}
+	for _, key := range keys {
+		val, _ := oplogCore.Get(key)
+		if !bytes.Equal(val, []byte("v2")) {
+			t.Errorf("InitServiceWithDB (resume): %s = %s, want v2", key, val)
+		}
+	}
+
+	sv, _ = pttdb.GetSchemaVersion(oplogCore.Name(), meta)
+	if sv == nil || sv.Version != 1 || sv.Pending != 0 {
+		t.Errorf("InitServiceWithDB (resume): schema version: %v, want 1", sv)
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"math/rand"
-	"os"
 	"testing"
 	"time"
 
@@ -215,11 +214,11 @@ var (
 
 	tDefaultMerkle *Merkle = nil
 
-	tDBOplog             *pttdb.LDBBatch    = nil
-	tDBOplogCore         *pttdb.LDBDatabase = nil
-	tDBOplogPrefix                          = []byte(".ttlg")
-	tDBOplogIdxPrefix                       = []byte(".ttig")
-	tDBOplogMerklePrefix                    = []byte(".ttmk")
+	tDBOplog             *pttdb.LDBBatch  = nil
+	tDBOplogCore         pttdb.KVDatabase = nil
+	tDBOplogPrefix                        = []byte(".ttlg")
+	tDBOplogIdxPrefix                     = []byte(".ttig")
+	tDBOplogMerklePrefix                  = []byte(".ttmk")
 
 	origHandler      log.Handler
 	origGenIV        func(iv []byte) error
@@ -361,7 +360,7 @@ func setupTest(t *testing.T) {
 		PubKeyBytes: crypto.FromECDSAPub(&tKeyMe.PublicKey),
 	}
 
-	tDBOplogCore, _ = pttdb.NewLDBDatabase("oplog", "./test.out", 0, 0)
+	tDBOplogCore = pttdb.NewMemLDBDatabase("oplog")
 	tDBOplog, _ = pttdb.NewLDBBatch(tDBOplogCore)
 	tDefaultOplog, _ = NewOplog(tDefaultID, tDefaultTimestamp1, tMyID, MasterOpTypeAddMaster, nil, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
 	tDefaultOplog.Sign(tKeyInfoMe)
@@ -406,8 +405,6 @@ func teardownTest(t *testing.T) {
 		tDBLock = nil
 	}
 
-	os.RemoveAll("./test.out")
-
 	ts, _ := types.GetTimestamp()
 	t.Logf("after teardown: GetTimestamp: %v", ts)
 }
@@ -117,12 +117,18 @@ type JoinEntity struct {
 
 /*
 ConfirmJoin represents the data for the invitors to confirm the join
+
+The confirm-join is persisted with EntityID and NodeID,
+and Entity and Peer are resolved again when approving the join after restart.
 */
 type ConfirmJoin struct {
-	Entity     Entity
-	JoinEntity *JoinEntity
-	KeyInfo    *KeyInfo
-	Peer       *PttPeer
-	UpdateTS   types.Timestamp
-	JoinType   JoinType
+	Entity     Entity          `json:"-"`
+	JoinEntity *JoinEntity     `json:"J"`
+	KeyInfo    *KeyInfo        `json:"K"`
+	Peer       *PttPeer        `json:"-"`
+	UpdateTS   types.Timestamp `json:"UT"`
+	JoinType   JoinType        `json:"T"`
+
+	EntityID *types.PttID     `json:"EID"`
+	NodeID   *discover.NodeID `json:"NID"`
 }
@@ -215,12 +215,15 @@ func (k *KeyInfo) Save(db *pttdb.LDBBatch, isLocked bool) error {
 
 	log.Debug("Save: to PutAll", "idxKey", idxKey, "idx", idx, "key", key, "marshaled", marshaled)
 
-	_, err = db.TryPutAll(idxKey, idx, kvs, true, false)
+	tx := db.NewTx()
+	defer tx.Rollback()
+
+	_, err = tx.TryPutAll(idxKey, idx, kvs, true, false)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (k *KeyInfo) Delete(db *pttdb.LDBBatch, isLocked bool) error {
@@ -0,0 +1,229 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+/*
+MailboxItem is the item stored in the mailbox-node for the offline recipient.
+
+Data is encrypted by the sender (ex: the oplogs encrypted with the op-key of the friend),
+and is opaque to the mailbox-node.
+*/
+type MailboxItem struct {
+	ID       *types.PttID    `json:"ID"`
+	From     *types.PttID    `json:"F"` // set by the mailbox-node from the identified peer
+	To       *types.PttID    `json:"T"`
+	CreateTS types.Timestamp `json:"CT"`
+	ExpireTS types.Timestamp `json:"ET"`
+	Data     []byte          `json:"D"`
+}
+
+func (m *MailboxItem) size() uint64 {
+	return uint64(len(m.Data))
+}
+
+/*
+MailboxReceipt is the delivery-acknowledgement of the mailbox-item to the sender.
+*/
+type MailboxReceipt struct {
+	ID        *types.PttID    `json:"ID"`
+	To        *types.PttID    `json:"T"`
+	DeliverTS types.Timestamp `json:"DT"`
+}
+
+/*
+MailboxEvent is posted after the mailbox-item addressed to me is handled as the op of the entity.
+*/
+type MailboxEvent struct {
+	Item *MailboxItem `json:"I"`
+}
+
+/*
+MailboxReceiptEvent is posted when the mailbox-item from me is delivered.
+*/
+type MailboxReceiptEvent struct {
+	Receipt *MailboxReceipt `json:"R"`
+}
+
+type BackendMailboxStats struct {
+	NItems      int    `json:"N"`
+	Bytes       uint64 `json:"B"`
+	Quota       uint64 `json:"Q"`
+	UserQuota   uint64 `json:"UQ"`
+	SenderQuota uint64 `json:"SQ"`
+}
+
+/**********
+ * db
+ **********/
+
+func marshalMailboxItemKey(to *types.PttID, id *types.PttID) ([]byte, error) {
+	return common.Concat([][]byte{DBMailboxPrefix, to[:], id[:]})
+}
+
+func marshalMailboxReceiptKey(from *types.PttID, id *types.PttID) ([]byte, error) {
+	return common.Concat([][]byte{DBMailboxReceiptPrefix, from[:], id[:]})
+}
+
+func saveMailboxItem(item *MailboxItem) error {
+	key, err := marshalMailboxItemKey(item.To, item.ID)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return dbOplogCore.Put(key, marshaled)
+}
+
+func getMailboxItem(to *types.PttID, id *types.PttID) (*MailboxItem, error) {
+	key, err := marshalMailboxItemKey(to, id)
+	if err != nil {
+		return nil, err
+	}
+
+	marshaled, err := dbOplogCore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &MailboxItem{}
+	err = json.Unmarshal(marshaled, item)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func deleteMailboxItem(item *MailboxItem) error {
+	key, err := marshalMailboxItemKey(item.To, item.ID)
+	if err != nil {
+		return err
+	}
+
+	return dbOplogCore.Delete(key)
+}
+
+/*
+getMailboxItems gets the mailbox-items of the recipient, or all the mailbox-items if to is nil.
+*/
+func getMailboxItems(to *types.PttID) ([]*MailboxItem, error) {
+	prefix := DBMailboxPrefix
+	if to != nil {
+		var err error
+		prefix, err = common.Concat([][]byte{DBMailboxPrefix, to[:]})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	iter, err := dbOplogCore.NewIteratorWithPrefix(nil, prefix, pttdb.ListOrderNext)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Release()
+
+	items := make([]*MailboxItem, 0)
+	for iter.Next() {
+		item := &MailboxItem{}
+		err = json.Unmarshal(iter.Value(), item)
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func saveMailboxReceipt(from *types.PttID, receipt *MailboxReceipt) error {
+	key, err := marshalMailboxReceiptKey(from, receipt.ID)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	return dbOplogCore.Put(key, marshaled)
+}
+
+func deleteMailboxReceipt(from *types.PttID, receipt *MailboxReceipt) error {
+	key, err := marshalMailboxReceiptKey(from, receipt.ID)
+	if err != nil {
+		return err
+	}
+
+	return dbOplogCore.Delete(key)
+}
+
+/*
+getMailboxReceipts gets the receipts for the sender, or all the receipts with the senders if from is nil.
+*/
+func getMailboxReceipts(from *types.PttID) ([]*MailboxReceipt, []*types.PttID, error) {
+	prefix := DBMailboxReceiptPrefix
+	if from != nil {
+		var err error
+		prefix, err = common.Concat([][]byte{DBMailboxReceiptPrefix, from[:]})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	iter, err := dbOplogCore.NewIteratorWithPrefix(nil, prefix, pttdb.ListOrderNext)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Release()
+
+	offset := len(DBMailboxReceiptPrefix)
+	receipts := make([]*MailboxReceipt, 0)
+	froms := make([]*types.PttID, 0)
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < offset+types.SizePttID {
+			continue
+		}
+
+		receipt := &MailboxReceipt{}
+		err = json.Unmarshal(iter.Value(), receipt)
+		if err != nil {
+			continue
+		}
+
+		eachFrom := &types.PttID{}
+		copy(eachFrom[:], key[offset:offset+types.SizePttID])
+
+		receipts = append(receipts, receipt)
+		froms = append(froms, eachFrom)
+	}
+
+	return receipts, froms, nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/event"
+	"github.com/ailabstw/go-pttai/p2p"
+)
+
+type testMailboxMyEntity struct {
+	PttMyEntity
+	id *types.PttID
+}
+
+func (e *testMailboxMyEntity) GetID() *types.PttID {
+	return e.id
+}
+
+func newTestMailboxPtt(t *testing.T, isMailbox bool) *testRelayPtt {
+	p := newTestRelayPtt(t, false)
+	p.config.IsMailbox = isMailbox
+	p.eventMux = new(event.TypeMux)
+	p.entities = make(map[types.PttID]Entity)
+	p.ops = make(map[common.Address]*types.PttID)
+
+	id, _ := types.NewPttID()
+	p.myEntity = &testMailboxMyEntity{id: id}
+
+	return p
+}
+
+type testMailboxPM struct {
+	ProtocolManager
+	b *BaseProtocolManager
+}
+
+func (pm *testMailboxPM) Ptt() Ptt { return pm.b.Ptt() }
+func (pm *testMailboxPM) GetOpKeyInfoFromHash(hash *common.Address) (*KeyInfo, error) {
+	return pm.b.GetOpKeyInfoFromHash(hash, false)
+}
+func (pm *testMailboxPM) IsMailboxRecipient(id *types.PttID) bool { return pm.b.IsMailboxRecipient(id) }
+func (pm *testMailboxPM) HandleAddMasterOplog(dataBytes []byte, peer *PttPeer) error {
+	return pm.b.HandleAddMasterOplog(dataBytes, peer)
+}
+
+/*
+newTestMailboxPM creates the entity in p with the op-key shared by the members, and the mailbox-recipient.
+*/
+func newTestMailboxPM(p *testRelayPtt, entityID *types.PttID, recipient *types.PttID) *BaseProtocolManager {
+	key, _ := crypto.ToECDSA(tDefaultSignKeyBytes2)
+	hash := &common.Address{}
+	copy(hash[:], entityID[:])
+	ts, _ := types.GetTimestamp()
+	keyInfo := &KeyInfo{Hash: hash, Key: key, KeyBytes: crypto.FromECDSA(key), UpdateTS: ts, Status: types.StatusAlive}
+
+	peers, _ := NewPttPeerSet()
+	b := &BaseProtocolManager{
+		ptt:                p,
+		db:                 tDBOplog,
+		peers:              peers,
+		opKeyInfos:         map[common.Address]*KeyInfo{*hash: keyInfo},
+		expireOpKeySeconds: 3600,
+		renewOpKeySeconds:  3600,
+		isValidOplog: func(signInfos []*SignInfo) (*types.PttID, uint32, bool) {
+			return tUserIDMe, uint32(len(signInfos)), len(signInfos) != 0
+		},
+	}
+	b.SetMailboxRecipients([]*types.PttID{recipient})
+
+	entity := &testCoreEntity{id: entityID, pm: &testMailboxPM{b: b}}
+	b.entity = entity
+
+	p.entities[*entityID] = entity
+	p.ops[*hash] = entityID
+
+	return b
+}
+
+func connectTestMailboxPtts(m, p *testRelayPtt) (*PttPeer, *PttPeer, func()) {
+	rwM, rwP := p2p.MsgPipe()
+
+	peerP, _ := m.NewPeer(Ptt2, p2p.NewPeer(*p.myNodeID, "p", nil), rwM)
+	peerP.UserID = p.myEntity.GetID()
+	peerM, _ := p.NewPeer(Ptt2, p2p.NewPeer(*m.myNodeID, "m", nil), rwP)
+	peerM.UserID = m.myEntity.GetID()
+	peerM.NodeRecord = NewNodeRecordEntry(m.config)
+
+	// the features are negotiated in the handshake.
+	peerP.features = PttFeatures
+	peerM.features = PttFeatures
+	p.SetPeerType(peerM, PeerTypeRandom, false, false)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go handleTestMailboxMsgs(m, peerP, rwM, &wg)
+	go handleTestMailboxMsgs(p, peerM, rwP, &wg)
+
+	return peerP, peerM, func() {
+		rwM.Close()
+		wg.Wait()
+	}
+}
+
+func handleTestMailboxMsgs(p *testRelayPtt, peer *PttPeer, rw p2p.MsgReadWriter, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return
+		}
+		data := &PttData{}
+		err = msg.Decode(data)
+		if err != nil {
+			return
+		}
+		p.HandleMessage(CodeType(msg.Code), data, peer)
+	}
+}
+
+func TestBasePtt_Mailbox(t *testing.T) {
+	setupTest(t)
+	defer teardownTest(t)
+
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return types.Timestamp{Ts: 1234567890}, nil
+	}
+
+	origDBOplogCore, origDBOplog, origDBMasterLockMap := dbOplogCore, dbOplog, DBMasterLockMap
+	dbOplogCore, dbOplog, DBMasterLockMap = tDBOplogCore, tDBOplog, tDBLock
+	defer func() {
+		dbOplogCore, dbOplog, DBMasterLockMap = origDBOplogCore, origDBOplog, origDBMasterLockMap
+	}()
+
+	m := newTestMailboxPtt(t, true)
+	a := newTestMailboxPtt(t, false)
+	b := newTestMailboxPtt(t, false)
+
+	peerA, _, closeA := connectTestMailboxPtts(m, a)
+	defer closeA()
+	peerB, _, closeB := connectTestMailboxPtts(m, b)
+	defer closeB()
+
+	m.SetPeerType(peerA, PeerTypeRandom, false, false)
+
+	ackSub := a.eventMux.Subscribe(&MailboxPutAck{})
+	defer ackSub.Unsubscribe()
+	receiptSub := a.eventMux.Subscribe(&MailboxReceiptEvent{})
+	defer receiptSub.Unsubscribe()
+	itemSub := b.eventMux.Subscribe(&MailboxEvent{})
+	defer itemSub.Unsubscribe()
+
+	// the entity of a and b, with the master-oplog from a.
+	aID, bID := a.myEntity.GetID(), b.myEntity.GetID()
+	entityID, _ := types.NewPttID()
+	pmA := newTestMailboxPM(a, entityID, bID)
+	pmB := newTestMailboxPM(b, entityID, aID)
+
+	policies := []*ApprovalPolicy{{Type: ApprovalPolicyAnyMaster, Ops: []OpType{MasterOpTypeAddMaster}}}
+	oplog, _ := NewMasterOplog(entityID, types.Timestamp{Ts: 1234567890}, tUserIDMe, MasterOpTypeSetApprovalPolicy, &MasterOpSetApprovalPolicy{Policies: policies})
+	oplog.Sign(tKeyInfoMe)
+	oplog.MasterSign(tUserIDMe, tKeyInfoMe)
+	oplog.SetMasterLogID(tUserIDMe, 1)
+
+	waitAck := func(name string) *MailboxPutAck {
+		select {
+		case ev := <-ackSub.Chan():
+			ack := ev.Data.(*MailboxPutAck)
+			if ack.Error != "" {
+				t.Errorf("%v: invalid ack: %v", name, ack)
+			}
+			return ack
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: no ack", name)
+		}
+		return nil
+	}
+
+	// a puts the invalid data to the offline b.
+	invalidItem, err := a.PutMailbox(bID, []byte("encrypted"), 3600)
+	if err != nil {
+		t.Fatalf("PutMailbox: e: %v", err)
+	}
+	if ack := waitAck("PutMailbox"); *ack.ID != *invalidItem.ID {
+		t.Errorf("PutMailbox: invalid ack: %v", ack)
+	}
+
+	// a broadcasts the oplog to the offline b.
+	err = pmA.BroadcastOplog(oplog.Oplog, AddMasterOplogMsg, AddPendingMasterOplogMsg)
+	if err != nil {
+		t.Fatalf("BroadcastOplog: e: %v", err)
+	}
+	oplogItemID := waitAck("BroadcastOplog").ID
+
+	stats, err := m.GetMailboxStats()
+	if err != nil || stats.NItems != 2 {
+		t.Errorf("GetMailboxStats: stats: %v e: %v", stats, err)
+	}
+
+	// b connects.
+	m.SetPeerType(peerB, PeerTypeRandom, false, false)
+	m.deliverMailbox(peerB)
+
+	select {
+	case ev := <-itemSub.Chan():
+		got := ev.Data.(*MailboxEvent).Item
+		if *got.ID != *oplogItemID || *got.From != *aID {
+			t.Errorf("deliverMailbox: invalid item: %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("deliverMailbox: not delivered")
+	}
+
+	ps := pmB.ApprovalPolicies()
+	if ps == nil || !reflect.DeepEqual(ps.LogID, oplog.ID) || !reflect.DeepEqual(ps.Policies, policies) {
+		t.Errorf("HandleMailboxDeliver: policies: %v, want: %v", ps, policies)
+	}
+
+	select {
+	case ev := <-receiptSub.Chan():
+		receipt := ev.Data.(*MailboxReceiptEvent).Receipt
+		if *receipt.ID != *oplogItemID || *receipt.To != *bID {
+			t.Errorf("HandleMailboxAck: invalid receipt: %v", receipt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("HandleMailboxAck: no receipt")
+	}
+
+	// the invalid item is not acked.
+	stats, _ = m.GetMailboxStats()
+	if stats.NItems != 1 {
+		t.Errorf("HandleMailboxAck: expected the invalid item kept: %v", stats.NItems)
+	}
+	if _, err := getMailboxItem(bID, invalidItem.ID); err != nil {
+		t.Errorf("HandleMailboxAck: expected the invalid item kept: e: %v", err)
+	}
+
+	// the receipts are removed after sent.
+	for i := 0; i < 100; i++ {
+		receipts, _, _ := getMailboxReceipts(a.myEntity.GetID())
+		if len(receipts) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if receipts, _, _ := getMailboxReceipts(a.myEntity.GetID()); len(receipts) != 0 {
+		t.Errorf("deliverMailbox: expected receipts removed: %v", len(receipts))
+	}
+
+	// not a mailbox-node.
+	if _, err := a.GetMailboxStats(); err != ErrNotMailbox {
+		t.Errorf("GetMailboxStats: expected not mailbox: e: %v", err)
+	}
+	c := newTestMailboxPtt(t, false)
+	if _, err := c.PutMailbox(bID, []byte("encrypted"), 3600); err != ErrNoMailbox {
+		t.Errorf("PutMailbox: expected no mailbox: e: %v", err)
+	}
+}
+
+func TestBasePtt_putMailboxItem(t *testing.T) {
+	setupTest(t)
+	defer teardownTest(t)
+
+	origDBOplogCore := dbOplogCore
+	dbOplogCore = tDBOplogCore
+	defer func() { dbOplogCore = origDBOplogCore }()
+
+	m := newTestMailboxPtt(t, true)
+	m.config.MailboxUserQuotaBytes = 10
+	m.config.MailboxMaxExpireSeconds = 100
+
+	newItem := func(to *types.PttID, data string) *MailboxItem {
+		id, _ := types.NewPttID()
+		expireTS := tDefaultTimestamp
+		expireTS.Ts += 1000
+		return &MailboxItem{ID: id, To: to, CreateTS: tDefaultTimestamp, ExpireTS: expireTS, Data: []byte(data)}
+	}
+
+	// user-quota
+	item := newItem(tUserIDMe, "12345678")
+	if err := m.putMailboxItem(item, tDefaultID); err != nil {
+		t.Fatalf("putMailboxItem: e: %v", err)
+	}
+	if item.ExpireTS.Ts != tDefaultTimestamp.Ts+100 {
+		t.Errorf("putMailboxItem: expected the limited expire-ts: %v", item.ExpireTS)
+	}
+	if err := m.putMailboxItem(newItem(tUserIDMe, "12345678"), tDefaultID); err != ErrMailboxQuota {
+		t.Errorf("putMailboxItem: expected user-quota: e: %v", err)
+	}
+
+	// sender-quota
+	m.config.MailboxSenderQuotaBytes = 12
+	if err := m.putMailboxItem(newItem(tDefaultID, "12345678"), tDefaultID); err != ErrMailboxQuota {
+		t.Errorf("putMailboxItem: expected sender-quota: e: %v", err)
+	}
+	if err := m.putMailboxItem(newItem(tDefaultID, "1234"), tUserIDMe); err != nil {
+		t.Errorf("putMailboxItem: other sender: e: %v", err)
+	}
+
+	// quota
+	m.config.MailboxQuotaBytes = 12
+	if err := m.putMailboxItem(newItem(tDefaultID, "12345678"), tDefaultID); err != ErrMailboxQuota {
+		t.Errorf("putMailboxItem: expected quota: e: %v", err)
+	}
+
+	// expire
+	ts := tDefaultTimestamp
+	ts.Ts += 101
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return ts, nil
+	}
+	if err := m.ExpireMailbox(); err != nil {
+		t.Errorf("ExpireMailbox: e: %v", err)
+	}
+	stats, _ := m.GetMailboxStats()
+	if stats.NItems != 0 {
+		t.Errorf("ExpireMailbox: expected expired: %v", stats.NItems)
+	}
+	if err := m.putMailboxItem(newItem(tDefaultID, "12345678"), tDefaultID); err != nil {
+		t.Errorf("putMailboxItem: after expire: e: %v", err)
+	}
+}
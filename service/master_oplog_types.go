@@ -22,6 +22,7 @@ const (
 	_ OpType = iota
 	MasterOpTypeAddMaster
 	MasterOpTypeRevokeMaster
+	MasterOpTypeSetApprovalPolicy
 )
 
 type MasterOpAddMaster struct {
@@ -34,3 +35,7 @@ type MasterOpRevokeMaster struct {
 	ID      *discover.NodeID
 	Masters map[discover.NodeID]uint32 `json:"M"`
 }
+
+type MasterOpSetApprovalPolicy struct {
+	Policies []*ApprovalPolicy `json:"P"`
+}
@@ -53,9 +53,18 @@ func (rw *BaseMeteredMsgReadWriter) ReadMsg() (p2p.Msg, error) {
 		return msg, err
 	}
 
+	meterReadMsg(msg)
+
 	return msg, nil
 }
 
 func (rw *BaseMeteredMsgReadWriter) WriteMsg(msg p2p.Msg) error {
-	return rw.MsgReadWriter.WriteMsg(msg)
+	err := rw.MsgReadWriter.WriteMsg(msg)
+	if err != nil {
+		return err
+	}
+
+	meterWriteMsg(msg)
+
+	return nil
 }
@@ -0,0 +1,117 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/ailabstw/go-pttai/metrics"
+	"github.com/ailabstw/go-pttai/p2p"
+)
+
+var (
+	msgInPacketsMeter  = metrics.NewRegisteredMeter("ptt/msg/in/packets", nil)
+	msgInTrafficMeter  = metrics.NewRegisteredMeter("ptt/msg/in/traffic", nil)
+	msgOutPacketsMeter = metrics.NewRegisteredMeter("ptt/msg/out/packets", nil)
+	msgOutTrafficMeter = metrics.NewRegisteredMeter("ptt/msg/out/traffic", nil)
+
+	syncSucceededMeter = metrics.NewRegisteredMeter("ptt/sync/succeeded", nil)
+	syncFailedMeter    = metrics.NewRegisteredMeter("ptt/sync/failed", nil)
+)
+
+/*
+registerMetrics registers the gauges of the peers and the entities,
+and the healthchecks of ptt.
+
+The gauges are re-registered with the new ptt when the node restarts.
+*/
+func (p *BasePtt) registerMetrics() {
+	if !metrics.Enabled {
+		return
+	}
+
+	registerFunctionalGauge("ptt/peers/me", func() int64 {
+		return int64(p.countPeers(PeerTypeMe))
+	})
+	registerFunctionalGauge("ptt/peers/important", func() int64 {
+		return int64(p.countPeers(PeerTypeImportant))
+	})
+	registerFunctionalGauge("ptt/peers/member", func() int64 {
+		return int64(p.countPeers(PeerTypeMember))
+	})
+	registerFunctionalGauge("ptt/peers/random", func() int64 {
+		return int64(p.countPeers(PeerTypeRandom))
+	})
+
+	registerFunctionalGauge("ptt/entities", func() int64 {
+		p.entityLock.RLock()
+		defer p.entityLock.RUnlock()
+
+		return int64(len(p.entities))
+	})
+
+	p.registerHealthcheck("ptt/health", func(health *BackendHealth) bool { return health.IsHealthy })
+	p.registerHealthcheck("ptt/ready", func(health *BackendHealth) bool { return health.IsReady })
+}
+
+func registerFunctionalGauge(name string, f func() int64) {
+	metrics.DefaultRegistry.Unregister(name)
+	metrics.NewRegisteredFunctionalGauge(name, nil, f)
+}
+
+func (p *BasePtt) registerHealthcheck(name string, isOK func(health *BackendHealth) bool) {
+	metrics.DefaultRegistry.Unregister(name)
+	metrics.DefaultRegistry.Register(name, metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		health, err := p.Health()
+		switch {
+		case err != nil:
+			h.Unhealthy(err)
+		case !isOK(health):
+			h.Unhealthy(fmt.Errorf("%v", failedHealthChecks(health.Checks)))
+		default:
+			h.Healthy()
+		}
+	}))
+}
+
+func (p *BasePtt) countPeers(peerType PeerType) int {
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+
+	switch peerType {
+	case PeerTypeMe:
+		return len(p.myPeers)
+	case PeerTypeImportant:
+		return len(p.importantPeers)
+	case PeerTypeMember:
+		return len(p.memberPeers)
+	case PeerTypeRandom:
+		return len(p.randomPeers)
+	}
+
+	return 0
+}
+
+func meterReadMsg(msg p2p.Msg) {
+	msgInPacketsMeter.Mark(1)
+	msgInTrafficMeter.Mark(int64(msg.Size))
+}
+
+func meterWriteMsg(msg p2p.Msg) {
+	msgOutPacketsMeter.Mark(1)
+	msgOutTrafficMeter.Mark(int64(msg.Size))
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"crypto/ecdsa"
+	"sort"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/enr"
+	"github.com/ailabstw/go-pttai/rlp"
+)
+
+/*
+NodeRecordEntry is the ptt-entry in the node-record (ENR),
+advertising the node-type, the supported ptt-protocol-versions,
+and whether the node is willing to relay / host the public boards.
+*/
+type NodeRecordEntry struct {
+	NodeType uint
+	Versions []uint
+	Flags    uint
+
+	// for forward-compatibility
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+func (e NodeRecordEntry) ENRKey() string { return "ptt" }
+
+func (e *NodeRecordEntry) GetNodeType() NodeType {
+	return NodeType(e.NodeType)
+}
+
+func (e *NodeRecordEntry) IsRelay() bool {
+	return e.Flags&NodeRecordFlagRelay != 0
+}
+
+func (e *NodeRecordEntry) IsMailbox() bool {
+	return e.Flags&NodeRecordFlagMailbox != 0
+}
+
+func (e *NodeRecordEntry) IsHostBoards() bool {
+	return e.Flags&NodeRecordFlagHostBoards != 0
+}
+
+func (e *NodeRecordEntry) IsSupportVersion(version uint) bool {
+	for _, v := range e.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+NewNodeRecordEntry generates the ptt-entry from the config.
+*/
+func NewNodeRecordEntry(cfg *Config) *NodeRecordEntry {
+	versions := make([]uint, len(ProtocolVersions))
+	copy(versions, ProtocolVersions[:])
+
+	var flags uint
+	if cfg.IsRelay {
+		flags |= NodeRecordFlagRelay
+	}
+	if cfg.IsHostBoards {
+		flags |= NodeRecordFlagHostBoards
+	}
+	if cfg.IsMailbox {
+		flags |= NodeRecordFlagMailbox
+	}
+
+	return &NodeRecordEntry{
+		NodeType: uint(cfg.NodeType),
+		Versions: versions,
+		Flags:    flags,
+	}
+}
+
+/*
+NewNodeRecord generates the node-record with the ptt-entry signed by the node-key.
+*/
+func NewNodeRecord(key *ecdsa.PrivateKey, entry *NodeRecordEntry) (*enr.Record, error) {
+	r := &enr.Record{}
+	r.Set(entry)
+
+	err := enr.SignV4(r, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+/*
+ParseNodeRecord gets the ptt-entry from the node-record.
+The signature is verified while decoding the record, and we check that the record is signed by the node.
+*/
+func ParseNodeRecord(r *enr.Record, nodeID *discover.NodeID) (*NodeRecordEntry, error) {
+	pubkey := &enr.Secp256k1{}
+	err := r.Load(pubkey)
+	if err != nil {
+		return nil, ErrInvalidNodeRecord
+	}
+
+	if discover.PubkeyID((*ecdsa.PublicKey)(pubkey)) != *nodeID {
+		return nil, ErrInvalidNodeRecord
+	}
+
+	entry := &NodeRecordEntry{}
+	err = r.Load(entry)
+	if err != nil {
+		return nil, ErrInvalidNodeRecord
+	}
+
+	return entry, nil
+}
+
+/**********
+ * node-types of the known nodes
+ **********/
+
+func (p *BasePtt) setNodeType(nodeID *discover.NodeID, nodeType NodeType) {
+	p.lockNodeTypes.Lock()
+	defer p.lockNodeTypes.Unlock()
+
+	if _, ok := p.nodeTypes[*nodeID]; !ok && len(p.nodeTypes) >= MaxNodeTypes {
+		for eachNodeID := range p.nodeTypes {
+			delete(p.nodeTypes, eachNodeID)
+			break
+		}
+	}
+
+	p.nodeTypes[*nodeID] = nodeType
+}
+
+func (p *BasePtt) getNodeType(nodeID *discover.NodeID) NodeType {
+	p.lockNodeTypes.RLock()
+	defer p.lockNodeTypes.RUnlock()
+
+	return p.nodeTypes[*nodeID]
+}
+
+/*
+preferNodes sorts the nodes with the server-nodes first,
+and skips the mobile-nodes if there are other nodes available to avoid draining the mobile-nodes.
+*/
+func (p *BasePtt) preferNodes(nodes []*discover.Node) []*discover.Node {
+	priorities := make(map[discover.NodeID]int)
+	isAllMobile := true
+	for _, node := range nodes {
+		nodeType := p.getNodeType(&node.ID)
+		priorities[node.ID] = nodeTypePriority(nodeType)
+		if nodeType != NodeTypeMobile {
+			isAllMobile = false
+		}
+	}
+
+	preferred := make([]*discover.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !isAllMobile && priorities[node.ID] == nodeTypePriority(NodeTypeMobile) {
+			log.Debug("preferNodes: skip mobile", "node", node.ID)
+			continue
+		}
+		preferred = append(preferred, node)
+	}
+
+	sort.SliceStable(preferred, func(i, j int) bool {
+		return priorities[preferred[i].ID] < priorities[preferred[j].ID]
+	})
+
+	return preferred
+}
+
+func nodeTypePriority(nodeType NodeType) int {
+	switch nodeType {
+	case NodeTypeServer:
+		return 0
+	case NodeTypeMobile:
+		return 2
+	}
+	return 1
+}
+
+/*
+isMobilePeer / isHostPeer are from the node-record in the handshake.
+Peers without the node-record are treated as desktop-nodes.
+*/
+func isMobilePeer(peer *PttPeer) bool {
+	return peer.NodeRecord != nil && peer.NodeRecord.GetNodeType() == NodeTypeMobile
+}
+
+func isHostPeer(peer *PttPeer) bool {
+	return peer.NodeRecord != nil && peer.NodeRecord.GetNodeType() == NodeTypeServer && peer.NodeRecord.IsHostBoards()
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/enr"
+	"github.com/ailabstw/go-pttai/rlp"
+)
+
+func TestNodeRecord(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	nodeID := discover.PubkeyID(&key.PublicKey)
+
+	cfg := &Config{NodeType: NodeTypeServer, IsHostBoards: true}
+	r, err := NewNodeRecord(key, NewNodeRecordEntry(cfg))
+	if err != nil {
+		t.Fatalf("NewNodeRecord: e: %v", err)
+	}
+
+	enc, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		t.Fatalf("unable to encode: e: %v", err)
+	}
+	dec := &enr.Record{}
+	err = rlp.DecodeBytes(enc, dec)
+	if err != nil {
+		t.Fatalf("unable to decode: e: %v", err)
+	}
+
+	entry, err := ParseNodeRecord(dec, &nodeID)
+	if err != nil {
+		t.Fatalf("ParseNodeRecord: e: %v", err)
+	}
+	if entry.GetNodeType() != NodeTypeServer || !entry.IsHostBoards() || entry.IsRelay() || !entry.IsSupportVersion(Ptt1) {
+		t.Errorf("ParseNodeRecord: invalid entry: %v", entry)
+	}
+
+	// signed by another node
+	otherKey, _ := crypto.GenerateKey()
+	otherNodeID := discover.PubkeyID(&otherKey.PublicKey)
+	_, err = ParseNodeRecord(dec, &otherNodeID)
+	if err != ErrInvalidNodeRecord {
+		t.Errorf("ParseNodeRecord: other node: e: %v", err)
+	}
+
+	// no ptt-entry
+	r = &enr.Record{}
+	enr.SignV4(r, key)
+	_, err = ParseNodeRecord(r, &nodeID)
+	if err != ErrInvalidNodeRecord {
+		t.Errorf("ParseNodeRecord: no entry: e: %v", err)
+	}
+}
+
+func TestPttPeer_Handshake(t *testing.T) {
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+	nodeIDA := discover.PubkeyID(&keyA.PublicKey)
+	nodeIDB := discover.PubkeyID(&keyB.PublicKey)
+
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	defer rwB.Close()
+
+	// peerB is B seen from A, peerA is A seen from B.
+	peerB, _ := NewPttPeer(Ptt2, p2p.NewPeer(nodeIDB, "B", nil), rwA, nil)
+	peerA, _ := NewPttPeer(Ptt2, p2p.NewPeer(nodeIDA, "A", nil), rwB, nil)
+
+	nodeRecordA, _ := NewNodeRecord(keyA, NewNodeRecordEntry(&Config{NodeType: NodeTypeMobile}))
+
+	// B does not provide the node-record as the status before the node-record.
+	errc := make(chan error, 2)
+	go func() { errc <- peerB.Handshake(1, nodeRecordA) }()
+	go func() { errc <- peerA.Handshake(1, nil) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("Handshake: e: %v", err)
+		}
+	}
+
+	if peerB.NodeRecord != nil {
+		t.Errorf("Handshake: B: unexpected node-record: %v", peerB.NodeRecord)
+	}
+	if peerA.NodeRecord == nil || peerA.NodeRecord.GetNodeType() != NodeTypeMobile {
+		t.Errorf("Handshake: A: invalid node-record: %v", peerA.NodeRecord)
+	}
+	if !isMobilePeer(peerA) || isMobilePeer(peerB) || isHostPeer(peerA) {
+		t.Errorf("Handshake: invalid peer-types")
+	}
+
+	// A sends the node-record of B.
+	nodeRecordB, _ := NewNodeRecord(keyB, NewNodeRecordEntry(&Config{NodeType: NodeTypeServer}))
+	go func() { errc <- peerB.Handshake(1, nodeRecordB) }()
+	go func() { errc <- peerA.Handshake(1, nil) }()
+	var nErr int
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			nErr++
+		}
+	}
+	if nErr == 0 {
+		t.Errorf("Handshake: expected err with the node-record of another node")
+	}
+}
+
+// testLegacyPttStatus is the status of the nodes before Ptt2.
+type testLegacyPttStatus struct {
+	Version   uint32
+	NetworkID uint32
+}
+
+func TestPttPeer_Ptt1Status(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	nodeID := discover.PubkeyID(&key.PublicKey)
+
+	rwA, rwB := p2p.MsgPipe()
+	defer rwA.Close()
+	defer rwB.Close()
+
+	peer, _ := NewPttPeer(Ptt1, p2p.NewPeer(nodeID, "A", nil), rwA, nil)
+	nodeRecord, _ := NewNodeRecord(key, NewNodeRecordEntry(&Config{NodeType: NodeTypeServer}))
+
+	// the ptt1-status is decoded strictly by the legacy nodes.
+	enc, err := rlp.EncodeToBytes(peer.newStatus(1, nodeRecord))
+	if err != nil {
+		t.Fatalf("unable to encode: e: %v", err)
+	}
+	legacyEnc, _ := rlp.EncodeToBytes(&testLegacyPttStatus{Version: uint32(Ptt1), NetworkID: 1})
+	if string(enc) != string(legacyEnc) {
+		t.Errorf("newStatus: ptt1-status differs from the legacy status: %x %x", enc, legacyEnc)
+	}
+
+	status := &testLegacyPttStatus{}
+	err = rlp.DecodeBytes(enc, status)
+	if err != nil || status.Version != uint32(Ptt1) || status.NetworkID != 1 {
+		t.Errorf("DecodeBytes: legacy: %v e: %v", status, err)
+	}
+}
+
+func TestBasePtt_preferNodes(t *testing.T) {
+	p := &BasePtt{nodeTypes: make(map[discover.NodeID]NodeType)}
+
+	newNode := func(b byte, nodeType NodeType) *discover.Node {
+		node := &discover.Node{}
+		node.ID[0] = b
+		if nodeType != NodeTypeUnknown {
+			p.setNodeType(&node.ID, nodeType)
+		}
+		return node
+	}
+
+	mobile := newNode(1, NodeTypeMobile)
+	desktop := newNode(2, NodeTypeDesktop)
+	unknown := newNode(3, NodeTypeUnknown)
+	server := newNode(4, NodeTypeServer)
+
+	got := p.preferNodes([]*discover.Node{mobile, desktop, unknown, server})
+	want := []*discover.Node{server, desktop, unknown}
+	if len(got) != len(want) {
+		t.Fatalf("preferNodes: got %v want %v", len(got), len(want))
+	}
+	for i, node := range got {
+		if node != want[i] {
+			t.Errorf("preferNodes: (%v) got %v want %v", i, node.ID[0], want[i].ID[0])
+		}
+	}
+
+	// all mobile
+	mobile2 := newNode(5, NodeTypeMobile)
+	got = p.preferNodes([]*discover.Node{mobile, mobile2})
+	if len(got) != 2 {
+		t.Errorf("preferNodes: all mobile: got %v", len(got))
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"reflect"
-	"sort"
 
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
@@ -44,6 +43,8 @@ type Oplog struct {
 
 	Data interface{} `json:"D"`
 
+	SignV uint8 `json:"sV,omitempty"` // OplogSignVersion, the encoding of the signed bytes
+
 	db               *pttdb.LDBBatch
 	dbPrefixID       *types.PttID
 	dbPrefix         []byte
@@ -170,7 +171,10 @@ func (o *Oplog) SaveWithIsSync(isLocked bool) error {
 		defer o.dbLock.Unlock(o.ID)
 	}
 
-	idxKey, idx, kvs, err := o.SaveCore()
+	tx := o.db.NewTx()
+	defer tx.Rollback()
+
+	idxKey, idx, kvs, err := o.SaveCore(tx)
 	if err != nil {
 		return err
 	}
@@ -180,15 +184,16 @@ func (o *Oplog) SaveWithIsSync(isLocked bool) error {
 	err = origO.Load(kvs[0].K)
 	if err == nil && reflect.DeepEqual(o.Hash, origO.Hash) && bool(origO.IsSync) {
 		o.IsSync = true
-		return nil
+		// commit the deletes of the stale keys queued in SaveCore.
+		return tx.Commit()
 	}
 
-	_, err = o.db.TryPutAll(idxKey, idx, kvs, true, false)
+	_, err = tx.TryPutAll(idxKey, idx, kvs, true, false)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (o *Oplog) Save(isLocked bool) error {
@@ -200,17 +205,20 @@ func (o *Oplog) Save(isLocked bool) error {
 		defer o.dbLock.Unlock(o.ID)
 	}
 
-	idxKey, idx, kvs, err := o.SaveCore()
+	tx := o.db.NewTx()
+	defer tx.Rollback()
+
+	idxKey, idx, kvs, err := o.SaveCore(tx)
 	if err != nil {
 		return err
 	}
 
-	_, err = o.db.TryPutAll(idxKey, idx, kvs, true, false)
+	_, err = tx.TryPutAll(idxKey, idx, kvs, true, false)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (o *Oplog) ForceSave(isLocked bool) error {
@@ -222,24 +230,32 @@ func (o *Oplog) ForceSave(isLocked bool) error {
 		defer o.dbLock.Unlock(o.ID)
 	}
 
-	idxKey, idx, kvs, err := o.SaveCore()
+	tx := o.db.NewTx()
+	defer tx.Rollback()
+
+	idxKey, idx, kvs, err := o.SaveCore(tx)
 	if err != nil {
 		return err
 	}
 
 	// XXX need to do verify before save
-	_, err = o.db.ForcePutAll(idxKey, idx, kvs)
+	_, err = tx.ForcePutAll(idxKey, idx, kvs)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (o *Oplog) SaveCore() ([]byte, *pttdb.Index, []*pttdb.KeyVal, error) {
+/*
+SaveCore prepares the idx and the key-vals of the oplog.
+
+The orig-log with different status is deleted in tx, so that the deletion and the new put are committed together.
+*/
+func (o *Oplog) SaveCore(tx *pttdb.LDBTx) ([]byte, *pttdb.Index, []*pttdb.KeyVal, error) {
 	o.NewestLog = nil
 	if o.MasterLogID == nil {
-		return o.SaveCorePending()
+		return o.SaveCorePending(tx)
 	}
 
 	key, err := o.MarshalKey(o.dbPrefix)
@@ -290,27 +306,27 @@ func (o *Oplog) SaveCore() ([]byte, *pttdb.Index, []*pttdb.KeyVal, error) {
 
 	// dealing with oplog with master-log-id.
 	// delete orig-log if the status of the orig-log is not with master-log-id
-	origKey, err := o.db.GetKeyByIdxKey(idxKey, 0)
+	origKey, err := tx.GetKeyByIdxKey(idxKey, 0)
 	if err != nil && err != leveldb.ErrNotFound {
 		return nil, nil, nil, err
 	}
 	if err == nil {
 		origStatus := bytesToStatus(origKey)
 		if origStatus != types.StatusAlive {
-			o.db.DeleteAll(idxKey)
+			tx.DeleteAll(idxKey)
 		}
 	}
 
 	return idxKey, idx, kvs, nil
 }
 
-func (o *Oplog) SaveCorePending() ([]byte, *pttdb.Index, []*pttdb.KeyVal, error) {
+func (o *Oplog) SaveCorePending(tx *pttdb.LDBTx) ([]byte, *pttdb.Index, []*pttdb.KeyVal, error) {
 	idxKey, err := o.IdxKey()
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	origKey, err := o.db.GetKeyByIdxKey(idxKey, 0)
+	origKey, err := tx.GetKeyByIdxKey(idxKey, 0)
 	if err != nil && err != leveldb.ErrNotFound {
 		return nil, nil, nil, err
 	}
@@ -321,7 +337,7 @@ func (o *Oplog) SaveCorePending() ([]byte, *pttdb.Index, []*pttdb.KeyVal, error)
 		if currentStatus < origStatus {
 			return nil, nil, nil, ErrInvalidOplog
 		} else if currentStatus > origStatus {
-			o.db.DeleteAll(idxKey)
+			tx.DeleteAll(idxKey)
 		}
 	}
 
@@ -353,6 +369,59 @@ func (o *Oplog) SaveCorePending() ([]byte, *pttdb.Index, []*pttdb.KeyVal, error)
 	return idxKey, idx, kvs, nil
 }
 
+/*
+SaveFailed moves the expired pending / internal-pending oplog to the failed oplogs.
+
+The failed oplog is still integrated if the oplog is valid (with MasterLogID) from the peers.
+*/
+func (o *Oplog) SaveFailed(isLocked bool) error {
+	if !isLocked {
+		err := o.dbLock.Lock(o.ID)
+		if err != nil {
+			return err
+		}
+		defer o.dbLock.Unlock(o.ID)
+	}
+
+	tx := o.db.NewTx()
+	defer tx.Rollback()
+
+	idxKey, err := o.IdxKey()
+	if err != nil {
+		return err
+	}
+
+	origKey, err := tx.GetKeyByIdxKey(idxKey, 0)
+	if err != nil {
+		return err
+	}
+
+	origStatus := bytesToStatus(origKey)
+	if origStatus != types.StatusPending && origStatus != types.StatusInternalPending {
+		return ErrInvalidOplog
+	}
+
+	key, err := o.MarshalKey(dbPrefixToDBPrefixFailed(o.dbPrefix))
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := o.Marshal()
+	if err != nil {
+		return err
+	}
+
+	idx := &pttdb.Index{Keys: [][]byte{key}, UpdateTS: o.UpdateTS}
+	kvs := []*pttdb.KeyVal{{K: key, V: marshaled}}
+
+	_, err = tx.ForcePutAll(idxKey, idx, kvs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (o *Oplog) Get(id *types.PttID, isLocked bool) error {
 	if !isLocked {
 		err := o.dbLock.RLock(id)
@@ -368,6 +437,10 @@ func (o *Oplog) Get(id *types.PttID, isLocked bool) error {
 		return err
 	}
 
+	if isPrunedOplogKey(key) {
+		return ErrOplogPruned
+	}
+
 	return o.Load(key)
 }
 
@@ -546,7 +619,9 @@ func (o *Oplog) Sign(keyInfo *KeyInfo) error {
 	o.NewestLog = nil
 	o.Extra = nil
 
-	marshaled, err := o.Marshal()
+	o.SignV = CurrentOplogSignVersion
+
+	marshaled, err := o.SignBytes()
 	if err != nil {
 		return err
 	}
@@ -588,16 +663,21 @@ func (o *Oplog) SignsHash() ([]byte, error) {
 	theBytes[offset], theBytes[offset+1], theBytes[offset+2], theBytes[offset+3] = o.DoerHash, o.Salt[:], o.Sig, o.Pubkey
 	offset += 4
 
-	// XXX need to do clone-and-sort
+	// masters / internals, in order in OplogSignVersionRLP,
+	// and as-is in OplogSignVersionJSON (the legacy nodes hash the signs in the received order).
+	masterSigns, internalSigns := o.MasterSigns, o.InternalSigns
+	if o.SignV != OplogSignVersionJSON {
+		masterSigns, internalSigns = sortSignInfos(masterSigns), sortSignInfos(internalSigns)
+	}
+
 	// masters
-	for _, eachSign := range o.MasterSigns {
+	for _, eachSign := range masterSigns {
 		theBytes[offset], theBytes[offset+1], theBytes[offset+2], theBytes[offset+3] = eachSign.Hash, eachSign.Salt[:], eachSign.Sig, eachSign.Pubkey
 		offset += 4
 	}
 
-	// XXX need to do clone-and-sort
 	// internals
-	for _, eachSign := range o.InternalSigns {
+	for _, eachSign := range internalSigns {
 		theBytes[offset], theBytes[offset+1], theBytes[offset+2], theBytes[offset+3] = eachSign.Hash, eachSign.Salt[:], eachSign.Sig, eachSign.Pubkey
 		offset += 4
 	}
@@ -647,7 +727,7 @@ func (o *Oplog) MasterSign(id *types.PttID, keyInfo *KeyInfo) error {
 	o.Extra = nil
 
 	// sign
-	marshaled, err := o.Marshal()
+	marshaled, err := o.SignBytes()
 	if err != nil {
 		return err
 	}
@@ -670,11 +750,8 @@ func (o *Oplog) MasterSign(id *types.PttID, keyInfo *KeyInfo) error {
 	copy(masterSign.Salt[:], bytesWithSalt[len(marshaled):])
 
 	// post-sign
-	// XXX master-signs in order
-	idx := sort.Search(len(origMasterSigns), func(i int) bool {
-		return bytes.Compare(origMasterSigns[i].ID[:], masterSign.ID[:]) > 0
-	})
-	o.MasterSigns = append(append(origMasterSigns[:idx], masterSign), origMasterSigns[idx:]...)
+	// master-signs in order
+	o.MasterSigns = insertSignInfo(origMasterSigns, masterSign)
 
 	o.UpdateTS = ts
 	o.Hash, _ = o.SignsHash()
@@ -718,7 +795,7 @@ func (o *Oplog) InternalSign(id *types.PttID, keyInfo *KeyInfo) error {
 	o.Extra = nil
 
 	// sign
-	marshaled, err := o.Marshal()
+	marshaled, err := o.SignBytes()
 	if err != nil {
 		return err
 	}
@@ -741,11 +818,8 @@ func (o *Oplog) InternalSign(id *types.PttID, keyInfo *KeyInfo) error {
 	copy(internalSign.Salt[:], bytesWithSalt[len(marshaled):])
 
 	// post-sign
-	// XXX internal-signs in order
-	idx := sort.Search(len(origInternalSigns), func(i int) bool {
-		return bytes.Compare(origInternalSigns[i].ID[:], internalSign.ID[:]) > 0
-	})
-	o.InternalSigns = append(append(origInternalSigns[:idx], internalSign), origInternalSigns[idx:]...)
+	// internal-signs in order
+	o.InternalSigns = insertSignInfo(origInternalSigns, internalSign)
 
 	o.UpdateTS = ts
 	o.Hash, _ = o.SignsHash()
@@ -773,6 +847,11 @@ func (o *Oplog) Verify() error {
 		return ErrInvalidData
 	}
 
+	if o.SignV != OplogSignVersionJSON && !(isSortedSignInfos(o.MasterSigns) && isSortedSignInfos(o.InternalSigns)) {
+		log.Warn("Verify: signs not in order")
+		return ErrInvalidSignOrder
+	}
+
 	origUpdateTS := o.UpdateTS
 	origHash, origDoerHash, origSalt, origSig, origPubBytes, origKeyExtra := o.Hash, o.DoerHash, o.Salt, o.Sig, o.Pubkey, o.KeyExtra
 	origMasterLogID, origMasterSigns, origInternalSigns := o.MasterLogID, o.MasterSigns, o.InternalSigns
@@ -804,7 +883,7 @@ func (o *Oplog) Verify() error {
 	o.NewestLog = nil
 	o.Extra = nil
 
-	marshaled, err := o.Marshal()
+	marshaled, err := o.SignBytes()
 	if err != nil {
 		return err
 	}
@@ -826,7 +905,7 @@ func (o *Oplog) Verify() error {
 	// master signs
 	if origMasterSigns != nil {
 		for _, masterSign := range origMasterSigns {
-			marshaled, err = o.Marshal()
+			marshaled, err = o.SignBytes()
 			if err != nil {
 				return err
 			}
@@ -843,7 +922,7 @@ func (o *Oplog) Verify() error {
 	// internal signs
 	if origInternalSigns != nil {
 		for _, internalSign := range origInternalSigns {
-			marshaled, err = o.Marshal()
+			marshaled, err = o.SignBytes()
 			if err != nil {
 				return err
 			}
@@ -1103,39 +1182,25 @@ func integrateSignInfos(signInfos []*SignInfo, origSignInfos []*SignInfo) ([]*Si
 	newSignInfos := make([]*SignInfo, 0, lenSignInfos+lenOrigSignInfos)
 	idx := 0
 	idxOrig := 0
-	signInfoID := signInfos[idx].ID
-	origSignInfoID := origSignInfos[idxOrig].ID
 	var cmp int
 
-loop:
-	for {
-		cmp = bytes.Compare(signInfoID[:], origSignInfoID[:])
+	for idx < lenSignInfos && idxOrig < lenOrigSignInfos {
+		cmp = bytes.Compare(signInfos[idx].ID[:], origSignInfos[idxOrig].ID[:])
 		switch {
 		case cmp < 0:
 			newSignInfos = append(newSignInfos, signInfos[idx])
 			idx++
-			if idx >= lenSignInfos {
-				break loop
-			}
-			signInfoID = signInfos[idx].ID
 		case cmp > 0:
-			newSignInfos = append(newSignInfos, origSignInfos[idx])
+			newSignInfos = append(newSignInfos, origSignInfos[idxOrig])
 			idxOrig++
-			if idx >= lenOrigSignInfos {
-				break loop
-			}
-			origSignInfoID = origSignInfos[idxOrig].ID
-		case cmp == 0:
+		default:
 			newSignInfos = append(newSignInfos, signInfos[idx])
 			idx++
 			idxOrig++
-			if idx >= lenSignInfos || idxOrig >= lenOrigSignInfos {
-				break loop
-			}
-			signInfoID = signInfos[idx].ID
-			origSignInfoID = origSignInfos[idxOrig].ID
 		}
 	}
+	newSignInfos = append(newSignInfos, signInfos[idx:]...)
+	newSignInfos = append(newSignInfos, origSignInfos[idxOrig:]...)
 
 	return newSignInfos, len(newSignInfos) == lenSignInfos, len(newSignInfos) == lenOrigSignInfos, nil
 }
@@ -1162,12 +1227,21 @@ func dbPrefixToDBPrefixMaster(prefix []byte) []byte {
 	return dbPrefixMaster
 }
 
+func dbPrefixToDBPrefixFailed(prefix []byte) []byte {
+	dbPrefixFailed := common.CloneBytes(prefix)
+	dbPrefixFailed[pttdb.SizeDBKeyPrefix-1] = 'f'
+
+	return dbPrefixFailed
+}
+
 func bytesToStatus(theBytes []byte) types.Status {
 	switch theBytes[pttdb.SizeDBKeyPrefix-1] {
 	case 'i':
 		return types.StatusInternalPending
 	case 'm':
 		return types.StatusPending
+	case 'f':
+		return types.StatusFailed
 	}
 
 	return types.StatusAlive
@@ -0,0 +1,421 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+/*
+OplogAuditType describes a kind of oplogs that can be browsed in the audit-log and compacted:
+how to set the db of the oplogs of an entity, how to name the ops,
+and whether a later oplog fully supersedes an earlier one (nil if never).
+*/
+type OplogAuditType struct {
+	Name        string
+	OpNames     map[OpType]string
+	SetDB       func(pm ProtocolManager, log *Oplog)
+	IsSupersede func(log *Oplog, prev *Oplog) bool
+}
+
+func (t *OplogAuditType) OpName(op OpType) string {
+	return t.OpNames[op]
+}
+
+type OplogAuditFilter struct {
+	Status  types.Status    `json:"S,omitempty"` // StatusInvalid for all the statuses
+	DoerID  *types.PttID    `json:"DID,omitempty"`
+	Ops     []OpType        `json:"O,omitempty"`
+	StartTS types.Timestamp `json:"ST"`
+	EndTS   types.Timestamp `json:"ET"`
+}
+
+/*
+IsMatch checks the doer, the op and the create-ts of the oplog.
+Zero start-ts / end-ts means unbounded, and both bounds are inclusive.
+*/
+func (f *OplogAuditFilter) IsMatch(log *Oplog) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.DoerID != nil && (log.DoerID == nil || *f.DoerID != *log.DoerID) {
+		return false
+	}
+
+	if len(f.Ops) != 0 {
+		isFound := false
+		for _, op := range f.Ops {
+			if op == log.Op {
+				isFound = true
+				break
+			}
+		}
+		if !isFound {
+			return false
+		}
+	}
+
+	if f.StartTS != types.ZeroTimestamp && log.CreateTS.IsLess(f.StartTS) {
+		return false
+	}
+
+	if f.EndTS != types.ZeroTimestamp && f.EndTS.IsLess(log.CreateTS) {
+		return false
+	}
+
+	return true
+}
+
+/*
+BackendOplogAudit is the audit-view of an oplog, including the results of
+the signature verification (Verify) and the pre-log chain check (CheckPreLog).
+*/
+type BackendOplogAudit struct {
+	ID       *types.PttID    `json:"ID"`
+	Op       OpType          `json:"O"`
+	OpName   string          `json:"ON"`
+	ObjID    *types.PttID    `json:"OID"`
+	PreLogID *types.PttID    `json:"p,omitempty"`
+	CreateTS types.Timestamp `json:"CT"`
+	UpdateTS types.Timestamp `json:"UT"`
+
+	DoerID   *types.PttID `json:"DID"`
+	DoerName []byte       `json:"DN,omitempty"`
+
+	MasterLogID     *types.PttID   `json:"mID,omitempty"`
+	MasterSigners   []*types.PttID `json:"m,omitempty"`
+	InternalSigners []*types.PttID `json:"i,omitempty"`
+
+	Status types.Status `json:"S"`
+	IsSync types.Bool   `json:"y"`
+
+	IsVerified bool   `json:"V"`
+	VerifyErr  string `json:"VE,omitempty"`
+
+	IsValidPreLog bool   `json:"P"`
+	PreLogErr     string `json:"PE,omitempty"`
+}
+
+var (
+	MasterOplogAuditType = &OplogAuditType{
+		Name: "master",
+		OpNames: map[OpType]string{
+			MasterOpTypeAddMaster:         "AddMaster",
+			MasterOpTypeRevokeMaster:      "RevokeMaster",
+			MasterOpTypeSetApprovalPolicy: "SetApprovalPolicy",
+		},
+		SetDB: func(pm ProtocolManager, log *Oplog) {
+			log.SetDB(dbOplog, pm.Entity().GetID(), DBMasterOplogPrefix, DBMasterIdxOplogPrefix, DBMasterMerkleOplogPrefix, DBMasterLockMap)
+		},
+		IsSupersede: isSupersedeMasterOplog,
+	}
+
+	OpKeyOplogAuditType = &OplogAuditType{
+		Name: "opKey",
+		OpNames: map[OpType]string{
+			OpKeyOpTypeAddKey:    "AddKey",
+			OpKeyOpTypeRevokeKey: "RevokeKey",
+		},
+		SetDB: func(pm ProtocolManager, log *Oplog) {
+			log.SetDB(pm.DBOpKeyInfo(), pm.Entity().GetID(), DBOpKeyOplogPrefix, DBOpKeyIdxOplogPrefix, DBOpKeyMerkleOplogPrefix, pm.DBOpKeyLock())
+		},
+		IsSupersede: isSupersedeOpKeyOplog,
+	}
+
+	oplogAuditTypesLock sync.RWMutex
+	oplogAuditTypes     = map[string]*OplogAuditType{
+		MasterOplogAuditType.Name: MasterOplogAuditType,
+		OpKeyOplogAuditType.Name:  OpKeyOplogAuditType,
+	}
+)
+
+/*
+RegisterOplogAuditType registers the oplog-type to be browsed in the audit-log.
+*/
+func RegisterOplogAuditType(t *OplogAuditType) error {
+	if t == nil || t.Name == "" || t.SetDB == nil {
+		return ErrInvalidOplogAuditType
+	}
+
+	oplogAuditTypesLock.Lock()
+	defer oplogAuditTypesLock.Unlock()
+
+	if _, ok := oplogAuditTypes[t.Name]; ok {
+		return ErrInvalidOplogAuditType
+	}
+
+	oplogAuditTypes[t.Name] = t
+
+	return nil
+}
+
+/*
+isSupersedeMasterOplog: add-master / revoke-master carry the whole masters,
+and set-approval-policy carries the whole policies.
+*/
+func isSupersedeMasterOplog(log *Oplog, prev *Oplog) bool {
+	if log.ObjID == nil || prev.ObjID == nil || *log.ObjID != *prev.ObjID {
+		return false
+	}
+
+	isPolicy := log.Op == MasterOpTypeSetApprovalPolicy
+	isPrevPolicy := prev.Op == MasterOpTypeSetApprovalPolicy
+
+	return isPolicy == isPrevPolicy
+}
+
+/*
+isSupersedeOpKeyOplog: revoke-key supersedes add-key of the same key.
+*/
+func isSupersedeOpKeyOplog(log *Oplog, prev *Oplog) bool {
+	if log.ObjID == nil || prev.ObjID == nil || *log.ObjID != *prev.ObjID {
+		return false
+	}
+
+	return log.Op == OpKeyOpTypeRevokeKey
+}
+
+func GetOplogAuditType(name string) (*OplogAuditType, error) {
+	oplogAuditTypesLock.RLock()
+	defer oplogAuditTypesLock.RUnlock()
+
+	t, ok := oplogAuditTypes[name]
+	if !ok {
+		return nil, ErrInvalidOplogAuditType
+	}
+
+	return t, nil
+}
+
+/*
+getOplogAuditTypes returns the registered oplog-types ordered by name.
+*/
+func getOplogAuditTypes() []*OplogAuditType {
+	oplogAuditTypesLock.RLock()
+	ts := make([]*OplogAuditType, 0, len(oplogAuditTypes))
+	for _, t := range oplogAuditTypes {
+		ts = append(ts, t)
+	}
+	oplogAuditTypesLock.RUnlock()
+
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Name < ts[j].Name
+	})
+
+	return ts
+}
+
+/*
+AuditOplogs lists the valid, pending, internal-pending and failed oplogs matching the filter,
+ordered by update-ts (the order of the oplogs in the db), starting from startID (included) if not nil.
+
+The oplogs of the statuses are merged while iterating in listOrder, and the iteration stops at limit.
+*/
+func AuditOplogs(setDB func(log *Oplog), opNames map[OpType]string, filter *OplogAuditFilter, startID *types.PttID, limit int, listOrder pttdb.ListOrder) ([]*BackendOplogAudit, error) {
+
+	statuses := []types.Status{types.StatusAlive, types.StatusPending, types.StatusInternalPending, types.StatusFailed}
+	if filter != nil && filter.Status != types.StatusInvalid {
+		statuses = []types.Status{filter.Status}
+	}
+
+	template := &Oplog{}
+	setDB(template)
+
+	// start: update-ts + id of the start-log
+	var start []byte
+	if startID != nil {
+		startLog := &Oplog{}
+		setDB(startLog)
+		err := startLog.Get(startID, false)
+		if err != nil {
+			return nil, err
+		}
+
+		marshaledTS, err := startLog.UpdateTS.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		start = append(marshaledTS, startID[:]...)
+	}
+
+	// iters
+	iters := make([]*oplogAuditIter, 0, len(statuses))
+	defer func() {
+		for _, iter := range iters {
+			iter.iter.Release()
+		}
+	}()
+	for _, status := range statuses {
+		iter, err := newOplogAuditIter(template, status, start, listOrder)
+		if err != nil {
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+
+	// merge
+	existIDs := make(map[types.PttID]*Oplog)
+	audits := make([]*BackendOplogAudit, 0)
+	for limit <= 0 || len(audits) < limit {
+		var iter *oplogAuditIter
+		for _, each := range iters {
+			if !each.isValid {
+				continue
+			}
+			if iter == nil || each.isBefore(iter, listOrder) {
+				iter = each
+			}
+		}
+		if iter == nil {
+			break
+		}
+
+		log := &Oplog{}
+		err := log.Unmarshal(iter.iter.Value())
+		status := iter.status
+		iter.next()
+		if err != nil {
+			continue
+		}
+		setDB(log)
+
+		if !filter.IsMatch(log) {
+			continue
+		}
+
+		audit := auditOplog(log, setDB, opNames, existIDs)
+		audit.Status = status
+		audits = append(audits, audit)
+	}
+
+	return audits, nil
+}
+
+/*
+oplogAuditIter iterates the oplogs of the status in AuditOplogs.
+key is the key of the current oplog without the db-prefix (update-ts + id + op).
+*/
+type oplogAuditIter struct {
+	status types.Status
+
+	iter      iterator.Iterator
+	funcIter  func() bool
+	lenPrefix int
+
+	isValid bool
+	key     []byte
+}
+
+func newOplogAuditIter(template *Oplog, status types.Status, start []byte, listOrder pttdb.ListOrder) (*oplogAuditIter, error) {
+	prefix, err := DBPrefix(dbPrefixWithStatus(template.GetDBPrefix(), status), template.GetDBPrefxiID())
+	if err != nil {
+		return nil, err
+	}
+
+	var startKey []byte
+	if start != nil {
+		startKey = common.CloneBytes(prefix)
+		startKey = append(startKey, start...)
+	}
+
+	iter, err := template.GetDB().DB().NewIteratorWithPrefix(startKey, prefix, listOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &oplogAuditIter{
+		status:    status,
+		iter:      iter,
+		funcIter:  pttdb.GetFuncIter(iter, listOrder),
+		lenPrefix: len(prefix),
+	}
+	i.next()
+
+	return i, nil
+}
+
+func (i *oplogAuditIter) next() {
+	i.isValid = i.funcIter()
+	if i.isValid {
+		i.key = common.CloneBytes(i.iter.Key()[i.lenPrefix:])
+	}
+}
+
+func (i *oplogAuditIter) isBefore(j *oplogAuditIter, listOrder pttdb.ListOrder) bool {
+	cmp := bytes.Compare(i.key, j.key)
+	if listOrder == pttdb.ListOrderPrev {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func auditOplog(log *Oplog, setDB func(log *Oplog), opNames map[OpType]string, existIDs map[types.PttID]*Oplog) *BackendOplogAudit {
+	audit := &BackendOplogAudit{
+		ID:       log.ID,
+		Op:       log.Op,
+		OpName:   opNames[log.Op],
+		ObjID:    log.ObjID,
+		PreLogID: log.PreLogID,
+		CreateTS: log.CreateTS,
+		UpdateTS: log.UpdateTS,
+
+		DoerID: log.DoerID,
+
+		MasterLogID:     log.MasterLogID,
+		MasterSigners:   signInfosToIDs(log.MasterSigns),
+		InternalSigners: signInfosToIDs(log.InternalSigns),
+
+		Status: log.ToStatus(),
+		IsSync: log.IsSync,
+	}
+
+	err := log.Verify()
+	audit.IsVerified = err == nil
+	if err != nil {
+		audit.VerifyErr = err.Error()
+	}
+
+	prelog := &Oplog{}
+	setDB(prelog)
+	err = CheckPreLog(log, prelog, existIDs)
+	audit.IsValidPreLog = err == nil
+	if err != nil {
+		audit.PreLogErr = err.Error()
+	}
+
+	return audit
+}
+
+func signInfosToIDs(signInfos []*SignInfo) []*types.PttID {
+	if len(signInfos) == 0 {
+		return nil
+	}
+
+	ids := make([]*types.PttID, len(signInfos))
+	for i, signInfo := range signInfos {
+		ids[i] = signInfo.ID
+	}
+	return ids
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+func TestAuditOplogs(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	keyB, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	idB, _ := types.NewPttIDFromKey(keyB)
+	keyInfoB := &KeyInfo{
+		Key:         keyB,
+		KeyBytes:    crypto.FromECDSA(keyB),
+		PubKeyBytes: crypto.FromECDSAPub(&keyB.PublicKey),
+	}
+
+	setDB := func(log *Oplog) {
+		log.SetDB(tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+	}
+
+	newOplog := func(ts types.Timestamp, doerID *types.PttID, op OpType, keyInfo *KeyInfo) *Oplog {
+		o, err := NewOplog(tDefaultID, ts, doerID, op, nil, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+		if err != nil {
+			t.Fatalf("NewOplog: e: %v", err)
+		}
+		err = o.Sign(keyInfo)
+		if err != nil {
+			t.Fatalf("Sign: e: %v", err)
+		}
+		return o
+	}
+
+	ts1 := types.Timestamp{Ts: 1234567890, NanoTs: 123}
+	ts2 := ts1
+	ts2.Ts++
+	ts3 := ts2
+	ts3.Ts++
+
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return ts3, nil
+	}
+
+	// valid
+	log1 := newOplog(ts1, idB, MasterOpTypeAddMaster, keyInfoB)
+	log1.SetMasterLogID(log1.ID, 1)
+
+	// pending with the valid pre-log
+	log2 := newOplog(ts2, tUserIDMe, MasterOpTypeRevokeMaster, tKeyInfoMe)
+	log2.PreLogID = log1.ID
+	log2.Sign(tKeyInfoMe)
+	err := log2.MasterSign(idB, keyInfoB)
+	if err != nil {
+		t.Errorf("MasterSign: e: %v", err)
+	}
+
+	// tampered, with the missing pre-log
+	log3 := newOplog(ts3, idB, MasterOpTypeRevokeMaster, keyInfoB)
+	log3.PreLogID = tDefaultID
+	log3.Sign(keyInfoB)
+	log3.SetMasterLogID(log3.ID, 1)
+	log3.ObjID = tUserIDMe
+
+	for _, log := range []*Oplog{log1, log2, log3} {
+		err = log.Save(false)
+		if err != nil {
+			t.Errorf("Save: e: %v", err)
+		}
+	}
+
+	opNames := MasterOplogAuditType.OpNames
+
+	// all
+	audits, err := AuditOplogs(setDB, opNames, nil, nil, 0, pttdb.ListOrderNext)
+	if err != nil {
+		t.Errorf("AuditOplogs: e: %v", err)
+	}
+	if len(audits) != 3 {
+		t.Fatalf("AuditOplogs: len = %v, want 3", len(audits))
+	}
+
+	type auditResult struct {
+		ID            *types.PttID
+		OpName        string
+		Status        types.Status
+		IsVerified    bool
+		IsValidPreLog bool
+		MasterSigners []*types.PttID
+	}
+	want := []auditResult{
+		{log1.ID, "AddMaster", types.StatusAlive, true, true, nil},
+		{log2.ID, "RevokeMaster", types.StatusPending, true, true, []*types.PttID{idB}},
+		{log3.ID, "RevokeMaster", types.StatusAlive, false, false, nil},
+	}
+	for i, audit := range audits {
+		got := auditResult{audit.ID, audit.OpName, audit.Status, audit.IsVerified, audit.IsValidPreLog, audit.MasterSigners}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("AuditOplogs (%v): got = %v, want %v", i, got, want[i])
+		}
+	}
+
+	// filters
+	tests := []struct {
+		name      string
+		filter    *OplogAuditFilter
+		startID   *types.PttID
+		limit     int
+		listOrder pttdb.ListOrder
+		want      []*types.PttID
+	}{
+		{
+			name:      "doer",
+			filter:    &OplogAuditFilter{DoerID: idB},
+			listOrder: pttdb.ListOrderNext,
+			want:      []*types.PttID{log1.ID, log3.ID},
+		},
+		{
+			name:      "op",
+			filter:    &OplogAuditFilter{Ops: []OpType{MasterOpTypeRevokeMaster}},
+			listOrder: pttdb.ListOrderNext,
+			want:      []*types.PttID{log2.ID, log3.ID},
+		},
+		{
+			name:      "time-range",
+			filter:    &OplogAuditFilter{StartTS: ts2, EndTS: ts2},
+			listOrder: pttdb.ListOrderNext,
+			want:      []*types.PttID{log2.ID},
+		},
+		{
+			name:      "prev with limit",
+			limit:     2,
+			listOrder: pttdb.ListOrderPrev,
+			want:      []*types.PttID{log3.ID, log2.ID},
+		},
+		{
+			name:      "doer with limit",
+			filter:    &OplogAuditFilter{DoerID: idB},
+			limit:     1,
+			listOrder: pttdb.ListOrderNext,
+			want:      []*types.PttID{log1.ID},
+		},
+		{
+			name:      "next page",
+			startID:   log2.ID,
+			listOrder: pttdb.ListOrderNext,
+			want:      []*types.PttID{log2.ID, log3.ID},
+		},
+		{
+			name:      "prev page",
+			startID:   log2.ID,
+			limit:     2,
+			listOrder: pttdb.ListOrderPrev,
+			want:      []*types.PttID{log2.ID, log1.ID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audits, err := AuditOplogs(setDB, opNames, tt.filter, tt.startID, tt.limit, tt.listOrder)
+			if err != nil {
+				t.Errorf("AuditOplogs: e: %v", err)
+			}
+			got := make([]*types.PttID, len(audits))
+			for i, audit := range audits {
+				got[i] = audit.ID
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AuditOplogs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+PrunedOplog is the audit-record kept for the oplog removed in the compaction.
+
+The idx-key of the pruned oplog is kept, pointing to the pruned-oplog,
+so that the pruned oplog is not integrated again from the peers.
+*/
+type PrunedOplog struct {
+	ID       *types.PttID    `json:"ID"`
+	ObjID    *types.PttID    `json:"OID"`
+	Op       OpType          `json:"O"`
+	DoerID   *types.PttID    `json:"DID"`
+	CreateTS types.Timestamp `json:"CT"`
+	UpdateTS types.Timestamp `json:"UT"`
+	Hash     []byte          `json:"H"`
+}
+
+/*
+OplogSnapshot is the signed state of the oplogs at CutoffTS after the compaction.
+
+Oplogs are the valid oplogs created before CutoffTS and not superseded,
+so that the newly joining peers can bootstrap from the snapshot
+and sync only the oplogs after CutoffTS.
+
+AuditHash chains the ids / hashes of all the pruned oplogs since the first compaction.
+*/
+type OplogSnapshot struct {
+	V        types.Version
+	PrefixID *types.PttID    `json:"pID"`
+	DBPrefix []byte          `json:"P"`
+	CreateTS types.Timestamp `json:"CT"`
+	CutoffTS types.Timestamp `json:"cT"`
+
+	Oplogs []*Oplog `json:"L"`
+
+	NPruned   uint64 `json:"n"`
+	AuditHash []byte `json:"A"`
+
+	Sign *SignInfo `json:"s,omitempty"`
+}
+
+func (s *OplogSnapshot) SignBytes() ([]byte, error) {
+	origSign := s.Sign
+	defer func() {
+		s.Sign = origSign
+	}()
+	s.Sign = nil
+
+	return CanonicalJSON(s)
+}
+
+func (s *OplogSnapshot) DoSign(id *types.PttID, keyInfo *KeyInfo) error {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := s.SignBytes()
+	if err != nil {
+		return err
+	}
+
+	bytesWithSalt, hash, sig, pubBytes, err := SignData(marshaled, keyInfo)
+	if err != nil {
+		return err
+	}
+
+	sign := &SignInfo{
+		ID:       id,
+		CreateTS: ts,
+
+		Hash:   hash,
+		Sig:    sig,
+		Pubkey: pubBytes,
+		Extra:  keyInfo.Extra,
+	}
+	copy(sign.Salt[:], bytesWithSalt[len(marshaled):])
+
+	s.Sign = sign
+
+	return nil
+}
+
+/*
+Verify verifies the sign of the snapshot and all the oplogs in the snapshot.
+*/
+func (s *OplogSnapshot) Verify() error {
+	if s.Sign == nil || s.PrefixID == nil {
+		return ErrInvalidOplogSnapshot
+	}
+
+	marshaled, err := s.SignBytes()
+	if err != nil {
+		return err
+	}
+	bytesWithSalt := append(marshaled, s.Sign.Salt[:]...)
+
+	err = VerifyData(bytesWithSalt, s.Sign.Sig, s.Sign.Pubkey, s.Sign.ID, s.Sign.Extra)
+	if err != nil {
+		return err
+	}
+
+	for _, oplog := range s.Oplogs {
+		if oplog.MasterLogID == nil || !oplog.CreateTS.IsLess(s.CutoffTS) {
+			return ErrInvalidOplogSnapshot
+		}
+
+		err = oplog.Verify()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+CompactOplogs prunes the valid oplogs created before cutoffTS
+and superseded by later valid oplogs (isSupersede(log, prev)),
+together with their merkle-leaves.
+
+The merkle-nodes of the upper levels (hr / day / month / year) are kept for the audit.
+The snapshot of the remaining oplogs before cutoffTS is signed and saved.
+*/
+func CompactOplogs(setDB func(log *Oplog), isSupersede func(log *Oplog, prev *Oplog) bool, cutoffTS types.Timestamp, myID *types.PttID, keyInfo *KeyInfo) (*OplogSnapshot, error) {
+
+	template := &Oplog{}
+	setDB(template)
+
+	prevSnapshot, err := GetOplogSnapshot(setDB)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+
+	// all the valid oplogs
+	iter, err := GetOplogIter(template.GetDB(), template.GetDBPrefix(), template.GetDBIdxPrefix(), template.GetDBMerklePrefix(), template.GetDBPrefxiID(), nil, template.GetDBLock(), false, types.StatusAlive, pttdb.ListOrderNext)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*Oplog, 0)
+	for iter.Next() {
+		eachLog := &Oplog{}
+		err = eachLog.Unmarshal(iter.Value())
+		if err != nil {
+			continue
+		}
+		setDB(eachLog)
+		logs = append(logs, eachLog)
+	}
+	iter.Release()
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		if !logs[i].CreateTS.IsEqual(logs[j].CreateTS) {
+			return logs[i].CreateTS.IsLess(logs[j].CreateTS)
+		}
+		return bytes.Compare(logs[i].ID[:], logs[j].ID[:]) < 0
+	})
+
+	// superseded
+	superseded := make(map[types.PttID]bool)
+	latests := make([]*Oplog, 0)
+	for _, eachLog := range logs {
+		newLatests := latests[:0]
+		for _, prev := range latests {
+			if isSupersede(eachLog, prev) {
+				superseded[*prev.ID] = true
+				continue
+			}
+			newLatests = append(newLatests, prev)
+		}
+		latests = append(newLatests, eachLog)
+	}
+
+	// snapshot
+	snapshot := &OplogSnapshot{
+		V:        types.CurrentVersion,
+		PrefixID: template.GetDBPrefxiID(),
+		DBPrefix: template.GetDBPrefix(),
+		CutoffTS: cutoffTS,
+		Oplogs:   make([]*Oplog, 0),
+	}
+	if prevSnapshot != nil {
+		snapshot.NPruned = prevSnapshot.NPruned
+		snapshot.AuditHash = prevSnapshot.AuditHash
+	}
+
+	tx := template.GetDB().NewTx()
+	defer tx.Rollback()
+
+	for _, eachLog := range logs {
+		if !eachLog.CreateTS.IsLess(cutoffTS) {
+			break
+		}
+
+		if !superseded[*eachLog.ID] || !eachLog.UpdateTS.IsLess(cutoffTS) {
+			snapshot.Oplogs = append(snapshot.Oplogs, eachLog)
+			continue
+		}
+
+		err = pruneOplog(tx, eachLog)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.NPruned++
+		snapshot.AuditHash = crypto.Keccak256(snapshot.AuditHash, eachLog.ID[:], eachLog.Hash)
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.CreateTS = ts
+
+	err = snapshot.DoSign(myID, keyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putOplogSnapshot(tx, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("CompactOplogs: done", "prefix", snapshot.DBPrefix, "prefixID", snapshot.PrefixID, "oplogs", len(snapshot.Oplogs), "pruned", snapshot.NPruned)
+
+	return snapshot, nil
+}
+
+func pruneOplog(tx *pttdb.LDBTx, oplog *Oplog) error {
+	idxKey, err := oplog.IdxKey()
+	if err != nil {
+		return err
+	}
+
+	prefix, err := common.Concat([][]byte{DBPrunedOplogPrefix, oplog.GetDBPrefix()})
+	if err != nil {
+		return err
+	}
+
+	key, err := oplog.MarshalKey(prefix)
+	if err != nil {
+		return err
+	}
+
+	pruned := &PrunedOplog{
+		ID:       oplog.ID,
+		ObjID:    oplog.ObjID,
+		Op:       oplog.Op,
+		DoerID:   oplog.DoerID,
+		CreateTS: oplog.CreateTS,
+		UpdateTS: oplog.UpdateTS,
+		Hash:     oplog.Hash,
+	}
+	marshaled, err := json.Marshal(pruned)
+	if err != nil {
+		return err
+	}
+
+	idx := &pttdb.Index{Keys: [][]byte{key}, UpdateTS: oplog.UpdateTS}
+	kvs := []*pttdb.KeyVal{{K: key, V: marshaled}}
+
+	_, err = tx.ForcePutAll(idxKey, idx, kvs)
+	return err
+}
+
+func isPrunedOplogKey(key []byte) bool {
+	return bytes.HasPrefix(key, DBPrunedOplogPrefix)
+}
+
+/*
+ApplyOplogSnapshot bootstraps the oplogs from the snapshot.
+isValidSigner checks whether the signer of the snapshot is trusted (ex: the master).
+*/
+func ApplyOplogSnapshot(setDB func(log *Oplog), snapshot *OplogSnapshot, isValidSigner func(id *types.PttID) bool) error {
+	err := snapshot.Verify()
+	if err != nil {
+		return err
+	}
+
+	if !isValidSigner(snapshot.Sign.ID) {
+		return ErrInvalidOplogSnapshot
+	}
+
+	template := &Oplog{}
+	setDB(template)
+	if *snapshot.PrefixID != *template.GetDBPrefxiID() || !bytes.Equal(snapshot.DBPrefix, template.GetDBPrefix()) {
+		return ErrInvalidOplogSnapshot
+	}
+
+	prevSnapshot, err := GetOplogSnapshot(setDB)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	if prevSnapshot != nil && !prevSnapshot.CutoffTS.IsLess(snapshot.CutoffTS) {
+		return ErrInvalidOplogSnapshot
+	}
+
+	for _, oplog := range snapshot.Oplogs {
+		setDB(oplog)
+		oplog.IsSync = true
+		err = oplog.Save(false)
+		if err != nil && err != pttdb.ErrInvalidUpdateTS {
+			return err
+		}
+	}
+
+	tx := template.GetDB().NewTx()
+	defer tx.Rollback()
+
+	err = putOplogSnapshot(tx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetOplogSnapshot(setDB func(log *Oplog)) (*OplogSnapshot, error) {
+	template := &Oplog{}
+	setDB(template)
+
+	key, err := marshalOplogSnapshotKey(template.GetDBPrefix(), template.GetDBPrefxiID())
+	if err != nil {
+		return nil, err
+	}
+
+	marshaled, err := template.GetDB().DBGet(key)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &OplogSnapshot{}
+	err = json.Unmarshal(marshaled, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+func putOplogSnapshot(tx *pttdb.LDBTx, snapshot *OplogSnapshot) error {
+	marshaled, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	key, err := marshalOplogSnapshotKey(snapshot.DBPrefix, snapshot.PrefixID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Put(key, marshaled)
+}
+
+func marshalOplogSnapshotKey(dbPrefix []byte, prefixID *types.PttID) ([]byte, error) {
+	return common.Concat([][]byte{DBOplogSnapshotPrefix, dbPrefix, prefixID[:]})
+}
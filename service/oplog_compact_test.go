@@ -0,0 +1,190 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+func TestCompactOplogs(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	keyB, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	idB, _ := types.NewPttIDFromKey(keyB)
+	keyInfoB := &KeyInfo{
+		Key:         keyB,
+		KeyBytes:    crypto.FromECDSA(keyB),
+		PubKeyBytes: crypto.FromECDSAPub(&keyB.PublicKey),
+	}
+
+	setDB := func(log *Oplog) {
+		log.SetDB(tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+	}
+
+	newOplog := func(ts types.Timestamp, op OpType) *Oplog {
+		o, err := NewOplog(tDefaultID, ts, idB, op, nil, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+		if err != nil {
+			t.Fatalf("NewOplog: e: %v", err)
+		}
+		o.Sign(keyInfoB)
+		o.SetMasterLogID(o.ID, 1)
+		o.IsSync = true
+
+		err = o.Save(false)
+		if err != nil {
+			t.Fatalf("Save: e: %v", err)
+		}
+		return o
+	}
+
+	ts := types.Timestamp{Ts: 1234567890, NanoTs: 123}
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return ts, nil
+	}
+
+	log1 := newOplog(types.Timestamp{Ts: ts.Ts - 300}, MasterOpTypeAddMaster)
+	log2 := newOplog(types.Timestamp{Ts: ts.Ts - 200}, MasterOpTypeRevokeMaster)
+	log3 := newOplog(types.Timestamp{Ts: ts.Ts - 150}, MasterOpTypeSetApprovalPolicy)
+	log4 := newOplog(types.Timestamp{Ts: ts.Ts - 50}, MasterOpTypeAddMaster)
+
+	cutoffTS := types.Timestamp{Ts: ts.Ts - 100}
+
+	// compact
+	snapshot, err := CompactOplogs(setDB, isSupersedeMasterOplog, cutoffTS, tUserIDMe, tKeyInfoMe)
+	if err != nil {
+		t.Fatalf("CompactOplogs: e: %v", err)
+	}
+
+	if snapshot.NPruned != 2 {
+		t.Errorf("CompactOplogs: NPruned = %v, want 2", snapshot.NPruned)
+	}
+	if len(snapshot.Oplogs) != 1 || *snapshot.Oplogs[0].ID != *log3.ID {
+		t.Errorf("CompactOplogs: Oplogs = %v, want [%v]", snapshot.Oplogs, log3.ID)
+	}
+
+	wantAuditHash := crypto.Keccak256(crypto.Keccak256(nil, log1.ID[:], log1.Hash), log2.ID[:], log2.Hash)
+	if !reflect.DeepEqual(snapshot.AuditHash, wantAuditHash) {
+		t.Errorf("CompactOplogs: AuditHash = %v, want %v", snapshot.AuditHash, wantAuditHash)
+	}
+
+	err = snapshot.Verify()
+	if err != nil {
+		t.Errorf("OplogSnapshot.Verify: e: %v", err)
+	}
+
+	// sign-bytes as the canonical json of the snapshot without the sign
+	signBytes, err := snapshot.SignBytes()
+	if err != nil {
+		t.Errorf("OplogSnapshot.SignBytes: e: %v", err)
+	}
+	unsigned := *snapshot
+	unsigned.Sign = nil
+	wantSignBytes, _ := CanonicalJSON(&unsigned)
+	if !reflect.DeepEqual(signBytes, wantSignBytes) || signBytes[0] != '{' {
+		t.Errorf("OplogSnapshot.SignBytes: %s, want %s", signBytes, wantSignBytes)
+	}
+
+	// pruned
+	for _, each := range []*Oplog{log1, log2} {
+		o := &Oplog{}
+		setDB(o)
+		err = o.Get(each.ID, false)
+		if err != ErrOplogPruned {
+			t.Errorf("Get (pruned): e = %v, want %v", err, ErrOplogPruned)
+		}
+
+		_, err = each.IntegrateExisting(false)
+		if err != ErrOplogPruned {
+			t.Errorf("IntegrateExisting (pruned): e = %v, want %v", err, ErrOplogPruned)
+		}
+	}
+
+	for _, each := range []*Oplog{log3, log4} {
+		o := &Oplog{}
+		setDB(o)
+		err = o.Get(each.ID, false)
+		if err != nil {
+			t.Errorf("Get: e: %v", err)
+		}
+	}
+
+	// merkle-leaves
+	merkle, _ := NewMerkle(tDBOplogPrefix, tDBOplogMerklePrefix, tDefaultID, tDBOplog)
+	leaves, err := merkle.GetMerkleTreeListByLevel(MerkleTreeLevelNow, types.ZeroTimestamp, ts)
+	if err != nil {
+		t.Errorf("GetMerkleTreeListByLevel: e: %v", err)
+	}
+	if len(leaves) != 2 {
+		t.Errorf("GetMerkleTreeListByLevel: leaves = %v, want 2", len(leaves))
+	}
+
+	// saved snapshot
+	saved, err := GetOplogSnapshot(setDB)
+	if err != nil {
+		t.Errorf("GetOplogSnapshot: e: %v", err)
+	}
+	err = saved.Verify()
+	if err != nil {
+		t.Errorf("OplogSnapshot.Verify (saved): e: %v", err)
+	}
+
+	// tampered
+	saved.NPruned++
+	err = saved.Verify()
+	if err == nil {
+		t.Errorf("OplogSnapshot.Verify (tampered): expect err")
+	}
+
+	// bootstrap
+	dbCore := pttdb.NewMemLDBDatabase("oplog2")
+	defer dbCore.Close()
+	db, _ := pttdb.NewLDBBatch(dbCore)
+	setDB2 := func(log *Oplog) {
+		log.SetDB(db, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+	}
+
+	isNotSigner := func(id *types.PttID) bool { return false }
+	err = ApplyOplogSnapshot(setDB2, snapshot, isNotSigner)
+	if err != ErrInvalidOplogSnapshot {
+		t.Errorf("ApplyOplogSnapshot (invalid signer): e = %v, want %v", err, ErrInvalidOplogSnapshot)
+	}
+
+	isSigner := func(id *types.PttID) bool { return *id == *tUserIDMe }
+	err = ApplyOplogSnapshot(setDB2, snapshot, isSigner)
+	if err != nil {
+		t.Errorf("ApplyOplogSnapshot: e: %v", err)
+	}
+
+	o := &Oplog{}
+	setDB2(o)
+	err = o.Get(log3.ID, false)
+	if err != nil {
+		t.Errorf("Get (bootstrapped): e: %v", err)
+	}
+
+	err = ApplyOplogSnapshot(setDB2, snapshot, isSigner)
+	if err != ErrInvalidOplogSnapshot {
+		t.Errorf("ApplyOplogSnapshot (not newer): e = %v, want %v", err, ErrInvalidOplogSnapshot)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/rlp"
+)
+
+/*
+oplogSignContent is the signed part of the oplog in OplogSignVersionRLP.
+
+The ids are encoded as bytes (empty if nil), and Data and KeyExtra as the canonical json,
+so that the encoding depends only on the values, not on the go-types of Data or the order of the struct-fields.
+
+DoerHash, Salt, Sig, Pubkey and KeyExtra are empty for the sign of the doer,
+and are the sign of the doer for the master-signs and the internal-signs.
+*/
+type oplogSignContent struct {
+	SignV        uint8
+	V            uint8
+	ID           []byte
+	DoerID       []byte
+	CreateTS     uint64
+	CreateNanoTS uint32
+	ObjID        []byte
+	Op           uint32
+	PreLogID     []byte
+	Data         []byte
+
+	DoerHash []byte
+	Salt     []byte
+	Sig      []byte
+	Pubkey   []byte
+	KeyExtra []byte
+}
+
+/*
+SignBytes returns the bytes to be signed (without the salt), based on the SignV of the oplog.
+*/
+func (o *Oplog) SignBytes() ([]byte, error) {
+	switch o.SignV {
+	case OplogSignVersionJSON:
+		return o.Marshal()
+	case OplogSignVersionRLP:
+		return o.marshalSignContent()
+	}
+
+	return nil, ErrInvalidSignVersion
+}
+
+func (o *Oplog) marshalSignContent() ([]byte, error) {
+	data, err := CanonicalJSON(o.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyExtra []byte
+	if o.KeyExtra != nil {
+		keyExtra, err = CanonicalJSON(o.KeyExtra)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var salt []byte
+	if o.Salt != (types.Salt{}) {
+		salt = o.Salt[:]
+	}
+
+	content := &oplogSignContent{
+		SignV:        o.SignV,
+		V:            uint8(o.V),
+		ID:           pttIDToSignBytes(o.ID),
+		DoerID:       pttIDToSignBytes(o.DoerID),
+		CreateTS:     o.CreateTS.Ts,
+		CreateNanoTS: o.CreateTS.NanoTs,
+		ObjID:        pttIDToSignBytes(o.ObjID),
+		Op:           uint32(o.Op),
+		PreLogID:     pttIDToSignBytes(o.PreLogID),
+		Data:         data,
+
+		DoerHash: o.DoerHash,
+		Salt:     salt,
+		Sig:      o.Sig,
+		Pubkey:   o.Pubkey,
+		KeyExtra: keyExtra,
+	}
+
+	return rlp.EncodeToBytes(content)
+}
+
+func pttIDToSignBytes(id *types.PttID) []byte {
+	if id == nil {
+		return nil
+	}
+	return id[:]
+}
+
+/*
+CanonicalJSON returns the json of v with the keys of the objects sorted, without spaces and html-escaping.
+
+The numbers are kept as they are in the json of v, so a struct and the map unmarshaled from its json
+have the same canonical json, as long as the numbers are within the precision of float64.
+*/
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	marshaled, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(marshaled))
+	dec.UseNumber()
+
+	var generic interface{}
+	err = dec.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(generic)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+/*
+sortSignInfos returns the sign-infos sorted by ID (and Hash for the same ID), without modifying signInfos.
+*/
+func sortSignInfos(signInfos []*SignInfo) []*SignInfo {
+	sorted := make([]*SignInfo, len(signInfos))
+	copy(sorted, signInfos)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		cmp := bytes.Compare(pttIDToSignBytes(sorted[i].ID), pttIDToSignBytes(sorted[j].ID))
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return bytes.Compare(sorted[i].Hash, sorted[j].Hash) < 0
+	})
+
+	return sorted
+}
+
+/*
+insertSignInfo returns the new sign-infos with signInfo inserted in the order of ID,
+replacing the sign-info of the same ID, without modifying signInfos.
+*/
+func insertSignInfo(signInfos []*SignInfo, signInfo *SignInfo) []*SignInfo {
+	lenSignInfos := len(signInfos)
+	idx := sort.Search(lenSignInfos, func(i int) bool {
+		return bytes.Compare(signInfos[i].ID[:], signInfo.ID[:]) >= 0
+	})
+
+	newSignInfos := make([]*SignInfo, 0, lenSignInfos+1)
+	newSignInfos = append(newSignInfos, signInfos[:idx]...)
+	newSignInfos = append(newSignInfos, signInfo)
+	if idx < lenSignInfos && bytes.Equal(signInfos[idx].ID[:], signInfo.ID[:]) {
+		idx++
+	}
+	newSignInfos = append(newSignInfos, signInfos[idx:]...)
+
+	return newSignInfos
+}
+
+/*
+isSortedSignInfos checks that the sign-infos are in strictly increasing order of ID.
+*/
+func isSortedSignInfos(signInfos []*SignInfo) bool {
+	for i := 1; i < len(signInfos); i++ {
+		if signInfos[i-1].ID == nil || signInfos[i].ID == nil {
+			return false
+		}
+		if bytes.Compare(signInfos[i-1].ID[:], signInfos[i].ID[:]) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,327 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+)
+
+var (
+	tSignVectorTimestamp = types.Timestamp{Ts: 1234567890, NanoTs: 123}
+)
+
+type tSignVectorData struct {
+	Title []byte         `json:"T"`
+	N     uint64         `json:"N"`
+	IDs   []*types.PttID `json:"IDs"`
+	Name  string         `json:"n"`
+}
+
+/*
+tSignVector is the cross-version test-vector of the signed oplogs.
+
+SignBytes is the bytes signed by the doer (without the salt),
+and MasterSignBytes is the bytes signed by the masters (without the salt).
+*/
+type tSignVector struct {
+	Name            string          `json:"name"`
+	Oplog           json.RawMessage `json:"oplog"`
+	SignBytes       string          `json:"signBytes"`
+	MasterSignBytes string          `json:"masterSignBytes"`
+	Hash            string          `json:"hash"`
+}
+
+func tNewSignVectorOplogs(t *testing.T) []*Oplog {
+	origSignVersion := CurrentOplogSignVersion
+	CurrentOplogSignVersion = OplogSignVersionRLP
+	defer func() {
+		CurrentOplogSignVersion = origSignVersion
+	}()
+
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return tSignVectorTimestamp, nil
+	}
+
+	keyB, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	idB, _ := types.NewPttIDFromKey(keyB)
+	keyInfoB := &KeyInfo{
+		Key:         keyB,
+		KeyBytes:    crypto.FromECDSA(keyB),
+		PubKeyBytes: crypto.FromECDSAPub(&keyB.PublicKey),
+	}
+
+	newOplog := func() *Oplog {
+		data := &tSignVectorData{
+			Title: []byte("title"),
+			N:     1234567890123,
+			IDs:   []*types.PttID{tDefaultID, tUserIDMe},
+			Name:  "<a&b>",
+		}
+
+		o, err := NewOplog(tDefaultID, tSignVectorTimestamp, tUserIDMe, MasterOpTypeAddMaster, data, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+		if err != nil {
+			t.Fatalf("NewOplog: e: %v", err)
+		}
+		o.ID = &types.PttID{}
+		copy(o.ID[:], []byte("0123456789abcdefghijklmnopqrstuvwxyz0123456789"))
+
+		err = o.Sign(tKeyInfoMe)
+		if err != nil {
+			t.Fatalf("Sign: e: %v", err)
+		}
+
+		return o
+	}
+
+	// doer
+	doerOplog := newOplog()
+
+	// masters, signed in the reverse order of ids.
+	masterOplog := newOplog()
+	masterKeyInfos := []*KeyInfo{tKeyInfoMe, keyInfoB}
+	masterIDs := []*types.PttID{tUserIDMe, idB}
+	if bytes.Compare(tUserIDMe[:], idB[:]) < 0 {
+		masterKeyInfos[0], masterKeyInfos[1] = masterKeyInfos[1], masterKeyInfos[0]
+		masterIDs[0], masterIDs[1] = masterIDs[1], masterIDs[0]
+	}
+	for i, keyInfo := range masterKeyInfos {
+		err := masterOplog.MasterSign(masterIDs[i], keyInfo)
+		if err != nil {
+			t.Fatalf("MasterSign: e: %v", err)
+		}
+	}
+
+	return []*Oplog{doerOplog, masterOplog}
+}
+
+func TestOplog_SignVectors(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	marshaled, err := ioutil.ReadFile("testdata/oplog_sign_vectors.json")
+	if err != nil {
+		t.Fatalf("ReadFile: e: %v", err)
+	}
+
+	vectors := make([]*tSignVector, 0)
+	err = json.Unmarshal(marshaled, &vectors)
+	if err != nil {
+		t.Fatalf("Unmarshal: e: %v", err)
+	}
+
+	oplogs := tNewSignVectorOplogs(t)
+	if len(vectors) != len(oplogs) {
+		t.Fatalf("vectors: %v, want: %v", len(vectors), len(oplogs))
+	}
+
+	// run test
+	for i, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			// the oplog received from other nodes, with Data as map[string]interface{}.
+			o := &Oplog{}
+			err := o.Unmarshal(v.Oplog)
+			if err != nil {
+				t.Fatalf("Unmarshal: e: %v", err)
+			}
+
+			err = o.Verify()
+			if err != nil {
+				t.Errorf("Verify: e: %v", err)
+			}
+
+			if got := hex.EncodeToString(o.Hash); got != v.Hash {
+				t.Errorf("Hash = %v, want %v", got, v.Hash)
+			}
+
+			masterSignBytes, _ := o.SignBytes()
+			if got := hex.EncodeToString(masterSignBytes); got != v.MasterSignBytes {
+				t.Errorf("MasterSignBytes = %v, want %v", got, v.MasterSignBytes)
+			}
+
+			o.DoerHash, o.Salt, o.Sig, o.Pubkey, o.KeyExtra = nil, types.Salt{}, nil, nil, nil
+			signBytes, _ := o.SignBytes()
+			if got := hex.EncodeToString(signBytes); got != v.SignBytes {
+				t.Errorf("SignBytes = %v, want %v", got, v.SignBytes)
+			}
+
+			// the oplog signed locally, with Data as the struct.
+			if !reflect.DeepEqual(oplogs[i].Hash, o.Hash) {
+				t.Errorf("Hash (local) = %x, want %v", oplogs[i].Hash, v.Hash)
+			}
+		})
+	}
+}
+
+/*
+tLegacyVerify verifies the oplog as the nodes before OplogSignVersionRLP:
+the unknown sV is dropped in unmarshal, and the oplog is verified as OplogSignVersionJSON
+(with Data unmarshaled to the struct of the op).
+*/
+func tLegacyVerify(o *Oplog) error {
+	marshaled, err := o.Marshal()
+	if err != nil {
+		return err
+	}
+
+	legacy := make(map[string]interface{})
+	err = json.Unmarshal(marshaled, &legacy)
+	if err != nil {
+		return err
+	}
+	delete(legacy, "sV")
+
+	marshaled, err = json.Marshal(legacy)
+	if err != nil {
+		return err
+	}
+
+	legacyO := &Oplog{Data: &tSignVectorData{}}
+	err = legacyO.Unmarshal(marshaled)
+	if err != nil {
+		return err
+	}
+
+	return legacyO.Verify()
+}
+
+func TestOplog_SignLegacyVerify(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	keyB, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	idB, _ := types.NewPttIDFromKey(keyB)
+	keyInfoB := &KeyInfo{
+		Key:         keyB,
+		KeyBytes:    crypto.FromECDSA(keyB),
+		PubKeyBytes: crypto.FromECDSAPub(&keyB.PublicKey),
+	}
+
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return tSignVectorTimestamp, nil
+	}
+
+	newOplog := func() *Oplog {
+		data := &tSignVectorData{Title: []byte("title"), N: 1, Name: "<a&b>"}
+		o, err := NewOplog(tDefaultID, tSignVectorTimestamp, tUserIDMe, MasterOpTypeAddMaster, data, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+		if err != nil {
+			t.Fatalf("NewOplog: e: %v", err)
+		}
+
+		err = o.Sign(tKeyInfoMe)
+		if err != nil {
+			t.Fatalf("Sign: e: %v", err)
+		}
+		for _, each := range []struct {
+			id      *types.PttID
+			keyInfo *KeyInfo
+		}{{idB, keyInfoB}, {tUserIDMe, tKeyInfoMe}} {
+			err = o.MasterSign(each.id, each.keyInfo)
+			if err != nil {
+				t.Fatalf("MasterSign: e: %v", err)
+			}
+		}
+
+		return o
+	}
+
+	// default: verifiable by the legacy nodes.
+	o := newOplog()
+	if o.SignV != OplogSignVersionJSON {
+		t.Errorf("SignV = %v, want %v", o.SignV, OplogSignVersionJSON)
+	}
+	if err := o.Verify(); err != nil {
+		t.Errorf("Verify: e: %v", err)
+	}
+	if err := tLegacyVerify(o); err != nil {
+		t.Errorf("Verify (legacy): e: %v", err)
+	}
+
+	// the legacy oplog with the master-signs not in order.
+	o.MasterSigns[0], o.MasterSigns[1] = o.MasterSigns[1], o.MasterSigns[0]
+	o.Hash, _ = o.SignsHash()
+	if err := o.Verify(); err != nil {
+		t.Errorf("Verify (legacy order): e: %v", err)
+	}
+
+	// OplogSignRLP: not verifiable by the legacy nodes.
+	CurrentOplogSignVersion = OplogSignVersionRLP
+	defer func() {
+		CurrentOplogSignVersion = OplogSignVersionJSON
+	}()
+
+	o = newOplog()
+	if err := o.Verify(); err != nil {
+		t.Errorf("Verify (rlp): e: %v", err)
+	}
+	if err := tLegacyVerify(o); err == nil {
+		t.Errorf("Verify (legacy, rlp): no error")
+	}
+}
+
+func TestOplog_SignsOrder(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	o := tNewSignVectorOplogs(t)[1]
+	if len(o.MasterSigns) != 2 || !isSortedSignInfos(o.MasterSigns) {
+		t.Fatalf("MasterSigns: not sorted: %v", o.MasterSigns)
+	}
+
+	// the same hash regardless of the order of the signs.
+	hash, _ := o.SignsHash()
+	o.MasterSigns[0], o.MasterSigns[1] = o.MasterSigns[1], o.MasterSigns[0]
+	reversedHash, _ := o.SignsHash()
+	if !reflect.DeepEqual(hash, reversedHash) {
+		t.Errorf("SignsHash = %x, want %x", reversedHash, hash)
+	}
+
+	err := o.Verify()
+	if err != ErrInvalidSignOrder {
+		t.Errorf("Verify: e = %v, want %v", err, ErrInvalidSignOrder)
+	}
+}
+
+func TestOplog_integrateSignInfos(t *testing.T) {
+	ids := make([]*types.PttID, 4)
+	for i := range ids {
+		ids[i] = &types.PttID{}
+		ids[i][0] = byte(i)
+	}
+	signs := make([]*SignInfo, 4)
+	for i := range signs {
+		signs[i] = &SignInfo{ID: ids[i]}
+	}
+
+	got, isAll, isAllOrig, err := integrateSignInfos([]*SignInfo{signs[0], signs[2]}, []*SignInfo{signs[1], signs[2], signs[3]})
+	if err != nil {
+		t.Fatalf("integrateSignInfos: e: %v", err)
+	}
+	if !reflect.DeepEqual(got, signs) || isAll || isAllOrig {
+		t.Errorf("integrateSignInfos = (%v, %v, %v), want (%v, false, false)", got, isAll, isAllOrig, signs)
+	}
+}
@@ -84,3 +84,59 @@ func TestOplog_MarshalMerkleKey(t *testing.T) {
 
 	// teardown test
 }
+
+func TestOplog_SaveWithIsSync(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	ts := types.Timestamp{Ts: 1234567890, NanoTs: 123}
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return ts, nil
+	}
+
+	o, _ := NewOplog(tDefaultID, ts, tUserIDMe, MasterOpTypeAddMaster, nil, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+	o.Sign(tKeyInfoMe)
+	err := o.MasterSign(tUserIDMe, tKeyInfoMe)
+	if err != nil {
+		t.Fatalf("MasterSign: e: %v", err)
+	}
+
+	// pending
+	err = o.Save(false)
+	if err != nil {
+		t.Fatalf("Save: e: %v", err)
+	}
+
+	idxKey, _ := o.IdxKey()
+	pendingKey, err := tDBOplog.GetKeyByIdxKey(idxKey, 0)
+	if err != nil {
+		t.Fatalf("GetKeyByIdxKey: e: %v", err)
+	}
+
+	// the synced alive oplog with the same hash is already there (without the index).
+	o.SetMasterLogID(o.ID, 1)
+	o.IsSync = true
+	key, _ := o.MarshalKey(o.dbPrefix)
+	marshaled, _ := o.Marshal()
+	tDBOplogCore.Put(key, marshaled)
+
+	o.IsSync = false
+	err = o.SaveWithIsSync(false)
+	if err != nil {
+		t.Errorf("SaveWithIsSync: e: %v", err)
+	}
+	if !o.IsSync {
+		t.Errorf("SaveWithIsSync: IsSync = false")
+	}
+
+	// the stale pending oplog is deleted.
+	isExists, _ := tDBOplogCore.Has(pendingKey)
+	if isExists {
+		t.Errorf("SaveWithIsSync: pending oplog not deleted")
+	}
+	_, err = tDBOplog.GetKeyByIdxKey(idxKey, 0)
+	if err == nil {
+		t.Errorf("SaveWithIsSync: index not deleted")
+	}
+}
@@ -58,12 +58,7 @@ func GetOplogIter(db *pttdb.LDBBatch, dbOplogPrefix []byte, dbOplogIdxPrefix []b
 
 func getOplogIterCore(db *pttdb.LDBBatch, dbOplogPrefix []byte, dbOplogIdxPrefix []byte, dbOplogMerklePrefix []byte, prefixID *types.PttID, logID *types.PttID, dbLock *types.LockMap, isLocked bool, status types.Status, listOrder pttdb.ListOrder) (iterator.Iterator, error) {
 
-	switch status {
-	case types.StatusInternalPending:
-		dbOplogPrefix = dbPrefixToDBPrefixInternal(dbOplogPrefix)
-	case types.StatusPending:
-		dbOplogPrefix = dbPrefixToDBPrefixMaster(dbOplogPrefix)
-	}
+	dbOplogPrefix = dbPrefixWithStatus(dbOplogPrefix, status)
 
 	prefix, err := DBPrefix(dbOplogPrefix, prefixID)
 	if err != nil {
@@ -84,6 +79,22 @@ func getOplogIterCore(db *pttdb.LDBBatch, dbOplogPrefix []byte, dbOplogIdxPrefix
 	return db.DB().NewIteratorWithPrefix(startKey, prefix, listOrder)
 }
 
+/*
+dbPrefixWithStatus returns the db-prefix of the oplogs with the status.
+*/
+func dbPrefixWithStatus(dbOplogPrefix []byte, status types.Status) []byte {
+	switch status {
+	case types.StatusInternalPending:
+		return dbPrefixToDBPrefixInternal(dbOplogPrefix)
+	case types.StatusPending:
+		return dbPrefixToDBPrefixMaster(dbOplogPrefix)
+	case types.StatusFailed:
+		return dbPrefixToDBPrefixFailed(dbOplogPrefix)
+	}
+
+	return dbOplogPrefix
+}
+
 func CheckPreLog(oplog *Oplog, prelog *Oplog, existIDs map[types.PttID]*Oplog) error {
 	if oplog.PreLogID == nil {
 		existIDs[*oplog.ID] = oplog
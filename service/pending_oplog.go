@@ -0,0 +1,192 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+)
+
+/*
+PendingOplogType describes the pending oplogs to be rebroadcasted / expired by the protocol-manager.
+Msg / PendingMsg are the ops for BroadcastOplogs.
+*/
+type PendingOplogType struct {
+	Name       string
+	SetDB      func(log *Oplog)
+	Msg        OpType
+	PendingMsg OpType
+}
+
+/*
+FailedOplogEvent is posted (on the event-mux of both the protocol-manager and the ptt)
+when my pending oplog is expired without enough signs.
+*/
+type FailedOplogEvent struct {
+	EntityID *types.PttID `json:"EID"`
+	TypeName string       `json:"T"`
+	Oplog    *Oplog       `json:"O"`
+}
+
+type pendingOplogRetry struct {
+	nRetry int
+	nextTS types.Timestamp
+}
+
+/*
+pendingOplogRetrySeconds is the backoff of the n-th retry: RetryPendingOplogSeconds * 2^n,
+up to MaxRetryPendingOplogSeconds.
+*/
+func pendingOplogRetrySeconds(nRetry int) uint64 {
+	seconds := uint64(RetryPendingOplogSeconds)
+	for i := 0; i < nRetry && seconds < MaxRetryPendingOplogSeconds; i++ {
+		seconds <<= 1
+	}
+	if seconds > MaxRetryPendingOplogSeconds {
+		seconds = MaxRetryPendingOplogSeconds
+	}
+
+	return seconds
+}
+
+/*
+RegisterPendingOplogType registers the pending oplogs to be rebroadcasted / expired.
+*/
+func (pm *BaseProtocolManager) RegisterPendingOplogType(t *PendingOplogType) {
+	pm.lockPendingOplog.Lock()
+	defer pm.lockPendingOplog.Unlock()
+
+	pm.pendingOplogTypes = append(pm.pendingOplogTypes, t)
+}
+
+func (pm *BaseProtocolManager) retryPendingOplogsLoop() {
+	defer pm.pendingOplogWG.Done()
+
+	ticker := time.NewTicker(RetryPendingOplogTickSeconds)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := pm.RetryPendingOplogs()
+			if err != nil {
+				log.Warn("retryPendingOplogsLoop: unable to retry", "e", err)
+			}
+		case <-pm.quitPendingOplog:
+			log.Debug("retryPendingOplogsLoop: quit")
+			return
+		}
+	}
+}
+
+/*
+RetryPendingOplogs rebroadcasts the pending / internal-pending oplogs with backoff,
+and moves the expired ones (ExpireOplogSeconds) to the failed oplogs.
+*/
+func (pm *BaseProtocolManager) RetryPendingOplogs() error {
+	pm.lockPendingOplog.Lock()
+	defer pm.lockPendingOplog.Unlock()
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+
+	retries := make(map[types.PttID]*pendingOplogRetry)
+	for _, t := range pm.pendingOplogTypes {
+		logs, failedLogs, err := pm.GetPendingOplogs(t.SetDB)
+		if err != nil {
+			return err
+		}
+
+		pm.failPendingOplogs(t, failedLogs)
+
+		toRetryLogs := make([]*Oplog, 0, len(logs))
+		for _, eachLog := range logs {
+			retry, ok := pm.pendingOplogRetries[*eachLog.ID]
+			if !ok {
+				// the first broadcast is done by the creator / integrator.
+				retry = &pendingOplogRetry{nextTS: eachLog.UpdateTS}
+				retry.nextTS.Ts += pendingOplogRetrySeconds(0)
+			}
+			retries[*eachLog.ID] = retry
+
+			if ts.IsLess(retry.nextTS) {
+				continue
+			}
+
+			t.SetDB(eachLog)
+			toRetryLogs = append(toRetryLogs, eachLog)
+
+			retry.nRetry++
+			retry.nextTS = ts
+			retry.nextTS.Ts += pendingOplogRetrySeconds(retry.nRetry)
+		}
+
+		if len(toRetryLogs) == 0 {
+			continue
+		}
+
+		log.Debug("RetryPendingOplogs: to broadcast", "type", t.Name, "logs", len(toRetryLogs))
+		err = pm.BroadcastOplogs(toRetryLogs, t.Msg, t.PendingMsg)
+		if err != nil {
+			log.Warn("RetryPendingOplogs: unable to broadcast", "type", t.Name, "e", err)
+		}
+	}
+
+	// the oplogs not pending anymore are removed from the retries.
+	pm.pendingOplogRetries = retries
+
+	return nil
+}
+
+func (pm *BaseProtocolManager) failPendingOplogs(t *PendingOplogType, logs []*Oplog) {
+	var myID *types.PttID
+	if myEntity := pm.Ptt().MyEntity(); myEntity != nil {
+		myID = myEntity.GetID()
+	}
+
+	var entityID *types.PttID
+	if entity := pm.Entity(); entity != nil {
+		entityID = entity.GetID()
+	}
+
+	for _, eachLog := range logs {
+		t.SetDB(eachLog)
+		err := eachLog.SaveFailed(false)
+		if err != nil {
+			log.Warn("failPendingOplogs: unable to save failed", "type", t.Name, "logID", eachLog.ID, "e", err)
+			continue
+		}
+
+		log.Info("failPendingOplogs: oplog failed", "type", t.Name, "logID", eachLog.ID, "doerID", eachLog.DoerID)
+
+		if myID == nil || eachLog.DoerID == nil || *myID != *eachLog.DoerID {
+			continue
+		}
+
+		event := &FailedOplogEvent{
+			EntityID: entityID,
+			TypeName: t.Name,
+			Oplog:    eachLog,
+		}
+		pm.eventMux.Post(event)
+		pm.Ptt().EventMux().Post(event)
+	}
+}
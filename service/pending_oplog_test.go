@@ -0,0 +1,153 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/event"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+func Test_pendingOplogRetrySeconds(t *testing.T) {
+	tests := []struct {
+		nRetry int
+		want   uint64
+	}{
+		{0, 10},
+		{1, 20},
+		{3, 80},
+		{4, 120},
+		{10, 120},
+	}
+
+	for _, tt := range tests {
+		if got := pendingOplogRetrySeconds(tt.nRetry); got != tt.want {
+			t.Errorf("pendingOplogRetrySeconds(%v) = %v, want %v", tt.nRetry, got, tt.want)
+		}
+	}
+}
+
+func TestBaseProtocolManager_RetryPendingOplogs(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	keyB, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	idB, _ := types.NewPttIDFromKey(keyB)
+	keyInfoB := &KeyInfo{
+		Key:         keyB,
+		KeyBytes:    crypto.FromECDSA(keyB),
+		PubKeyBytes: crypto.FromECDSAPub(&keyB.PublicKey),
+	}
+
+	setDB := func(log *Oplog) {
+		log.SetDB(tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+	}
+
+	peers, _ := NewPttPeerSet()
+	pm := &BaseProtocolManager{
+		eventMux:            new(event.TypeMux),
+		peers:               peers,
+		pendingOplogRetries: make(map[types.PttID]*pendingOplogRetry),
+		ptt:                 &BasePtt{eventMux: new(event.TypeMux)},
+	}
+	pm.RegisterPendingOplogType(&PendingOplogType{Name: "test", SetDB: setDB, Msg: AddOpKeyOplogsMsg, PendingMsg: AddPendingOpKeyOplogsMsg})
+
+	ts := types.Timestamp{Ts: 1234567890}
+	setTS := func(seconds uint64) {
+		types.GetTimestamp = func() (types.Timestamp, error) {
+			return types.Timestamp{Ts: ts.Ts + seconds}, nil
+		}
+	}
+
+	newPendingOplog := func(createTS types.Timestamp) *Oplog {
+		o, _ := NewOplog(tDefaultID, createTS, idB, MasterOpTypeAddMaster, nil, tDBOplog, tDefaultID, tDBOplogPrefix, tDBOplogIdxPrefix, tDBOplogMerklePrefix, tDBLock)
+		o.Sign(keyInfoB)
+		err := o.MasterSign(idB, keyInfoB)
+		if err != nil {
+			t.Fatalf("MasterSign: e: %v", err)
+		}
+		err = o.Save(false)
+		if err != nil {
+			t.Fatalf("Save: e: %v", err)
+		}
+		return o
+	}
+
+	setTS(0)
+	log1 := newPendingOplog(ts)
+	setTS(100)
+	log2 := newPendingOplog(types.Timestamp{Ts: ts.Ts + 100})
+
+	// not expired, not to retry yet
+	setTS(105)
+	err := pm.RetryPendingOplogs()
+	if err != nil {
+		t.Errorf("RetryPendingOplogs: e: %v", err)
+	}
+	if retry := pm.pendingOplogRetries[*log2.ID]; retry == nil || retry.nRetry != 0 {
+		t.Errorf("RetryPendingOplogs: retry = %v, want nRetry 0", retry)
+	}
+	if retry := pm.pendingOplogRetries[*log1.ID]; retry == nil || retry.nRetry != 1 {
+		t.Errorf("RetryPendingOplogs: retry = %v, want nRetry 1", retry)
+	}
+
+	// log1 expired
+	setTS(ExpireOplogSeconds + 10)
+	err = pm.RetryPendingOplogs()
+	if err != nil {
+		t.Errorf("RetryPendingOplogs: e: %v", err)
+	}
+
+	if _, ok := pm.pendingOplogRetries[*log1.ID]; ok {
+		t.Errorf("RetryPendingOplogs: failed log1 still in retries")
+	}
+	if retry := pm.pendingOplogRetries[*log2.ID]; retry == nil || retry.nRetry != 1 {
+		t.Errorf("RetryPendingOplogs: retry = %v, want nRetry 1", retry)
+	}
+
+	audits, err := AuditOplogs(setDB, nil, &OplogAuditFilter{Status: types.StatusFailed}, nil, 0, pttdb.ListOrderNext)
+	if err != nil {
+		t.Errorf("AuditOplogs: e: %v", err)
+	}
+	if len(audits) != 1 || *audits[0].ID != *log1.ID {
+		t.Errorf("AuditOplogs (failed): %v, want [%v]", audits, log1.ID)
+	}
+
+	// failed oplog is not to be pending again, but is still to be valid.
+	o := &Oplog{}
+	setDB(o)
+	o.Get(log1.ID, false)
+	err = o.Save(false)
+	if err != ErrInvalidOplog {
+		t.Errorf("Save (pending): e = %v, want %v", err, ErrInvalidOplog)
+	}
+
+	o.SetMasterLogID(o.ID, 1)
+	err = o.Save(false)
+	if err != nil {
+		t.Errorf("Save (valid): e: %v", err)
+	}
+
+	audits, _ = AuditOplogs(setDB, nil, &OplogAuditFilter{Status: types.StatusFailed}, nil, 0, pttdb.ListOrderNext)
+	if len(audits) != 0 {
+		t.Errorf("AuditOplogs (failed): %v, want []", audits)
+	}
+}
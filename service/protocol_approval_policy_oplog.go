@@ -0,0 +1,150 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func (pm *BaseProtocolManager) setMasterDB(log *Oplog) {
+	log.SetDB(dbOplog, pm.Entity().GetID(), DBMasterOplogPrefix, DBMasterIdxOplogPrefix, DBMasterMerkleOplogPrefix, DBMasterLockMap)
+}
+
+func (pm *BaseProtocolManager) BroadcastMasterOplog(log *MasterOplog) error {
+	return pm.BroadcastOplog(log.Oplog, AddMasterOplogMsg, AddPendingMasterOplogMsg)
+}
+
+func (pm *BaseProtocolManager) BroadcastMasterOplogs(logs []*Oplog) error {
+	return pm.BroadcastOplogs(logs, AddMasterOplogsMsg, AddPendingMasterOplogsMsg)
+}
+
+/*
+HandleAddMasterOplog handles the master-oplog broadcasted by BroadcastMasterOplog
+(AddMasterOplogMsg / AddPendingMasterOplogMsg).
+*/
+func (pm *BaseProtocolManager) HandleAddMasterOplog(dataBytes []byte, peer *PttPeer) error {
+	oplog := &Oplog{}
+	err := json.Unmarshal(dataBytes, oplog)
+	if err != nil {
+		return err
+	}
+
+	return pm.handleMasterOplog(oplog, peer)
+}
+
+/*
+HandleAddMasterOplogs handles the master-oplogs broadcasted by BroadcastMasterOplogs
+or sent by SyncApprovalPolicy (AddMasterOplogsMsg / AddPendingMasterOplogsMsg).
+*/
+func (pm *BaseProtocolManager) HandleAddMasterOplogs(dataBytes []byte, peer *PttPeer) error {
+	oplogs := make([]*Oplog, 0)
+	err := json.Unmarshal(dataBytes, &oplogs)
+	if err != nil {
+		return err
+	}
+
+	for _, oplog := range oplogs {
+		err = pm.handleMasterOplog(oplog, peer)
+		if err != nil {
+			log.Warn("HandleAddMasterOplogs: unable to handle oplog", "logID", oplog.ID, "peer", peer, "e", err)
+		}
+	}
+
+	return nil
+}
+
+/*
+handleMasterOplog verifies the received master-oplog and integrates it with the handler of the op.
+	1. set db and check the entity
+	2. data of the op (the sign-bytes of the json-sign-version depend on the typed data)
+	3. verify the doer and the master-signs
+	4. handle the op, and broadcast if I sign the oplog.
+*/
+func (pm *BaseProtocolManager) handleMasterOplog(oplog *Oplog, peer *PttPeer) error {
+	// 1. db
+	pm.setMasterDB(oplog)
+	if !reflect.DeepEqual(oplog.ObjID, pm.Entity().GetID()) {
+		return ErrInvalidOplog
+	}
+
+	// 2. data
+	switch oplog.Op {
+	case MasterOpTypeSetApprovalPolicy:
+		data := &MasterOpSetApprovalPolicy{}
+		err := oplog.GetData(data)
+		if err != nil {
+			return err
+		}
+		oplog.Data = data
+	default:
+		return ErrInvalidOp
+	}
+
+	// 3. verify
+	err := oplog.Verify()
+	if err != nil {
+		return err
+	}
+
+	if oplog.MasterLogID != nil {
+		_, _, isValid := pm.isValidOplog(oplog.MasterSigns)
+		if !isValid {
+			return ErrInvalidOplog
+		}
+	}
+
+	// 4. handle
+	masterLog := &MasterOplog{Oplog: oplog}
+	isNewSign, err := pm.HandleApprovalPolicyOplog(masterLog)
+	if err != nil {
+		return err
+	}
+
+	if isNewSign {
+		pm.BroadcastMasterOplog(masterLog)
+	}
+
+	return nil
+}
+
+/*
+SyncApprovalPolicy sends the master-oplog of the current approval policies to the new peer,
+so that the nodes joining later evaluate the oplogs with the same policies.
+*/
+func (pm *BaseProtocolManager) SyncApprovalPolicy(peer *PttPeer) error {
+	ps := pm.ApprovalPolicies()
+	if ps == nil || ps.LogID == nil {
+		return nil
+	}
+
+	oplog := &Oplog{}
+	pm.setMasterDB(oplog)
+	err := oplog.Get(ps.LogID, false)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	oplog.Extra = nil
+
+	return pm.SendDataToPeers(AddMasterOplogsMsg, []*Oplog{oplog}, []*PttPeer{peer})
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+)
+
+func TestBaseProtocolManager_HandleAddMasterOplog(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return types.Timestamp{Ts: 1234567890}, nil
+	}
+
+	origDBOplog, origDBMasterLockMap := dbOplog, DBMasterLockMap
+	defer func() {
+		dbOplog, DBMasterLockMap = origDBOplog, origDBMasterLockMap
+	}()
+	dbOplog, DBMasterLockMap = tDBOplog, tDBLock
+
+	isValid := true
+	peers, _ := NewPttPeerSet()
+	pm := &BaseProtocolManager{
+		entity: &testCoreEntity{id: tDefaultID},
+		db:     tDBOplog,
+		peers:  peers,
+		isValidOplog: func(signInfos []*SignInfo) (*types.PttID, uint32, bool) {
+			return tUserIDMe, uint32(len(signInfos)), isValid && len(signInfos) != 0
+		},
+	}
+
+	policies := []*ApprovalPolicy{{Type: ApprovalPolicyAnyMaster, Ops: []OpType{MasterOpTypeAddMaster}}}
+
+	newOplog := func(entityID *types.PttID) []byte {
+		o, _ := NewMasterOplog(entityID, types.Timestamp{Ts: 1234567890}, tUserIDMe, MasterOpTypeSetApprovalPolicy, &MasterOpSetApprovalPolicy{Policies: policies})
+		o.Sign(tKeyInfoMe)
+		err := o.MasterSign(tUserIDMe, tKeyInfoMe)
+		if err != nil {
+			t.Fatalf("MasterSign: e: %v", err)
+		}
+		o.SetMasterLogID(tUserIDMe, 1)
+
+		marshaled, _ := json.Marshal(o.Oplog)
+		return marshaled
+	}
+
+	// other entity
+	otherID := &types.PttID{}
+	otherID[0] = 1
+	err := pm.HandleAddMasterOplog(newOplog(otherID), nil)
+	if err != ErrInvalidOplog {
+		t.Errorf("HandleAddMasterOplog: other entity: e: %v, want: %v", err, ErrInvalidOplog)
+	}
+
+	// modified data
+	o := &Oplog{}
+	json.Unmarshal(newOplog(tDefaultID), o)
+	o.Data = &MasterOpSetApprovalPolicy{Policies: []*ApprovalPolicy{{Type: ApprovalPolicyNOfMasters, N: 2}}}
+	marshaled, _ := json.Marshal(o)
+	err = pm.HandleAddMasterOplog(marshaled, nil)
+	if err != ErrInvalidData {
+		t.Errorf("HandleAddMasterOplog: modified data: e: %v, want: %v", err, ErrInvalidData)
+	}
+
+	// invalid master-signs
+	isValid = false
+	err = pm.HandleAddMasterOplog(newOplog(tDefaultID), nil)
+	if err != ErrInvalidOplog {
+		t.Errorf("HandleAddMasterOplog: invalid master-signs: e: %v, want: %v", err, ErrInvalidOplog)
+	}
+	if pm.ApprovalPolicies() != nil {
+		t.Errorf("HandleAddMasterOplog: invalid master-signs: policies: %v", pm.ApprovalPolicies())
+	}
+
+	// valid
+	isValid = true
+	marshaled = newOplog(tDefaultID)
+	err = pm.HandleAddMasterOplog(marshaled, nil)
+	if err != nil {
+		t.Errorf("HandleAddMasterOplog: e: %v", err)
+	}
+
+	json.Unmarshal(marshaled, o)
+	ps := pm.ApprovalPolicies()
+	if ps == nil || !reflect.DeepEqual(ps.LogID, o.ID) || !reflect.DeepEqual(ps.Policies, policies) {
+		t.Errorf("HandleAddMasterOplog: policies: %v, want: %v", ps, policies)
+	}
+
+	saved := &Oplog{}
+	pm.setMasterDB(saved)
+	err = saved.Get(o.ID, false)
+	if err != nil {
+		t.Errorf("HandleAddMasterOplog: unable to get saved oplog: e: %v", err)
+	}
+
+	loaded, err := pm.loadApprovalPolicies()
+	if err != nil || loaded == nil || !reflect.DeepEqual(loaded.LogID, o.ID) {
+		t.Errorf("HandleAddMasterOplog: loaded: %v e: %v", loaded, err)
+	}
+}
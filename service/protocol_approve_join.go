@@ -45,7 +45,11 @@ func (p *BasePtt) ApproveJoin(confirmKey []byte) error {
 		return ErrInvalidKey
 	}
 
-	entity, joinEntity, keyInfo, peer := confirmJoin.Entity, confirmJoin.JoinEntity, confirmJoin.KeyInfo, confirmJoin.Peer
+	entity, peer, err := p.resolveConfirmJoin(confirmJoin)
+	if err != nil {
+		return err
+	}
+	joinEntity, keyInfo := confirmJoin.JoinEntity, confirmJoin.KeyInfo
 
 	pm := entity.PM()
 	opKeyInfo, approvedData, err := pm.ApproveJoin(joinEntity, keyInfo, peer)
@@ -86,6 +90,11 @@ func (p *BasePtt) ApproveJoin(confirmKey []byte) error {
 
 	delete(p.confirmJoins, confirmKeyStr)
 
+	err = deleteConfirmJoin(confirmKey)
+	if err != nil {
+		log.Warn("ApproveJoin: unable to delete confirm-join", "entity", id, "e", err)
+	}
+
 	return nil
 }
 
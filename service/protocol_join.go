@@ -31,7 +31,7 @@ TryJoin trys to do join (joiner)
 	1. If the nodeID is my node: return err because we dont join entity from our devices
 
 	2. If the nodeID is my peer: do join.
-	3. Else: do add peer.
+	3. Else: do add peer, and search the members of the entity through topic-discovery.
 */
 func (p *BasePtt) TryJoin(challenge []byte, hash *common.Address, key *ecdsa.PrivateKey, request *JoinRequest) error {
 	nodeID := request.NodeID
@@ -84,6 +84,15 @@ func (p *BasePtt) TryJoin(challenge []byte, hash *common.Address, key *ecdsa.Pri
 	}
 	p.Server().AddPeer(node)
 
+	if request.ID != nil {
+		go func() {
+			_, err := p.SearchEntityPeers(request.ID, SearchEntityPeersSeconds, MaxSearchEntityPeers)
+			if err != nil && err != ErrNoTopicDiscovery && err != ErrBusy {
+				log.Warn("TryJoin: unable to search entity peers", "entityID", request.ID, "e", err)
+			}
+		}()
+	}
+
 	return nil
 }
 
@@ -0,0 +1,592 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+)
+
+type MailboxPutAck struct {
+	ID    *types.PttID `json:"ID"`
+	Error string       `json:"E,omitempty"`
+}
+
+type MailboxDeliver struct {
+	Items []*MailboxItem `json:"I"`
+}
+
+type MailboxAck struct {
+	IDs []*types.PttID `json:"IDs"`
+}
+
+type MailboxReceipts struct {
+	Receipts []*MailboxReceipt `json:"R"`
+}
+
+/**********
+ * sender
+ **********/
+
+/*
+PutMailbox puts the encrypted data to the mailbox-nodes for the (offline) recipient.
+
+The mailbox-nodes of the recipient (the devices of the recipient) are preferred,
+otherwise one of the connected mailbox-nodes.
+*/
+func (p *BasePtt) PutMailbox(to *types.PttID, data []byte, expireSeconds uint64) (*MailboxItem, error) {
+	peers := p.mailboxPeers(to)
+	if len(peers) == 0 {
+		return nil, ErrNoMailbox
+	}
+
+	id, err := types.NewPttID()
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	expireTS := ts
+	expireTS.Ts += expireSeconds
+
+	item := &MailboxItem{
+		ID:       id,
+		To:       to,
+		CreateTS: ts,
+		ExpireTS: expireTS,
+		Data:     data,
+	}
+
+	for _, peer := range peers {
+		err = p.SendDataToPeer(CodeTypeMailboxPut, item, peer)
+		if err != nil {
+			log.Warn("PutMailbox: unable to send", "peer", peer, "e", err)
+		}
+	}
+
+	return item, nil
+}
+
+func (p *BasePtt) mailboxPeers(to *types.PttID) []*PttPeer {
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+
+	recipientPeers := make([]*PttPeer, 0)
+	otherPeers := make([]*PttPeer, 0)
+	for _, peers := range []map[discover.NodeID]*PttPeer{p.myPeers, p.importantPeers, p.memberPeers, p.randomPeers} {
+		for _, peer := range peers {
+			if peer.UserID == nil || peer.NodeRecord == nil || !peer.NodeRecord.IsMailbox() || !peer.IsSupportFeature(PttFeatureMailbox) {
+				continue
+			}
+			if reflect.DeepEqual(peer.UserID, to) {
+				recipientPeers = append(recipientPeers, peer)
+			} else {
+				otherPeers = append(otherPeers, peer)
+			}
+		}
+	}
+
+	if len(recipientPeers) != 0 {
+		return recipientPeers
+	}
+	if len(otherPeers) != 0 {
+		return randomPttPeers(otherPeers)[:1]
+	}
+
+	return nil
+}
+
+func (p *BasePtt) HandleMailboxPutAck(dataBytes []byte, peer *PttPeer) error {
+	ack := &MailboxPutAck{}
+	err := json.Unmarshal(dataBytes, ack)
+	if err != nil {
+		return err
+	}
+
+	if ack.Error != "" {
+		log.Warn("HandleMailboxPutAck: unable to put", "peer", peer, "ID", ack.ID, "e", ack.Error)
+	}
+
+	p.eventMux.Post(ack)
+
+	return nil
+}
+
+func (p *BasePtt) HandleMailboxReceipts(dataBytes []byte, peer *PttPeer) error {
+	data := &MailboxReceipts{}
+	err := json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return err
+	}
+
+	for _, receipt := range data.Receipts {
+		p.eventMux.Post(&MailboxReceiptEvent{Receipt: receipt})
+	}
+
+	return nil
+}
+
+/**********
+ * mailbox-node
+ **********/
+
+/*
+HandleMailboxPut stores the mailbox-item from the identified peer with the quotas,
+and delivers the item if the recipient is connected.
+*/
+func (p *BasePtt) HandleMailboxPut(dataBytes []byte, peer *PttPeer) error {
+	if !p.config.IsMailbox {
+		return ErrNotMailbox
+	}
+
+	if peer.UserID == nil {
+		return ErrPeerUserID
+	}
+
+	item := &MailboxItem{}
+	err := json.Unmarshal(dataBytes, item)
+	if err != nil {
+		return err
+	}
+
+	err = p.putMailboxItem(item, peer.UserID)
+
+	ack := &MailboxPutAck{ID: item.ID}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	sendErr := p.SendDataToPeer(CodeTypeMailboxPutAck, ack, peer)
+	if err != nil {
+		return err
+	}
+	if sendErr != nil {
+		log.Warn("HandleMailboxPut: unable to ack", "peer", peer, "e", sendErr)
+	}
+
+	p.deliverMailboxTo(item.To)
+
+	return nil
+}
+
+/*
+putMailboxItem stores the mailbox-item with the quotas (total / each recipient / each sender).
+The sender is from the identified peer, and the expire-ts is limited by the config.
+*/
+func (p *BasePtt) putMailboxItem(item *MailboxItem, from *types.PttID) error {
+	if item.ID == nil || item.To == nil {
+		return ErrInvalidMailboxItem
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+	maxExpireTS := ts
+	maxExpireTS.Ts += p.config.MailboxMaxExpireSeconds
+
+	item.From = from
+	if maxExpireTS.IsLess(item.ExpireTS) {
+		item.ExpireTS = maxExpireTS
+	}
+	if !ts.IsLess(item.ExpireTS) {
+		return ErrInvalidMailboxItem
+	}
+
+	p.lockMailbox.Lock()
+	defer p.lockMailbox.Unlock()
+
+	if _, err := getMailboxItem(item.To, item.ID); err == nil {
+		return nil
+	}
+
+	err = p.loadMailboxUsage()
+	if err != nil {
+		return err
+	}
+
+	size := item.size()
+	if p.config.MailboxQuotaBytes != 0 && p.mailboxBytes+size > p.config.MailboxQuotaBytes {
+		return ErrMailboxQuota
+	}
+
+	if p.config.MailboxUserQuotaBytes != 0 {
+		items, err := getMailboxItems(item.To)
+		if err != nil {
+			return err
+		}
+		userBytes := size
+		for _, eachItem := range items {
+			userBytes += eachItem.size()
+		}
+		if userBytes > p.config.MailboxUserQuotaBytes {
+			return ErrMailboxQuota
+		}
+	}
+
+	// the sender-quota, so that a sender cannot take the whole quota with many recipients.
+	if p.config.MailboxSenderQuotaBytes != 0 {
+		items, err := getMailboxItems(nil)
+		if err != nil {
+			return err
+		}
+		senderBytes := size
+		for _, eachItem := range items {
+			if reflect.DeepEqual(eachItem.From, from) {
+				senderBytes += eachItem.size()
+			}
+		}
+		if senderBytes > p.config.MailboxSenderQuotaBytes {
+			return ErrMailboxQuota
+		}
+	}
+
+	err = saveMailboxItem(item)
+	if err != nil {
+		return err
+	}
+	p.mailboxBytes += size
+
+	return nil
+}
+
+/*
+loadMailboxUsage loads the bytes of the stored mailbox-items. Expected to be with lockMailbox.
+*/
+func (p *BasePtt) loadMailboxUsage() error {
+	if p.isMailboxUsageLoaded {
+		return nil
+	}
+
+	items, err := getMailboxItems(nil)
+	if err != nil {
+		return err
+	}
+
+	p.mailboxBytes = 0
+	for _, item := range items {
+		p.mailboxBytes += item.size()
+	}
+	p.isMailboxUsageLoaded = true
+
+	return nil
+}
+
+func (p *BasePtt) deliverMailboxTo(to *types.PttID) {
+	p.peerLock.RLock()
+	peers := make([]*PttPeer, 0)
+	for _, eachPeers := range []map[discover.NodeID]*PttPeer{p.myPeers, p.importantPeers, p.memberPeers, p.randomPeers} {
+		for _, peer := range eachPeers {
+			if reflect.DeepEqual(peer.UserID, to) {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	p.peerLock.RUnlock()
+
+	for _, peer := range peers {
+		p.deliverMailbox(peer)
+	}
+}
+
+/*
+deliverMailbox delivers the mailbox-items and the receipts to the identified peer.
+*/
+func (p *BasePtt) deliverMailbox(peer *PttPeer) {
+	if !p.config.IsMailbox || peer.UserID == nil {
+		return
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return
+	}
+
+	// items
+	items, err := getMailboxItems(peer.UserID)
+	if err != nil {
+		log.Error("deliverMailbox: unable to get items", "peer", peer, "e", err)
+		return
+	}
+
+	validItems := make([]*MailboxItem, 0, len(items))
+	for _, item := range items {
+		if ts.IsLess(item.ExpireTS) {
+			validItems = append(validItems, item)
+		}
+	}
+
+	for i := 0; i < len(validItems); i += MailboxDeliverBatchSize {
+		end := i + MailboxDeliverBatchSize
+		if end > len(validItems) {
+			end = len(validItems)
+		}
+		err = p.SendDataToPeer(CodeTypeMailboxDeliver, &MailboxDeliver{Items: validItems[i:end]}, peer)
+		if err != nil {
+			log.Warn("deliverMailbox: unable to deliver", "peer", peer, "e", err)
+			return
+		}
+	}
+
+	// receipts
+	receipts, _, err := getMailboxReceipts(peer.UserID)
+	if err != nil || len(receipts) == 0 {
+		return
+	}
+
+	err = p.SendDataToPeer(CodeTypeMailboxReceipts, &MailboxReceipts{Receipts: receipts}, peer)
+	if err != nil {
+		log.Warn("deliverMailbox: unable to send receipts", "peer", peer, "e", err)
+		return
+	}
+
+	for _, receipt := range receipts {
+		deleteMailboxReceipt(peer.UserID, receipt)
+	}
+}
+
+/*
+HandleMailboxAck removes the delivered mailbox-items of the peer,
+and keeps the receipts for the senders.
+*/
+func (p *BasePtt) HandleMailboxAck(dataBytes []byte, peer *PttPeer) error {
+	if !p.config.IsMailbox {
+		return ErrNotMailbox
+	}
+
+	if peer.UserID == nil {
+		return ErrPeerUserID
+	}
+
+	data := &MailboxAck{}
+	err := json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return err
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+
+	p.lockMailbox.Lock()
+	froms := make([]*types.PttID, 0, len(data.IDs))
+	for _, id := range data.IDs {
+		// only the items to the peer.
+		item, err := getMailboxItem(peer.UserID, id)
+		if err != nil {
+			continue
+		}
+
+		err = deleteMailboxItem(item)
+		if err != nil {
+			continue
+		}
+		if p.isMailboxUsageLoaded {
+			p.mailboxBytes -= item.size()
+		}
+
+		receipt := &MailboxReceipt{
+			ID:        item.ID,
+			To:        item.To,
+			DeliverTS: ts,
+		}
+		err = saveMailboxReceipt(item.From, receipt)
+		if err != nil {
+			log.Warn("HandleMailboxAck: unable to save receipt", "ID", item.ID, "e", err)
+			continue
+		}
+		froms = append(froms, item.From)
+	}
+	p.lockMailbox.Unlock()
+
+	for _, from := range froms {
+		p.deliverMailboxTo(from)
+	}
+
+	return nil
+}
+
+func (p *BasePtt) expireMailboxLoop() {
+	defer p.syncWG.Done()
+
+	ticker := time.NewTicker(ExpireMailboxLoopSeconds)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := p.ExpireMailbox()
+			if err != nil {
+				log.Warn("expireMailboxLoop: unable to expire", "e", err)
+			}
+		case <-p.quitSync:
+			log.Debug("expireMailboxLoop: quit")
+			return
+		}
+	}
+}
+
+/*
+ExpireMailbox removes the expired mailbox-items and receipts.
+*/
+func (p *BasePtt) ExpireMailbox() error {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+
+	p.lockMailbox.Lock()
+	defer p.lockMailbox.Unlock()
+
+	items, err := getMailboxItems(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if ts.IsLess(item.ExpireTS) {
+			continue
+		}
+		log.Debug("ExpireMailbox: expired", "ID", item.ID, "To", item.To)
+		deleteMailboxItem(item)
+	}
+	p.isMailboxUsageLoaded = false
+
+	receipts, froms, err := getMailboxReceipts(nil)
+	if err != nil {
+		return err
+	}
+
+	for i, receipt := range receipts {
+		expireTS := receipt.DeliverTS
+		expireTS.Ts += p.config.MailboxMaxExpireSeconds
+		if ts.IsLess(expireTS) {
+			continue
+		}
+		deleteMailboxReceipt(froms[i], receipt)
+	}
+
+	return nil
+}
+
+func (p *BasePtt) GetMailboxStats() (*BackendMailboxStats, error) {
+	if !p.config.IsMailbox {
+		return nil, ErrNotMailbox
+	}
+
+	p.lockMailbox.Lock()
+	defer p.lockMailbox.Unlock()
+
+	items, err := getMailboxItems(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var bytes uint64
+	for _, item := range items {
+		bytes += item.size()
+	}
+
+	return &BackendMailboxStats{
+		NItems:      len(items),
+		Bytes:       bytes,
+		Quota:       p.config.MailboxQuotaBytes,
+		UserQuota:   p.config.MailboxUserQuotaBytes,
+		SenderQuota: p.config.MailboxSenderQuotaBytes,
+	}, nil
+}
+
+/**********
+ * recipient
+ **********/
+
+/*
+HandleMailboxDeliver handles the mailbox-items addressed to me as the ops of the entities,
+posts the handled items, and acks them to the mailbox-node.
+
+The items not handled (ex: the entity is not synced yet) are not acked,
+and are delivered again on the next connection until expired.
+*/
+func (p *BasePtt) HandleMailboxDeliver(dataBytes []byte, peer *PttPeer) error {
+	if p.myEntity == nil {
+		return ErrInvalidEntity
+	}
+	myID := p.myEntity.GetID()
+
+	data := &MailboxDeliver{}
+	err := json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]*types.PttID, 0, len(data.Items))
+	for _, item := range data.Items {
+		if !reflect.DeepEqual(item.To, myID) {
+			continue
+		}
+
+		err = p.handleMailboxItem(item, peer)
+		if err != nil {
+			log.Warn("HandleMailboxDeliver: unable to handle", "ID", item.ID, "From", item.From, "e", err)
+			continue
+		}
+
+		p.eventMux.Post(&MailboxEvent{Item: item})
+		ids = append(ids, item.ID)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return p.SendDataToPeer(CodeTypeMailboxAck, &MailboxAck{IDs: ids}, peer)
+}
+
+/*
+handleMailboxItem handles the data of the mailbox-item, the op-data put by PutMailboxData.
+*/
+func (p *BasePtt) handleMailboxItem(item *MailboxItem, peer *PttPeer) error {
+	data := &PttData{}
+	err := json.Unmarshal(item.Data, data)
+	if err != nil {
+		return err
+	}
+
+	code, hash, encData, err := p.UnmarshalData(data)
+	if err != nil {
+		return err
+	}
+
+	if code != CodeTypeOp || data.Code != code || !reflect.DeepEqual(hash[:], data.Hash[:]) {
+		return ErrInvalidData
+	}
+
+	entity, err := p.getEntityFromHash(hash, &p.lockOps, p.ops)
+	if err != nil {
+		return err
+	}
+
+	return PMHandleMailboxWrapper(entity.PM(), hash, encData, item.From, peer)
+}
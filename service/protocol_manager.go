@@ -23,6 +23,7 @@ import (
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/event"
+	"github.com/ailabstw/go-pttai/log"
 	"github.com/ailabstw/go-pttai/p2p/discover"
 	"github.com/ailabstw/go-pttai/pttdb"
 )
@@ -70,6 +71,18 @@ type ProtocolManager interface {
 	SetNewestMasterLogID(id *types.PttID) error
 	GetNewestMasterLogID() *types.PttID
 
+	HandleAddMasterOplog(dataBytes []byte, peer *PttPeer) error
+	HandleAddMasterOplogs(dataBytes []byte, peer *PttPeer) error
+
+	// approval-policy
+	ApprovalPolicies() *ApprovalPolicies
+	SetApprovalPolicies(policies []*ApprovalPolicy) (*MasterOplog, error)
+	SyncApprovalPolicy(peer *PttPeer) error
+
+	// oplog-snapshot
+	SyncOplogSnapshots(peer *PttPeer) error
+	HandleOplogSnapshot(dataBytes []byte, peer *PttPeer) error
+
 	// join
 	GetJoinKeyInfo(hash *common.Address) (*KeyInfo, error)
 	GetJoinKey() (*KeyInfo, error)
@@ -121,6 +134,19 @@ type ProtocolManager interface {
 	IdentifyPeerAck(data *IdentifyPeer, peer *PttPeer) error
 	HandleIdentifyPeerAck(dataBytes []byte, peer *PttPeer) error
 
+	// topic-discovery
+	IsPublic() bool
+	SetIsPublic(isPublic bool)
+
+	RegisterTopic() error
+	UnregisterTopic() error
+	SearchTopicPeers() ([]*discover.Node, error)
+
+	// mailbox
+	MailboxRecipients() []*types.PttID
+	SetMailboxRecipients(ids []*types.PttID)
+	IsMailboxRecipient(id *types.PttID) bool
+
 	// sync
 	ForceSyncCycle() time.Duration
 
@@ -129,6 +155,12 @@ type ProtocolManager interface {
 
 	SyncWG() *sync.WaitGroup
 
+	SetSyncTS(ts types.Timestamp)
+	SyncTS() types.Timestamp
+
+	SetIsSyncRunning(isSyncRunning bool)
+	IsSyncRunning() bool
+
 	// entity
 	Entity() Entity
 
@@ -170,11 +202,30 @@ type BaseProtocolManager struct {
 	// oplog
 	isValidOplog func(signInfos []*SignInfo) (*types.PttID, uint32, bool)
 
+	// approval-policy
+	lockApprovalPolicies sync.RWMutex
+	approvalPolicies     *ApprovalPolicies
+
+	// pending-oplog
+	lockPendingOplog    sync.Mutex
+	pendingOplogTypes   []*PendingOplogType
+	pendingOplogRetries map[types.PttID]*pendingOplogRetry
+	quitPendingOplog    chan struct{}
+	pendingOplogWG      sync.WaitGroup
+
 	// peer
 	peers       *PttPeerSet
 	newPeerCh   chan *PttPeer
 	noMorePeers chan struct{}
 
+	// topic-discovery
+	isPublic        bool
+	isRegisterTopic bool
+
+	// mailbox
+	lockMailboxRecipients sync.RWMutex
+	mailboxRecipients     []*types.PttID
+
 	// sync
 	maxSyncRandomSeconds int
 	minSyncRandomSeconds int
@@ -182,6 +233,10 @@ type BaseProtocolManager struct {
 	quitSync chan struct{}
 	syncWG   *sync.WaitGroup
 
+	lockSyncTS    sync.RWMutex
+	syncTS        types.Timestamp
+	isSyncRunning bool
+
 	// entity
 	entity Entity
 
@@ -222,6 +277,9 @@ func NewBaseProtocolManager(ptt Ptt, renewOpKeySeconds uint64, expireOpKeySecond
 		// oplog
 		isValidOplog: isValidOplog,
 
+		// pending-oplog
+		pendingOplogRetries: make(map[types.PttID]*pendingOplogRetry),
+
 		// peers
 		newPeerCh: make(chan *PttPeer),
 		peers:     peers,
@@ -264,6 +322,29 @@ func NewBaseProtocolManager(ptt Ptt, renewOpKeySeconds uint64, expireOpKeySecond
 
 	pm.newestMasterLogID = newestMasterLogID
 
+	// approval-policy
+	approvalPolicies, err := pm.loadApprovalPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	pm.approvalPolicies = approvalPolicies
+
+	// pending-oplog
+	pm.RegisterPendingOplogType(&PendingOplogType{
+		Name:       MasterOplogAuditType.Name,
+		SetDB:      pm.setMasterDB,
+		Msg:        AddMasterOplogsMsg,
+		PendingMsg: AddPendingMasterOplogsMsg,
+	})
+
+	pm.RegisterPendingOplogType(&PendingOplogType{
+		Name:       OpKeyOplogAuditType.Name,
+		SetDB:      pm.setOpKeyDB,
+		Msg:        AddOpKeyOplogsMsg,
+		PendingMsg: AddPendingOpKeyOplogsMsg,
+	})
+
 	return pm, nil
 
 }
@@ -271,10 +352,31 @@ func NewBaseProtocolManager(ptt Ptt, renewOpKeySeconds uint64, expireOpKeySecond
 func (pm *BaseProtocolManager) Start() error {
 	pm.isStart = true
 
+	pm.quitPendingOplog = make(chan struct{})
+	pm.pendingOplogWG.Add(1)
+	go pm.retryPendingOplogsLoop()
+
+	// topic-discovery: only the alive public entities (ex: the public boards) are advertised,
+	// the others (ex: me, friends) are not revealed to be on this node.
+	if pm.IsPublic() && pm.Entity().GetStatus() == types.StatusAlive {
+		err := pm.RegisterTopic()
+		if err != nil {
+			log.Warn("Start: unable to register topic", "entity", pm.Entity().GetID(), "e", err)
+		}
+	}
+
 	return nil
 }
 
 func (pm *BaseProtocolManager) Stop() error {
+	if pm.isStart {
+		close(pm.quitPendingOplog)
+		pm.pendingOplogWG.Wait()
+		pm.isStart = false
+	}
+
+	pm.UnregisterTopic()
+
 	pm.eventMux.Stop()
 
 	return nil
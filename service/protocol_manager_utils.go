@@ -21,12 +21,14 @@ import (
 	"time"
 
 	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/log"
 	"github.com/ailabstw/go-pttai/p2p"
 )
 
 /*
 StartPM starts the pm
+	0. load the sync-ts before the restart
 	1. go PMSync
 	2. go PMSyncOpKeyLoop
 	3. pm.Start
@@ -34,6 +36,12 @@ StartPM starts the pm
 func StartPM(pm ProtocolManager) error {
 	log.Info("StartPM: start", "entity", pm.Entity().Name())
 
+	// 0. sync-ts
+	err := loadSyncTS(pm)
+	if err != nil {
+		log.Warn("StartPM: unable to load sync-ts", "entity", pm.Entity().Name(), "e", err)
+	}
+
 	// 1. PMSync
 	pm.SyncWG().Add(1)
 	go func() {
@@ -43,7 +51,7 @@ func StartPM(pm ProtocolManager) error {
 	}()
 
 	// 3. pm.Start
-	err := pm.Start()
+	err = pm.Start()
 	if err != nil {
 		return err
 	}
@@ -60,12 +68,21 @@ func StopPM(pm ProtocolManager) error {
 		return err
 	}
 
+	// sync-ts to be resumed after the restart
+	err = saveSyncTS(pm)
+	if err != nil {
+		log.Warn("Stop PM: unable to save sync-ts", "entity", pm.Entity().Name(), "e", err)
+	}
+
 	log.Info("Stop PM: done", "entity", pm.Entity().Name())
 
 	return nil
 }
 
 func PMSync(pm ProtocolManager) error {
+	pm.SetIsSyncRunning(true)
+	defer pm.SetIsSyncRunning(false)
+
 	var err error
 	forceSyncTicker := time.NewTicker(pm.ForceSyncCycle())
 
@@ -77,17 +94,37 @@ looping:
 				break looping
 			}
 
+			err = pm.SyncApprovalPolicy(peer)
+			if err != nil {
+				log.Warn("unable to SyncApprovalPolicy after newPeer", "e", err)
+			}
+
+			err = pm.SyncOplogSnapshots(peer)
+			if err != nil {
+				log.Warn("unable to SyncOplogSnapshots after newPeer", "e", err)
+			}
+
 			err = pm.Sync(peer)
 			if err != nil {
 				log.Error("unable to Sync after newPeer", "e", err)
+				syncFailedMeter.Mark(1)
+			} else {
+				setPMSyncTS(pm)
+				syncSucceededMeter.Mark(1)
 			}
 		case <-forceSyncTicker.C:
 			forceSyncTicker.Stop()
 			forceSyncTicker = time.NewTicker(pm.ForceSyncCycle())
 
+			searchTopicPeersIfNoPeers(pm)
+
 			err = pm.Sync(nil)
 			if err != nil {
 				log.Error("unable to Sync after forceSync", "e", err)
+				syncFailedMeter.Mark(1)
+			} else {
+				setPMSyncTS(pm)
+				syncSucceededMeter.Mark(1)
 			}
 		case <-pm.QuitSync():
 			return p2p.DiscQuitting
@@ -98,6 +135,15 @@ looping:
 	return nil
 }
 
+func setPMSyncTS(pm ProtocolManager) {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return
+	}
+
+	pm.SetSyncTS(ts)
+}
+
 func PMHandleMessageWrapper(pm ProtocolManager, hash *common.Address, encData []byte, peer *PttPeer) error {
 	opKeyInfo, err := pm.GetOpKeyInfoFromHash(hash)
 
@@ -124,31 +170,57 @@ func PMHandleMessageWrapper(pm ProtocolManager, hash *common.Address, encData []
 		return ErrInvalidEntity
 	}
 
+	switch op {
+	case AddMasterOplogMsg, AddPendingMasterOplogMsg:
+		return pm.HandleAddMasterOplog(dataBytes, peer)
+	case AddMasterOplogsMsg, AddPendingMasterOplogsMsg:
+		return pm.HandleAddMasterOplogs(dataBytes, peer)
+	case OplogSnapshotMsg:
+		return pm.HandleOplogSnapshot(dataBytes, peer)
+	}
+
 	return pm.HandleMessage(op, dataBytes, peer)
 }
 
 /*
-Send Data to Peers using op-key
-*/
-func (pm *BaseProtocolManager) SendDataToPeers(op OpType, data interface{}, peerList []*PttPeer) error {
+PMHandleMailboxWrapper handles the op-data put by the sender (from) and delivered by the mailbox-node (peer).
 
-	dataBytes, err := json.Marshal(data)
+The sender is checked instead of the peer, which is not a member of the entity,
+and the ops bound to the connection (identify-peer / oplog-snapshot) are not accepted.
+*/
+func PMHandleMailboxWrapper(pm ProtocolManager, hash *common.Address, encData []byte, from *types.PttID, peer *PttPeer) error {
+	opKeyInfo, err := pm.GetOpKeyInfoFromHash(hash)
 	if err != nil {
 		return err
 	}
 
-	opKeyInfo, err := pm.GetOldestOpKey(false)
+	op, dataBytes, err := pm.Ptt().DecryptData(encData, opKeyInfo)
 	if err != nil {
 		return err
 	}
 
-	ptt := pm.Ptt()
-	encData, err := ptt.EncryptData(op, dataBytes, opKeyInfo)
-	if err != nil {
-		return err
+	if !pm.IsMailboxRecipient(from) {
+		return ErrInvalidEntity
 	}
 
-	pttData, err := ptt.MarshalData(CodeTypeOp, opKeyInfo.Hash, encData)
+	switch op {
+	case IdentifyPeerMsg, IdentifyPeerAckMsg, OplogSnapshotMsg:
+		return ErrInvalidOp
+	case AddMasterOplogMsg, AddPendingMasterOplogMsg:
+		return pm.HandleAddMasterOplog(dataBytes, peer)
+	case AddMasterOplogsMsg, AddPendingMasterOplogsMsg:
+		return pm.HandleAddMasterOplogs(dataBytes, peer)
+	}
+
+	return pm.HandleMessage(op, dataBytes, peer)
+}
+
+/*
+Send Data to Peers using op-key
+*/
+func (pm *BaseProtocolManager) SendDataToPeers(op OpType, data interface{}, peerList []*PttPeer) error {
+
+	pttData, err := pm.marshalOpData(CodeTypeOp, op, data)
 	if err != nil {
 		return err
 	}
@@ -179,33 +251,40 @@ Send Data to Peers using op-key
 */
 func (pm *BaseProtocolManager) SendDataToPeerWithCode(code CodeType, op OpType, data interface{}, peer *PttPeer) error {
 
-	dataBytes, err := json.Marshal(data)
+	pttData, err := pm.marshalOpData(code, op, data)
 	if err != nil {
 		return err
 	}
 
-	opKeyInfo, err := pm.GetOldestOpKey(false)
+	pttData.Node = peer.GetID()[:]
+
+	err = peer.SendData(pttData)
 	if err != nil {
-		return err
+		return ErrNotSent
 	}
 
-	ptt := pm.Ptt()
-	encData, err := ptt.EncryptData(op, dataBytes, opKeyInfo)
+	return nil
+}
+
+/*
+marshalOpData marshals the data encrypted with the oldest op-key.
+*/
+func (pm *BaseProtocolManager) marshalOpData(code CodeType, op OpType, data interface{}) (*PttData, error) {
+	dataBytes, err := json.Marshal(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pttData, err := ptt.MarshalData(code, opKeyInfo.Hash, encData)
+	opKeyInfo, err := pm.GetOldestOpKey(false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pttData.Node = peer.GetID()[:]
-
-	err = peer.SendData(pttData)
+	ptt := pm.Ptt()
+	encData, err := ptt.EncryptData(op, dataBytes, opKeyInfo)
 	if err != nil {
-		return ErrNotSent
+		return nil, err
 	}
 
-	return nil
+	return ptt.MarshalData(code, opKeyInfo.Hash, encData)
 }
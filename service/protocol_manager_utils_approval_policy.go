@@ -0,0 +1,232 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+ApprovalPolicies returns the approval policies of the entity, nil if the entity does not have any policy.
+*/
+func (pm *BaseProtocolManager) ApprovalPolicies() *ApprovalPolicies {
+	pm.lockApprovalPolicies.RLock()
+	defer pm.lockApprovalPolicies.RUnlock()
+
+	return pm.approvalPolicies
+}
+
+/*
+SetApprovalPolicies creates the master-oplog setting the approval policies of the entity.
+
+The policies are applied once the master-oplog is valid (with MasterLogID).
+*/
+func (pm *BaseProtocolManager) SetApprovalPolicies(policies []*ApprovalPolicy) (*MasterOplog, error) {
+	ps := &ApprovalPolicies{Policies: policies}
+	err := ps.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	myID := pm.Ptt().MyEntity().GetID()
+	entityID := pm.Entity().GetID()
+
+	oplog, err := NewMasterOplog(entityID, ts, myID, MasterOpTypeSetApprovalPolicy, &MasterOpSetApprovalPolicy{Policies: policies})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pm.SignOplog(oplog.Oplog)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = pm.HandleApprovalPolicyOplog(oplog)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.BroadcastMasterOplog(oplog)
+
+	return oplog, nil
+}
+
+/*
+HandleApprovalPolicyOplog integrates the master-oplog setting the approval policies (created or received),
+and applies the policies if the oplog is valid and newer than the current policies.
+
+Returns whether I newly sign the oplog, the oplog is to be broadcasted if so.
+*/
+func (pm *BaseProtocolManager) HandleApprovalPolicyOplog(oplog *MasterOplog) (bool, error) {
+	if oplog.Op != MasterOpTypeSetApprovalPolicy {
+		return false, ErrInvalidOp
+	}
+
+	err := oplog.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer oplog.Unlock()
+
+	_, err = oplog.IntegrateExisting(true)
+	if err == ErrOplogPruned {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	isNewSign := false
+	if oplog.MasterLogID == nil {
+		isNewSign, err = pm.InternalSign(oplog.Oplog)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = oplog.Save(true)
+	if err != nil {
+		return false, err
+	}
+
+	if oplog.MasterLogID == nil {
+		return isNewSign, nil
+	}
+
+	err = pm.applyApprovalPolicyOplog(oplog)
+	if err != nil {
+		return false, err
+	}
+
+	return isNewSign, nil
+}
+
+func (pm *BaseProtocolManager) applyApprovalPolicyOplog(oplog *MasterOplog) error {
+	data := &MasterOpSetApprovalPolicy{}
+	err := oplog.GetData(data)
+	if err != nil {
+		return err
+	}
+
+	ps := &ApprovalPolicies{
+		Policies: data.Policies,
+		LogID:    oplog.ID,
+		UpdateTS: oplog.CreateTS,
+	}
+	err = ps.Validate()
+	if err != nil {
+		return err
+	}
+
+	pm.lockApprovalPolicies.Lock()
+	defer pm.lockApprovalPolicies.Unlock()
+
+	if !pm.approvalPolicies.IsNewer(ps.UpdateTS, ps.LogID) {
+		return nil
+	}
+
+	err = pm.saveApprovalPolicies(ps)
+	if err != nil {
+		return err
+	}
+
+	pm.approvalPolicies = ps
+
+	return nil
+}
+
+/*
+isValidOplogWithPolicy evaluates the master-signs of the oplog with the approval policy of the op of the oplog,
+and falls back to the isValidOplog of the entity if there is no policy.
+
+The policies are for the oplogs of the entity, the master-oplogs are always with isValidOplog.
+*/
+func (pm *BaseProtocolManager) isValidOplogWithPolicy(oplog *Oplog) (*types.PttID, uint32, bool) {
+	if bytes.Equal(oplog.GetDBPrefix(), DBMasterOplogPrefix) {
+		return pm.isValidOplog(oplog.MasterSigns)
+	}
+
+	policy := pm.ApprovalPolicies().Policy(oplog.Op)
+	if policy == nil {
+		return pm.isValidOplog(oplog.MasterSigns)
+	}
+
+	isMaster := pm.IsMaster
+	if pm.entity != nil {
+		isMaster = pm.entity.PM().IsMaster
+	}
+
+	weight, isValid := policy.IsSatisfied(oplog, isMaster)
+	log.Debug("isValidOplogWithPolicy", "logID", oplog.ID, "op", oplog.Op, "policy", policy.Type, "weight", weight, "isValid", isValid)
+	if !isValid {
+		return nil, weight, false
+	}
+
+	masterLogID := pm.GetNewestMasterLogID()
+	if masterLogID == nil {
+		return nil, weight, false
+	}
+
+	return masterLogID, weight, true
+}
+
+func (pm *BaseProtocolManager) saveApprovalPolicies(ps *ApprovalPolicies) error {
+	key, err := DBPrefix(DBApprovalPolicyPrefix, pm.Entity().GetID())
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+
+	return pm.db.DB().Put(key, marshaled)
+}
+
+func (pm *BaseProtocolManager) loadApprovalPolicies() (*ApprovalPolicies, error) {
+	key, err := DBPrefix(DBApprovalPolicyPrefix, pm.Entity().GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := pm.db.DBGet(key)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &ApprovalPolicies{}
+	err = json.Unmarshal(val, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
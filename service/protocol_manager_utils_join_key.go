@@ -17,11 +17,14 @@
 package service
 
 import (
+	"encoding/json"
 	"reflect"
 	"time"
 
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func (b *BaseProtocolManager) GetJoinKeyInfo(hash *common.Address) (*KeyInfo, error) {
@@ -60,6 +63,12 @@ func (b *BaseProtocolManager) CreateJoinKeyInfoLoop() error {
 	ticker := time.NewTicker(RenewJoinKeySeconds)
 	defer ticker.Stop()
 
+	// the join-keys issued before the restart are still valid.
+	err := b.loadJoinKeyInfos()
+	if err != nil {
+		log.Warn("CreateJoinKeyInfoLoop: unable to load join-keys", "entity", b.Entity().GetID(), "e", err)
+	}
+
 	b.createJoinKeyInfo()
 
 loop:
@@ -88,7 +97,7 @@ func (b *BaseProtocolManager) createJoinKeyInfo() error {
 		return err
 	}
 
-	if len(b.joinKeyInfos) > 2 {
+	if len(b.joinKeyInfos) >= NJoinKeys {
 		origKeyInfo := b.joinKeyInfos[0]
 		b.ptt.RemoveJoinKey(origKeyInfo.Hash, entityID, true)
 		b.joinKeyInfos = b.joinKeyInfos[1:]
@@ -97,11 +106,86 @@ func (b *BaseProtocolManager) createJoinKeyInfo() error {
 	b.joinKeyInfos = append(b.joinKeyInfos, newKeyInfo)
 	b.ptt.AddJoinKey(newKeyInfo.Hash, entityID, true)
 
+	return b.saveJoinKeyInfos()
+}
+
+/*
+loadJoinKeyInfos loads the join-keys not expired yet from the last run.
+*/
+func (b *BaseProtocolManager) loadJoinKeyInfos() error {
+	b.lockJoinKeyInfo.Lock()
+	defer b.lockJoinKeyInfo.Unlock()
+
+	b.ptt.LockJoins()
+	defer b.ptt.UnlockJoins()
+
+	entityID := b.Entity().GetID()
+	key, err := common.Concat([][]byte{DBJoinKeyPrefix, entityID[:]})
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := dbMeta.Get(key)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	keyInfos := make([]*KeyInfo, 0)
+	err = json.Unmarshal(marshaled, &keyInfos)
+	if err != nil {
+		return err
+	}
+
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+	expireTS := ts
+	expireTS.Ts -= NJoinKeys * IntRenewJoinKeySeconds
+
+	for _, keyInfo := range keyInfos {
+		if keyInfo.UpdateTS.IsLess(expireTS) {
+			continue
+		}
+
+		err = keyInfo.Init(nil)
+		if err != nil {
+			continue
+		}
+
+		b.joinKeyInfos = append(b.joinKeyInfos, keyInfo)
+		b.ptt.AddJoinKey(keyInfo.Hash, entityID, true)
+	}
+
 	return nil
 }
 
+func (b *BaseProtocolManager) saveJoinKeyInfos() error {
+	entityID := b.Entity().GetID()
+	key, err := common.Concat([][]byte{DBJoinKeyPrefix, entityID[:]})
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(b.joinKeyInfos)
+	if err != nil {
+		return err
+	}
+
+	return dbMeta.Put(key, marshaled)
+}
+
 func (pm *BaseProtocolManager) JoinKeyInfos() []*KeyInfo {
-	return pm.joinKeyInfos
+	pm.lockJoinKeyInfo.RLock()
+	defer pm.lockJoinKeyInfo.RUnlock()
+
+	joinKeyInfos := make([]*KeyInfo, len(pm.joinKeyInfos))
+	copy(joinKeyInfos, pm.joinKeyInfos)
+
+	return joinKeyInfos
 }
 
 func (pm *BaseProtocolManager) IsJoinKeyHash(hash *common.Address) bool {
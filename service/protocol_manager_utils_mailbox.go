@@ -0,0 +1,118 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+)
+
+/*
+MailboxRecipients returns the users receiving the oplogs of the entity through the mailbox when offline
+(ex: the friend of the friend-entity).
+No recipients unless set with SetMailboxRecipients by the protocol-manager of the entity.
+*/
+func (pm *BaseProtocolManager) MailboxRecipients() []*types.PttID {
+	pm.lockMailboxRecipients.RLock()
+	defer pm.lockMailboxRecipients.RUnlock()
+
+	return pm.mailboxRecipients
+}
+
+func (pm *BaseProtocolManager) SetMailboxRecipients(ids []*types.PttID) {
+	pm.lockMailboxRecipients.Lock()
+	defer pm.lockMailboxRecipients.Unlock()
+
+	pm.mailboxRecipients = ids
+}
+
+/*
+IsMailboxRecipient returns whether the user is one of the mailbox-recipients.
+The ops of the entity from the mailbox are accepted only if the sender is one of the mailbox-recipients.
+*/
+func (pm *BaseProtocolManager) IsMailboxRecipient(id *types.PttID) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, eachID := range pm.MailboxRecipients() {
+		if reflect.DeepEqual(eachID, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+PutMailboxData puts the data to the mailbox for the mailbox-recipients not in peerList (offline).
+The data is encrypted with the op-key as SendDataToPeers, and expires along with the op-key.
+*/
+func (pm *BaseProtocolManager) PutMailboxData(op OpType, data interface{}, peerList []*PttPeer) error {
+	toIDs := pm.offlineMailboxRecipients(peerList)
+	if len(toIDs) == 0 {
+		return nil
+	}
+
+	pttData, err := pm.marshalOpData(CodeTypeOp, op, data)
+	if err != nil {
+		return err
+	}
+
+	dataBytes, err := json.Marshal(pttData)
+	if err != nil {
+		return err
+	}
+
+	ptt := pm.Ptt()
+	for _, toID := range toIDs {
+		_, err = ptt.PutMailbox(toID, dataBytes, pm.ExpireOpKeySeconds())
+		if err != nil {
+			log.Warn("PutMailboxData: unable to put", "to", toID, "entity", pm.Entity().GetID(), "e", err)
+		}
+	}
+
+	return nil
+}
+
+func (pm *BaseProtocolManager) offlineMailboxRecipients(peerList []*PttPeer) []*types.PttID {
+	myID := pm.Ptt().MyEntity().GetID()
+
+	recipients := pm.MailboxRecipients()
+	toIDs := make([]*types.PttID, 0, len(recipients))
+	for _, id := range recipients {
+		if reflect.DeepEqual(id, myID) {
+			continue
+		}
+
+		isOnline := false
+		for _, peer := range peerList {
+			if reflect.DeepEqual(peer.UserID, id) {
+				isOnline = true
+				break
+			}
+		}
+		if !isOnline {
+			toIDs = append(toIDs, id)
+		}
+	}
+
+	return toIDs
+}
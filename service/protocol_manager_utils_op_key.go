@@ -294,6 +294,21 @@ func (pm *BaseProtocolManager) checkNewestOpKeyInfo(keyInfo *KeyInfo, expireRene
 	return
 }
 
+/*
+OpKeyInfos returns the copy of the op-key-infos.
+*/
+func (pm *BaseProtocolManager) OpKeyInfos() map[common.Address]*KeyInfo {
+	pm.lockOpKeyInfo.RLock()
+	defer pm.lockOpKeyInfo.RUnlock()
+
+	opKeyInfos := make(map[common.Address]*KeyInfo, len(pm.opKeyInfos))
+	for hash, keyInfo := range pm.opKeyInfos {
+		opKeyInfos[hash] = keyInfo
+	}
+
+	return opKeyInfos
+}
+
 func (pm *BaseProtocolManager) RenewOpKeySeconds() uint64 {
 	return pm.renewOpKeySeconds
 }
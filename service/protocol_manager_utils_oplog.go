@@ -90,7 +90,7 @@ func (pm *BaseProtocolManager) GetOplogList(log *Oplog, startID *types.PttID, li
 		v := iter.Value()
 
 		eachLog = &Oplog{}
-		err := log.Unmarshal(v)
+		err := eachLog.Unmarshal(v)
 		if err != nil {
 			continue
 		}
@@ -128,6 +128,11 @@ func (pm *BaseProtocolManager) BroadcastOplog(log *Oplog, msg OpType, pendingMsg
 		op = pendingMsg
 	}
 
+	// the offline mailbox-recipients get the oplog from the mailbox.
+	if log.MasterLogID != nil {
+		pm.PutMailboxData(op, log, toSendPeers)
+	}
+
 	if len(toSendPeers) == 0 {
 		return nil
 	}
@@ -190,6 +195,11 @@ func (pm *BaseProtocolManager) BroadcastOplogs(logs []*Oplog, msg OpType, pendin
 		pm.SendDataToPeers(msg, allLogs, allPeerList)
 	}
 
+	// the offline mailbox-recipients get the oplogs from the mailbox.
+	if len(allLogs) != 0 {
+		pm.PutMailboxData(msg, allLogs, allPeerList)
+	}
+
 	return nil
 }
 
@@ -253,7 +263,7 @@ func (pm *BaseProtocolManager) InternalSign(log *Oplog) (bool, error) {
 		return false, err
 	}
 
-	masterLogID, weight, isValid := pm.isValidOplog(log.MasterSigns)
+	masterLogID, weight, isValid := pm.isValidOplogWithPolicy(log)
 	if !isValid {
 		return true, nil
 	}
@@ -320,6 +330,9 @@ func (pm *BaseProtocolManager) IntegrateOplog(log *Oplog, isLocked bool) (bool,
 	}
 
 	isToSign, err := log.IntegrateExisting(true)
+	if err == ErrOplogPruned {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
@@ -363,7 +376,7 @@ func (pm *BaseProtocolManager) validateIntegrateSign(log *Oplog, isLocked bool)
 		}
 	}
 
-	masterLogID, weight, isValid := pm.isValidOplog(log.MasterSigns)
+	masterLogID, weight, isValid := pm.isValidOplogWithPolicy(log)
 	if isValid {
 		err = log.SetMasterLogID(masterLogID, weight)
 		if err != nil {
@@ -20,6 +20,10 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func (pm *BaseProtocolManager) ForceSyncCycle() time.Duration {
@@ -43,3 +47,84 @@ func (pm *BaseProtocolManager) SyncWG() *sync.WaitGroup {
 func (pm *BaseProtocolManager) Sync(peer *PttPeer) error {
 	return nil
 }
+
+/*
+SetSyncTS sets the timestamp of the last successful sync.
+*/
+func (pm *BaseProtocolManager) SetSyncTS(ts types.Timestamp) {
+	pm.lockSyncTS.Lock()
+	defer pm.lockSyncTS.Unlock()
+
+	pm.syncTS = ts
+}
+
+func (pm *BaseProtocolManager) SyncTS() types.Timestamp {
+	pm.lockSyncTS.RLock()
+	defer pm.lockSyncTS.RUnlock()
+
+	return pm.syncTS
+}
+
+/*
+SetIsSyncRunning sets whether the sync-loop of the pm is running.
+*/
+func (pm *BaseProtocolManager) SetIsSyncRunning(isSyncRunning bool) {
+	pm.lockSyncTS.Lock()
+	defer pm.lockSyncTS.Unlock()
+
+	pm.isSyncRunning = isSyncRunning
+}
+
+func (pm *BaseProtocolManager) IsSyncRunning() bool {
+	pm.lockSyncTS.RLock()
+	defer pm.lockSyncTS.RUnlock()
+
+	return pm.isSyncRunning
+}
+
+/*
+loadSyncTS loads the timestamp of the last successful sync before the restart.
+*/
+func loadSyncTS(pm ProtocolManager) error {
+	key, err := marshalSyncTSKey(pm)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := dbMeta.Get(key)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ts, err := types.UnmarshalTimestamp(marshaled)
+	if err != nil {
+		return err
+	}
+
+	pm.SetSyncTS(ts)
+
+	return nil
+}
+
+func saveSyncTS(pm ProtocolManager) error {
+	key, err := marshalSyncTSKey(pm)
+	if err != nil {
+		return err
+	}
+
+	ts := pm.SyncTS()
+	marshaled, err := ts.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return dbMeta.Put(key, marshaled)
+}
+
+func marshalSyncTSKey(pm ProtocolManager) ([]byte, error) {
+	entityID := pm.Entity().GetID()
+	return common.Concat([][]byte{DBSyncTSPrefix, entityID[:]})
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+)
+
+/*
+IsPublic returns whether the entity is public (ex: the public boards), advertised through topic-discovery.
+The entities are not public unless set with SetIsPublic by the protocol-manager of the entity.
+*/
+func (pm *BaseProtocolManager) IsPublic() bool {
+	return pm.isPublic
+}
+
+/*
+SetIsPublic sets whether the entity is public. Expected to be called before Start.
+*/
+func (pm *BaseProtocolManager) SetIsPublic(isPublic bool) {
+	pm.isPublic = isPublic
+}
+
+/*
+RegisterTopic registers the topic of the entity for topic-discovery.
+Called in Start for the alive public entities, accepting joins / syncs from non-peers.
+*/
+func (pm *BaseProtocolManager) RegisterTopic() error {
+	err := pm.Ptt().RegisterEntityTopic(pm.Entity().GetID())
+	if err != nil {
+		return err
+	}
+
+	pm.isRegisterTopic = true
+
+	return nil
+}
+
+func (pm *BaseProtocolManager) UnregisterTopic() error {
+	if !pm.isRegisterTopic {
+		return nil
+	}
+
+	pm.isRegisterTopic = false
+
+	return pm.Ptt().UnregisterEntityTopic(pm.Entity().GetID())
+}
+
+/*
+SearchTopicPeers searches and dials the members of the entity through topic-discovery.
+Expected to be called when joining / syncing without direct peers.
+*/
+func (pm *BaseProtocolManager) SearchTopicPeers() ([]*discover.Node, error) {
+	return pm.Ptt().SearchEntityPeers(pm.Entity().GetID(), SearchEntityPeersSeconds, MaxSearchEntityPeers)
+}
+
+/*
+searchTopicPeersIfNoPeers searches the members of the public entity through topic-discovery in the background
+if the entity is without peers to sync with. The non-public entities are not registered by the members.
+*/
+func searchTopicPeersIfNoPeers(pm ProtocolManager) {
+	if !pm.IsPublic() || pm.Peers().Len(false) != 0 {
+		return
+	}
+
+	go func() {
+		nodes, err := pm.SearchTopicPeers()
+		if err != nil && err != ErrNoTopicDiscovery && err != ErrBusy {
+			log.Warn("searchTopicPeersIfNoPeers: unable to search", "entity", pm.Entity().GetID(), "e", err)
+			return
+		}
+		log.Debug("searchTopicPeersIfNoPeers: done", "entity", pm.Entity().GetID(), "nodes", len(nodes))
+	}()
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+type OplogSnapshotData struct {
+	TypeName string         `json:"T"`
+	Snapshot *OplogSnapshot `json:"S"`
+}
+
+/*
+SyncOplogSnapshots sends the snapshots of the compacted oplogs to the new peer,
+so that the peer bootstraps the oplogs pruned before the cutoff-ts of the snapshots.
+*/
+func (pm *BaseProtocolManager) SyncOplogSnapshots(peer *PttPeer) error {
+	entityPM := pm.Entity().PM()
+
+	for _, t := range getOplogAuditTypes() {
+		if t.IsSupersede == nil {
+			continue
+		}
+
+		setDB := func(log *Oplog) {
+			t.SetDB(entityPM, log)
+		}
+
+		snapshot, err := GetOplogSnapshot(setDB)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		data := &OplogSnapshotData{
+			TypeName: t.Name,
+			Snapshot: snapshot,
+		}
+
+		err = pm.SendDataToPeers(OplogSnapshotMsg, data, []*PttPeer{peer})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+HandleOplogSnapshot applies the snapshot from the peer if the snapshot is newer than mine
+and signed by the master of the entity.
+*/
+func (pm *BaseProtocolManager) HandleOplogSnapshot(dataBytes []byte, peer *PttPeer) error {
+	data := &OplogSnapshotData{}
+	err := json.Unmarshal(dataBytes, data)
+	if err != nil {
+		return err
+	}
+	if data.Snapshot == nil {
+		return ErrInvalidOplogSnapshot
+	}
+
+	t, err := GetOplogAuditType(data.TypeName)
+	if err != nil {
+		return err
+	}
+
+	entityPM := pm.Entity().PM()
+	setDB := func(log *Oplog) {
+		t.SetDB(entityPM, log)
+	}
+
+	prevSnapshot, err := GetOplogSnapshot(setDB)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	if prevSnapshot != nil && !prevSnapshot.CutoffTS.IsLess(data.Snapshot.CutoffTS) {
+		log.Debug("HandleOplogSnapshot: not newer", "entity", pm.Entity().GetID(), "type", t.Name, "peer", peer)
+		return nil
+	}
+
+	return ApplyOplogSnapshot(setDB, data.Snapshot, entityPM.IsMaster)
+}
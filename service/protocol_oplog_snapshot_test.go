@@ -0,0 +1,133 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+type testSnapshotPM struct {
+	ProtocolManager
+	entity   Entity
+	masterID *types.PttID
+}
+
+func (pm *testSnapshotPM) Entity() Entity                { return pm.entity }
+func (pm *testSnapshotPM) IsMaster(id *types.PttID) bool { return *id == *pm.masterID }
+
+func TestBaseProtocolManager_HandleOplogSnapshot(t *testing.T) {
+	// setup test
+	setupTest(t)
+	defer teardownTest(t)
+
+	keyB, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	idB, _ := types.NewPttIDFromKey(keyB)
+	keyInfoB := &KeyInfo{
+		Key:         keyB,
+		KeyBytes:    crypto.FromECDSA(keyB),
+		PubKeyBytes: crypto.FromECDSAPub(&keyB.PublicKey),
+	}
+
+	ts := types.Timestamp{Ts: 1234567890, NanoTs: 123}
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return ts, nil
+	}
+
+	origDBOplog, origDBMasterLockMap := dbOplog, DBMasterLockMap
+	defer func() {
+		dbOplog, DBMasterLockMap = origDBOplog, origDBMasterLockMap
+	}()
+	dbOplog, DBMasterLockMap = tDBOplog, tDBLock
+
+	entity := &testCoreEntity{id: tDefaultID}
+	entity.pm = &testSnapshotPM{entity: entity, masterID: tUserIDMe}
+	pm := &BaseProtocolManager{entity: entity}
+
+	setDB := func(log *Oplog) {
+		MasterOplogAuditType.SetDB(entity.pm, log)
+	}
+
+	// snapshot
+	newOplog := func(ts types.Timestamp, op OpType) *Oplog {
+		o, err := NewMasterOplog(tDefaultID, ts, idB, op, nil)
+		if err != nil {
+			t.Fatalf("NewMasterOplog: e: %v", err)
+		}
+		o.Sign(keyInfoB)
+		o.SetMasterLogID(o.ID, 1)
+
+		err = o.Save(false)
+		if err != nil {
+			t.Fatalf("Save: e: %v", err)
+		}
+		return o.Oplog
+	}
+
+	newOplog(types.Timestamp{Ts: ts.Ts - 300}, MasterOpTypeAddMaster)
+	log2 := newOplog(types.Timestamp{Ts: ts.Ts - 200}, MasterOpTypeSetApprovalPolicy)
+
+	snapshot, err := CompactOplogs(setDB, isSupersedeMasterOplog, types.Timestamp{Ts: ts.Ts - 100}, tUserIDMe, tKeyInfoMe)
+	if err != nil {
+		t.Fatalf("CompactOplogs: e: %v", err)
+	}
+
+	marshal := func(snapshot *OplogSnapshot) []byte {
+		marshaled, _ := json.Marshal(&OplogSnapshotData{TypeName: MasterOplogAuditType.Name, Snapshot: snapshot})
+		return marshaled
+	}
+
+	// the new peer
+	dbCore := pttdb.NewMemLDBDatabase("oplog2")
+	defer dbCore.Close()
+	dbOplog, _ = pttdb.NewLDBBatch(dbCore)
+
+	// not signed by the master
+	notMasterSnapshot := *snapshot
+	notMasterSnapshot.DoSign(idB, keyInfoB)
+	err = pm.HandleOplogSnapshot(marshal(&notMasterSnapshot), nil)
+	if err != ErrInvalidOplogSnapshot {
+		t.Errorf("HandleOplogSnapshot (not master): e = %v, want %v", err, ErrInvalidOplogSnapshot)
+	}
+
+	err = pm.HandleOplogSnapshot(marshal(snapshot), nil)
+	if err != nil {
+		t.Errorf("HandleOplogSnapshot: e: %v", err)
+	}
+
+	o := &Oplog{}
+	setDB(o)
+	err = o.Get(log2.ID, false)
+	if err != nil {
+		t.Errorf("Get (bootstrapped): e: %v", err)
+	}
+
+	saved, err := GetOplogSnapshot(setDB)
+	if err != nil || !saved.CutoffTS.IsEqual(snapshot.CutoffTS) {
+		t.Errorf("GetOplogSnapshot: %v e: %v", saved, err)
+	}
+
+	// not newer
+	err = pm.HandleOplogSnapshot(marshal(snapshot), nil)
+	if err != nil {
+		t.Errorf("HandleOplogSnapshot (not newer): e: %v", err)
+	}
+}
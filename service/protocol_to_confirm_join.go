@@ -16,7 +16,10 @@
 
 package service
 
-import "github.com/ailabstw/go-pttai/common/types"
+import (
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+)
 
 /*
 ToConfirmJoin puts the joinEntity into confirm-join-map and wait for confirming the join. (invitor)
@@ -35,6 +38,9 @@ func (p *BasePtt) ToConfirmJoin(confirmKey []byte, entity Entity, joinEntity *Jo
 		Peer:       peer,
 		UpdateTS:   ts,
 		JoinType:   joinType,
+
+		EntityID: entity.GetID(),
+		NodeID:   peer.GetID(),
 	}
 
 	confirmKeyStr := string(confirmKey)
@@ -49,5 +55,11 @@ func (p *BasePtt) ToConfirmJoin(confirmKey []byte, entity Entity, joinEntity *Jo
 
 	p.confirmJoins[confirmKeyStr] = confirmJoin
 
+	// persisted to be confirmed after restart.
+	err = saveConfirmJoin(confirmKey, confirmJoin)
+	if err != nil {
+		log.Warn("ToConfirmJoin: unable to save", "entity", confirmJoin.EntityID, "e", err)
+	}
+
 	return nil
 }
@@ -17,7 +17,9 @@
 package service
 
 import (
+	"crypto/ecdsa"
 	"sync"
+	"time"
 
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
@@ -25,6 +27,8 @@ import (
 	"github.com/ailabstw/go-pttai/log"
 	"github.com/ailabstw/go-pttai/p2p"
 	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/discv5"
+	"github.com/ailabstw/go-pttai/p2p/enr"
 	"github.com/ailabstw/go-pttai/rpc"
 )
 
@@ -33,6 +37,7 @@ Ptt is the public-access version of Ptt.
 */
 type Ptt interface {
 	// event-mux
+	EventMux() *event.TypeMux
 
 	ErrChan() *types.Chan
 
@@ -72,6 +77,14 @@ type Ptt interface {
 
 	MarshalData(code CodeType, hash *common.Address, encData []byte) (*PttData, error)
 	UnmarshalData(pttData *PttData) (CodeType, *common.Address, []byte, error)
+
+	// mailbox
+	PutMailbox(to *types.PttID, data []byte, expireSeconds uint64) (*MailboxItem, error)
+
+	// topic-discovery
+	RegisterEntityTopic(entityID *types.PttID) error
+	UnregisterEntityTopic(entityID *types.PttID) error
+	SearchEntityPeers(entityID *types.PttID, timeout time.Duration, maxNodes int) ([]*discover.Node, error)
 }
 
 type BasePtt struct {
@@ -120,12 +133,49 @@ type BasePtt struct {
 	quitSync chan struct{}
 	syncWG   sync.WaitGroup
 
+	// drain
+	lockDrain  sync.RWMutex
+	isDraining bool
+	handlerWG  sync.WaitGroup
+
 	// services
 	services map[string]Service
 
+	// health
+	lockHealth      sync.RWMutex
+	startTS         types.Timestamp
+	startedServices map[string]bool
+
 	// p2p server
 	server *p2p.Server
 
+	// node-record
+	nodeKey    *ecdsa.PrivateKey
+	nodeRecord *enr.Record
+
+	lockNodeTypes sync.RWMutex
+	nodeTypes     map[discover.NodeID]NodeType
+
+	// relay
+	isRelay      bool
+	relayLimiter *relayLimiter
+
+	lockRelay         sync.Mutex
+	relaySessions     map[discover.NodeID]*relaySession
+	relayFails        map[relayFailKey]time.Time
+	peerRelayLimiters map[discover.NodeID]*relayLimiter
+	relayTopicStop    chan struct{}
+
+	// mailbox
+	lockMailbox          sync.Mutex
+	mailboxBytes         uint64
+	isMailboxUsageLoaded bool
+
+	// topic-discovery
+	lockTopics   sync.Mutex
+	topics       map[types.PttID]chan struct{}
+	searchTopics map[discv5.Topic]bool
+
 	// protocols
 	protocols []p2p.Protocol
 
@@ -149,11 +199,27 @@ type BasePtt struct {
 
 	// MasterOplog
 	masterOplogMerkle *Merkle
+
+	// user-name
+	getUserName func(id *types.PttID) ([]byte, error)
 }
 
 func NewPtt(ctx *ServiceContext, cfg *Config, myNodeID *discover.NodeID) (*BasePtt, error) {
+	if cfg.OplogRetentionSeconds != 0 && cfg.OplogRetentionSeconds < MinOplogRetentionSeconds {
+		return nil, ErrInvalidOplogRetention
+	}
+
 	// init-service
-	InitService(cfg.DataDir)
+	err := InitService(cfg.DataDir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.OplogSignRLP {
+		CurrentOplogSignVersion = OplogSignVersionRLP
+	} else {
+		CurrentOplogSignVersion = OplogSignVersionJSON
+	}
 
 	p := &BasePtt{
 		config: cfg,
@@ -180,6 +246,20 @@ func NewPtt(ctx *ServiceContext, cfg *Config, myNodeID *discover.NodeID) (*BaseP
 		// sync
 		quitSync: make(chan struct{}),
 
+		// node-record
+		nodeTypes: make(map[discover.NodeID]NodeType),
+
+		// relay
+		relayLimiter: newRelayLimiter(cfg.RelayMaxBytesPerSecond),
+
+		relaySessions:     make(map[discover.NodeID]*relaySession),
+		relayFails:        make(map[relayFailKey]time.Time),
+		peerRelayLimiters: make(map[discover.NodeID]*relayLimiter),
+
+		// topic-discovery
+		topics:       make(map[types.PttID]chan struct{}),
+		searchTopics: make(map[discv5.Topic]bool),
+
 		// services
 		services: make(map[string]Service),
 
@@ -208,8 +288,31 @@ func (p *BasePtt) APIs() []rpc.API {
 func (p *BasePtt) Start(server *p2p.Server) error {
 	p.server = server
 
+	// relay
+	p.isRelay = p.config.IsRelay
+	if p.isRelay && !isPublicNode(server.Self().IP) {
+		log.Warn("Start: relay requires public reachability, disable relay", "ip", server.Self().IP)
+		p.isRelay = false
+	}
+
+	// node-record
+	nodeRecordEntry := NewNodeRecordEntry(p.config)
+	if !p.isRelay {
+		nodeRecordEntry.Flags &^= NodeRecordFlagRelay
+	}
+	nodeRecord, err := NewNodeRecord(server.PrivateKey, nodeRecordEntry)
+	if err != nil {
+		return err
+	}
+	p.nodeKey = server.PrivateKey
+	p.nodeRecord = nodeRecord
+
+	// confirm-joins pending before the restart
+	if err := p.loadConfirmJoins(); err != nil {
+		log.Warn("Start: unable to load confirm-joins", "e", err)
+	}
+
 	// Start services
-	var err error
 	successMap := make(map[string]Service)
 	errMap := make(map[string]error)
 	for name, service := range p.services {
@@ -219,6 +322,7 @@ func (p *BasePtt) Start(server *p2p.Server) error {
 			errMap[name] = err
 			break
 		}
+		successMap[name] = service
 	}
 
 	if err != nil {
@@ -233,13 +337,49 @@ func (p *BasePtt) Start(server *p2p.Server) error {
 		return errMapToErr(errMap)
 	}
 
+	// health
+	startTS, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+	p.setStarted(successMap, startTS)
+
+	// metrics
+	p.registerMetrics()
+
+	// oplog-compaction
+	if p.config.OplogRetentionSeconds != 0 {
+		p.syncWG.Add(1)
+		go p.compactOplogsLoop()
+	}
+
+	// mailbox
+	if p.config.IsMailbox {
+		p.syncWG.Add(1)
+		go p.expireMailboxLoop()
+	}
+
+	// topic-discovery
+	if p.config.TopicDiscovery && server.DiscV5 == nil {
+		log.Warn("Start: topic-discovery requires v5 discovery")
+	}
+
+	if p.isRelay && p.config.TopicDiscovery && server.DiscV5 != nil {
+		p.relayTopicStop = make(chan struct{})
+		go server.DiscV5.RegisterTopic(RelayTopic, p.relayTopicStop)
+	}
+
 	return nil
 }
 
 func (p *BasePtt) Stop() error {
+	p.drainHandlers()
+
 	close(p.quitSync)
 	close(p.noMorePeers)
 
+	p.setStarted(nil, types.ZeroTimestamp)
+
 	// close all service-loop
 	errMap := make(map[string]error)
 	for name, service := range p.services {
@@ -251,15 +391,28 @@ func (p *BasePtt) Stop() error {
 
 	p.syncWG.Wait()
 
+	p.unregisterAllEntityTopics()
+	if p.relayTopicStop != nil {
+		close(p.relayTopicStop)
+	}
+
+	p.closeAllRelaySessions()
+
 	p.peerWG.Wait()
 
 	// remove ptt-level chan
 
-	p.meOplogSub.Unsubscribe()
-	p.meOplogsSub.Unsubscribe()
+	if p.meOplogSub != nil {
+		p.meOplogSub.Unsubscribe()
+	}
+	if p.meOplogsSub != nil {
+		p.meOplogsSub.Unsubscribe()
+	}
 
 	p.eventMux.Stop()
 
+	TeardownService()
+
 	log.Debug("Stop: done")
 
 	if len(errMap) != 0 {
@@ -344,6 +497,10 @@ func (p *BasePtt) NotifyNodeStop() *types.Chan {
 	return p.notifyNodeStop
 }
 
+func (p *BasePtt) EventMux() *event.TypeMux {
+	return p.eventMux
+}
+
 func (p *BasePtt) ErrChan() *types.Chan {
 	return p.errChan
 }
@@ -16,6 +16,14 @@
 
 package service
 
+import (
+	"context"
+
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/pttdb"
+	"github.com/ailabstw/go-pttai/rpc"
+)
+
 type PrivateAPI struct {
 	p *BasePtt
 }
@@ -47,3 +55,95 @@ func (api *PrivateAPI) Shutdown() (bool, error) {
 func (api *PrivateAPI) Restart() (bool, error) {
 	return api.p.Restart()
 }
+
+func (api *PrivateAPI) Health() (*BackendHealth, error) {
+	return api.p.Health()
+}
+
+func (api *PrivateAPI) GetConfirmJoins() ([]*BackendConfirmJoin, error) {
+	return api.p.GetConfirmJoins()
+}
+
+func (api *PrivateAPI) ApproveJoin(confirmKey []byte) (bool, error) {
+	err := api.p.ApproveJoin(confirmKey)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (api *PrivateAPI) Backup(path string, passphrase string) (*pttdb.BackupManifest, error) {
+	return api.p.Backup(path, passphrase)
+}
+
+func (api *PrivateAPI) GetEntities() ([]*BackendEntity, error) {
+	return api.p.GetEntities()
+}
+
+func (api *PrivateAPI) GetKeys(entityID string) ([]*BackendKey, error) {
+	return api.p.GetKeys([]byte(entityID))
+}
+
+func (api *PrivateAPI) GetOplogAudit(entityID string, typeName string, filter *OplogAuditFilter, startID string, limit int, listOrder pttdb.ListOrder) ([]*BackendOplogAudit, error) {
+	return api.p.GetOplogAudit([]byte(entityID), typeName, filter, []byte(startID), limit, listOrder)
+}
+
+func (api *PrivateAPI) CompactOplogs(entityID string, typeName string, retentionSeconds uint64) (*BackendOplogSnapshot, error) {
+	return api.p.CompactOplogs([]byte(entityID), typeName, retentionSeconds)
+}
+
+func (api *PrivateAPI) GetOplogSnapshot(entityID string, typeName string) (*OplogSnapshot, error) {
+	return api.p.GetOplogSnapshot([]byte(entityID), typeName)
+}
+
+func (api *PrivateAPI) GetFailedOplogs(entityID string, typeName string, startID string, limit int, listOrder pttdb.ListOrder) ([]*BackendOplogAudit, error) {
+	return api.p.GetFailedOplogs([]byte(entityID), typeName, []byte(startID), limit, listOrder)
+}
+
+/*
+FailedOplogs creates the subscription of my oplogs expired without enough signs.
+*/
+func (api *PrivateAPI) FailedOplogs(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		sub := api.p.EventMux().Subscribe(&FailedOplogEvent{})
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev, ok := <-sub.Chan():
+				if !ok {
+					return
+				}
+				notifier.Notify(rpcSub.ID, ev.Data)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+func (api *PrivateAPI) SetApprovalPolicies(entityID string, policies []*ApprovalPolicy) (*MasterOplog, error) {
+	return api.p.SetApprovalPolicies([]byte(entityID), policies)
+}
+
+func (api *PrivateAPI) SearchTopicPeers(entityID string, seconds uint64) ([]*discover.Node, error) {
+	return api.p.SearchTopicPeers([]byte(entityID), seconds)
+}
+
+func (api *PrivateAPI) AddRelayPeer(nodeID string) (bool, error) {
+	return api.p.AddRelayPeerByID(nodeID)
+}
+
+func (api *PrivateAPI) GetMailboxStats() (*BackendMailboxStats, error) {
+	return api.p.GetMailboxStats()
+}
@@ -16,6 +16,18 @@
 
 package service
 
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
 func (p *BasePtt) GetVersion() (string, error) {
 	return p.config.Version, nil
 }
@@ -75,3 +87,307 @@ func (p *BasePtt) Restart() (bool, error) {
 	p.notifyNodeRestart.PassChan(struct{}{})
 	return true, nil
 }
+
+/*
+GetConfirmJoins returns the joins waiting for ApproveJoin (invitor),
+including the ones pending before the last restart.
+*/
+func (p *BasePtt) GetConfirmJoins() ([]*BackendConfirmJoin, error) {
+	p.lockConfirmJoin.RLock()
+	defer p.lockConfirmJoin.RUnlock()
+
+	confirmKeys := make([]string, 0, len(p.confirmJoins))
+	for confirmKey := range p.confirmJoins {
+		confirmKeys = append(confirmKeys, confirmKey)
+	}
+	sort.Strings(confirmKeys)
+
+	confirmJoins := make([]*BackendConfirmJoin, len(confirmKeys))
+	for i, confirmKey := range confirmKeys {
+		confirmJoins[i] = ConfirmJoinToBackendConfirmJoin([]byte(confirmKey), p.confirmJoins[confirmKey])
+	}
+
+	return confirmJoins, nil
+}
+
+/*
+Backup writes the encrypted backup archive of the data-dir to path while the node is running.
+*/
+func (p *BasePtt) Backup(path string, passphrase string) (*pttdb.BackupManifest, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return pttdb.Backup(path, pttdb.RootDir(), passphrase)
+}
+
+/*
+GetOplogAudit lists the oplogs of the entity with the verification results
+for investigating the disputes. typeName is the name of the registered OplogAuditType.
+
+startIDBytes is the id of the oplog to list from (included), empty to list from the first / the last oplog.
+*/
+func (p *BasePtt) GetOplogAudit(entityIDBytes []byte, typeName string, filter *OplogAuditFilter, startIDBytes []byte, limit int, listOrder pttdb.ListOrder) ([]*BackendOplogAudit, error) {
+	pm, t, err := p.getOplogAuditPM(entityIDBytes, typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var startID *types.PttID
+	if len(startIDBytes) != 0 {
+		startID, err = types.UnmarshalTextPttID(startIDBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	setDB := func(log *Oplog) {
+		t.SetDB(pm, log)
+	}
+
+	audits, err := AuditOplogs(setDB, t.OpNames, filter, startID, limit, listOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	getUserName := p.getUserName
+	if getUserName == nil {
+		return audits, nil
+	}
+
+	names := make(map[types.PttID][]byte)
+	for _, audit := range audits {
+		if audit.DoerID == nil {
+			continue
+		}
+
+		name, ok := names[*audit.DoerID]
+		if !ok {
+			name, err = getUserName(audit.DoerID)
+			if err != nil {
+				log.Debug("GetOplogAudit: unable to get user-name", "doerID", audit.DoerID, "e", err)
+			}
+			names[*audit.DoerID] = name
+		}
+		audit.DoerName = name
+	}
+
+	return audits, nil
+}
+
+/*
+SetUserNameGetter sets how to get the user-name of the doers in the oplog audit.
+*/
+func (p *BasePtt) SetUserNameGetter(getUserName func(id *types.PttID) ([]byte, error)) {
+	p.getUserName = getUserName
+}
+
+func (p *BasePtt) getOplogAuditPM(entityIDBytes []byte, typeName string) (ProtocolManager, *OplogAuditType, error) {
+	entityID, err := types.UnmarshalTextPttID(entityIDBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := GetOplogAuditType(typeName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.entityLock.RLock()
+	entity, ok := p.entities[*entityID]
+	p.entityLock.RUnlock()
+	if !ok {
+		return nil, nil, ErrInvalidEntity
+	}
+
+	return entity.PM(), t, nil
+}
+
+/*
+GetEntities returns the registered entities ordered by id.
+*/
+func (p *BasePtt) GetEntities() ([]*BackendEntity, error) {
+	entities := p.sortedEntities()
+
+	backendEntities := make([]*BackendEntity, len(entities))
+	for i, entity := range entities {
+		backendEntities[i] = EntityToBackendEntity(entity)
+	}
+
+	return backendEntities, nil
+}
+
+/*
+GetKeys returns the op-keys and the join-keys of the entity,
+or of all the registered entities if entityIDBytes is empty.
+*/
+func (p *BasePtt) GetKeys(entityIDBytes []byte) ([]*BackendKey, error) {
+	var entities []Entity
+	if len(entityIDBytes) == 0 {
+		entities = p.sortedEntities()
+	} else {
+		entityID, err := types.UnmarshalTextPttID(entityIDBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		p.entityLock.RLock()
+		entity, ok := p.entities[*entityID]
+		p.entityLock.RUnlock()
+		if !ok {
+			return nil, ErrInvalidEntity
+		}
+		entities = []Entity{entity}
+	}
+
+	keys := make([]*BackendKey, 0)
+	for _, entity := range entities {
+		keys = append(keys, entityToBackendKeys(entity)...)
+	}
+
+	return keys, nil
+}
+
+func entityToBackendKeys(entity Entity) []*BackendKey {
+	pm := entity.PM()
+	entityID := entity.GetID()
+
+	opKeyInfos := pm.OpKeyInfos()
+	joinKeyInfos := pm.JoinKeyInfos()
+
+	keys := make([]*BackendKey, 0, len(opKeyInfos)+len(joinKeyInfos))
+
+	opKeys := make([]*BackendKey, 0, len(opKeyInfos))
+	expireOpKeySeconds := pm.ExpireOpKeySeconds()
+	for _, keyInfo := range opKeyInfos {
+		opKeys = append(opKeys, KeyInfoToBackendKey(entityID, BackendKeyTypeOp, keyInfo, expireOpKeySeconds))
+	}
+	sort.Slice(opKeys, func(i, j int) bool {
+		return opKeys[i].UpdateTS.IsLess(opKeys[j].UpdateTS)
+	})
+	keys = append(keys, opKeys...)
+
+	expireJoinKeySeconds := uint64(NJoinKeys * IntRenewJoinKeySeconds)
+	for _, keyInfo := range joinKeyInfos {
+		keys = append(keys, KeyInfoToBackendKey(entityID, BackendKeyTypeJoin, keyInfo, expireJoinKeySeconds))
+	}
+
+	return keys
+}
+
+func (p *BasePtt) sortedEntities() []Entity {
+	p.entityLock.RLock()
+	entities := make([]Entity, 0, len(p.entities))
+	for _, entity := range p.entities {
+		entities = append(entities, entity)
+	}
+	p.entityLock.RUnlock()
+
+	sort.Slice(entities, func(i, j int) bool {
+		return bytes.Compare(entities[i].GetID()[:], entities[j].GetID()[:]) < 0
+	})
+
+	return entities
+}
+
+/*
+CompactOplogs prunes the superseded oplogs of the entity older than retentionSeconds,
+and returns the info of the signed snapshot.
+*/
+func (p *BasePtt) CompactOplogs(entityIDBytes []byte, typeName string, retentionSeconds uint64) (*BackendOplogSnapshot, error) {
+	if retentionSeconds < MinOplogRetentionSeconds {
+		return nil, ErrInvalidOplogRetention
+	}
+
+	pm, t, err := p.getOplogAuditPM(entityIDBytes, typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := p.compactOplogs(pm, t, retentionSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	return OplogSnapshotToBackendOplogSnapshot(snapshot), nil
+}
+
+func (p *BasePtt) GetOplogSnapshot(entityIDBytes []byte, typeName string) (*OplogSnapshot, error) {
+	pm, t, err := p.getOplogAuditPM(entityIDBytes, typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	setDB := func(log *Oplog) {
+		t.SetDB(pm, log)
+	}
+
+	return GetOplogSnapshot(setDB)
+}
+
+/*
+GetFailedOplogs lists my oplogs of the entity expired without enough signs.
+*/
+func (p *BasePtt) GetFailedOplogs(entityIDBytes []byte, typeName string, startIDBytes []byte, limit int, listOrder pttdb.ListOrder) ([]*BackendOplogAudit, error) {
+	filter := &OplogAuditFilter{
+		Status: types.StatusFailed,
+		DoerID: p.myEntity.GetID(),
+	}
+
+	return p.GetOplogAudit(entityIDBytes, typeName, filter, startIDBytes, limit, listOrder)
+}
+
+/*
+SetApprovalPolicies creates the master-oplog setting the approval policies of the entity.
+The oplog is broadcasted to be signed by the other masters, and the policies are applied once the oplog is valid.
+*/
+func (p *BasePtt) SetApprovalPolicies(entityIDBytes []byte, policies []*ApprovalPolicy) (*MasterOplog, error) {
+	entityID, err := types.UnmarshalTextPttID(entityIDBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	p.entityLock.RLock()
+	entity, ok := p.entities[*entityID]
+	p.entityLock.RUnlock()
+	if !ok {
+		return nil, ErrInvalidEntity
+	}
+
+	return entity.PM().SetApprovalPolicies(policies)
+}
+
+/*
+SearchTopicPeers searches and dials the members of the entity through topic-discovery in seconds.
+*/
+func (p *BasePtt) SearchTopicPeers(entityIDBytes []byte, seconds uint64) ([]*discover.Node, error) {
+	entityID, err := types.UnmarshalTextPttID(entityIDBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := SearchEntityPeersSeconds
+	if seconds != 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	return p.SearchEntityPeers(entityID, timeout, MaxSearchEntityPeers)
+}
+
+/*
+AddRelayPeerByID connects to the node (hex node-id) through the relay-nodes.
+*/
+func (p *BasePtt) AddRelayPeerByID(nodeIDStr string) (bool, error) {
+	nodeID, err := discover.HexID(nodeIDStr)
+	if err != nil {
+		return false, err
+	}
+
+	err = p.AddRelayPeer(&nodeID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
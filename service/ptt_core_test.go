@@ -0,0 +1,139 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+)
+
+type testCoreEntity struct {
+	Entity
+	id *types.PttID
+	pm ProtocolManager
+}
+
+func (e *testCoreEntity) GetID() *types.PttID          { return e.id }
+func (e *testCoreEntity) GetCreateTS() types.Timestamp { return types.Timestamp{Ts: 1234567890} }
+func (e *testCoreEntity) GetStatus() types.Status      { return types.StatusAlive }
+func (e *testCoreEntity) GetOwnerID() *types.PttID     { return e.id }
+func (e *testCoreEntity) Name() string                 { return "test" }
+func (e *testCoreEntity) PM() ProtocolManager          { return e.pm }
+
+type testCorePM struct {
+	ProtocolManager
+	b *BaseProtocolManager
+}
+
+func (pm *testCorePM) Peers() *PttPeerSet                      { return pm.b.Peers() }
+func (pm *testCorePM) OpKeyInfos() map[common.Address]*KeyInfo { return pm.b.OpKeyInfos() }
+func (pm *testCorePM) JoinKeyInfos() []*KeyInfo                { return pm.b.JoinKeyInfos() }
+func (pm *testCorePM) ExpireOpKeySeconds() uint64              { return pm.b.ExpireOpKeySeconds() }
+func (pm *testCorePM) SyncTS() types.Timestamp                 { return pm.b.SyncTS() }
+func (pm *testCorePM) IsSyncRunning() bool                     { return pm.b.IsSyncRunning() }
+
+func newTestCoreEntity(id *types.PttID, opKeyInfos []*KeyInfo, joinKeyInfos []*KeyInfo) *testCoreEntity {
+	peers, _ := NewPttPeerSet()
+	b := &BaseProtocolManager{
+		peers:              peers,
+		opKeyInfos:         make(map[common.Address]*KeyInfo),
+		joinKeyInfos:       joinKeyInfos,
+		expireOpKeySeconds: 100,
+	}
+	for _, keyInfo := range opKeyInfos {
+		b.opKeyInfos[*keyInfo.Hash] = keyInfo
+	}
+	b.SetSyncTS(types.Timestamp{Ts: 1234567900})
+
+	return &testCoreEntity{id: id, pm: &testCorePM{b: b}}
+}
+
+func newTestCoreKeyInfo(hashByte byte, ts uint64) *KeyInfo {
+	return &KeyInfo{
+		Hash:     &common.Address{hashByte},
+		UpdateTS: types.Timestamp{Ts: ts},
+		Status:   types.StatusAlive,
+	}
+}
+
+func TestBasePtt_GetEntitiesKeys(t *testing.T) {
+	id1 := &types.PttID{1}
+	id2 := &types.PttID{2}
+
+	opKey1 := newTestCoreKeyInfo(1, 1234567891)
+	opKey2 := newTestCoreKeyInfo(2, 1234567890)
+	joinKey := newTestCoreKeyInfo(3, 1234567892)
+
+	p := &BasePtt{
+		entities: map[types.PttID]Entity{
+			*id2: newTestCoreEntity(id2, nil, nil),
+			*id1: newTestCoreEntity(id1, []*KeyInfo{opKey1, opKey2}, []*KeyInfo{joinKey}),
+		},
+	}
+
+	entities, err := p.GetEntities()
+	if err != nil {
+		t.Errorf("GetEntities: e: %v", err)
+	}
+	if len(entities) != 2 || !reflect.DeepEqual(entities[0].ID, id1) || !reflect.DeepEqual(entities[1].ID, id2) {
+		t.Errorf("GetEntities: invalid order: %v", entities)
+	}
+	expected := []*common.Address{opKey1.Hash, opKey2.Hash}
+	if !reflect.DeepEqual(entities[0].OpKeyHashes, expected) {
+		t.Errorf("GetEntities: OpKeyHashes: %v expected: %v", entities[0].OpKeyHashes, expected)
+	}
+	if entities[0].SyncTS.Ts != 1234567900 || entities[0].NPeers != 0 {
+		t.Errorf("GetEntities: invalid entity: %v", entities[0])
+	}
+	if len(entities[1].OpKeyHashes) != 0 {
+		t.Errorf("GetEntities: OpKeyHashes: %v", entities[1].OpKeyHashes)
+	}
+
+	// all entities
+	keys, err := p.GetKeys(nil)
+	if err != nil {
+		t.Errorf("GetKeys: e: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("GetKeys: len: %v", len(keys))
+	}
+	if keys[0].Type != BackendKeyTypeOp || keys[0].Hash != opKey2.Hash || keys[0].ExpireTS.Ts != 1234567990 {
+		t.Errorf("GetKeys: invalid op-key: %v", keys[0])
+	}
+	if keys[1].Type != BackendKeyTypeOp || keys[1].Hash != opKey1.Hash {
+		t.Errorf("GetKeys: invalid op-key: %v", keys[1])
+	}
+	if keys[2].Type != BackendKeyTypeJoin || keys[2].Hash != joinKey.Hash || keys[2].ExpireTS.Ts != 1234567892+NJoinKeys*IntRenewJoinKeySeconds || !reflect.DeepEqual(keys[2].EntityID, id1) {
+		t.Errorf("GetKeys: invalid join-key: %v", keys[2])
+	}
+
+	// one entity
+	entityID, _ := id2.MarshalText()
+	keys, err = p.GetKeys(entityID)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("GetKeys: keys: %v e: %v", keys, err)
+	}
+
+	otherID, _ := (&types.PttID{3}).MarshalText()
+	_, err = p.GetKeys(otherID)
+	if err != ErrInvalidEntity {
+		t.Errorf("GetKeys: e: %v expected: %v", err, ErrInvalidEntity)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"time"
+
+	"github.com/ailabstw/go-pttai/log"
+)
+
+/*
+addHandler adds the in-flight handler of the msg, false if ptt is draining for stop / restart.
+*/
+func (p *BasePtt) addHandler() bool {
+	p.lockDrain.RLock()
+	defer p.lockDrain.RUnlock()
+
+	if p.isDraining {
+		return false
+	}
+
+	p.handlerWG.Add(1)
+
+	return true
+}
+
+func (p *BasePtt) doneHandler() {
+	p.handlerWG.Done()
+}
+
+/*
+drainHandlers rejects the new msgs and waits for the in-flight handlers
+to finish before stopping the services (at most DrainHandlersTimeout),
+so that the handlers are not cut in the middle with the dbs closed.
+*/
+func (p *BasePtt) drainHandlers() {
+	p.lockDrain.Lock()
+	p.isDraining = true
+	p.lockDrain.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.handlerWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Debug("drainHandlers: done")
+	case <-time.After(DrainHandlersTimeout):
+		log.Warn("drainHandlers: timeout", "timeout", DrainHandlersTimeout)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+)
+
+/*
+BackendHealth is the health of ptt.
+
+IsHealthy is false if any liveness-check fails, the node is stuck and should be restarted.
+IsReady is false if any check fails, the node should not take the requests for now.
+*/
+type BackendHealth struct {
+	IsHealthy bool                   `json:"H"`
+	IsReady   bool                   `json:"R"`
+	TS        types.Timestamp        `json:"T"`
+	Checks    []*BackendHealthCheck  `json:"C"`
+	Entities  []*BackendEntityHealth `json:"E,omitempty"`
+}
+
+type BackendHealthCheck struct {
+	Name        string `json:"N"`
+	IsOK        bool   `json:"OK"`
+	IsReadiness bool   `json:"RO"` // only affects the readiness
+	Msg         string `json:"M,omitempty"`
+}
+
+type BackendEntityHealth struct {
+	ID             *types.PttID    `json:"ID"`
+	Name           string          `json:"N"`
+	NPeers         int             `json:"NP"`
+	IsSyncRunning  bool            `json:"SR"`
+	SyncTS         types.Timestamp `json:"ST"`
+	SyncLagSeconds uint64          `json:"L"`
+}
+
+/*
+Health checks the p2p-server, the peers, the services, the db and the sync-loops of the entities.
+*/
+func (p *BasePtt) Health() (*BackendHealth, error) {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lockHealth.RLock()
+	startTS := p.startTS
+	startedServices := p.startedServices
+	p.lockHealth.RUnlock()
+
+	entities := p.entitiesHealth(startTS, ts)
+
+	checks := []*BackendHealthCheck{
+		p.checkP2P(),
+		p.checkPeers(),
+	}
+	checks = append(checks, p.checkServices(startedServices)...)
+	checks = append(checks,
+		checkDB(ts),
+		checkSyncRunning(entities),
+		p.checkSyncLag(entities),
+	)
+
+	health := &BackendHealth{
+		IsHealthy: true,
+		IsReady:   true,
+		TS:        ts,
+		Checks:    checks,
+		Entities:  entities,
+	}
+	for _, check := range checks {
+		if check.IsOK {
+			continue
+		}
+		health.IsReady = false
+		if !check.IsReadiness {
+			health.IsHealthy = false
+		}
+	}
+
+	if !health.IsHealthy {
+		log.Warn("Health: not healthy", "checks", failedHealthChecks(checks))
+	}
+
+	return health, nil
+}
+
+func (p *BasePtt) setStarted(services map[string]Service, ts types.Timestamp) {
+	startedServices := make(map[string]bool)
+	for name := range services {
+		startedServices[name] = true
+	}
+
+	p.lockHealth.Lock()
+	defer p.lockHealth.Unlock()
+
+	p.startTS = ts
+	p.startedServices = startedServices
+}
+
+func (p *BasePtt) entitiesHealth(startTS types.Timestamp, ts types.Timestamp) []*BackendEntityHealth {
+	entities := p.sortedEntities()
+
+	entitiesHealth := make([]*BackendEntityHealth, 0, len(entities))
+	for _, entity := range entities {
+		if entity.GetStatus() >= types.StatusDeleted {
+			continue
+		}
+
+		pm := entity.PM()
+		syncTS := pm.SyncTS()
+
+		// not synced since the start, counting from the start.
+		lastTS := syncTS
+		if lastTS.IsLess(startTS) {
+			lastTS = startTS
+		}
+
+		var lag uint64
+		if lastTS.Ts < ts.Ts {
+			lag = uint64(ts.Ts - lastTS.Ts)
+		}
+
+		entitiesHealth = append(entitiesHealth, &BackendEntityHealth{
+			ID:             entity.GetID(),
+			Name:           entity.Name(),
+			NPeers:         pm.Peers().Len(false),
+			IsSyncRunning:  pm.IsSyncRunning(),
+			SyncTS:         syncTS,
+			SyncLagSeconds: lag,
+		})
+	}
+
+	return entitiesHealth
+}
+
+func (p *BasePtt) checkP2P() *BackendHealthCheck {
+	check := &BackendHealthCheck{Name: HealthCheckP2P}
+
+	if p.server == nil || !p.server.IsRunning() {
+		check.Msg = "p2p server not running"
+		return check
+	}
+
+	check.IsOK = true
+	return check
+}
+
+func (p *BasePtt) checkPeers() *BackendHealthCheck {
+	nPeers := p.countPeers(PeerTypeMe) + p.countPeers(PeerTypeImportant) + p.countPeers(PeerTypeMember) + p.countPeers(PeerTypeRandom)
+
+	return &BackendHealthCheck{
+		Name:        HealthCheckPeers,
+		IsOK:        nPeers >= p.config.HealthMinPeers,
+		IsReadiness: true,
+		Msg:         fmt.Sprintf("%v peers (min: %v)", nPeers, p.config.HealthMinPeers),
+	}
+}
+
+func (p *BasePtt) checkServices(startedServices map[string]bool) []*BackendHealthCheck {
+	names := make([]string, 0, len(p.services))
+	for name := range p.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	checks := make([]*BackendHealthCheck, len(names))
+	for i, name := range names {
+		check := &BackendHealthCheck{Name: HealthCheckServicePrefix + name}
+		if startedServices[name] {
+			check.IsOK = true
+		} else {
+			check.Msg = "service not started"
+		}
+		checks[i] = check
+	}
+
+	return checks
+}
+
+/*
+checkDB checks that the meta-db is writable by putting and deleting the health-key.
+*/
+func checkDB(ts types.Timestamp) *BackendHealthCheck {
+	check := &BackendHealthCheck{Name: HealthCheckDB}
+
+	if dbMeta == nil {
+		check.Msg = "db not opened"
+		return check
+	}
+
+	val, err := ts.Marshal()
+	if err == nil {
+		err = dbMeta.Put(DBHealthPrefix, val)
+	}
+	if err == nil {
+		err = dbMeta.Delete(DBHealthPrefix)
+	}
+	if err != nil {
+		check.Msg = fmt.Sprintf("db not writable: %v", err)
+		return check
+	}
+
+	check.IsOK = true
+	return check
+}
+
+func checkSyncRunning(entities []*BackendEntityHealth) *BackendHealthCheck {
+	check := &BackendHealthCheck{Name: HealthCheckSync}
+
+	nStopped := 0
+	for _, entity := range entities {
+		if !entity.IsSyncRunning {
+			nStopped++
+		}
+	}
+
+	if nStopped != 0 {
+		check.Msg = fmt.Sprintf("sync not running: %v/%v entities", nStopped, len(entities))
+		return check
+	}
+
+	check.IsOK = true
+	return check
+}
+
+/*
+checkSyncLag checks that the entities are synced within HealthMaxSyncLagSeconds.
+The entities without peers are not counted because there is nothing to sync with.
+*/
+func (p *BasePtt) checkSyncLag(entities []*BackendEntityHealth) *BackendHealthCheck {
+	check := &BackendHealthCheck{Name: HealthCheckSyncLag}
+
+	maxLag := p.config.HealthMaxSyncLagSeconds
+	if maxLag == 0 {
+		check.IsOK = true
+		return check
+	}
+
+	nLagging := 0
+	for _, entity := range entities {
+		if entity.NPeers != 0 && entity.SyncLagSeconds > maxLag {
+			nLagging++
+		}
+	}
+
+	if nLagging != 0 {
+		check.Msg = fmt.Sprintf("not synced for %v seconds: %v/%v entities", maxLag, nLagging, len(entities))
+		return check
+	}
+
+	check.IsOK = true
+	return check
+}
+
+func failedHealthChecks(checks []*BackendHealthCheck) string {
+	failed := make([]string, 0, len(checks))
+	for _, check := range checks {
+		if !check.IsOK {
+			failed = append(failed, check.Name+": "+check.Msg)
+		}
+	}
+
+	return strings.Join(failed, "; ")
+}
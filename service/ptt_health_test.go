@@ -0,0 +1,148 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+func testHealthCheck(t *testing.T, health *BackendHealth, name string) *BackendHealthCheck {
+	for _, check := range health.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("Health: no check: %v", name)
+	return nil
+}
+
+func TestBasePtt_Health(t *testing.T) {
+	setupTest(t)
+	defer teardownTest(t)
+
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return types.Timestamp{Ts: 1234571500}, nil
+	}
+
+	origDBMeta := dbMeta
+	dbMeta = pttdb.NewMemLDBDatabase("meta")
+	defer func() {
+		dbMeta.Close()
+		dbMeta = origDBMeta
+	}()
+
+	key, _ := crypto.GenerateKey()
+	server := &p2p.Server{Config: p2p.Config{
+		Name:        "test",
+		MaxPeers:    10,
+		ListenAddr:  "127.0.0.1:0",
+		PrivateKey:  key,
+		NoDiscovery: true,
+	}}
+
+	id1 := &types.PttID{1}
+	id2 := &types.PttID{2}
+	entity1 := newTestCoreEntity(id1, nil, nil)
+	entity2 := newTestCoreEntity(id2, nil, nil)
+
+	p := &BasePtt{
+		config: &Config{
+			HealthMinPeers:          1,
+			HealthMaxSyncLagSeconds: 3000,
+		},
+		server: server,
+		entities: map[types.PttID]Entity{
+			*id1: entity1,
+			*id2: entity2,
+		},
+		services: map[string]Service{
+			"me":      nil,
+			"content": nil,
+		},
+	}
+
+	// not started
+	health, err := p.Health()
+	if err != nil {
+		t.Fatalf("Health: e: %v", err)
+	}
+	if health.IsHealthy || health.IsReady {
+		t.Errorf("Health: not started: %v %v", health.IsHealthy, health.IsReady)
+	}
+	for _, name := range []string{HealthCheckP2P, HealthCheckPeers, HealthCheckServicePrefix + "content", HealthCheckServicePrefix + "me", HealthCheckSync} {
+		if check := testHealthCheck(t, health, name); check.IsOK {
+			t.Errorf("Health: %v: ok", name)
+		}
+	}
+	if check := testHealthCheck(t, health, HealthCheckDB); !check.IsOK {
+		t.Errorf("Health: db: %v", check.Msg)
+	}
+	if len(health.Entities) != 2 || health.Entities[0].SyncLagSeconds != 3600 || health.Entities[0].IsSyncRunning {
+		t.Errorf("Health: invalid entity: %v", health.Entities[0])
+	}
+
+	// started without peers: healthy but not ready.
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start: e: %v", err)
+	}
+	defer server.Stop()
+
+	p.setStarted(p.services, types.Timestamp{Ts: 1234567800})
+	entity1.pm.(*testCorePM).b.SetIsSyncRunning(true)
+	entity2.pm.(*testCorePM).b.SetIsSyncRunning(true)
+
+	health, _ = p.Health()
+	if !health.IsHealthy || health.IsReady {
+		t.Errorf("Health: no peers: %v %v checks: %v", health.IsHealthy, health.IsReady, failedHealthChecks(health.Checks))
+	}
+	if check := testHealthCheck(t, health, HealthCheckPeers); check.IsOK || !check.IsReadiness {
+		t.Errorf("Health: peers: %v", check)
+	}
+
+	// the entity with peers is not synced for too long.
+	peerA, _, rwA, rwB := newTestPttPeers(Ptt1)
+	defer rwA.Close()
+	defer rwB.Close()
+
+	p.randomPeers = map[discover.NodeID]*PttPeer{*peerA.GetID(): peerA}
+	entity1.pm.Peers().Register(peerA, PeerTypeRandom, false)
+
+	health, _ = p.Health()
+	if health.IsHealthy || health.IsReady {
+		t.Errorf("Health: sync-lag: %v %v", health.IsHealthy, health.IsReady)
+	}
+	if check := testHealthCheck(t, health, HealthCheckSyncLag); check.IsOK {
+		t.Errorf("Health: sync-lag: ok")
+	}
+
+	// synced
+	entity1.pm.(*testCorePM).b.SetSyncTS(types.Timestamp{Ts: 1234571000})
+
+	health, _ = p.Health()
+	if !health.IsHealthy || !health.IsReady {
+		t.Errorf("Health: synced: %v %v checks: %v", health.IsHealthy, health.IsReady, failedHealthChecks(health.Checks))
+	}
+	if health.Entities[0].SyncLagSeconds != 500 || health.Entities[1].SyncLagSeconds != 3600 {
+		t.Errorf("Health: lag: %v %v", health.Entities[0].SyncLagSeconds, health.Entities[1].SyncLagSeconds)
+	}
+}
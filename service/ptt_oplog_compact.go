@@ -0,0 +1,83 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+)
+
+/*
+compactOplogsLoop compacts the oplogs of all the entities periodically,
+with the retention of the config.
+*/
+func (p *BasePtt) compactOplogsLoop() {
+	defer p.syncWG.Done()
+
+	ticker := time.NewTicker(CompactOplogsSeconds)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.compactAllOplogs(p.config.OplogRetentionSeconds)
+		case <-p.quitSync:
+			log.Debug("compactOplogsLoop: quit")
+			return
+		}
+	}
+}
+
+func (p *BasePtt) compactAllOplogs(retentionSeconds uint64) {
+	p.entityLock.RLock()
+	pms := make([]ProtocolManager, 0, len(p.entities))
+	for _, entity := range p.entities {
+		pms = append(pms, entity.PM())
+	}
+	p.entityLock.RUnlock()
+
+	ts := getOplogAuditTypes()
+
+	for _, pm := range pms {
+		for _, t := range ts {
+			_, err := p.compactOplogs(pm, t, retentionSeconds)
+			if err != nil {
+				log.Error("compactAllOplogs: unable to compact", "entity", pm.Entity().GetID(), "type", t.Name, "e", err)
+			}
+		}
+	}
+}
+
+func (p *BasePtt) compactOplogs(pm ProtocolManager, t *OplogAuditType, retentionSeconds uint64) (*OplogSnapshot, error) {
+	if t.IsSupersede == nil {
+		return nil, ErrInvalidOplogAuditType
+	}
+
+	cutoffTS, err := types.GetTimestamp()
+	if err != nil {
+		return nil, err
+	}
+	cutoffTS.Ts -= retentionSeconds
+
+	setDB := func(log *Oplog) {
+		t.SetDB(pm, log)
+	}
+
+	return CompactOplogs(setDB, t.IsSupersede, cutoffTS, p.myEntity.GetID(), p.SignKey())
+}
@@ -24,6 +24,8 @@ import (
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/p2p"
 	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/enr"
+	"github.com/ailabstw/go-pttai/rlp"
 )
 
 type PttPeer struct {
@@ -36,12 +38,19 @@ type PttPeer struct {
 
 	version uint
 
+	// negotiated in the handshake.
+	features   uint64
+	maxMsgSize uint64
+
 	term chan struct{} // Termination channel to stop the broadcaster
 
 	ptt *BasePtt
 
 	UserID *types.PttID
 
+	// node-record from the handshake, nil if not provided.
+	NodeRecord *NodeRecordEntry
+
 	lockID      sync.Mutex
 	IDEntityID  *types.PttID
 	IDChallenge *types.Salt
@@ -55,6 +64,9 @@ func NewPttPeer(version uint, p *p2p.Peer, rw p2p.MsgReadWriter, ptt *BasePtt) (
 		version: version,
 		ptt:     ptt,
 
+		features:   Ptt1Features,
+		maxMsgSize: ProtocolMaxMsgSize,
+
 		term:   make(chan struct{}),
 		IDChan: make(chan struct{}, 1),
 	}, nil
@@ -70,21 +82,26 @@ func (p *PttPeer) Broadcast() error {
 }
 
 func (p *PttPeer) Info() interface{} {
+	nodeType := NodeTypeUnknown
+	if p.NodeRecord != nil {
+		nodeType = p.NodeRecord.GetNodeType()
+	}
+
 	return &PttPeerInfo{
 		NodeID:   p.GetID(),
 		UserID:   p.UserID,
 		PeerType: p.PeerType,
+		NodeType: nodeType,
 	}
 }
 
-func (p *PttPeer) Handshake(networkID uint32) error {
+func (p *PttPeer) Handshake(networkID uint32, nodeRecord *enr.Record) error {
 	errc := make(chan error, 2)
 
+	status := p.newStatus(networkID, nodeRecord)
+
 	go func() {
-		errc <- p2p.Send(p.rw, uint64(CodeTypeStatus), &PttStatus{
-			Version:   uint32(p.version),
-			NetworkID: networkID,
-		})
+		errc <- p2p.Send(p.rw, uint64(CodeTypeStatus), status)
 	}()
 
 	go func() {
@@ -107,6 +124,38 @@ func (p *PttPeer) Handshake(networkID uint32) error {
 	return nil
 }
 
+/*
+newStatus returns the status of the version of the peer.
+The node-record is only in the status since Ptt2.
+*/
+func (p *PttPeer) newStatus(networkID uint32, nodeRecord *enr.Record) interface{} {
+	if p.version < Ptt2 {
+		return &PttStatus{
+			Version:   uint32(p.version),
+			NetworkID: networkID,
+		}
+	}
+
+	var nodeRecords []*enr.Record
+	if nodeRecord != nil {
+		nodeRecords = []*enr.Record{nodeRecord}
+	}
+
+	versions := make([]uint32, len(ProtocolVersions))
+	for i, version := range ProtocolVersions {
+		versions[i] = uint32(version)
+	}
+
+	return &PttStatus2{
+		Version:     uint32(p.version),
+		NetworkID:   networkID,
+		Versions:    versions,
+		Features:    PttFeatures,
+		MaxMsgSize:  ProtocolMaxMsgSize,
+		NodeRecords: nodeRecords,
+	}
+}
+
 func (p *PttPeer) ReadStatus(networkID uint32) error {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
@@ -121,7 +170,25 @@ func (p *PttPeer) ReadStatus(networkID uint32) error {
 		return ErrMsgTooLarge
 	}
 
-	status := &PttStatus{}
+	if p.version < Ptt2 {
+		status := &PttStatus{}
+		err = msg.Decode(&status)
+		if err != nil {
+			return err
+		}
+
+		if status.NetworkID != networkID {
+			return ErrInvalidData
+		}
+
+		if uint(status.Version) != p.version {
+			return ErrInvalidData
+		}
+
+		return nil
+	}
+
+	status := &PttStatus2{}
 	err = msg.Decode(&status)
 	if err != nil {
 		return err
@@ -131,9 +198,62 @@ func (p *PttPeer) ReadStatus(networkID uint32) error {
 		return ErrInvalidData
 	}
 
+	err = p.negotiate(status)
+	if err != nil {
+		return err
+	}
+
+	return p.setNodeRecord(status.NodeRecords)
+}
+
+/*
+negotiate negotiates the capabilities with the status from the peer.
+
+    1. the version is the highest common version (as the p2p-layer).
+    2. the features are the common features.
+    3. the max-msg-size is the smaller one.
+*/
+func (p *PttPeer) negotiate(status *PttStatus2) error {
 	if uint(status.Version) != p.version {
+		return ErrIncompatibleVersion
+	}
+
+	var version uint
+	for _, eachVersion := range status.Versions {
+		for _, myVersion := range ProtocolVersions {
+			if uint(eachVersion) == myVersion && myVersion > version {
+				version = myVersion
+			}
+		}
+	}
+	if version != p.version {
+		return ErrIncompatibleVersion
+	}
+
+	if status.MaxMsgSize == 0 {
 		return ErrInvalidData
 	}
+	maxMsgSize := uint64(ProtocolMaxMsgSize)
+	if status.MaxMsgSize < maxMsgSize {
+		maxMsgSize = status.MaxMsgSize
+	}
+
+	p.features = status.Features & PttFeatures
+	p.maxMsgSize = maxMsgSize
+
+	return nil
+}
+
+func (p *PttPeer) setNodeRecord(nodeRecords []*enr.Record) error {
+	if len(nodeRecords) == 0 {
+		return nil
+	}
+
+	nodeRecord, err := ParseNodeRecord(nodeRecords[0], p.GetID())
+	if err != nil {
+		return err
+	}
+	p.NodeRecord = nodeRecord
 
 	return nil
 }
@@ -146,13 +266,36 @@ func (p *PttPeer) Version() uint {
 	return p.version
 }
 
+/*
+IsSupportFeature returns whether the feature is supported by the peer, negotiated in the handshake.
+*/
+func (p *PttPeer) IsSupportFeature(feature uint64) bool {
+	return p.features&feature == feature
+}
+
+/*
+MaxMsgSize returns the max msg-size accepted by the peer, negotiated in the handshake.
+*/
+func (p *PttPeer) MaxMsgSize() uint64 {
+	return p.maxMsgSize
+}
+
 func (p *PttPeer) RW() p2p.MsgReadWriter {
 	return p.rw
 }
 
 func (p *PttPeer) SendData(data *PttData) error {
 	//log.Debug("SendData", "p", p, "data", data)
-	return p2p.Send(p.rw, uint64(data.Code), data)
+	size, r, err := rlp.EncodeToReader(data)
+	if err != nil {
+		return err
+	}
+
+	if uint64(size) > p.maxMsgSize {
+		return ErrMsgTooLarge
+	}
+
+	return p.rw.WriteMsg(p2p.Msg{Code: uint64(data.Code), Size: uint32(size), Payload: r})
 }
 
 /**********
@@ -0,0 +1,150 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/enr"
+)
+
+func newTestPttPeers(version uint) (*PttPeer, *PttPeer, *p2p.MsgPipeRW, *p2p.MsgPipeRW) {
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+
+	rwA, rwB := p2p.MsgPipe()
+
+	// peerB is B seen from A, peerA is A seen from B.
+	peerB, _ := NewPttPeer(version, p2p.NewPeer(discover.PubkeyID(&keyB.PublicKey), "B", nil), rwA, nil)
+	peerA, _ := NewPttPeer(version, p2p.NewPeer(discover.PubkeyID(&keyA.PublicKey), "A", nil), rwB, nil)
+
+	return peerA, peerB, rwA, rwB
+}
+
+func TestPttPeer_HandshakeVersions(t *testing.T) {
+	p := &BasePtt{}
+
+	for _, version := range ProtocolVersions {
+		peerA, peerB, rwA, rwB := newTestPttPeers(version)
+
+		errc := make(chan error, 2)
+		go func() { errc <- peerB.Handshake(1, nil) }()
+		go func() { errc <- peerA.Handshake(1, nil) }()
+		for i := 0; i < 2; i++ {
+			if err := <-errc; err != nil {
+				t.Fatalf("Handshake: version: %v e: %v", version, err)
+			}
+		}
+
+		if peerA.Version() != version || peerB.Version() != version {
+			t.Errorf("Handshake: invalid version: %v %v", peerA.Version(), peerB.Version())
+		}
+		isSupportFeatures := version >= Ptt2
+		if peerA.IsSupportFeature(PttFeatureRelay) != isSupportFeatures || peerA.IsSupportFeature(PttFeatureMailbox) != isSupportFeatures || peerA.MaxMsgSize() != ProtocolMaxMsgSize {
+			t.Errorf("Handshake: version: %v invalid capabilities: %v %v", version, peerA.features, peerA.MaxMsgSize())
+		}
+
+		// the messages are the same for the versions.
+		pttData, _ := p.MarshalData(CodeTypeOp, &common.Address{}, []byte("test"))
+		go func() { errc <- peerB.SendData(pttData) }()
+
+		msg, err := rwB.ReadMsg()
+		if err != nil {
+			t.Fatalf("ReadMsg: version: %v e: %v", version, err)
+		}
+		data := &PttData{}
+		err = msg.Decode(data)
+		if err != nil || CodeType(msg.Code) != CodeTypeOp || string(data.EvWithSalt) != string(pttData.EvWithSalt) {
+			t.Errorf("Decode: version: %v code: %v e: %v", version, msg.Code, err)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("SendData: version: %v e: %v", version, err)
+		}
+
+		rwA.Close()
+		rwB.Close()
+	}
+}
+
+// testPttStatus3 is the status from the future version with more capabilities.
+type testPttStatus3 struct {
+	Version   uint32
+	NetworkID uint32
+
+	Versions   []uint32
+	Features   uint64
+	MaxMsgSize uint64
+
+	NodeRecords []*enr.Record
+
+	Extra string
+}
+
+func TestPttPeer_negotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		status interface{}
+		err    error
+	}{
+		{"future", &testPttStatus3{Version: uint32(Ptt2), NetworkID: 1, Versions: []uint32{99, uint32(Ptt2), uint32(Ptt1)}, Features: PttFeatureMailbox | 1<<30, MaxMsgSize: 100, Extra: "extra"}, nil},
+		{"version", &PttStatus2{Version: uint32(Ptt1), NetworkID: 1, Versions: []uint32{uint32(Ptt2), uint32(Ptt1)}, MaxMsgSize: 100}, ErrIncompatibleVersion},
+		{"versions", &PttStatus2{Version: uint32(Ptt2), NetworkID: 1, Versions: []uint32{uint32(Ptt1)}, MaxMsgSize: 100}, ErrIncompatibleVersion},
+		{"max-msg-size", &PttStatus2{Version: uint32(Ptt2), NetworkID: 1, Versions: []uint32{uint32(Ptt2)}}, ErrInvalidData},
+		{"network", &PttStatus2{Version: uint32(Ptt2), NetworkID: 2, Versions: []uint32{uint32(Ptt2)}, MaxMsgSize: 100}, ErrInvalidData},
+	}
+
+	for _, tt := range tests {
+		_, peerB, rwA, rwB := newTestPttPeers(Ptt2)
+
+		go p2p.Send(rwB, uint64(CodeTypeStatus), tt.status)
+		err := peerB.ReadStatus(1)
+		if err != tt.err {
+			t.Errorf("ReadStatus: %v: e: %v expected: %v", tt.name, err, tt.err)
+		}
+
+		if tt.err == nil {
+			if !peerB.IsSupportFeature(PttFeatureMailbox) || peerB.IsSupportFeature(PttFeatureRelay) || peerB.features != PttFeatureMailbox {
+				t.Errorf("ReadStatus: %v: invalid features: %v", tt.name, peerB.features)
+			}
+			if peerB.MaxMsgSize() != 100 {
+				t.Errorf("ReadStatus: %v: invalid max-msg-size: %v", tt.name, peerB.MaxMsgSize())
+			}
+
+			pttData := &PttData{Code: CodeTypeOp, EvWithSalt: make([]byte, 200)}
+			if err := peerB.SendData(pttData); err != ErrMsgTooLarge {
+				t.Errorf("SendData: %v: expected too large: e: %v", tt.name, err)
+			}
+		}
+
+		rwA.Close()
+		rwB.Close()
+	}
+
+	// ptt1-status to ptt2-peer.
+	_, peerB, rwA, rwB := newTestPttPeers(Ptt2)
+	defer rwA.Close()
+	defer rwB.Close()
+
+	go p2p.Send(rwB, uint64(CodeTypeStatus), &PttStatus{Version: uint32(Ptt2), NetworkID: 1})
+	if err := peerB.ReadStatus(1); err == nil {
+		t.Errorf("ReadStatus: expected err with ptt1-status")
+	}
+}
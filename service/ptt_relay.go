@@ -0,0 +1,438 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"net"
+	"time"
+
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/netutil"
+)
+
+/**********
+ * relay-node
+ **********/
+
+/*
+isPublicNode checks whether the node is reachable from the public network.
+The unspecified ip is not able to be determined, and is treated as public.
+*/
+func isPublicNode(ip net.IP) bool {
+	return ip == nil || ip.IsUnspecified() || !netutil.IsLAN(ip)
+}
+
+/*
+HandleRelayFrame handles the relay-frame from the peer.
+ 1. relay-fail: the receiver is not reachable through the relay-node, close the relay-session.
+ 2. the frame is for me: deliver to the relay-session (accept the new relay-session).
+ 3. the frame is for others: forward to the receiver if I am a relay-node.
+*/
+func (p *BasePtt) HandleRelayFrame(code CodeType, frame *RelayFrame, peer *PttPeer) error {
+	if p.getRelaySession(peer.GetID()) != nil {
+		return ErrInvalidRelay
+	}
+
+	if code == CodeTypeRelayFail {
+		fromID, err := bytesToNodeID(frame.From)
+		if err != nil {
+			return err
+		}
+		return p.handleRelayFail(fromID, peer)
+	}
+
+	toID, err := bytesToNodeID(frame.To)
+	if err != nil {
+		return err
+	}
+
+	if *toID == *p.myNodeID {
+		return p.receiveRelayFrame(frame, peer)
+	}
+
+	return p.forwardRelayFrame(toID, frame, peer)
+}
+
+/*
+forwardRelayFrame forwards the relay-frame to the receiver without looking into the payload.
+*/
+func (p *BasePtt) forwardRelayFrame(toID *discover.NodeID, frame *RelayFrame, peer *PttPeer) error {
+	if !p.isRelay {
+		return ErrNotRelay
+	}
+
+	// bandwidth-caps
+	size := len(frame.Payload)
+	if !p.relayLimiter.Allow(size) || !p.peerRelayLimiter(peer.GetID()).Allow(size) {
+		log.Warn("forwardRelayFrame: exceed bandwidth, drop", "from", peer, "to", toID, "size", size)
+		return nil
+	}
+
+	// only forward to the direct peers.
+	target := p.GetPeer(toID, false)
+	if target == nil || !target.IsSupportFeature(PttFeatureRelay) || p.getRelaySession(toID) != nil {
+		return p2p.Send(peer.RW(), uint64(CodeTypeRelayFail), &RelayFrame{
+			From: toID[:],
+			To:   peer.GetID()[:],
+		})
+	}
+
+	// the sender is from the authenticated connection.
+	frame.From = peer.GetID()[:]
+
+	return p2p.Send(target.RW(), uint64(CodeTypeRelay), frame)
+}
+
+func (p *BasePtt) peerRelayLimiter(nodeID *discover.NodeID) *relayLimiter {
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	limiter, ok := p.peerRelayLimiters[*nodeID]
+	if !ok {
+		limiter = newRelayLimiter(p.config.RelayMaxPeerBytesPerSecond)
+		p.peerRelayLimiters[*nodeID] = limiter
+	}
+
+	return limiter
+}
+
+/**********
+ * relay-session
+ **********/
+
+/*
+AddRelayPeer connects to the node through one of the relay-nodes.
+The relay-nodes are from the connected peers advertising relay in the node-record.
+If there is no available relay-node, we try to find the relay-nodes through topic-discovery.
+*/
+func (p *BasePtt) AddRelayPeer(nodeID *discover.NodeID) error {
+	if *nodeID == *p.myNodeID {
+		return ErrInvalidData
+	}
+
+	if p.GetPeer(nodeID, false) != nil || p.getRelaySession(nodeID) != nil {
+		return nil
+	}
+
+	relays := p.relayCandidates(nodeID)
+	if len(relays) == 0 {
+		if p.config.TopicDiscovery && p.server != nil && p.server.DiscV5 != nil {
+			go p.searchRelays()
+		}
+		return ErrNoRelay
+	}
+
+	relay := relays[0]
+	log.Debug("AddRelayPeer: to connect", "node", nodeID, "relay", relay)
+
+	session := newRelaySession(nodeID, relay)
+
+	p.lockRelay.Lock()
+	p.relaySessions[*nodeID] = session
+	p.lockRelay.Unlock()
+
+	go p.runRelayPeer(session)
+
+	return nil
+}
+
+/*
+relayCandidates gets the direct peers advertising relay in the node-record,
+without the relays failed recently for the node.
+*/
+func (p *BasePtt) relayCandidates(nodeID *discover.NodeID) []*PttPeer {
+	p.peerLock.RLock()
+	peers := make([]*PttPeer, 0, len(p.importantPeers)+len(p.memberPeers)+len(p.randomPeers))
+	for _, eachPeers := range []map[discover.NodeID]*PttPeer{p.importantPeers, p.memberPeers, p.randomPeers} {
+		for _, peer := range eachPeers {
+			peers = append(peers, peer)
+		}
+	}
+	p.peerLock.RUnlock()
+
+	now := time.Now()
+
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	relays := make([]*PttPeer, 0, len(peers))
+	for _, peer := range peers {
+		if peer.NodeRecord == nil || !peer.NodeRecord.IsRelay() || !peer.IsSupportFeature(PttFeatureRelay) {
+			continue
+		}
+		if _, ok := p.relaySessions[peer.ID()]; ok {
+			continue
+		}
+		failTime, ok := p.relayFails[relayFailKey{NodeID: *nodeID, RelayID: peer.ID()}]
+		if ok && now.Sub(failTime) < ExpireRelayFailSeconds {
+			continue
+		}
+		relays = append(relays, peer)
+	}
+
+	return randomPttPeers(relays)
+}
+
+/*
+searchRelays searches and dials the relay-nodes through topic-discovery.
+*/
+func (p *BasePtt) searchRelays() {
+	nodes, err := p.searchTopic(RelayTopic, SearchEntityPeersSeconds, MaxSearchEntityPeers)
+	if err != nil {
+		log.Warn("searchRelays: unable to search", "e", err)
+		return
+	}
+
+	for _, node := range nodes {
+		p.server.AddPeer(node)
+	}
+}
+
+/*
+receiveRelayFrame delivers the frame to the relay-session.
+The relay-session is accepted if this is the first frame from the sender.
+*/
+func (p *BasePtt) receiveRelayFrame(frame *RelayFrame, relay *PttPeer) error {
+	fromID, err := bytesToNodeID(frame.From)
+	if err != nil {
+		return err
+	}
+
+	// already connected directly.
+	if p.GetPeer(fromID, false) != nil && p.getRelaySession(fromID) == nil {
+		return ErrAlreadyRegistered
+	}
+
+	p.lockRelay.Lock()
+	session, ok := p.relaySessions[*fromID]
+	if !ok {
+		session = newRelaySession(fromID, relay)
+		p.relaySessions[*fromID] = session
+		go p.runRelayPeer(session)
+	}
+	p.lockRelay.Unlock()
+
+	if session.relay.ID() != relay.ID() {
+		return ErrInvalidRelay
+	}
+
+	return session.deliver(frame)
+}
+
+func (p *BasePtt) handleRelayFail(nodeID *discover.NodeID, relay *PttPeer) error {
+	log.Debug("handleRelayFail: not reachable", "node", nodeID, "relay", relay)
+
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	session, ok := p.relaySessions[*nodeID]
+	if !ok || session.relay.ID() != relay.ID() {
+		return nil
+	}
+
+	now := time.Now()
+	for key, failTime := range p.relayFails {
+		if now.Sub(failTime) >= ExpireRelayFailSeconds {
+			delete(p.relayFails, key)
+		}
+	}
+	p.relayFails[relayFailKey{NodeID: *nodeID, RelayID: relay.ID()}] = now
+
+	delete(p.relaySessions, *nodeID)
+	session.Close()
+
+	return nil
+}
+
+/*
+runRelayPeer runs the peer connected through the relay-session as the direct peer.
+*/
+func (p *BasePtt) runRelayPeer(session *relaySession) {
+	p.peerWG.Add(1)
+	defer p.peerWG.Done()
+
+	defer p.closeRelaySession(session)
+
+	err := p.authRelaySession(session)
+	if err != nil {
+		log.Warn("runRelayPeer: unable to auth", "node", session.nodeID, "relay", session.relay, "e", err)
+		return
+	}
+
+	peer, err := p.NewPeer(RelayPeerVersion, p2p.NewPeer(*session.nodeID, RelayPeerName, nil), session)
+	if err != nil {
+		log.Error("runRelayPeer: unable to new peer", "node", session.nodeID, "e", err)
+		return
+	}
+
+	err = p.HandlePeer(peer)
+	log.Debug("runRelayPeer: after HandlePeer", "node", session.nodeID, "relay", session.relay, "e", err)
+}
+
+/*
+authRelaySession authenticates the node of the relay-session end-to-end.
+The From of the relay-frames is set by the relay-node, and is not trusted:
+ 1. send the challenge.
+ 2. sign the challenge from the node with my node-key.
+ 3. verify that the signature of my challenge is from the node of the relay-session.
+*/
+func (p *BasePtt) authRelaySession(session *relaySession) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.authRelaySessionCore(session)
+	}()
+
+	timeout := time.NewTimer(HandshakeTimeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-timeout.C:
+		return p2p.DiscReadTimeout
+	}
+}
+
+func (p *BasePtt) authRelaySessionCore(session *relaySession) error {
+	if p.nodeKey == nil {
+		return ErrInvalidKey
+	}
+
+	// 1. challenge
+	challenge := GenChallenge()
+	err := p2p.Send(session, uint64(CodeTypeRelayAuth), &RelayAuth{Challenge: challenge})
+	if err != nil {
+		return err
+	}
+
+	auth := &RelayAuth{}
+	err = readRelayAuthMsg(session, CodeTypeRelayAuth, auth)
+	if err != nil {
+		return err
+	}
+	if len(auth.Challenge) != SizeChallenge {
+		return ErrInvalidRelay
+	}
+
+	// 2. sign
+	sig, err := crypto.Sign(relayAuthHash(auth.Challenge, p.myNodeID, session.nodeID), p.nodeKey)
+	if err != nil {
+		return err
+	}
+	err = p2p.Send(session, uint64(CodeTypeRelayAuthAck), &RelayAuthAck{Sig: sig})
+	if err != nil {
+		return err
+	}
+
+	// 3. verify
+	ack := &RelayAuthAck{}
+	err = readRelayAuthMsg(session, CodeTypeRelayAuthAck, ack)
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := crypto.SigToPub(relayAuthHash(challenge, session.nodeID, p.myNodeID), ack.Sig)
+	if err != nil {
+		return ErrInvalidRelay
+	}
+	if discover.PubkeyID(pubKey) != *session.nodeID {
+		return ErrInvalidRelay
+	}
+
+	return nil
+}
+
+func readRelayAuthMsg(session *relaySession, code CodeType, data interface{}) error {
+	msg, err := session.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if CodeType(msg.Code) != code {
+		return ErrInvalidRelay
+	}
+
+	err = msg.Decode(data)
+	if err != nil {
+		return ErrInvalidRelay
+	}
+
+	return nil
+}
+
+func relayAuthHash(challenge []byte, signerID *discover.NodeID, challengerID *discover.NodeID) []byte {
+	return crypto.Keccak256(challenge, signerID[:], challengerID[:])
+}
+
+func (p *BasePtt) getRelaySession(nodeID *discover.NodeID) *relaySession {
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	return p.relaySessions[*nodeID]
+}
+
+func (p *BasePtt) closeRelaySession(session *relaySession) {
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	if p.relaySessions[*session.nodeID] == session {
+		delete(p.relaySessions, *session.nodeID)
+	}
+	session.Close()
+}
+
+/*
+removeRelayPeer closes the relay-session of the peer,
+and the relay-sessions through the peer.
+*/
+func (p *BasePtt) removeRelayPeer(nodeID *discover.NodeID) {
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	delete(p.peerRelayLimiters, *nodeID)
+
+	for eachNodeID, session := range p.relaySessions {
+		if eachNodeID == *nodeID || session.relay.ID() == *nodeID {
+			delete(p.relaySessions, eachNodeID)
+			session.Close()
+		}
+	}
+}
+
+func (p *BasePtt) closeAllRelaySessions() {
+	p.lockRelay.Lock()
+	defer p.lockRelay.Unlock()
+
+	for nodeID, session := range p.relaySessions {
+		delete(p.relaySessions, nodeID)
+		session.Close()
+	}
+}
+
+func bytesToNodeID(b []byte) (*discover.NodeID, error) {
+	nodeID := &discover.NodeID{}
+	if len(b) != len(nodeID) {
+		return nil, ErrInvalidData
+	}
+	copy(nodeID[:], b)
+
+	return nodeID, nil
+}
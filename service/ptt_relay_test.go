@@ -0,0 +1,325 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common"
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/rlp"
+)
+
+func TestRelayLimiter(t *testing.T) {
+	l := newRelayLimiter(100)
+	if !l.Allow(60) || !l.Allow(40) {
+		t.Errorf("Allow: expected within the burst")
+	}
+	if l.Allow(10) {
+		t.Errorf("Allow: expected exceed")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if !l.Allow(10) {
+		t.Errorf("Allow: expected refilled")
+	}
+
+	noLimit := newRelayLimiter(0)
+	if !noLimit.Allow(1 << 30) {
+		t.Errorf("Allow: expected no limit")
+	}
+}
+
+type testRelayPtt struct {
+	*BasePtt
+	key *ecdsa.PrivateKey
+}
+
+func newTestRelayPtt(t *testing.T, isRelay bool) *testRelayPtt {
+	key, _ := crypto.GenerateKey()
+	nodeID := discover.PubkeyID(&key.PublicKey)
+
+	cfg := DefaultConfig
+	cfg.IsRelay = isRelay
+	cfg.NodeType = NodeTypeDesktop
+	if isRelay {
+		cfg.NodeType = NodeTypeServer
+	}
+
+	nodeRecord, err := NewNodeRecord(key, NewNodeRecordEntry(&cfg))
+	if err != nil {
+		t.Fatalf("unable to new node-record: e: %v", err)
+	}
+
+	p := &BasePtt{
+		config:   &cfg,
+		myNodeID: &nodeID,
+
+		myPeers:        make(map[discover.NodeID]*PttPeer),
+		importantPeers: make(map[discover.NodeID]*PttPeer),
+		memberPeers:    make(map[discover.NodeID]*PttPeer),
+		randomPeers:    make(map[discover.NodeID]*PttPeer),
+		userPeerMap:    make(map[types.PttID]*discover.NodeID),
+		dialHist:       NewDialHistory(),
+
+		nodeKey:    key,
+		nodeRecord: nodeRecord,
+		nodeTypes:  make(map[discover.NodeID]NodeType),
+
+		isRelay:           isRelay,
+		relayLimiter:      newRelayLimiter(cfg.RelayMaxBytesPerSecond),
+		relaySessions:     make(map[discover.NodeID]*relaySession),
+		relayFails:        make(map[relayFailKey]time.Time),
+		peerRelayLimiters: make(map[discover.NodeID]*relayLimiter),
+	}
+
+	return &testRelayPtt{BasePtt: p, key: key}
+}
+
+func connectTestRelayPtts(a, b *testRelayPtt) (*p2p.MsgPipeRW, *p2p.MsgPipeRW) {
+	rwA, rwB := p2p.MsgPipe()
+
+	peerB, _ := a.NewPeer(Ptt2, p2p.NewPeer(*b.myNodeID, "b", nil), rwA)
+	peerA, _ := b.NewPeer(Ptt2, p2p.NewPeer(*a.myNodeID, "a", nil), rwB)
+
+	go a.HandlePeer(peerB)
+	go b.HandlePeer(peerA)
+
+	return rwA, rwB
+}
+
+func waitTestRelayPeer(p *testRelayPtt, nodeID *discover.NodeID) *PttPeer {
+	for i := 0; i < 100; i++ {
+		peer := p.GetPeer(nodeID, false)
+		if peer != nil {
+			return peer
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestBasePtt_AddRelayPeer(t *testing.T) {
+	a := newTestRelayPtt(t, false)
+	r := newTestRelayPtt(t, true)
+	b := newTestRelayPtt(t, false)
+
+	rwAR, rwRA := connectTestRelayPtts(a, r)
+	defer rwAR.Close()
+	defer rwRA.Close()
+	rwBR, rwRB := connectTestRelayPtts(b, r)
+	defer rwBR.Close()
+	defer rwRB.Close()
+
+	if waitTestRelayPeer(a, r.myNodeID) == nil || waitTestRelayPeer(r, b.myNodeID) == nil {
+		t.Fatalf("unable to connect to the relay")
+	}
+
+	// a connects to b through r.
+	if err := a.AddRelayPeer(b.myNodeID); err != nil {
+		t.Fatalf("AddRelayPeer: e: %v", err)
+	}
+
+	peerB := waitTestRelayPeer(a, b.myNodeID)
+	if peerB == nil {
+		t.Fatalf("AddRelayPeer: a: unable to get b")
+	}
+	peerA := waitTestRelayPeer(b, a.myNodeID)
+	if peerA == nil {
+		t.Fatalf("AddRelayPeer: b: unable to get a")
+	}
+
+	// node-records are verified through the relay.
+	if peerB.NodeRecord == nil || peerB.NodeRecord.GetNodeType() != NodeTypeDesktop {
+		t.Errorf("AddRelayPeer: invalid node-record: %v", peerB.NodeRecord)
+	}
+
+	// the relay-node does not keep the relay-sessions.
+	if len(r.relaySessions) != 0 {
+		t.Errorf("AddRelayPeer: relay: relaySessions: %v", len(r.relaySessions))
+	}
+
+	// unable to relay through the virtual peers.
+	if len(b.relayCandidates(a.myNodeID)) != 1 {
+		t.Errorf("AddRelayPeer: relayCandidates: %v", len(b.relayCandidates(a.myNodeID)))
+	}
+
+	// the unreachable node.
+	c := newTestRelayPtt(t, false)
+
+	// no relay without the relay-flag in the node-record.
+	if err := r.AddRelayPeer(c.myNodeID); err != ErrNoRelay {
+		t.Errorf("AddRelayPeer: no relay: e: %v", err)
+	}
+
+	if err := a.AddRelayPeer(c.myNodeID); err != nil {
+		t.Fatalf("AddRelayPeer: c: e: %v", err)
+	}
+	for i := 0; i < 100 && a.getRelaySession(c.myNodeID) != nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if a.getRelaySession(c.myNodeID) != nil {
+		t.Errorf("AddRelayPeer: c: relay-session not closed")
+	}
+	if err := a.AddRelayPeer(c.myNodeID); err != ErrNoRelay {
+		t.Errorf("AddRelayPeer: c: failed relay: e: %v", err)
+	}
+
+	// the relay-sessions are closed with the relay-node.
+	a.RemovePeer(a.GetPeer(r.myNodeID, false), false)
+	if a.getRelaySession(b.myNodeID) != nil {
+		t.Errorf("RemovePeer: relay-session not closed")
+	}
+}
+
+func TestBasePtt_HandleRelayFrame(t *testing.T) {
+	r := newTestRelayPtt(t, true)
+	a := newTestRelayPtt(t, false)
+	b := newTestRelayPtt(t, false)
+
+	rwRA, rwAR := p2p.MsgPipe()
+	defer rwRA.Close()
+	rwRB, rwBR := p2p.MsgPipe()
+	defer rwRB.Close()
+
+	peerA, _ := r.NewPeer(Ptt2, p2p.NewPeer(*a.myNodeID, "a", nil), rwRA)
+	peerB, _ := r.NewPeer(Ptt2, p2p.NewPeer(*b.myNodeID, "b", nil), rwRB)
+	peerA.features = PttFeatures
+	peerB.features = PttFeatures
+	r.SetPeerType(peerB, PeerTypeRandom, false, false)
+
+	// the encrypted ptt-frame from a to b.
+	key, _ := crypto.GenerateKey()
+	keyInfo := &KeyInfo{Key: key, KeyBytes: crypto.FromECDSA(key)}
+	encData, err := a.EncryptData(ZeroOpType, []byte("secret"), keyInfo)
+	if err != nil {
+		t.Fatalf("unable to encrypt: e: %v", err)
+	}
+	hash := &common.Address{}
+	pttData, _ := a.MarshalData(CodeTypeOp, hash, encData)
+	payload, _ := rlp.EncodeToBytes(pttData)
+
+	frame := &RelayFrame{To: b.myNodeID[:], Code: uint64(CodeTypeOp), Payload: payload}
+
+	errc := make(chan error, 1)
+	go func() { errc <- r.HandleRelayFrame(CodeTypeRelay, frame, peerA) }()
+
+	msg, err := rwBR.ReadMsg()
+	if err != nil {
+		t.Fatalf("unable to read: e: %v", err)
+	}
+	forwarded := &RelayFrame{}
+	msg.Decode(forwarded)
+	if err := <-errc; err != nil {
+		t.Errorf("HandleRelayFrame: e: %v", err)
+	}
+
+	if !bytes.Equal(forwarded.Payload, payload) || !bytes.Equal(forwarded.From, a.myNodeID[:]) {
+		t.Errorf("HandleRelayFrame: invalid forwarded frame")
+	}
+	if bytes.Contains(forwarded.Payload, []byte("secret")) {
+		t.Errorf("HandleRelayFrame: plaintext in the relayed payload")
+	}
+
+	// the unreachable node.
+	go func() {
+		errc <- r.HandleRelayFrame(CodeTypeRelay, &RelayFrame{To: make([]byte, len(discover.NodeID{}))}, peerA)
+	}()
+	msg, err = rwAR.ReadMsg()
+	if err != nil || msg.Code != uint64(CodeTypeRelayFail) {
+		t.Errorf("HandleRelayFrame: expected relay-fail: code: %v e: %v", msg.Code, err)
+	}
+	msg.Discard()
+	<-errc
+
+	// bandwidth-caps
+	r.config.RelayMaxPeerBytesPerSecond = 1
+	r.peerRelayLimiters = make(map[discover.NodeID]*relayLimiter)
+	if err := r.HandleRelayFrame(CodeTypeRelay, frame, peerA); err != nil {
+		t.Errorf("HandleRelayFrame: exceed bandwidth: e: %v", err)
+	}
+
+	// not a relay-node
+	if err := a.HandleRelayFrame(CodeTypeRelay, frame, peerB); err != ErrNotRelay {
+		t.Errorf("HandleRelayFrame: not relay: e: %v", err)
+	}
+}
+
+/*
+newTestRelaySession creates the relay-session of p to the node,
+with the frames sent through the relay delivered to the session of the other end.
+*/
+func newTestRelaySession(p *testRelayPtt, nodeID *discover.NodeID, other **relaySession) (*relaySession, *p2p.MsgPipeRW) {
+	rw, rwRelay := p2p.MsgPipe()
+	relay, _ := p.NewPeer(Ptt2, p2p.NewPeer(discover.NodeID{}, "r", nil), rw)
+
+	go func() {
+		for {
+			msg, err := rwRelay.ReadMsg()
+			if err != nil {
+				return
+			}
+			frame := &RelayFrame{}
+			msg.Decode(frame)
+			(*other).deliver(frame)
+		}
+	}()
+
+	return newRelaySession(nodeID, relay), rw
+}
+
+func TestBasePtt_authRelaySession(t *testing.T) {
+	a := newTestRelayPtt(t, false)
+	b := newTestRelayPtt(t, false)
+	c := newTestRelayPtt(t, false)
+
+	auth := func(nodeIDOfA *discover.NodeID) (error, error) {
+		var sessionA, sessionB *relaySession
+		sessionB, rwB := newTestRelaySession(b, nodeIDOfA, &sessionA)
+		defer rwB.Close()
+		sessionA, rwA := newTestRelaySession(a, b.myNodeID, &sessionB)
+		defer rwA.Close()
+
+		errc := make(chan error, 1)
+		go func() { errc <- a.authRelaySession(sessionA) }()
+
+		errB := b.authRelaySession(sessionB)
+		sessionB.Close()
+		errA := <-errc
+		sessionA.Close()
+
+		return errA, errB
+	}
+
+	// valid
+	errA, errB := auth(a.myNodeID)
+	if errA != nil || errB != nil {
+		t.Errorf("authRelaySession: a: e: %v b: e: %v", errA, errB)
+	}
+
+	// the relay-node claims that the frames from a are from c.
+	_, errB = auth(c.myNodeID)
+	if errB != ErrInvalidRelay {
+		t.Errorf("authRelaySession: spoofed: e: %v, want: %v", errB, ErrInvalidRelay)
+	}
+}
@@ -112,6 +112,7 @@ func (p *BasePtt) NodeInfo() interface{} {
 	return &PttNodeInfo{
 		NodeID:   p.myNodeID,
 		UserID:   userID,
+		NodeType: p.config.NodeType,
 		Peers:    peers,
 		Entities: len(p.entities),
 		Services: len(p.services),
@@ -0,0 +1,186 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/discv5"
+)
+
+/*
+EntityTopic is the discv5-topic of the entity.
+The topic is derived from the PttID (instead of the op-key-hash) because the op-key is renewed periodically.
+*/
+func EntityTopic(entityID *types.PttID) discv5.Topic {
+	return discv5.EntityTopic(ProtocolName, entityID[:])
+}
+
+func (p *BasePtt) topicNetwork() (*discv5.Network, error) {
+	if p.server == nil || p.server.DiscV5 == nil {
+		return nil, ErrNoTopicDiscovery
+	}
+
+	return p.server.DiscV5, nil
+}
+
+/*
+RegisterEntityTopic registers the topic of the entity to discv5,
+so the joiners / syncers are able to find the members without direct peers.
+
+Do nothing if topic-discovery is not enabled in the config.
+*/
+func (p *BasePtt) RegisterEntityTopic(entityID *types.PttID) error {
+	if !p.config.TopicDiscovery {
+		return nil
+	}
+
+	net, err := p.topicNetwork()
+	if err != nil {
+		return err
+	}
+
+	p.lockTopics.Lock()
+	defer p.lockTopics.Unlock()
+
+	if _, ok := p.topics[*entityID]; ok {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	p.topics[*entityID] = stop
+
+	topic := EntityTopic(entityID)
+	log.Debug("RegisterEntityTopic", "entityID", entityID, "topic", topic)
+
+	go net.RegisterTopic(topic, stop)
+
+	return nil
+}
+
+func (p *BasePtt) UnregisterEntityTopic(entityID *types.PttID) error {
+	p.lockTopics.Lock()
+	defer p.lockTopics.Unlock()
+
+	stop, ok := p.topics[*entityID]
+	if !ok {
+		return nil
+	}
+
+	close(stop)
+	delete(p.topics, *entityID)
+
+	return nil
+}
+
+func (p *BasePtt) unregisterAllEntityTopics() {
+	p.lockTopics.Lock()
+	defer p.lockTopics.Unlock()
+
+	for entityID, stop := range p.topics {
+		close(stop)
+		delete(p.topics, entityID)
+	}
+}
+
+/*
+SearchEntityTopic searches the nodes registered the topic of the entity,
+until timeout or maxNodes nodes are found.
+*/
+func (p *BasePtt) SearchEntityTopic(entityID *types.PttID, timeout time.Duration, maxNodes int) ([]*discover.Node, error) {
+	if !p.config.TopicDiscovery {
+		return nil, ErrNoTopicDiscovery
+	}
+
+	return p.searchTopic(EntityTopic(entityID), timeout, maxNodes)
+}
+
+func (p *BasePtt) searchTopic(topic discv5.Topic, timeout time.Duration, maxNodes int) ([]*discover.Node, error) {
+	net, err := p.topicNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	// discv5 keeps only one search for each topic.
+	p.lockTopics.Lock()
+	if p.searchTopics[topic] {
+		p.lockTopics.Unlock()
+		return nil, ErrBusy
+	}
+	p.searchTopics[topic] = true
+	p.lockTopics.Unlock()
+
+	defer func() {
+		p.lockTopics.Lock()
+		delete(p.searchTopics, topic)
+		p.lockTopics.Unlock()
+	}()
+
+	setPeriod := make(chan time.Duration, 1)
+	found := make(chan *discv5.Node, maxNodes)
+
+	setPeriod <- SearchEntityTopicPeriod
+	go net.SearchTopic(topic, setPeriod, found, nil)
+	defer close(setPeriod)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	nodes := make([]*discover.Node, 0, maxNodes)
+	nodeIDs := make(map[discv5.NodeID]bool)
+	for len(nodes) < maxNodes {
+		select {
+		case n := <-found:
+			nodeID := discover.NodeID(n.ID)
+			if nodeIDs[n.ID] || (p.myNodeID != nil && nodeID == *p.myNodeID) {
+				continue
+			}
+			nodeIDs[n.ID] = true
+			nodes = append(nodes, discover.NewNode(nodeID, n.IP, n.UDP, n.TCP))
+		case <-timer.C:
+			return nodes, nil
+		case <-p.quitSync:
+			return nodes, nil
+		}
+	}
+
+	return nodes, nil
+}
+
+/*
+SearchEntityPeers searches the nodes of the entity through the topic-discovery,
+and dials the found nodes, preferring the server-nodes.
+*/
+func (p *BasePtt) SearchEntityPeers(entityID *types.PttID, timeout time.Duration, maxNodes int) ([]*discover.Node, error) {
+	nodes, err := p.SearchEntityTopic(entityID, timeout, maxNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("SearchEntityPeers: found", "entityID", entityID, "nodes", len(nodes))
+
+	nodes = p.preferNodes(nodes)
+
+	for _, node := range nodes {
+		p.server.AddPeer(node)
+	}
+
+	return nodes, nil
+}
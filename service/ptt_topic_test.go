@@ -0,0 +1,166 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/event"
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discv5"
+)
+
+func TestEntityTopic(t *testing.T) {
+	topic := EntityTopic(tDefaultID)
+	if topic != EntityTopic(tDefaultID) {
+		t.Errorf("EntityTopic: not deterministic")
+	}
+	if !strings.HasPrefix(string(topic), ProtocolName+"/") || len(topic) != len(ProtocolName)+1+2*SizeEntityTopicHash {
+		t.Errorf("EntityTopic: invalid topic: %v", topic)
+	}
+
+	otherID := &types.PttID{}
+	copy(otherID[:], tDefaultID[:])
+	otherID[0]++
+	if topic == EntityTopic(otherID) {
+		t.Errorf("EntityTopic: same topic for different entities")
+	}
+}
+
+func TestBasePtt_RegisterEntityTopic(t *testing.T) {
+	p := &BasePtt{
+		config:       &Config{},
+		topics:       make(map[types.PttID]chan struct{}),
+		searchTopics: make(map[discv5.Topic]bool),
+		quitSync:     make(chan struct{}),
+	}
+
+	// disabled
+	if err := p.RegisterEntityTopic(tDefaultID); err != nil {
+		t.Errorf("RegisterEntityTopic: disabled: e: %v", err)
+	}
+	if len(p.topics) != 0 {
+		t.Errorf("RegisterEntityTopic: disabled: topics: %v", len(p.topics))
+	}
+	if _, err := p.SearchEntityTopic(tDefaultID, time.Second, 1); err != ErrNoTopicDiscovery {
+		t.Errorf("SearchEntityTopic: disabled: e: %v", err)
+	}
+
+	// enabled without v5 discovery
+	p.config.TopicDiscovery = true
+	p.server = &p2p.Server{}
+	if err := p.RegisterEntityTopic(tDefaultID); err != ErrNoTopicDiscovery {
+		t.Errorf("RegisterEntityTopic: no discv5: e: %v", err)
+	}
+
+	// enabled
+	key, _ := crypto.GenerateKey()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: e: %v", err)
+	}
+	ntab, err := discv5.ListenUDP(key, conn, conn.LocalAddr().(*net.UDPAddr), "", nil)
+	if err != nil {
+		t.Fatalf("unable to listen discv5: e: %v", err)
+	}
+	defer ntab.Close()
+	p.server.DiscV5 = ntab
+
+	if err := p.RegisterEntityTopic(tDefaultID); err != nil {
+		t.Errorf("RegisterEntityTopic: e: %v", err)
+	}
+	if err := p.RegisterEntityTopic(tDefaultID); err != nil {
+		t.Errorf("RegisterEntityTopic: again: e: %v", err)
+	}
+	if len(p.topics) != 1 {
+		t.Errorf("RegisterEntityTopic: topics: %v", len(p.topics))
+	}
+
+	// search without other nodes
+	nodes, err := p.SearchEntityTopic(tDefaultID, 100*time.Millisecond, 1)
+	if err != nil || len(nodes) != 0 {
+		t.Errorf("SearchEntityTopic: nodes: %v e: %v", len(nodes), err)
+	}
+	if len(p.searchTopics) != 0 {
+		t.Errorf("SearchEntityTopic: searchTopics: %v", len(p.searchTopics))
+	}
+
+	if err := p.UnregisterEntityTopic(tDefaultID); err != nil {
+		t.Errorf("UnregisterEntityTopic: e: %v", err)
+	}
+	if len(p.topics) != 0 {
+		t.Errorf("UnregisterEntityTopic: topics: %v", len(p.topics))
+	}
+}
+
+func TestBaseProtocolManager_StartRegisterTopic(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: e: %v", err)
+	}
+	ntab, err := discv5.ListenUDP(key, conn, conn.LocalAddr().(*net.UDPAddr), "", nil)
+	if err != nil {
+		t.Fatalf("unable to listen discv5: e: %v", err)
+	}
+	defer ntab.Close()
+
+	p := &BasePtt{
+		config:       &Config{TopicDiscovery: true},
+		server:       &p2p.Server{DiscV5: ntab},
+		topics:       make(map[types.PttID]chan struct{}),
+		searchTopics: make(map[discv5.Topic]bool),
+		quitSync:     make(chan struct{}),
+	}
+
+	pm := &BaseProtocolManager{
+		eventMux: new(event.TypeMux),
+		entity:   &testCoreEntity{id: tDefaultID},
+		ptt:      p,
+	}
+
+	// not public (ex: me, friends)
+	err = pm.Start()
+	if err != nil {
+		t.Errorf("Start: e: %v", err)
+	}
+	if len(p.topics) != 0 {
+		t.Errorf("Start: not public: topics: %v", len(p.topics))
+	}
+	pm.Stop()
+
+	// public
+	pm.eventMux = new(event.TypeMux)
+	pm.SetIsPublic(true)
+	err = pm.Start()
+	if err != nil {
+		t.Errorf("Start: e: %v", err)
+	}
+	if len(p.topics) != 1 {
+		t.Errorf("Start: public: topics: %v", len(p.topics))
+	}
+	pm.Stop()
+
+	if len(p.topics) != 0 {
+		t.Errorf("Stop: topics: %v", len(p.topics))
+	}
+}
@@ -21,6 +21,8 @@ import (
 
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/p2p/enr"
+	"github.com/ailabstw/go-pttai/rlp"
 )
 
 // CodeType
@@ -46,6 +48,17 @@ const (
 	CodeTypeIdentifyPeerWithMyIDChallengeAck
 	CodeTypeIdentifyPeerWithMyIDAck
 
+	CodeTypeRelay
+	CodeTypeRelayFail
+	CodeTypeRelayAuth
+	CodeTypeRelayAuthAck
+
+	CodeTypeMailboxPut
+	CodeTypeMailboxPutAck
+	CodeTypeMailboxDeliver
+	CodeTypeMailboxAck
+	CodeTypeMailboxReceipts
+
 	NCodeType
 )
 
@@ -120,21 +133,44 @@ func (p *PttData) Clone() *PttData {
 
 // PttStatus
 
+/*
+PttStatus is the status of Ptt1, the same as the nodes before Ptt2,
+which decode the status strictly (no extra fields, ex: the node-record).
+*/
 type PttStatus struct {
 	Version   uint32
 	NetworkID uint32
 }
 
+/*
+PttStatus2 is the status since Ptt2, with the capabilities and the node-record for the negotiation.
+*/
+type PttStatus2 struct {
+	Version   uint32
+	NetworkID uint32
+
+	Versions   []uint32
+	Features   uint64
+	MaxMsgSize uint64
+
+	NodeRecords []*enr.Record
+
+	// in tail for the compatibility with the future capabilities.
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
 // PttPeerInfo
 type PttPeerInfo struct {
 	NodeID   *discover.NodeID `json:"N"`
 	UserID   *types.PttID     `json:"U"`
 	PeerType PeerType         `json:"T"`
+	NodeType NodeType         `json:"NT"`
 }
 
 type PttNodeInfo struct {
-	NodeID *discover.NodeID `json:"N"`
-	UserID *types.PttID     `json:"U"`
+	NodeID   *discover.NodeID `json:"N"`
+	UserID   *types.PttID     `json:"U"`
+	NodeType NodeType         `json:"NT"`
 
 	Peers    int `json:"NP"`
 	Entities int `json:"NE"`
@@ -254,10 +254,12 @@ func (p *BasePtt) SendDataToPeer(code CodeType, data interface{}, peer *PttPeer)
 		return err
 	}
 
-	pttData, err := p.MarshalData(code, nil, marshaledData)
+	pttData, err := p.MarshalData(code, &common.Address{}, marshaledData)
 	if err != nil {
 		return err
 	}
 
+	pttData.Node = peer.GetID()[:]
+
 	return peer.SendData(pttData)
 }
@@ -21,6 +21,7 @@ import (
 
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/p2p"
 	"github.com/ailabstw/go-pttai/p2p/discover"
 )
 
@@ -39,6 +40,29 @@ func (p *BasePtt) HandleMessageWrapper(peer *PttPeer) error {
 	}
 	defer msg.Discard()
 
+	// drain
+	if !p.addHandler() {
+		return p2p.DiscQuitting
+	}
+	defer p.doneHandler()
+
+	// relay
+	code := CodeType(msg.Code)
+	if code == CodeTypeRelay || code == CodeTypeRelayFail {
+		frame := &RelayFrame{}
+		err = msg.Decode(frame)
+		if err != nil {
+			log.Error("HandleMessageWrapper: unable to decode relay-frame", "peer", peer, "e", err)
+			return nil
+		}
+
+		err = p.HandleRelayFrame(code, frame, peer)
+		if err != nil {
+			log.Warn("HandleMessageWrapper: unable to handle relay-frame", "code", code, "peer", peer, "e", err)
+		}
+		return nil
+	}
+
 	data := &PttData{}
 	err = msg.Decode(data)
 	if err != nil {
@@ -92,6 +116,16 @@ func (p *BasePtt) HandleMessage(code CodeType, data *PttData, peer *PttPeer) err
 		err = p.HandleCodeIdentifyPeerWithMyID(evHash, encData, peer)
 	case CodeTypeIdentifyPeerWithMyIDChallenge:
 		err = p.HandleCodeIdentifyPeerWithMyIDChallenge(evHash, encData, peer)
+	case CodeTypeMailboxPut:
+		err = p.HandleMailboxPut(encData, peer)
+	case CodeTypeMailboxPutAck:
+		err = p.HandleMailboxPutAck(encData, peer)
+	case CodeTypeMailboxDeliver:
+		err = p.HandleMailboxDeliver(encData, peer)
+	case CodeTypeMailboxAck:
+		err = p.HandleMailboxAck(encData, peer)
+	case CodeTypeMailboxReceipts:
+		err = p.HandleMailboxReceipts(encData, peer)
 	default:
 		err = ErrInvalidMsgCode
 	}
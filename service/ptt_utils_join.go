@@ -18,10 +18,13 @@ package service
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 
 	"github.com/ailabstw/go-pttai/common"
 	"github.com/ailabstw/go-pttai/common/types"
 	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/log"
+	"github.com/ailabstw/go-pttai/pttdb"
 )
 
 func joinKeyToKeyInfo(key *ecdsa.PrivateKey) *KeyInfo {
@@ -61,3 +64,115 @@ func (p *BasePtt) LockJoins() {
 func (p *BasePtt) UnlockJoins() {
 	p.lockJoins.Unlock()
 }
+
+/*
+resolveConfirmJoin resolves the entity and the peer of the confirm-join.
+The entity and the peer are not persisted and are nil for the confirm-joins loaded after restart,
+and the joiner may reconnect as a new peer.
+*/
+func (p *BasePtt) resolveConfirmJoin(confirmJoin *ConfirmJoin) (Entity, *PttPeer, error) {
+	entity := confirmJoin.Entity
+	if entity == nil && confirmJoin.EntityID != nil {
+		p.entityLock.RLock()
+		entity = p.entities[*confirmJoin.EntityID]
+		p.entityLock.RUnlock()
+	}
+	if entity == nil {
+		return nil, nil, ErrInvalidEntity
+	}
+
+	var peer *PttPeer
+	if confirmJoin.NodeID != nil {
+		peer = p.GetPeer(confirmJoin.NodeID, false)
+	}
+	if peer == nil {
+		peer = confirmJoin.Peer
+	}
+	if peer == nil {
+		return nil, nil, ErrNoPeer
+	}
+
+	confirmJoin.Entity = entity
+	confirmJoin.Peer = peer
+
+	return entity, peer, nil
+}
+
+/*
+loadConfirmJoins loads the confirm-joins pending before the restart,
+and deletes the expired ones.
+*/
+func (p *BasePtt) loadConfirmJoins() error {
+	ts, err := types.GetTimestamp()
+	if err != nil {
+		return err
+	}
+	expireTS := ts
+	expireTS.Ts -= ExpireConfirmJoinSeconds
+
+	iter, err := dbMeta.NewIteratorWithPrefix(nil, DBConfirmJoinPrefix, pttdb.ListOrderNext)
+	if err != nil {
+		return err
+	}
+	defer iter.Release()
+
+	p.lockConfirmJoin.Lock()
+	defer p.lockConfirmJoin.Unlock()
+
+	offset := len(DBConfirmJoinPrefix)
+	expiredKeys := make([][]byte, 0)
+	for iter.Next() {
+		key := common.CloneBytes(iter.Key())
+		confirmKey := key[offset:]
+
+		confirmJoin := &ConfirmJoin{}
+		err = json.Unmarshal(iter.Value(), confirmJoin)
+		if err == nil && confirmJoin.KeyInfo != nil {
+			err = confirmJoin.KeyInfo.Init(nil)
+		}
+		if err != nil || confirmJoin.KeyInfo == nil || confirmJoin.UpdateTS.IsLess(expireTS) {
+			expiredKeys = append(expiredKeys, key)
+			continue
+		}
+
+		p.confirmJoins[string(confirmKey)] = confirmJoin
+	}
+
+	for _, key := range expiredKeys {
+		err = dbMeta.Delete(key)
+		if err != nil {
+			log.Warn("loadConfirmJoins: unable to delete", "e", err)
+		}
+	}
+
+	log.Debug("loadConfirmJoins: done", "confirmJoins", len(p.confirmJoins), "expired", len(expiredKeys))
+
+	return nil
+}
+
+func marshalConfirmJoinKey(confirmKey []byte) ([]byte, error) {
+	return common.Concat([][]byte{DBConfirmJoinPrefix, confirmKey})
+}
+
+func saveConfirmJoin(confirmKey []byte, confirmJoin *ConfirmJoin) error {
+	key, err := marshalConfirmJoinKey(confirmKey)
+	if err != nil {
+		return err
+	}
+
+	marshaled, err := json.Marshal(confirmJoin)
+	if err != nil {
+		return err
+	}
+
+	return dbMeta.Put(key, marshaled)
+}
+
+func deleteConfirmJoin(confirmKey []byte) error {
+	key, err := marshalConfirmJoinKey(confirmKey)
+	if err != nil {
+		return err
+	}
+
+	return dbMeta.Delete(key)
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ailabstw/go-pttai/common/types"
+	"github.com/ailabstw/go-pttai/crypto"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+	"github.com/ailabstw/go-pttai/pttdb"
+)
+
+func TestBasePtt_LoadConfirmJoins(t *testing.T) {
+	setupTest(t)
+	defer teardownTest(t)
+
+	ts := types.Timestamp{Ts: 1234567890}
+	types.GetTimestamp = func() (types.Timestamp, error) {
+		return ts, nil
+	}
+
+	origDBMeta := dbMeta
+	dbMeta = pttdb.NewMemLDBDatabase("meta")
+	defer func() {
+		dbMeta.Close()
+		dbMeta = origDBMeta
+	}()
+
+	entityID := &types.PttID{1}
+	joinerID := &types.PttID{2}
+	oldJoinerID := &types.PttID{3}
+	nodeID := &discover.NodeID{4}
+
+	key, _ := crypto.GenerateKey()
+	keyInfo := joinKeyToKeyInfo(key)
+
+	confirmJoin := &ConfirmJoin{
+		JoinEntity: &JoinEntity{ID: joinerID, Name: []byte("joiner")},
+		KeyInfo:    keyInfo,
+		UpdateTS:   ts,
+		JoinType:   JoinTypeBoard,
+		EntityID:   entityID,
+		NodeID:     nodeID,
+	}
+	confirmKey := getConfirmKey(joinerID, entityID)
+	if err := saveConfirmJoin(confirmKey, confirmJoin); err != nil {
+		t.Fatalf("saveConfirmJoin: e: %v", err)
+	}
+
+	expiredTS := ts
+	expiredTS.Ts -= ExpireConfirmJoinSeconds + 1
+	expiredConfirmJoin := &ConfirmJoin{
+		JoinEntity: &JoinEntity{ID: oldJoinerID},
+		KeyInfo:    keyInfo,
+		UpdateTS:   expiredTS,
+		JoinType:   JoinTypeBoard,
+		EntityID:   entityID,
+		NodeID:     nodeID,
+	}
+	expiredConfirmKey := getConfirmKey(oldJoinerID, entityID)
+	if err := saveConfirmJoin(expiredConfirmKey, expiredConfirmJoin); err != nil {
+		t.Fatalf("saveConfirmJoin: e: %v", err)
+	}
+
+	// restart
+	p := &BasePtt{confirmJoins: make(map[string]*ConfirmJoin)}
+	if err := p.loadConfirmJoins(); err != nil {
+		t.Fatalf("loadConfirmJoins: e: %v", err)
+	}
+
+	confirmJoins, _ := p.GetConfirmJoins()
+	if len(confirmJoins) != 1 {
+		t.Fatalf("GetConfirmJoins: invalid len: %v", len(confirmJoins))
+	}
+	got := confirmJoins[0]
+	if !reflect.DeepEqual(got.ConfirmKey, confirmKey) || !reflect.DeepEqual(got.ID, joinerID) || !reflect.DeepEqual(got.EntityID, entityID) || !reflect.DeepEqual(got.NodeID, nodeID) || got.JoinType != JoinTypeBoard {
+		t.Errorf("GetConfirmJoins: invalid confirm-join: %v", got)
+	}
+
+	loaded := p.confirmJoins[string(confirmKey)]
+	if loaded.KeyInfo.Key == nil || !reflect.DeepEqual(loaded.KeyInfo.Hash, keyInfo.Hash) {
+		t.Errorf("loadConfirmJoins: key-info not initialized: %v", loaded.KeyInfo)
+	}
+
+	expiredKey, _ := marshalConfirmJoinKey(expiredConfirmKey)
+	if has, _ := dbMeta.Has(expiredKey); has {
+		t.Errorf("loadConfirmJoins: expired confirm-join not deleted")
+	}
+
+	// entity not registered after restart
+	err := p.ApproveJoin(confirmKey)
+	if err != ErrInvalidEntity {
+		t.Errorf("ApproveJoin: e: %v expected: %v", err, ErrInvalidEntity)
+	}
+}
@@ -54,10 +54,13 @@ func (p *BasePtt) HandlePeer(peer *PttPeer) error {
 	defer log.Debug("HandlePeer: done", "peer", peer)
 
 	// 1. basic handshake
-	err := peer.Handshake(p.networkID)
+	err := peer.Handshake(p.networkID, p.nodeRecord)
 	if err != nil {
 		return err
 	}
+	if peer.NodeRecord != nil {
+		p.setNodeType(peer.GetID(), peer.NodeRecord.GetNodeType())
+	}
 
 	// 2. add new peer (defer remove-peer)
 	err = p.AddNewPeer(peer)
@@ -166,6 +169,7 @@ func (p *BasePtt) determinePeerTypeFromAllEntities(peer *PttPeer, isLocked bool)
 	for _, entity := range p.entities {
 		pm = entity.PM()
 		if pm.IsMemberPeer(peer) {
+			// the self-declared host-peers are still members, only preferred in RandomSyncPeer.
 			return PeerTypeMember, nil
 		}
 	}
@@ -197,6 +201,10 @@ func (p *BasePtt) SetupPeer(peer *PttPeer, peerType PeerType, isLocked bool) err
 		return err
 	}
 
+	if p.config.IsMailbox {
+		go p.deliverMailbox(peer)
+	}
+
 	return nil
 }
 
@@ -251,6 +259,8 @@ func (p *BasePtt) RemovePeer(peer *PttPeer, isLocked bool) error {
 
 	peer.Peer.Disconnect(p2p.DiscUselessPeer)
 
+	p.removeRelayPeer(peerID)
+
 	return err
 }
 
@@ -538,6 +548,17 @@ func (p *BasePtt) dropAnyPeer(peerType PeerType, isLocked bool) error {
 }
 
 func (p *BasePtt) dropAnyPeerCore(peers map[discover.NodeID]*PttPeer) error {
+	// drop the mobile-peers first to avoid draining the mobile-nodes.
+	mobilePeers := make(map[discover.NodeID]*PttPeer)
+	for eachPeerID, eachPeer := range peers {
+		if isMobilePeer(eachPeer) {
+			mobilePeers[eachPeerID] = eachPeer
+		}
+	}
+	if len(mobilePeers) != 0 {
+		peers = mobilePeers
+	}
+
 	randIdx := mrand.Intn(len(peers))
 
 	i := 0
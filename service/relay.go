@@ -0,0 +1,198 @@
+// Copyright 2018 The go-pttai Authors
+// This file is part of the go-pttai library.
+//
+// The go-pttai library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-pttai library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-pttai library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/ailabstw/go-pttai/p2p"
+	"github.com/ailabstw/go-pttai/p2p/discover"
+)
+
+/*
+RelayFrame is the envelope of the ptt-frame relayed through the relay-node.
+
+The relay-node forwards only the envelope. The payload is the original ptt-frame
+(the PttData with EncData encrypted by the keys of the entities),
+so the relay-node is unable to read the content.
+*/
+type RelayFrame struct {
+	From    []byte // node-id of the sender, set by the relay-node and verified with RelayAuth
+	To      []byte // node-id of the receiver
+	Code    uint64
+	Payload []byte
+}
+
+/*
+RelayAuth is the challenge to the node of the relay-session.
+
+The relay-node is able to set any From. The 2 ends authenticate each other
+by signing the challenge with the node-key before running the peer.
+*/
+type RelayAuth struct {
+	Challenge []byte
+}
+
+/*
+RelayAuthAck is the signature of the challenge, the node-id of the signer, and the node-id of the challenger.
+*/
+type RelayAuthAck struct {
+	Sig []byte
+}
+
+type relayFailKey struct {
+	NodeID  discover.NodeID
+	RelayID discover.NodeID
+}
+
+/**********
+ * relay-limiter
+ **********/
+
+/*
+relayLimiter is a token-bucket limiting the relayed bytes per second.
+*/
+type relayLimiter struct {
+	lock sync.Mutex
+
+	bytesPerSecond uint64
+	tokens         float64
+	lastTime       time.Time
+}
+
+func newRelayLimiter(bytesPerSecond uint64) *relayLimiter {
+	return &relayLimiter{
+		bytesPerSecond: bytesPerSecond,
+		tokens:         float64(bytesPerSecond),
+		lastTime:       time.Now(),
+	}
+}
+
+/*
+Allow consumes n bytes from the bucket. 0 bytesPerSecond means no limit.
+*/
+func (l *relayLimiter) Allow(n int) bool {
+	if l.bytesPerSecond == 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.lastTime).Seconds() * float64(l.bytesPerSecond)
+	if l.tokens > float64(l.bytesPerSecond) {
+		l.tokens = float64(l.bytesPerSecond)
+	}
+	l.lastTime = now
+
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+
+	return true
+}
+
+/**********
+ * relay-session
+ **********/
+
+/*
+relaySession is the MsgReadWriter of the peer connected through the relay-node.
+The msgs are wrapped as RelayFrame and sent to the relay-node.
+*/
+type relaySession struct {
+	nodeID *discover.NodeID
+	relay  *PttPeer
+
+	in chan p2p.Msg
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newRelaySession(nodeID *discover.NodeID, relay *PttPeer) *relaySession {
+	return &relaySession{
+		nodeID: nodeID,
+		relay:  relay,
+
+		in:     make(chan p2p.Msg, RelaySessionQueueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+func (s *relaySession) ReadMsg() (p2p.Msg, error) {
+	select {
+	case msg := <-s.in:
+		return msg, nil
+	case <-s.closed:
+		return p2p.Msg{}, ErrRelayClosed
+	}
+}
+
+func (s *relaySession) WriteMsg(msg p2p.Msg) error {
+	select {
+	case <-s.closed:
+		return ErrRelayClosed
+	default:
+	}
+
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	frame := &RelayFrame{
+		To:      s.nodeID[:],
+		Code:    msg.Code,
+		Payload: payload,
+	}
+
+	return p2p.Send(s.relay.RW(), uint64(CodeTypeRelay), frame)
+}
+
+/*
+deliver delivers the frame from the relay-node to the reader.
+The frame is dropped if the reader is not able to catch up.
+*/
+func (s *relaySession) deliver(frame *RelayFrame) error {
+	msg := p2p.Msg{
+		Code:       frame.Code,
+		Size:       uint32(len(frame.Payload)),
+		Payload:    bytes.NewReader(frame.Payload),
+		ReceivedAt: time.Now(),
+	}
+
+	select {
+	case s.in <- msg:
+		return nil
+	case <-s.closed:
+		return ErrRelayClosed
+	default:
+		return ErrBusy
+	}
+}
+
+func (s *relaySession) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
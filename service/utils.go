@@ -87,7 +87,7 @@ func GenChallenge() []byte {
 	return challenge
 }
 
-func NewPttIDWithKey(myID *types.PttID, db *pttdb.LDBDatabase) (*types.PttID, error) {
+func NewPttIDWithKey(myID *types.PttID, db pttdb.KVDatabase) (*types.PttID, error) {
 	key, err := crypto.GenerateKey()
 	if err != nil {
 		return nil, err
@@ -141,3 +141,22 @@ func RandomPeer(peerList []*PttPeer) *PttPeer {
 	randNum := mrand.Intn(lenPeerList)
 	return peerList[randNum]
 }
+
+/*
+RandomSyncPeer picks a random peer from peerList as the sync-source,
+preferring the server-nodes hosting the boards (isHostPeer).
+*/
+func RandomSyncPeer(peerList []*PttPeer) *PttPeer {
+	hostPeerList := make([]*PttPeer, 0, len(peerList))
+	for _, peer := range peerList {
+		if isHostPeer(peer) {
+			hostPeerList = append(hostPeerList, peer)
+		}
+	}
+
+	if len(hostPeerList) != 0 {
+		return RandomPeer(hostPeerList)
+	}
+
+	return RandomPeer(peerList)
+}
@@ -119,3 +119,24 @@ func TestVerifyData(t *testing.T) {
 
 	// teardown test
 }
+
+func TestRandomSyncPeer(t *testing.T) {
+	memberPeer := &PttPeer{}
+	desktopPeer := &PttPeer{NodeRecord: NewNodeRecordEntry(&Config{NodeType: NodeTypeDesktop, IsHostBoards: true})}
+	hostPeer := &PttPeer{NodeRecord: NewNodeRecordEntry(&Config{NodeType: NodeTypeServer, IsHostBoards: true})}
+
+	if RandomSyncPeer(nil) != nil {
+		t.Errorf("RandomSyncPeer: expected nil without peers")
+	}
+
+	for i := 0; i < 10; i++ {
+		if got := RandomSyncPeer([]*PttPeer{memberPeer, desktopPeer, hostPeer}); got != hostPeer {
+			t.Errorf("RandomSyncPeer: got %v want the host-peer", got)
+		}
+	}
+
+	got := RandomSyncPeer([]*PttPeer{memberPeer, desktopPeer})
+	if got != memberPeer && got != desktopPeer {
+		t.Errorf("RandomSyncPeer: got %v without the host-peers", got)
+	}
+}